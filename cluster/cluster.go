@@ -9,6 +9,8 @@ import (
 	"bytes"
 	"fmt"
 	"os/exec"
+	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/cloudberrydb/gp-common-go-libs/dbconn"
@@ -312,8 +314,85 @@ func (cluster *Cluster) GenerateSSHCommandList(scope Scope, generator interface{
 	return commands
 }
 
+/*
+ * This function wraps GenerateSSHCommandList such that each command is run
+ * with environment variables exported first, e.g. to set PGPORT or GPHOME on
+ * the remote host.  Like the generator, the "env" argument can accept one of
+ * several types:
+ * - map[string]string, for the same variables on every segment or host
+ * - func(int) map[string]string, which takes a content id, for per-segment
+ *   variables such as the segment's port; only valid with a per-segment generator
+ * - func(string) map[string]string, which takes a hostname, for per-host variables
+ * Values are single-quoted so that they are passed through the shell as-is.
+ */
+func (cluster *Cluster) GenerateSSHCommandListWithEnv(scope Scope, generator interface{}, env interface{}) []ShellCommand {
+	var envForContent func(content int) map[string]string
+	var envForHost func(host string) map[string]string
+	switch generateEnv := env.(type) {
+	case map[string]string:
+		envForContent = func(int) map[string]string { return generateEnv }
+		envForHost = func(string) map[string]string { return generateEnv }
+	case func(content int) map[string]string:
+		envForContent = generateEnv
+	case func(host string) map[string]string:
+		envForContent = func(content int) map[string]string { return generateEnv(cluster.GetHostForContent(content)) }
+		envForHost = generateEnv
+	default:
+		gplog.Fatal(nil, "Environment passed to GenerateSSHCommandListWithEnv had an invalid type.")
+	}
+
+	var commands []ShellCommand
+	switch generateCommand := generator.(type) {
+	case func(content int) string:
+		commands = cluster.GenerateSSHCommandList(scope, func(content int) string {
+			return formatEnvironment(envForContent(content)) + generateCommand(content)
+		})
+	case func(host string) string:
+		if envForHost == nil {
+			gplog.Fatal(nil, "A per-segment environment cannot be used with a per-host command generator.")
+		}
+		commands = cluster.GenerateSSHCommandList(scope, func(host string) string {
+			return formatEnvironment(envForHost(host)) + generateCommand(host)
+		})
+	}
+	return commands
+}
+
+var envVarNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+/*
+ * Returns an "export" statement for the given variables, sorted by name so the
+ * generated command is deterministic, or the empty string if there are none.
+ */
+func formatEnvironment(env map[string]string) string {
+	if len(env) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(env))
+	for name := range env {
+		if !envVarNameRegex.MatchString(name) {
+			gplog.Fatal(nil, "Invalid environment variable name: %s", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	assignments := make([]string, len(names))
+	for i, name := range names {
+		assignments[i] = fmt.Sprintf("%s=%s", name, shellQuote(env[name]))
+	}
+	return fmt.Sprintf("export %s; ", strings.Join(assignments, " "))
+}
+
+/*
+ * Quotes a string for use as a single word in a Bash command line, where
+ * nothing inside single quotes is special except the closing quote itself.
+ */
+func shellQuote(str string) string {
+	return "'" + strings.Replace(str, "'", `'\''`, -1) + "'"
+}
+
 func (executor *GPDBExecutor) ExecuteLocalCommand(commandStr string) (string, error) {
-	output, err := exec.Command("bash", "-c", commandStr).CombinedOutput()
+	output, err := operating.System.ExecCommand("bash", "-c", commandStr)
 	return string(output), err
 }
 
@@ -240,6 +240,67 @@ var _ = Describe("cluster/cluster tests", func() {
 			Entry("returns a list of ssh commands for one local host and two remote hosts, excluding the coordinator host", cluster.ON_HOSTS, false, false, standbyCoordinator, 0, 2),
 		)
 	})
+	Describe("GenerateSSHCommandListWithEnv", func() {
+		BeforeEach(func() {
+			testCluster = cluster.NewCluster([]cluster.SegConfig{coordinatorSeg, localSegOne, remoteSegOne})
+		})
+		It("exports the same environment for every segment", func() {
+			commandList := testCluster.GenerateSSHCommandListWithEnv(cluster.ON_SEGMENTS, func(_ int) string {
+				return "ls"
+			}, map[string]string{"GPHOME": "/usr/local/gpdb", "PGOPTIONS": "-c gp_session_role=utility"})
+			Expect(commandList).To(Equal([]cluster.ShellCommand{
+				cluster.NewShellCommand(cluster.ON_SEGMENTS, 0, "", []string{"bash", "-c", "export GPHOME='/usr/local/gpdb' PGOPTIONS='-c gp_session_role=utility'; ls"}),
+				cluster.NewShellCommand(cluster.ON_SEGMENTS, 1, "", []string{"ssh", "-o", "StrictHostKeyChecking=no", "testUser@remotehost1", "export GPHOME='/usr/local/gpdb' PGOPTIONS='-c gp_session_role=utility'; ls"}),
+			}))
+		})
+		It("exports a per-segment environment", func() {
+			commandList := testCluster.GenerateSSHCommandListWithEnv(cluster.ON_SEGMENTS|cluster.INCLUDE_COORDINATOR, func(_ int) string {
+				return "psql -c 'SELECT 1'"
+			}, func(content int) map[string]string {
+				return map[string]string{"PGPORT": fmt.Sprintf("%d", testCluster.GetPortForContent(content))}
+			})
+			Expect(commandList).To(Equal([]cluster.ShellCommand{
+				cluster.NewShellCommand(cluster.ON_SEGMENTS|cluster.INCLUDE_COORDINATOR, -1, "", []string{"bash", "-c", "export PGPORT='5432'; psql -c 'SELECT 1'"}),
+				cluster.NewShellCommand(cluster.ON_SEGMENTS|cluster.INCLUDE_COORDINATOR, 0, "", []string{"bash", "-c", "export PGPORT='20000'; psql -c 'SELECT 1'"}),
+				cluster.NewShellCommand(cluster.ON_SEGMENTS|cluster.INCLUDE_COORDINATOR, 1, "", []string{"ssh", "-o", "StrictHostKeyChecking=no", "testUser@remotehost1", "export PGPORT='20001'; psql -c 'SELECT 1'"}),
+			}))
+		})
+		It("exports a per-host environment", func() {
+			commandList := testCluster.GenerateSSHCommandListWithEnv(cluster.ON_HOSTS|cluster.INCLUDE_COORDINATOR, func(_ string) string {
+				return "ls"
+			}, func(host string) map[string]string {
+				return map[string]string{"HOST_LABEL": host}
+			})
+			Expect(commandList).To(Equal([]cluster.ShellCommand{
+				cluster.NewShellCommand(cluster.ON_HOSTS|cluster.INCLUDE_COORDINATOR, -2, "localhost", []string{"bash", "-c", "export HOST_LABEL='localhost'; ls"}),
+				cluster.NewShellCommand(cluster.ON_HOSTS|cluster.INCLUDE_COORDINATOR, -2, "remotehost1", []string{"ssh", "-o", "StrictHostKeyChecking=no", "testUser@remotehost1", "export HOST_LABEL='remotehost1'; ls"}),
+			}))
+		})
+		It("quotes values containing shell metacharacters", func() {
+			commandList := testCluster.GenerateSSHCommandListWithEnv(cluster.ON_SEGMENTS, func(_ int) string {
+				return "ls"
+			}, map[string]string{"LABEL": "it's $HOME; `rm -rf /`"})
+			Expect(commandList[0].CommandString).To(Equal(`bash -c export LABEL='it'\''s $HOME; ` + "`rm -rf /`" + `'; ls`))
+		})
+		It("leaves the command unchanged for an empty environment", func() {
+			commandList := testCluster.GenerateSSHCommandListWithEnv(cluster.ON_SEGMENTS, func(_ int) string {
+				return "ls"
+			}, map[string]string{})
+			Expect(commandList[0].CommandString).To(Equal("bash -c ls"))
+		})
+		It("panics on an invalid environment variable name", func() {
+			defer testhelper.ShouldPanicWithMessage("Invalid environment variable name: BAD;NAME")
+			testCluster.GenerateSSHCommandListWithEnv(cluster.ON_SEGMENTS, func(_ int) string {
+				return "ls"
+			}, map[string]string{"BAD;NAME": "value"})
+		})
+		It("panics if a per-segment environment is used with a per-host generator", func() {
+			defer testhelper.ShouldPanicWithMessage("A per-segment environment cannot be used with a per-host command generator.")
+			testCluster.GenerateSSHCommandListWithEnv(cluster.ON_HOSTS, func(_ string) string {
+				return "ls"
+			}, func(_ int) map[string]string { return nil })
+		})
+	})
 	Describe("ExecuteLocalCommand", func() {
 		BeforeEach(func() {
 			os.MkdirAll("/tmp/gp_common_go_libs_test", 0777)
@@ -264,6 +325,35 @@ var _ = Describe("cluster/cluster tests", func() {
 			Expect(output).To(Equal("bash: some-non-existent-command: command not found\n"))
 			Expect(err.Error()).To(Equal("exit status 127"))
 		})
+		It("runs the command through operating.System.ExecCommand", func() {
+			defer func() { operating.System.ExecCommand = operating.ExecCommand }()
+			var calledName string
+			var calledArgs []string
+			operating.System.ExecCommand = func(name string, args ...string) ([]byte, error) {
+				calledName, calledArgs = name, args
+				return []byte("canned output\n"), nil
+			}
+			testCluster := cluster.Cluster{}
+			testCluster.Executor = &cluster.GPDBExecutor{}
+			output, err := testCluster.ExecuteLocalCommand("pg_ctl status")
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(output).To(Equal("canned output\n"))
+			Expect(calledName).To(Equal("bash"))
+			Expect(calledArgs).To(Equal([]string{"-c", "pg_ctl status"}))
+		})
+		It("returns the output and error from operating.System.ExecCommand", func() {
+			defer func() { operating.System.ExecCommand = operating.ExecCommand }()
+			operating.System.ExecCommand = func(name string, args ...string) ([]byte, error) {
+				return []byte("pg_ctl: no server running\n"), errors.New("exit status 3")
+			}
+			testCluster := cluster.Cluster{}
+			testCluster.Executor = &cluster.GPDBExecutor{}
+			output, err := testCluster.ExecuteLocalCommand("pg_ctl status")
+
+			Expect(output).To(Equal("pg_ctl: no server running\n"))
+			Expect(err).To(MatchError("exit status 3"))
+		})
 	})
 	Describe("ExecuteClusterCommand", func() {
 		BeforeEach(func() {
@@ -0,0 +1,68 @@
+package dbconn
+
+/*
+ * This file contains functions related to cancelling queries and terminating
+ * server backends.
+ */
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+/*
+ * CancelBackend cancels the query running in the backend with the given PID,
+ * as found with GetSessionInfo, and TerminateBackend ends that backend's
+ * session altogether.  The request is issued on the connection given by
+ * whichConn, which must be a different connection from the one running the
+ * query, since that one is busy until the query finishes.  If whichConn is
+ * omitted, the first connection that is not running a statement and does not
+ * belong to the backend, as far as GetSessionInfo has seen, is used instead,
+ * and an error is returned if there is no such connection.  Both return false
+ * if no backend with that PID exists or the server refused to signal it.
+ */
+func (dbconn *DBConn) CancelBackend(pid int, whichConn ...int) (bool, error) {
+	return dbconn.signalBackend("pg_cancel_backend", pid, whichConn...)
+}
+
+func (dbconn *DBConn) TerminateBackend(pid int, whichConn ...int) (bool, error) {
+	return dbconn.signalBackend("pg_terminate_backend", pid, whichConn...)
+}
+
+func (dbconn *DBConn) signalBackend(function string, pid int, whichConn ...int) (bool, error) {
+	query := fmt.Sprintf("SELECT %s(%d)", function, pid)
+	var signalled bool
+	var err error
+	if len(whichConn) > 0 {
+		err = dbconn.Get(&signalled, query, whichConn...)
+	} else {
+		err = dbconn.getOnIdleConn(&signalled, dbconn.transformQuery(query), pid)
+	}
+	if err != nil {
+		return false, err
+	}
+	return signalled, nil
+}
+
+/*
+ * Runs the query on a connection that is free to run it right away, skipping
+ * connections that are running a statement, such as the query being
+ * cancelled, and those known to belong to the backend with the given PID.
+ */
+func (dbconn *DBConn) getOnIdleConn(destination interface{}, query string, pid int) error {
+	for connNum := 0; connNum < dbconn.NumConns; connNum++ {
+		unlock, ok := dbconn.tryLockConn(connNum)
+		if !ok {
+			continue
+		}
+		if connNum < len(dbconn.sessionInfo) && dbconn.sessionInfo[connNum] != nil && dbconn.sessionInfo[connNum].BackendPID == pid {
+			unlock()
+			continue
+		}
+		err := dbconn.getLocked(destination, query, connNum)
+		unlock()
+		return err
+	}
+	return errors.Errorf("Cannot signal backend %d; no connection is free to send the request", pid)
+}
@@ -0,0 +1,141 @@
+package dbconn_test
+
+import (
+	"regexp"
+	"sync/atomic"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/cloudberrydb/gp-common-go-libs/operating"
+	"github.com/cloudberrydb/gp-common-go-libs/testhelper"
+	"github.com/pkg/errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("dbconn/backend tests", func() {
+	// Keeps the next statement in flight on its connection, which it holds
+	// when it reads the time, until the returned function is called
+	holdNextStatement := func() (inFlight chan struct{}, finish func()) {
+		inFlight = make(chan struct{})
+		done := make(chan struct{})
+		var calls int32
+		operating.System.Now = func() time.Time {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				close(inFlight)
+				<-done
+			}
+			return time.Now()
+		}
+		return inFlight, func() { close(done) }
+	}
+	AfterEach(func() {
+		operating.System = operating.InitializeSystemFunctions()
+	})
+
+	Describe("DBConn.CancelBackend", func() {
+		It("cancels the backend's query using the given connection", func() {
+			multiConn, mocks := testhelper.CreateAndConnectMockDBWithMocks(2)
+			defer multiConn.Close()
+			mocks[1].ExpectQuery(regexp.QuoteMeta("SELECT pg_cancel_backend(12345)")).
+				WillReturnRows(sqlmock.NewRows([]string{"pg_cancel_backend"}).AddRow(true))
+
+			cancelled, err := multiConn.CancelBackend(12345, 1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cancelled).To(BeTrue())
+			Expect(mocks[0].ExpectationsWereMet()).To(Succeed())
+			Expect(mocks[1].ExpectationsWereMet()).To(Succeed())
+		})
+		It("uses a connection that is not running a statement if none is given", func() {
+			multiConn, mocks := testhelper.CreateAndConnectMockDBWithMocks(2)
+			defer multiConn.Close()
+			mocks[0].ExpectExec("SELECT pg_sleep(.*)").WillReturnResult(testhelper.TestResult{Rows: 1})
+			mocks[1].ExpectQuery(regexp.QuoteMeta("SELECT pg_cancel_backend(12345)")).
+				WillReturnRows(sqlmock.NewRows([]string{"pg_cancel_backend"}).AddRow(true))
+			inFlight, finish := holdNextStatement()
+			errs := make(chan error, 1)
+			go func() {
+				_, err := multiConn.Exec("SELECT pg_sleep(60)", 0)
+				errs <- err
+			}()
+			Eventually(inFlight).Should(BeClosed())
+
+			cancelled, err := multiConn.CancelBackend(12345)
+			finish()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cancelled).To(BeTrue())
+			Eventually(errs).Should(Receive(BeNil()))
+			Expect(mocks[0].ExpectationsWereMet()).To(Succeed())
+			Expect(mocks[1].ExpectationsWereMet()).To(Succeed())
+		})
+		It("does not use the connection of the backend being cancelled if none is given", func() {
+			multiConn, mocks := testhelper.CreateAndConnectMockDBWithMocks(2)
+			defer multiConn.Close()
+			mocks[0].ExpectQuery(regexp.QuoteMeta("SELECT pg_backend_pid() AS backendpid")).
+				WillReturnRows(sqlmock.NewRows([]string{"backendpid", "currentuser", "currentdatabase", "serverversion"}).AddRow(12345, "testrole", "testdb", "6.0.0"))
+			mocks[1].ExpectQuery(regexp.QuoteMeta("SELECT pg_cancel_backend(12345)")).
+				WillReturnRows(sqlmock.NewRows([]string{"pg_cancel_backend"}).AddRow(true))
+			_, err := multiConn.GetSessionInfo(0)
+			Expect(err).ToNot(HaveOccurred())
+
+			cancelled, err := multiConn.CancelBackend(12345)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cancelled).To(BeTrue())
+			Expect(mocks[0].ExpectationsWereMet()).To(Succeed())
+			Expect(mocks[1].ExpectationsWereMet()).To(Succeed())
+		})
+		It("returns an error rather than waiting if every connection is busy and none is given", func() {
+			mock.ExpectExec("SELECT pg_sleep(.*)").WillReturnResult(testhelper.TestResult{Rows: 1})
+			inFlight, finish := holdNextStatement()
+			errs := make(chan error, 1)
+			go func() {
+				_, err := connection.Exec("SELECT pg_sleep(60)")
+				errs <- err
+			}()
+			Eventually(inFlight).Should(BeClosed())
+
+			cancelled, err := connection.CancelBackend(12345)
+			finish()
+			Expect(err).To(MatchError("Cannot signal backend 12345; no connection is free to send the request"))
+			Expect(cancelled).To(BeFalse())
+			Eventually(errs).Should(Receive(BeNil()))
+		})
+		It("returns false if the backend could not be signalled", func() {
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT pg_cancel_backend(12345)")).
+				WillReturnRows(sqlmock.NewRows([]string{"pg_cancel_backend"}).AddRow(false))
+
+			cancelled, err := connection.CancelBackend(12345)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cancelled).To(BeFalse())
+		})
+		It("returns an error if the query fails", func() {
+			mock.ExpectQuery("SELECT (.*)").WillReturnError(errors.New("must be a member of the role whose query is being canceled"))
+
+			cancelled, err := connection.CancelBackend(12345)
+			Expect(err).To(MatchError("must be a member of the role whose query is being canceled"))
+			Expect(cancelled).To(BeFalse())
+		})
+	})
+	Describe("DBConn.TerminateBackend", func() {
+		It("terminates the backend using the given connection", func() {
+			multiConn, mocks := testhelper.CreateAndConnectMockDBWithMocks(2)
+			defer multiConn.Close()
+			mocks[1].ExpectQuery(regexp.QuoteMeta("SELECT pg_terminate_backend(12345)")).
+				WillReturnRows(sqlmock.NewRows([]string{"pg_terminate_backend"}).AddRow(true))
+
+			terminated, err := multiConn.TerminateBackend(12345, 1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(terminated).To(BeTrue())
+			Expect(mocks[1].ExpectationsWereMet()).To(Succeed())
+		})
+		It("returns false if the backend could not be signalled", func() {
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT pg_terminate_backend(12345)")).
+				WillReturnRows(sqlmock.NewRows([]string{"pg_terminate_backend"}).AddRow(false))
+
+			terminated, err := connection.TerminateBackend(12345)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(terminated).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,90 @@
+package dbconn
+
+/*
+ * This file contains functions related to caching the results of read-only
+ * queries on the client.
+ */
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/cloudberrydb/gp-common-go-libs/operating"
+	"github.com/pkg/errors"
+)
+
+type cachedResult struct {
+	value     reflect.Value
+	expiresAt time.Time
+}
+
+/*
+ * EnableResultCache makes SelectCached keep the result of each query for ttl
+ * after it is run, so that utilities issuing the same catalog query many
+ * times in a short window only run it once.  Results are cached by query text
+ * alone, so only queries whose results do not depend on the connection or
+ * transaction they run in should be cached.
+ */
+func (dbconn *DBConn) EnableResultCache(ttl time.Duration) {
+	dbconn.resultCacheLock.Lock()
+	defer dbconn.resultCacheLock.Unlock()
+	dbconn.resultCacheTTL = ttl
+	dbconn.resultCache = make(map[string]cachedResult)
+}
+
+/*
+ * InvalidateCache discards every cached result, e.g. after a statement that
+ * changes the catalog.  Close does this as well.
+ */
+func (dbconn *DBConn) InvalidateCache() {
+	dbconn.resultCacheLock.Lock()
+	defer dbconn.resultCacheLock.Unlock()
+	if dbconn.resultCache != nil {
+		dbconn.resultCache = make(map[string]cachedResult)
+	}
+}
+
+/*
+ * SelectCached is Select for a query whose result may be served from the
+ * result cache.  Destination must be a pointer to a slice, and a cached
+ * result is only used if it was selected into a slice of the same type.  As
+ * with Select, the rows are appended to the slice, and the cache keeps its own
+ * copy of them, so callers may modify the slice freely.  If the cache has not
+ * been enabled, SelectCached is the same as Select.
+ */
+func (dbconn *DBConn) SelectCached(destination interface{}, query string, whichConn ...int) error {
+	destValue := reflect.ValueOf(destination)
+	if destValue.Kind() != reflect.Ptr || destValue.Elem().Kind() != reflect.Slice {
+		return errors.Errorf("Cannot cache query results in a %T; the destination must be a pointer to a slice", destination)
+	}
+
+	dbconn.resultCacheLock.Lock()
+	cacheEnabled := dbconn.resultCache != nil
+	cached, ok := dbconn.resultCache[query]
+	dbconn.resultCacheLock.Unlock()
+	if ok && cached.value.Type() == destValue.Elem().Type() && operating.System.Now().Before(cached.expiresAt) {
+		destValue.Elem().Set(reflect.AppendSlice(destValue.Elem(), cached.value))
+		return nil
+	}
+
+	prevLen := destValue.Elem().Len()
+	err := dbconn.Select(destination, query, whichConn...)
+	if err != nil || !cacheEnabled {
+		return err
+	}
+	dbconn.resultCacheLock.Lock()
+	defer dbconn.resultCacheLock.Unlock()
+	if dbconn.resultCache != nil {
+		dbconn.resultCache[query] = cachedResult{
+			value:     copySlice(destValue.Elem().Slice(prevLen, destValue.Elem().Len())),
+			expiresAt: operating.System.Now().Add(dbconn.resultCacheTTL),
+		}
+	}
+	return nil
+}
+
+func copySlice(slice reflect.Value) reflect.Value {
+	copied := reflect.MakeSlice(slice.Type(), slice.Len(), slice.Len())
+	reflect.Copy(copied, slice)
+	return copied
+}
@@ -0,0 +1,136 @@
+package dbconn_test
+
+import (
+	"regexp"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/cloudberrydb/gp-common-go-libs/operating"
+	"github.com/pkg/errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("dbconn/cache tests", func() {
+	Describe("DBConn.SelectCached", func() {
+		var now time.Time
+		query := "SELECT nspname FROM pg_namespace ORDER BY nspname"
+		expectQuery := func(names ...string) {
+			rows := sqlmock.NewRows([]string{"nspname"})
+			for _, name := range names {
+				rows.AddRow(name)
+			}
+			mock.ExpectQuery(regexp.QuoteMeta(query)).WillReturnRows(rows)
+		}
+		BeforeEach(func() {
+			now = time.Date(2017, time.January, 1, 1, 1, 1, 1, time.Local)
+			operating.System.Now = func() time.Time { return now }
+		})
+		AfterEach(func() {
+			operating.System.Now = time.Now
+		})
+
+		It("reuses a cached result within the TTL", func() {
+			connection.EnableResultCache(time.Minute)
+			expectQuery("public", "sales")
+
+			first := make([]string, 0)
+			Expect(connection.SelectCached(&first, query)).To(Succeed())
+			now = now.Add(59 * time.Second)
+			second := make([]string, 0)
+			Expect(connection.SelectCached(&second, query)).To(Succeed())
+			Expect(first).To(Equal([]string{"public", "sales"}))
+			Expect(second).To(Equal([]string{"public", "sales"}))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("runs the query again once the cached result expires", func() {
+			connection.EnableResultCache(time.Minute)
+			expectQuery("public")
+			expectQuery("public", "sales")
+
+			first := make([]string, 0)
+			Expect(connection.SelectCached(&first, query)).To(Succeed())
+			now = now.Add(time.Minute)
+			second := make([]string, 0)
+			Expect(connection.SelectCached(&second, query)).To(Succeed())
+			Expect(first).To(Equal([]string{"public"}))
+			Expect(second).To(Equal([]string{"public", "sales"}))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("runs the query again after the cache is invalidated", func() {
+			connection.EnableResultCache(time.Minute)
+			expectQuery("public")
+			expectQuery("public", "sales")
+
+			first := make([]string, 0)
+			Expect(connection.SelectCached(&first, query)).To(Succeed())
+			connection.InvalidateCache()
+			second := make([]string, 0)
+			Expect(connection.SelectCached(&second, query)).To(Succeed())
+			Expect(second).To(Equal([]string{"public", "sales"}))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("does not let callers modify a cached result", func() {
+			connection.EnableResultCache(time.Minute)
+			expectQuery("public")
+
+			first := make([]string, 0)
+			Expect(connection.SelectCached(&first, query)).To(Succeed())
+			first[0] = "modified"
+			second := make([]string, 0)
+			Expect(connection.SelectCached(&second, query)).To(Succeed())
+			Expect(second).To(Equal([]string{"public"}))
+		})
+		It("appends a cached result to the destination as Select does", func() {
+			connection.EnableResultCache(time.Minute)
+			expectQuery("public")
+
+			first := []string{"existing"}
+			Expect(connection.SelectCached(&first, query)).To(Succeed())
+			second := []string{"existing"}
+			Expect(connection.SelectCached(&second, query)).To(Succeed())
+			Expect(first).To(Equal([]string{"existing", "public"}))
+			Expect(second).To(Equal([]string{"existing", "public"}))
+		})
+		It("does not serve a result cached for a different destination type", func() {
+			connection.EnableResultCache(time.Minute)
+			expectQuery("public")
+			expectQuery("public")
+
+			names := make([]string, 0)
+			Expect(connection.SelectCached(&names, query)).To(Succeed())
+			type namespace struct{ Nspname string }
+			namespaces := make([]namespace, 0)
+			Expect(connection.SelectCached(&namespaces, query)).To(Succeed())
+			Expect(namespaces).To(Equal([]namespace{{Nspname: "public"}}))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("does not cache results unless the cache is enabled", func() {
+			expectQuery("public")
+			expectQuery("public", "sales")
+
+			first := make([]string, 0)
+			Expect(connection.SelectCached(&first, query)).To(Succeed())
+			second := make([]string, 0)
+			Expect(connection.SelectCached(&second, query)).To(Succeed())
+			Expect(second).To(Equal([]string{"public", "sales"}))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("does not cache a failed query", func() {
+			connection.EnableResultCache(time.Minute)
+			mock.ExpectQuery(regexp.QuoteMeta(query)).WillReturnError(errors.New("permission denied"))
+			expectQuery("public")
+
+			results := make([]string, 0)
+			Expect(connection.SelectCached(&results, query)).To(MatchError("permission denied"))
+			Expect(connection.SelectCached(&results, query)).To(Succeed())
+			Expect(results).To(Equal([]string{"public"}))
+		})
+		It("returns an error if the destination is not a pointer to a slice", func() {
+			var name string
+			err := connection.SelectCached(&name, query)
+			Expect(err).To(MatchError("Cannot cache query results in a *string; the destination must be a pointer to a slice"))
+		})
+	})
+})
@@ -0,0 +1,532 @@
+package dbconn
+
+/*
+ * This file contains structs and functions related to querying the system
+ * catalog for information about database objects and the server.
+ */
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+/*
+ * Location is the tablespace directory on the coordinator and is empty for the
+ * built-in pg_default and pg_global tablespaces.  SegmentLocations maps the
+ * content ID of each primary segment to its directory for the tablespace.
+ *
+ * Before GPDB 6, tablespaces live inside filespaces, so the locations reported
+ * for those versions are the filespace locations.
+ */
+type TablespaceInfo struct {
+	Oid              uint32
+	Name             string
+	Location         string
+	SegmentLocations map[int]string
+}
+
+type tablespaceSegmentLocation struct {
+	Oid       uint32
+	ContentID int
+	Location  string
+}
+
+func (dbconn *DBConn) GetTablespaces(whichConn ...int) ([]TablespaceInfo, error) {
+	connNum := dbconn.ValidateConnNum(whichConn...)
+	tablespaceQuery := `
+SELECT
+	t.oid,
+	t.spcname AS name,
+	coalesce(pg_tablespace_location(t.oid), '') AS location
+FROM pg_tablespace t
+ORDER BY t.oid;`
+	segmentQuery := `
+SELECT
+	t.oid,
+	l.gp_segment_id AS contentid,
+	l.tblspc_loc AS location
+FROM pg_tablespace t, gp_tablespace_location(t.oid) l
+WHERE l.gp_segment_id >= 0
+	AND l.tblspc_loc <> ''
+ORDER BY t.oid, l.gp_segment_id;`
+	if dbconn.Version.Before("6") {
+		tablespaceQuery = `
+SELECT
+	t.oid,
+	t.spcname AS name,
+	coalesce(fse.fselocation, '') AS location
+FROM pg_tablespace t
+LEFT JOIN pg_filespace_entry fse ON t.spcfsoid = fse.fsefsoid
+	AND fse.fsedbid = (SELECT dbid FROM gp_segment_configuration WHERE content = -1 AND role = 'p')
+	AND t.spcname NOT IN ('pg_default', 'pg_global')
+ORDER BY t.oid;`
+		segmentQuery = `
+SELECT
+	t.oid,
+	c.content AS contentid,
+	fse.fselocation AS location
+FROM pg_tablespace t
+JOIN pg_filespace_entry fse ON t.spcfsoid = fse.fsefsoid
+JOIN gp_segment_configuration c ON fse.fsedbid = c.dbid
+WHERE c.role = 'p'
+	AND c.content >= 0
+	AND t.spcname NOT IN ('pg_default', 'pg_global')
+ORDER BY t.oid, c.content;`
+	}
+
+	tablespaces := make([]TablespaceInfo, 0)
+	err := dbconn.selectInto(&tablespaces, tablespaceQuery, connNum)
+	if err != nil {
+		return nil, err
+	}
+	segmentLocations := make([]tablespaceSegmentLocation, 0)
+	err = dbconn.selectInto(&segmentLocations, segmentQuery, connNum)
+	if err != nil {
+		return nil, err
+	}
+
+	tablespacesByOid := make(map[uint32]*TablespaceInfo, len(tablespaces))
+	for i := range tablespaces {
+		tablespaces[i].SegmentLocations = make(map[int]string)
+		tablespacesByOid[tablespaces[i].Oid] = &tablespaces[i]
+	}
+	for _, segmentLocation := range segmentLocations {
+		tablespace, ok := tablespacesByOid[segmentLocation.Oid]
+		if !ok {
+			return nil, errors.Errorf("Found a segment location for tablespace with OID %d, which does not exist", segmentLocation.Oid)
+		}
+		tablespace.SegmentLocations[segmentLocation.ContentID] = segmentLocation.Location
+	}
+	return tablespaces, nil
+}
+
+/*
+ * Quotes a string for use as a literal in a query, using an escape string so
+ * that the result is the same regardless of standard_conforming_strings.
+ */
+func quoteLiteral(str string) string {
+	str = strings.ReplaceAll(str, `\`, `\\`)
+	str = strings.ReplaceAll(str, "'", "''")
+	return fmt.Sprintf("E'%s'", str)
+}
+
+/*
+ * BloatInfo holds an estimate of the space wasted by dead tuples in a table,
+ * based on the statistics from the table's most recent ANALYZE.
+ */
+type BloatInfo struct {
+	Schema        string
+	Table         string
+	RelPages      int64
+	ExpectedPages int64
+	DeadTuples    int64
+	WastedBytes   int64
+}
+
+type bloatStatistics struct {
+	RelPages      int64
+	ExpectedPages int64
+	LiveTuples    float64
+	DeadTuples    float64
+	BlockSize     int64
+}
+
+/*
+ * GetTableBloat estimates the bloat of a table from gp_toolkit, which compares
+ * the number of pages the table occupies to the number it would need for its
+ * live tuples.  In GPDB 7 and later gp_toolkit is an extension that may not be
+ * installed, in which case the estimate falls back to the dead tuple counts in
+ * pg_stat_all_tables.
+ */
+func (dbconn *DBConn) GetTableBloat(schema string, table string, whichConn ...int) (*BloatInfo, error) {
+	connNum := dbconn.ValidateConnNum(whichConn...)
+	useToolkit := true
+	if dbconn.Version.AtLeast("7") {
+		extensions := make([]string, 0)
+		err := dbconn.selectInto(&extensions, "SELECT extname FROM pg_extension WHERE extname = 'gp_toolkit'", connNum)
+		if err != nil {
+			return nil, err
+		}
+		useToolkit = len(extensions) > 0
+	}
+
+	query := fmt.Sprintf(`
+SELECT
+	b.btdrelpages AS relpages,
+	round(b.btdexppages)::bigint AS expectedpages,
+	c.reltuples AS livetuples,
+	0 AS deadtuples,
+	current_setting('block_size')::bigint AS blocksize
+FROM gp_toolkit.gp_bloat_expected_pages b
+JOIN pg_class c ON c.oid = b.btdrelid
+JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE n.nspname = %s
+	AND c.relname = %s;`, quoteLiteral(schema), quoteLiteral(table))
+	if !useToolkit {
+		query = fmt.Sprintf(`
+SELECT
+	c.relpages AS relpages,
+	0 AS expectedpages,
+	s.n_live_tup AS livetuples,
+	s.n_dead_tup AS deadtuples,
+	current_setting('block_size')::bigint AS blocksize
+FROM pg_stat_all_tables s
+JOIN pg_class c ON c.oid = s.relid
+WHERE s.schemaname = %s
+	AND s.relname = %s;`, quoteLiteral(schema), quoteLiteral(table))
+	}
+
+	results := make([]bloatStatistics, 0)
+	err := dbconn.selectInto(&results, query, connNum)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, errors.Errorf("Unable to find bloat statistics for table %s.%s", schema, table)
+	}
+	stats := results[0]
+
+	bloat := &BloatInfo{Schema: schema, Table: table, RelPages: stats.RelPages}
+	if useToolkit {
+		bloat.ExpectedPages = stats.ExpectedPages
+		wastedPages := stats.RelPages - stats.ExpectedPages
+		if wastedPages > 0 && stats.ExpectedPages > 0 {
+			// Assume the dead tuples are packed as densely as the live ones
+			bloat.DeadTuples = int64(math.Round(float64(wastedPages) * stats.LiveTuples / float64(stats.ExpectedPages)))
+			bloat.WastedBytes = wastedPages * stats.BlockSize
+		}
+	} else {
+		bloat.DeadTuples = int64(stats.DeadTuples)
+		totalTuples := stats.LiveTuples + stats.DeadTuples
+		if totalTuples > 0 {
+			wastedPages := math.Round(float64(stats.RelPages) * stats.DeadTuples / totalTuples)
+			bloat.ExpectedPages = stats.RelPages - int64(wastedPages)
+			bloat.WastedBytes = int64(wastedPages) * stats.BlockSize
+		} else {
+			bloat.ExpectedPages = stats.RelPages
+		}
+	}
+	return bloat, nil
+}
+
+/*
+ * GetCurrentWALPosition returns the current write-ahead log location on the
+ * connected server, e.g. "0/16B3748".  The function was renamed from
+ * pg_current_xlog_location to pg_current_wal_lsn in PostgreSQL 10, which GPDB
+ * 7 is based on.
+ */
+func (dbconn *DBConn) GetCurrentWALPosition(whichConn ...int) (string, error) {
+	connNum := dbconn.ValidateConnNum(whichConn...)
+	query := "SELECT pg_current_wal_lsn()::text AS lsn"
+	if dbconn.Version.Before("7") {
+		query = "SELECT pg_current_xlog_location()::text AS lsn"
+	}
+	var lsn string
+	err := dbconn.get(&lsn, query, connNum)
+	return lsn, err
+}
+
+/*
+ * Capabilities summarizes what the connected role is able to do, for the
+ * preflight checks that utilities run before starting work.  Extensions is
+ * always empty before GPDB 6, which does not support extensions.
+ */
+type Capabilities struct {
+	Version             GPDBVersion
+	IsSuperuser         bool
+	CanCreateTempTables bool
+	Extensions          []string
+}
+
+type roleCapabilities struct {
+	IsSuperuser         bool
+	CanCreateTempTables bool
+}
+
+func (dbconn *DBConn) ProbeCapabilities(whichConn ...int) (*Capabilities, error) {
+	connNum := dbconn.ValidateConnNum(whichConn...)
+	query := `
+SELECT
+	coalesce((SELECT rolsuper FROM pg_roles WHERE rolname = current_user), false) AS issuperuser,
+	has_database_privilege(current_database(), 'TEMP') AS cancreatetemptables;`
+	var role roleCapabilities
+	err := dbconn.get(&role, query, connNum)
+	if err != nil {
+		return nil, err
+	}
+
+	extensions := make([]string, 0)
+	if dbconn.Version.AtLeast("6") {
+		err = dbconn.selectInto(&extensions, "SELECT extname FROM pg_extension ORDER BY extname", connNum)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &Capabilities{
+		Version:             dbconn.Version,
+		IsSuperuser:         role.IsSuperuser,
+		CanCreateTempTables: role.CanCreateTempTables,
+		Extensions:          extensions,
+	}, nil
+}
+
+/*
+ * StandbyInfo describes the standby coordinator, if one is configured.  State
+ * and SyncState are the standby's replication state as reported in
+ * pg_stat_replication on the coordinator, and are empty if the standby is not
+ * currently connected to it.  Synchronized is true if the standby is
+ * streaming and confirms each commit before it completes.
+ */
+type StandbyInfo struct {
+	Configured   bool
+	Hostname     string
+	Port         int
+	Status       string
+	State        string
+	SyncState    string
+	Synchronized bool
+}
+
+func (dbconn *DBConn) GetStandbyStatus(whichConn ...int) (*StandbyInfo, error) {
+	connNum := dbconn.ValidateConnNum(whichConn...)
+	query := `
+SELECT
+	c.hostname,
+	c.port,
+	c.status,
+	coalesce(r.state, '') AS state,
+	coalesce(r.sync_state, '') AS syncstate
+FROM gp_segment_configuration c
+LEFT JOIN pg_stat_replication r ON r.application_name = 'gp_walreceiver'
+WHERE c.content = -1
+	AND c.role = 'm';`
+	results := make([]StandbyInfo, 0)
+	err := dbconn.selectInto(&results, query, connNum)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return &StandbyInfo{Configured: false}, nil
+	}
+	standby := results[0]
+	standby.Configured = true
+	standby.Synchronized = standby.State == "streaming" && standby.SyncState == "sync"
+	return &standby, nil
+}
+
+/*
+ * SegmentConfig describes one segment instance, primary or mirror, as listed
+ * in gp_segment_configuration.  The coordinator has ContentID -1, and its
+ * standby is the mirror with that content ID.
+ */
+type SegmentConfig struct {
+	DbID          int
+	ContentID     int
+	Role          string
+	PreferredRole string
+	Hostname      string
+	Address       string
+	Port          int
+	DataDir       string
+}
+
+/*
+ * GetSegmentConfiguration returns every segment instance in the cluster,
+ * ordered by content ID with each primary before its mirror.  Before GPDB 6,
+ * gp_segment_configuration has no datadir column, so the data directories are
+ * the locations of the pg_system filespace instead.
+ */
+func GetSegmentConfiguration(connection *DBConn, whichConn ...int) ([]SegmentConfig, error) {
+	connNum := connection.ValidateConnNum(whichConn...)
+	query := `
+SELECT
+	dbid,
+	content AS contentid,
+	role,
+	preferred_role AS preferredrole,
+	hostname,
+	address,
+	port,
+	datadir
+FROM gp_segment_configuration
+ORDER BY content, role DESC;`
+	if connection.Version.Before("6") {
+		query = `
+SELECT
+	s.dbid,
+	s.content AS contentid,
+	s.role,
+	s.preferred_role AS preferredrole,
+	s.hostname,
+	s.address,
+	s.port,
+	e.fselocation AS datadir
+FROM gp_segment_configuration s
+JOIN pg_filespace_entry e ON s.dbid = e.fsedbid
+JOIN pg_filespace f ON e.fsefsoid = f.oid
+WHERE f.fsname = 'pg_system'
+ORDER BY s.content, s.role DESC;`
+	}
+	segments := make([]SegmentConfig, 0)
+	err := connection.selectInto(&segments, query, connNum)
+	if err != nil {
+		return nil, err
+	}
+	return segments, nil
+}
+
+/*
+ * GetCoordinatorDataDir and GetStandbyDataDir return the data directory of the
+ * acting coordinator and of the standby coordinator, respectively.  If no
+ * standby is configured, GetStandbyDataDir returns an error.
+ */
+func GetCoordinatorDataDir(connection *DBConn, whichConn ...int) (string, error) {
+	coordinator, err := getCoordinatorSegment(connection, "p", whichConn...)
+	if err != nil {
+		return "", err
+	}
+	if coordinator == nil {
+		return "", errors.New("Unable to find the coordinator in gp_segment_configuration")
+	}
+	return coordinator.DataDir, nil
+}
+
+func GetStandbyDataDir(connection *DBConn, whichConn ...int) (string, error) {
+	standby, err := getCoordinatorSegment(connection, "m", whichConn...)
+	if err != nil {
+		return "", err
+	}
+	if standby == nil {
+		return "", errors.New("No standby coordinator is configured")
+	}
+	return standby.DataDir, nil
+}
+
+func getCoordinatorSegment(connection *DBConn, role string, whichConn ...int) (*SegmentConfig, error) {
+	segments, err := GetSegmentConfiguration(connection, whichConn...)
+	if err != nil {
+		return nil, err
+	}
+	for i := range segments {
+		if segments[i].ContentID == -1 && segments[i].Role == role {
+			return &segments[i], nil
+		}
+	}
+	return nil, nil
+}
+
+/*
+ * ListDatabases returns the names of the databases that allow connections, in
+ * alphabetical order.  Template databases are left out unless includeTemplates
+ * is true; template0 never allows connections, so it is never listed.
+ */
+func ListDatabases(connection *DBConn, includeTemplates bool, whichConn ...int) ([]string, error) {
+	connNum := connection.ValidateConnNum(whichConn...)
+	templateClause := "\n\tAND NOT datistemplate"
+	if includeTemplates {
+		templateClause = ""
+	}
+	query := fmt.Sprintf(`
+SELECT datname
+FROM pg_database
+WHERE datallowconn%s
+ORDER BY datname;`, templateClause)
+	databases := make([]string, 0)
+	err := connection.selectInto(&databases, query, connNum)
+	if err != nil {
+		return nil, err
+	}
+	return databases, nil
+}
+
+/*
+ * SessionInfo describes the server session behind one pool connection.
+ * ServerVersion is the PostgreSQL version the server is based on, e.g.
+ * "9.4.26"; the GPDB version is in DBConn.Version.
+ */
+type SessionInfo struct {
+	BackendPID      int
+	CurrentUser     string
+	CurrentDatabase string
+	ServerVersion   string
+}
+
+/*
+ * GetSessionInfo returns the backend PID, user, database, and server version
+ * of a pool connection, e.g. for logging or for terminating the connection's
+ * own backend.  The information is queried the first time it is requested for
+ * each connection and cached until the connection is closed or replaced by
+ * reconnecting.
+ */
+func (dbconn *DBConn) GetSessionInfo(whichConn ...int) (SessionInfo, error) {
+	connNum := dbconn.ValidateConnNum(whichConn...)
+	if dbconn.sessionInfo == nil {
+		dbconn.sessionInfo = make([]*SessionInfo, dbconn.NumConns)
+	}
+	unlock := dbconn.lockConn(connNum)
+	cached := dbconn.sessionInfo[connNum]
+	unlock()
+	if cached != nil {
+		return *cached, nil
+	}
+	query := `
+SELECT
+	pg_backend_pid() AS backendpid,
+	current_user AS currentuser,
+	current_database() AS currentdatabase,
+	current_setting('server_version') AS serverversion;`
+	var info SessionInfo
+	err := dbconn.get(&info, query, connNum)
+	if err != nil {
+		return SessionInfo{}, err
+	}
+	unlock = dbconn.lockConn(connNum)
+	dbconn.sessionInfo[connNum] = &info
+	unlock()
+	return info, nil
+}
+
+/*
+ * GetRole returns the role the server reports for a pool connection:
+ * "dispatch" for a normal connection to the coordinator, "utility" for a
+ * utility mode connection, or "execute" for a segment executor.  The role is
+ * read from gp_role in GPDB 7 and later and from gp_session_role in earlier
+ * versions, and is cached per connection in the same way as GetSessionInfo.
+ */
+func (dbconn *DBConn) GetRole(whichConn ...int) (string, error) {
+	connNum := dbconn.ValidateConnNum(whichConn...)
+	if dbconn.roles == nil {
+		dbconn.roles = make([]string, dbconn.NumConns)
+	}
+	unlock := dbconn.lockConn(connNum)
+	cached := dbconn.roles[connNum]
+	unlock()
+	if cached != "" {
+		return cached, nil
+	}
+	guc := "gp_session_role"
+	if dbconn.Version.AtLeast("7") {
+		guc = "gp_role"
+	}
+	var role string
+	err := dbconn.get(&role, fmt.Sprintf("SELECT current_setting('%s');", guc), connNum)
+	if err != nil {
+		return "", err
+	}
+	unlock = dbconn.lockConn(connNum)
+	dbconn.roles[connNum] = role
+	unlock()
+	return role, nil
+}
+
+/*
+ * IsUtilityMode returns whether a pool connection is in utility mode.  It
+ * returns false if the role cannot be determined.
+ */
+func (dbconn *DBConn) IsUtilityMode(whichConn ...int) bool {
+	role, err := dbconn.GetRole(whichConn...)
+	return err == nil && role == "utility"
+}
@@ -0,0 +1,485 @@
+package dbconn_test
+
+import (
+	"database/sql/driver"
+	"regexp"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/cloudberrydb/gp-common-go-libs/dbconn"
+	"github.com/cloudberrydb/gp-common-go-libs/testhelper"
+	"github.com/jackc/pgconn"
+	"github.com/pkg/errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("dbconn/catalog tests", func() {
+	Describe("DBConn.GetTablespaces", func() {
+		tablespaceHeader := []string{"oid", "name", "location"}
+		segmentHeader := []string{"oid", "contentid", "location"}
+
+		It("returns tablespaces with their per-segment locations in GPDB 6", func() {
+			testhelper.SetDBVersion(connection, "6.20.0")
+			tablespaceRows := sqlmock.NewRows(tablespaceHeader).
+				AddRow(1663, "pg_default", "").
+				AddRow(1664, "pg_global", "").
+				AddRow(16385, "fast_disk", "/data/fast")
+			segmentRows := sqlmock.NewRows(segmentHeader).
+				AddRow(16385, 0, "/data/fast").
+				AddRow(16385, 1, "/data/fast1")
+			mock.ExpectQuery("SELECT (.*) FROM pg_tablespace t\\s+ORDER BY").WillReturnRows(tablespaceRows)
+			mock.ExpectQuery("SELECT (.*) gp_tablespace_location(.*)").WillReturnRows(segmentRows)
+
+			tablespaces, err := connection.GetTablespaces()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tablespaces).To(Equal([]dbconn.TablespaceInfo{
+				{Oid: 1663, Name: "pg_default", Location: "", SegmentLocations: map[int]string{}},
+				{Oid: 1664, Name: "pg_global", Location: "", SegmentLocations: map[int]string{}},
+				{Oid: 16385, Name: "fast_disk", Location: "/data/fast", SegmentLocations: map[int]string{0: "/data/fast", 1: "/data/fast1"}},
+			}))
+		})
+		It("returns filespace locations before GPDB 6", func() {
+			testhelper.SetDBVersion(connection, "5.28.0")
+			tablespaceRows := sqlmock.NewRows(tablespaceHeader).
+				AddRow(1663, "pg_default", "").
+				AddRow(16385, "fast_disk", "/data/fs/gpseg-1")
+			segmentRows := sqlmock.NewRows(segmentHeader).
+				AddRow(16385, 0, "/data/fs/gpseg0")
+			mock.ExpectQuery("SELECT (.*) pg_filespace_entry (.*)").WillReturnRows(tablespaceRows)
+			mock.ExpectQuery("SELECT (.*) pg_filespace_entry (.*) gp_segment_configuration (.*)").WillReturnRows(segmentRows)
+
+			tablespaces, err := connection.GetTablespaces()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tablespaces).To(Equal([]dbconn.TablespaceInfo{
+				{Oid: 1663, Name: "pg_default", Location: "", SegmentLocations: map[int]string{}},
+				{Oid: 16385, Name: "fast_disk", Location: "/data/fs/gpseg-1", SegmentLocations: map[int]string{0: "/data/fs/gpseg0"}},
+			}))
+		})
+		It("returns an error if the tablespace query fails", func() {
+			mock.ExpectQuery("SELECT (.*)").WillReturnError(errors.New("permission denied"))
+			_, err := connection.GetTablespaces()
+			Expect(err).To(MatchError("permission denied"))
+		})
+		It("returns an error if a segment location has no matching tablespace", func() {
+			testhelper.SetDBVersion(connection, "6.20.0")
+			mock.ExpectQuery("SELECT (.*)").WillReturnRows(sqlmock.NewRows(tablespaceHeader).AddRow(1663, "pg_default", ""))
+			mock.ExpectQuery("SELECT (.*)").WillReturnRows(sqlmock.NewRows(segmentHeader).AddRow(16385, 0, "/data/fast"))
+			_, err := connection.GetTablespaces()
+			Expect(err).To(MatchError("Found a segment location for tablespace with OID 16385, which does not exist"))
+		})
+	})
+	Describe("DBConn.GetTableBloat", func() {
+		header := []string{"relpages", "expectedpages", "livetuples", "deadtuples", "blocksize"}
+
+		It("estimates bloat from gp_toolkit", func() {
+			testhelper.SetDBVersion(connection, "6.20.0")
+			fakeResult := sqlmock.NewRows(header).AddRow(150, 100, 10000.0, 0, 32768)
+			mock.ExpectQuery(regexp.QuoteMeta("FROM gp_toolkit.gp_bloat_expected_pages")).WillReturnRows(fakeResult)
+
+			bloat, err := connection.GetTableBloat("public", "foo")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(bloat).To(Equal(&dbconn.BloatInfo{Schema: "public", Table: "foo", RelPages: 150, ExpectedPages: 100, DeadTuples: 5000, WastedBytes: 50 * 32768}))
+		})
+		It("reports no bloat if a table uses no more pages than expected", func() {
+			testhelper.SetDBVersion(connection, "6.20.0")
+			fakeResult := sqlmock.NewRows(header).AddRow(90, 100, 10000.0, 0, 32768)
+			mock.ExpectQuery("SELECT (.*)").WillReturnRows(fakeResult)
+
+			bloat, err := connection.GetTableBloat("public", "foo")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(bloat.DeadTuples).To(Equal(int64(0)))
+			Expect(bloat.WastedBytes).To(Equal(int64(0)))
+		})
+		It("quotes the schema and table names", func() {
+			testhelper.SetDBVersion(connection, "5.28.0")
+			fakeResult := sqlmock.NewRows(header).AddRow(1, 1, 1.0, 0, 32768)
+			mock.ExpectQuery(regexp.QuoteMeta(`WHERE n.nspname = E'it''s'
+	AND c.relname = E'back\\slash'`)).WillReturnRows(fakeResult)
+
+			_, err := connection.GetTableBloat("it's", `back\slash`)
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("uses gp_toolkit in GPDB 7 if the extension is installed", func() {
+			testhelper.SetDBVersion(connection, "7.0.0")
+			mock.ExpectQuery("SELECT extname FROM pg_extension (.*)").WillReturnRows(sqlmock.NewRows([]string{"extname"}).AddRow("gp_toolkit"))
+			fakeResult := sqlmock.NewRows(header).AddRow(150, 100, 10000.0, 0, 32768)
+			mock.ExpectQuery(regexp.QuoteMeta("FROM gp_toolkit.gp_bloat_expected_pages")).WillReturnRows(fakeResult)
+
+			bloat, err := connection.GetTableBloat("public", "foo")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(bloat.DeadTuples).To(Equal(int64(5000)))
+		})
+		It("falls back to pg_stat_all_tables in GPDB 7 if gp_toolkit is not installed", func() {
+			testhelper.SetDBVersion(connection, "7.0.0")
+			mock.ExpectQuery("SELECT extname FROM pg_extension (.*)").WillReturnRows(sqlmock.NewRows([]string{"extname"}))
+			fakeResult := sqlmock.NewRows(header).AddRow(200, 0, 7500.0, 2500.0, 32768)
+			mock.ExpectQuery(regexp.QuoteMeta("FROM pg_stat_all_tables")).WillReturnRows(fakeResult)
+
+			bloat, err := connection.GetTableBloat("public", "foo")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(bloat).To(Equal(&dbconn.BloatInfo{Schema: "public", Table: "foo", RelPages: 200, ExpectedPages: 150, DeadTuples: 2500, WastedBytes: 50 * 32768}))
+		})
+		It("returns an error if the table has no statistics", func() {
+			mock.ExpectQuery("SELECT (.*)").WillReturnRows(sqlmock.NewRows(header))
+			_, err := connection.GetTableBloat("public", "foo")
+			Expect(err).To(MatchError("Unable to find bloat statistics for table public.foo"))
+		})
+	})
+	Describe("DBConn.GetCurrentWALPosition", func() {
+		It("uses pg_current_wal_lsn in GPDB 7", func() {
+			testhelper.SetDBVersion(connection, "7.0.0")
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT pg_current_wal_lsn()::text AS lsn")).WillReturnRows(sqlmock.NewRows([]string{"lsn"}).AddRow("0/16B3748"))
+			lsn, err := connection.GetCurrentWALPosition()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(lsn).To(Equal("0/16B3748"))
+		})
+		It("uses pg_current_xlog_location before GPDB 7", func() {
+			testhelper.SetDBVersion(connection, "6.20.0")
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT pg_current_xlog_location()::text AS lsn")).WillReturnRows(sqlmock.NewRows([]string{"lsn"}).AddRow("0/C0000D8"))
+			lsn, err := connection.GetCurrentWALPosition()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(lsn).To(Equal("0/C0000D8"))
+		})
+		It("returns an error if the query fails", func() {
+			mock.ExpectQuery("SELECT (.*)").WillReturnError(errors.New("recovery is in progress"))
+			_, err := connection.GetCurrentWALPosition()
+			Expect(err).To(MatchError("recovery is in progress"))
+		})
+	})
+	Describe("DBConn.ProbeCapabilities", func() {
+		roleHeader := []string{"issuperuser", "cancreatetemptables"}
+		It("summarizes the capabilities of the connected role", func() {
+			testhelper.SetDBVersion(connection, "6.20.0")
+			mock.ExpectQuery("SELECT (.*) has_database_privilege(.*)").WillReturnRows(sqlmock.NewRows(roleHeader).AddRow(true, true))
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT extname FROM pg_extension ORDER BY extname")).WillReturnRows(sqlmock.NewRows([]string{"extname"}).AddRow("gp_toolkit").AddRow("plpgsql"))
+
+			capabilities, err := connection.ProbeCapabilities()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(capabilities).To(Equal(&dbconn.Capabilities{
+				Version:             dbconn.NewVersion("6.20.0"),
+				IsSuperuser:         true,
+				CanCreateTempTables: true,
+				Extensions:          []string{"gp_toolkit", "plpgsql"},
+			}))
+		})
+		It("reports a role without superuser or temporary table privileges", func() {
+			testhelper.SetDBVersion(connection, "6.20.0")
+			mock.ExpectQuery("SELECT (.*)").WillReturnRows(sqlmock.NewRows(roleHeader).AddRow(false, false))
+			mock.ExpectQuery("SELECT extname (.*)").WillReturnRows(sqlmock.NewRows([]string{"extname"}))
+
+			capabilities, err := connection.ProbeCapabilities()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(capabilities.IsSuperuser).To(BeFalse())
+			Expect(capabilities.CanCreateTempTables).To(BeFalse())
+			Expect(capabilities.Extensions).To(BeEmpty())
+		})
+		It("does not query extensions before GPDB 6", func() {
+			testhelper.SetDBVersion(connection, "5.28.0")
+			mock.ExpectQuery("SELECT (.*)").WillReturnRows(sqlmock.NewRows(roleHeader).AddRow(true, true))
+
+			capabilities, err := connection.ProbeCapabilities()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(capabilities.Extensions).To(BeEmpty())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("returns an error if a query fails", func() {
+			mock.ExpectQuery("SELECT (.*)").WillReturnError(errors.New("permission denied"))
+			_, err := connection.ProbeCapabilities()
+			Expect(err).To(MatchError("permission denied"))
+		})
+	})
+	Describe("DBConn.GetStandbyStatus", func() {
+		standbyHeader := []string{"hostname", "port", "status", "state", "syncstate"}
+		It("reports a configured standby that is synchronized", func() {
+			mock.ExpectQuery("SELECT (.*) FROM gp_segment_configuration c\\s+LEFT JOIN pg_stat_replication (.*)").
+				WillReturnRows(sqlmock.NewRows(standbyHeader).AddRow("sdw1", 5432, "u", "streaming", "sync"))
+
+			standby, err := connection.GetStandbyStatus()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(standby).To(Equal(&dbconn.StandbyInfo{
+				Configured:   true,
+				Hostname:     "sdw1",
+				Port:         5432,
+				Status:       "u",
+				State:        "streaming",
+				SyncState:    "sync",
+				Synchronized: true,
+			}))
+		})
+		It("reports a configured standby that is not synchronized", func() {
+			mock.ExpectQuery("SELECT (.*)").WillReturnRows(sqlmock.NewRows(standbyHeader).AddRow("sdw1", 5432, "u", "catchup", "async"))
+
+			standby, err := connection.GetStandbyStatus()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(standby.Configured).To(BeTrue())
+			Expect(standby.State).To(Equal("catchup"))
+			Expect(standby.Synchronized).To(BeFalse())
+		})
+		It("reports a configured standby that is not connected", func() {
+			mock.ExpectQuery("SELECT (.*)").WillReturnRows(sqlmock.NewRows(standbyHeader).AddRow("sdw1", 5432, "d", "", ""))
+
+			standby, err := connection.GetStandbyStatus()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(standby.Configured).To(BeTrue())
+			Expect(standby.Status).To(Equal("d"))
+			Expect(standby.Synchronized).To(BeFalse())
+		})
+		It("reports that no standby is configured", func() {
+			mock.ExpectQuery("SELECT (.*)").WillReturnRows(sqlmock.NewRows(standbyHeader))
+
+			standby, err := connection.GetStandbyStatus()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(standby).To(Equal(&dbconn.StandbyInfo{Configured: false}))
+		})
+		It("returns an error if the query fails", func() {
+			mock.ExpectQuery("SELECT (.*)").WillReturnError(errors.New("permission denied"))
+			_, err := connection.GetStandbyStatus()
+			Expect(err).To(MatchError("permission denied"))
+		})
+	})
+	Describe("GetSegmentConfiguration", func() {
+		header := []string{"dbid", "contentid", "role", "preferredrole", "hostname", "address", "port", "datadir"}
+		expectedSegments := []dbconn.SegmentConfig{
+			{DbID: 1, ContentID: -1, Role: "p", PreferredRole: "p", Hostname: "cdw", Address: "cdw", Port: 5432, DataDir: "/data/coordinator/gpseg-1"},
+			{DbID: 6, ContentID: -1, Role: "m", PreferredRole: "m", Hostname: "scdw", Address: "scdw", Port: 5432, DataDir: "/data/coordinator/gpseg-1"},
+			{DbID: 2, ContentID: 0, Role: "p", PreferredRole: "p", Hostname: "sdw1", Address: "sdw1-1", Port: 20000, DataDir: "/data/primary/gpseg0"},
+			{DbID: 4, ContentID: 0, Role: "m", PreferredRole: "m", Hostname: "sdw2", Address: "sdw2-1", Port: 21000, DataDir: "/data/mirror/gpseg0"},
+		}
+		segmentRows := func() *sqlmock.Rows {
+			rows := sqlmock.NewRows(header)
+			for _, segment := range expectedSegments {
+				rows.AddRow(segment.DbID, segment.ContentID, segment.Role, segment.PreferredRole, segment.Hostname, segment.Address, segment.Port, segment.DataDir)
+			}
+			return rows
+		}
+
+		It("reads the data directories from gp_segment_configuration in GPDB 6 and later", func() {
+			testhelper.SetDBVersion(connection, "6.20.0")
+			mock.ExpectQuery(`SELECT (.*) datadir\s+FROM gp_segment_configuration\s+ORDER BY`).WillReturnRows(segmentRows())
+
+			segments, err := dbconn.GetSegmentConfiguration(connection)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(segments).To(Equal(expectedSegments))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("reads the data directories from the pg_system filespace before GPDB 6", func() {
+			testhelper.SetDBVersion(connection, "5.28.0")
+			mock.ExpectQuery(`SELECT (.*) e.fselocation AS datadir (.*) JOIN pg_filespace_entry (.*) WHERE f.fsname = 'pg_system'`).WillReturnRows(segmentRows())
+
+			segments, err := dbconn.GetSegmentConfiguration(connection)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(segments).To(Equal(expectedSegments))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("returns an error if the query fails", func() {
+			mock.ExpectQuery("SELECT (.*)").WillReturnError(errors.New("permission denied"))
+			_, err := dbconn.GetSegmentConfiguration(connection)
+			Expect(err).To(MatchError("permission denied"))
+		})
+	})
+	Describe("GetCoordinatorDataDir and GetStandbyDataDir", func() {
+		header := []string{"dbid", "contentid", "role", "preferredrole", "hostname", "address", "port", "datadir"}
+		coordinatorRow := []driver.Value{1, -1, "p", "p", "cdw", "cdw", 5432, "/data/coordinator/gpseg-1"}
+		standbyRow := []driver.Value{6, -1, "m", "m", "scdw", "scdw", 5432, "/data/standby/gpseg-1"}
+		primaryRow := []driver.Value{2, 0, "p", "p", "sdw1", "sdw1", 20000, "/data/primary/gpseg0"}
+
+		Context("when a standby is configured", func() {
+			BeforeEach(func() {
+				mock.ExpectQuery("SELECT (.*) FROM gp_segment_configuration").
+					WillReturnRows(sqlmock.NewRows(header).AddRow(coordinatorRow...).AddRow(standbyRow...).AddRow(primaryRow...))
+			})
+			It("returns the coordinator data directory", func() {
+				dataDir, err := dbconn.GetCoordinatorDataDir(connection)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(dataDir).To(Equal("/data/coordinator/gpseg-1"))
+			})
+			It("returns the standby data directory", func() {
+				dataDir, err := dbconn.GetStandbyDataDir(connection)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(dataDir).To(Equal("/data/standby/gpseg-1"))
+			})
+		})
+		Context("when no standby is configured", func() {
+			BeforeEach(func() {
+				mock.ExpectQuery("SELECT (.*) FROM gp_segment_configuration").
+					WillReturnRows(sqlmock.NewRows(header).AddRow(coordinatorRow...).AddRow(primaryRow...))
+			})
+			It("returns the coordinator data directory", func() {
+				dataDir, err := dbconn.GetCoordinatorDataDir(connection)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(dataDir).To(Equal("/data/coordinator/gpseg-1"))
+			})
+			It("returns an error for the standby data directory", func() {
+				dataDir, err := dbconn.GetStandbyDataDir(connection)
+				Expect(err).To(MatchError("No standby coordinator is configured"))
+				Expect(dataDir).To(Equal(""))
+			})
+		})
+		It("uses the filespace locations before GPDB 6", func() {
+			testhelper.SetDBVersion(connection, "5.28.0")
+			mock.ExpectQuery("SELECT (.*) pg_filespace_entry (.*)").
+				WillReturnRows(sqlmock.NewRows(header).AddRow(coordinatorRow...))
+
+			dataDir, err := dbconn.GetCoordinatorDataDir(connection)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dataDir).To(Equal("/data/coordinator/gpseg-1"))
+		})
+		It("returns an error if the coordinator is not listed", func() {
+			mock.ExpectQuery("SELECT (.*)").WillReturnRows(sqlmock.NewRows(header).AddRow(primaryRow...))
+			_, err := dbconn.GetCoordinatorDataDir(connection)
+			Expect(err).To(MatchError("Unable to find the coordinator in gp_segment_configuration"))
+		})
+		It("returns an error if the query fails", func() {
+			mock.ExpectQuery("SELECT (.*)").WillReturnError(errors.New("permission denied"))
+			_, err := dbconn.GetStandbyDataDir(connection)
+			Expect(err).To(MatchError("permission denied"))
+		})
+	})
+	Describe("ListDatabases", func() {
+		It("excludes template databases", func() {
+			mock.ExpectQuery(regexp.QuoteMeta("WHERE datallowconn\n\tAND NOT datistemplate\nORDER BY datname")).
+				WillReturnRows(sqlmock.NewRows([]string{"datname"}).AddRow("postgres").AddRow("sales"))
+
+			databases, err := dbconn.ListDatabases(connection, false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(databases).To(Equal([]string{"postgres", "sales"}))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("includes template databases if requested", func() {
+			mock.ExpectQuery(regexp.QuoteMeta("WHERE datallowconn\nORDER BY datname")).
+				WillReturnRows(sqlmock.NewRows([]string{"datname"}).AddRow("postgres").AddRow("sales").AddRow("template1"))
+
+			databases, err := dbconn.ListDatabases(connection, true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(databases).To(Equal([]string{"postgres", "sales", "template1"}))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("returns an error if the query fails", func() {
+			mock.ExpectQuery("SELECT (.*)").WillReturnError(errors.New("permission denied"))
+			_, err := dbconn.ListDatabases(connection, false)
+			Expect(err).To(MatchError("permission denied"))
+		})
+	})
+	Describe("DBConn.GetSessionInfo", func() {
+		header := []string{"backendpid", "currentuser", "currentdatabase", "serverversion"}
+		expectSessionQuery := func(mock sqlmock.Sqlmock, pid int) {
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT pg_backend_pid() AS backendpid")).
+				WillReturnRows(sqlmock.NewRows(header).AddRow(pid, "testrole", "testdb", "9.4.26"))
+		}
+
+		It("returns the session info of the connection", func() {
+			expectSessionQuery(mock, 12345)
+
+			info, err := connection.GetSessionInfo()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(info).To(Equal(dbconn.SessionInfo{BackendPID: 12345, CurrentUser: "testrole", CurrentDatabase: "testdb", ServerVersion: "9.4.26"}))
+		})
+		It("queries the session info only once per connection", func() {
+			expectSessionQuery(mock, 12345)
+
+			first, err := connection.GetSessionInfo()
+			Expect(err).ToNot(HaveOccurred())
+			second, err := connection.GetSessionInfo()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(second).To(Equal(first))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("returns the session info of each pool connection", func() {
+			multiConn, mocks := testhelper.CreateAndConnectMockDBWithMocks(2)
+			defer multiConn.Close()
+			expectSessionQuery(mocks[0], 100)
+			expectSessionQuery(mocks[1], 200)
+
+			first, err := multiConn.GetSessionInfo(0)
+			Expect(err).ToNot(HaveOccurred())
+			second, err := multiConn.GetSessionInfo(1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(first.BackendPID).To(Equal(100))
+			Expect(second.BackendPID).To(Equal(200))
+			first, _ = multiConn.GetSessionInfo(0)
+			Expect(first.BackendPID).To(Equal(100))
+		})
+		It("queries the session info again after reconnecting", func() {
+			multiConn, mocks := testhelper.CreateMockDBConnWithMocks(2)
+			multiConn.MustConnect(1)
+			defer multiConn.Close()
+			expectSessionQuery(mocks[0], 100)
+			mocks[0].ExpectExec("SELECT 1").WillReturnError(&pgconn.PgError{Severity: "FATAL", Code: "57P01", Message: "terminating connection due to administrator command"})
+			mocks[1].ExpectExec("SELECT 1").WillReturnResult(testhelper.TestResult{})
+			expectSessionQuery(mocks[1], 200)
+			_, err := multiConn.GetSessionInfo()
+			Expect(err).ToNot(HaveOccurred())
+
+			multiConn.EnableAutoReconnect()
+			_, err = multiConn.Exec("SELECT 1")
+			Expect(err).ToNot(HaveOccurred())
+
+			info, err := multiConn.GetSessionInfo()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(info.BackendPID).To(Equal(200))
+		})
+		It("returns an error if the query fails and does not cache it", func() {
+			mock.ExpectQuery("SELECT (.*)").WillReturnError(errors.New("connection reset"))
+			expectSessionQuery(mock, 12345)
+
+			_, err := connection.GetSessionInfo()
+			Expect(err).To(MatchError("connection reset"))
+			info, err := connection.GetSessionInfo()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(info.BackendPID).To(Equal(12345))
+		})
+	})
+	Describe("DBConn.GetRole and IsUtilityMode", func() {
+		It("reads gp_session_role before GPDB 7", func() {
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT current_setting('gp_session_role');")).
+				WillReturnRows(sqlmock.NewRows([]string{"current_setting"}).AddRow("dispatch"))
+
+			role, err := connection.GetRole()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(role).To(Equal("dispatch"))
+			Expect(connection.IsUtilityMode()).To(BeFalse())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("reads gp_role in GPDB 7 and later", func() {
+			testhelper.SetDBVersion(connection, "7.0.0")
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT current_setting('gp_role');")).
+				WillReturnRows(sqlmock.NewRows([]string{"current_setting"}).AddRow("utility"))
+
+			role, err := connection.GetRole()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(role).To(Equal("utility"))
+			Expect(connection.IsUtilityMode()).To(BeTrue())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("caches the role of each pool connection", func() {
+			multiConn, mocks := testhelper.CreateAndConnectMockDBWithMocks(2)
+			defer multiConn.Close()
+			mocks[0].ExpectQuery("SELECT current_setting(.*)").
+				WillReturnRows(sqlmock.NewRows([]string{"current_setting"}).AddRow("dispatch"))
+			mocks[1].ExpectQuery("SELECT current_setting(.*)").
+				WillReturnRows(sqlmock.NewRows([]string{"current_setting"}).AddRow("utility"))
+
+			Expect(multiConn.IsUtilityMode(0)).To(BeFalse())
+			Expect(multiConn.IsUtilityMode(1)).To(BeTrue())
+			Expect(multiConn.IsUtilityMode(0)).To(BeFalse())
+			Expect(multiConn.IsUtilityMode(1)).To(BeTrue())
+			Expect(mocks[0].ExpectationsWereMet()).To(Succeed())
+			Expect(mocks[1].ExpectationsWereMet()).To(Succeed())
+		})
+		It("returns an error if the query fails and does not cache it", func() {
+			mock.ExpectQuery("SELECT current_setting(.*)").WillReturnError(errors.New("connection reset"))
+			mock.ExpectQuery("SELECT current_setting(.*)").
+				WillReturnRows(sqlmock.NewRows([]string{"current_setting"}).AddRow("utility"))
+
+			_, err := connection.GetRole()
+			Expect(err).To(MatchError("connection reset"))
+			Expect(connection.IsUtilityMode()).To(BeTrue())
+		})
+		It("reports that a connection is not in utility mode if the role cannot be determined", func() {
+			mock.ExpectQuery("SELECT current_setting(.*)").WillReturnError(errors.New("connection reset"))
+
+			Expect(connection.IsUtilityMode()).To(BeFalse())
+		})
+	})
+})
@@ -8,9 +8,14 @@ package dbconn
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
+	"net/url"
+	"reflect"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/cloudberrydb/gp-common-go-libs/gplog"
 	"github.com/cloudberrydb/gp-common-go-libs/operating"
@@ -51,6 +56,33 @@ type DBConn struct {
 	Port     int
 	Tx       []*sqlx.Tx
 	Version  GPDBVersion
+
+	connParams  map[string]string
+	sslMode     string
+	sslRootCert string
+	sslCert     string
+	sslKey      string
+	password    string
+	connStr     string
+	freeConns   chan int
+	connLocks   []chan struct{}
+	listeners   map[string]*activeListener
+	encoding    string
+	timeZone    string
+
+	queryTransformer  func(query string) string
+	preparedStmts     []map[string]*sqlx.Stmt
+	autoConnSelection bool
+	resultCache       map[string]cachedResult
+	resultCacheTTL    time.Duration
+	resultCacheLock   sync.Mutex
+	dryRun            bool
+	autoReconnect     bool
+	sessionGUCs       map[string]string
+	sessionInfo       []*SessionInfo
+	roles             []string
+	keepAlive         *keepAlive
+	queryTiming       *queryTiming
 }
 
 /*
@@ -61,6 +93,10 @@ type DBDriver interface {
 	Connect(driverName string, dataSourceName string) (*sqlx.DB, error)
 }
 
+/*
+ * GPDBDriver connects through the pgx database/sql driver, which all DBConns
+ * use unless constructed with NewDBConnWithDriver.
+ */
 type GPDBDriver struct {
 }
 
@@ -68,6 +104,20 @@ func (driver *GPDBDriver) Connect(driverName string, dataSourceName string) (*sq
 	return sqlx.Connect(driverName, dataSourceName)
 }
 
+/*
+ * A DBDriver that also implements ContextDBDriver can abandon a connection
+ * attempt when its context is cancelled.  ConnectContext still honors the
+ * context with other drivers, but their attempts run to completion in the
+ * background, and any connection they establish afterwards is closed.
+ */
+type ContextDBDriver interface {
+	ConnectContext(ctx context.Context, driverName string, dataSourceName string) (*sqlx.DB, error)
+}
+
+func (driver *GPDBDriver) ConnectContext(ctx context.Context, driverName string, dataSourceName string) (*sqlx.DB, error) {
+	return sqlx.ConnectContext(ctx, driverName, dataSourceName)
+}
+
 /*
  * Database functions
  */
@@ -94,6 +144,19 @@ func NewDBConnFromEnvironment(dbname string) *DBConn {
 	return NewDBConn(dbname, username, host, port)
 }
 
+/*
+ * NewDBConnWithDriver reads the connection information from the environment
+ * in the same way as NewDBConnFromEnvironment, but connects through the given
+ * driver instead of the default GPDBDriver.  The query functions on DBConn
+ * only use the returned *sqlx.DB, so callers do not need to change anything
+ * else to use a different driver.
+ */
+func NewDBConnWithDriver(dbname string, driver DBDriver) *DBConn {
+	dbconn := NewDBConnFromEnvironment(dbname)
+	dbconn.Driver = driver
+	return dbconn
+}
+
 func NewDBConn(dbname, username, host string, port int) *DBConn {
 	if dbname == "" {
 		gplog.Fatal(errors.New("No database provided"), "")
@@ -120,6 +183,62 @@ func NewDBConn(dbname, username, host string, port int) *DBConn {
 	}
 }
 
+/*
+ * NewDBConnFromEnvironment takes the user, host, and port from PGUSER, PGHOST,
+ * and PGPORT when the DBConn is created.  These setters override those values
+ * for contexts in which the environment cannot be changed, and take effect on
+ * the next call to Connect.
+ */
+func (dbconn *DBConn) SetUser(username string) {
+	dbconn.User = username
+}
+
+func (dbconn *DBConn) SetHost(host string) {
+	dbconn.Host = host
+}
+
+func (dbconn *DBConn) SetPort(port int) {
+	dbconn.Port = port
+}
+
+/*
+ * Clone returns an unconnected DBConn with the same connection information,
+ * driver, and options as this one, such as SSL settings, connection
+ * parameters, and session GUCs, so that a worker can open its own pool to the
+ * same database.  Results cached by SelectCached are not shared with the
+ * clone, though its cache is enabled if this DBConn's is.
+ */
+func (dbconn *DBConn) Clone() *DBConn {
+	clone := NewDBConn(dbconn.DBName, dbconn.User, dbconn.Host, dbconn.Port)
+	clone.Driver = dbconn.Driver
+	if dbconn.connParams != nil {
+		clone.connParams = make(map[string]string, len(dbconn.connParams))
+		for key, value := range dbconn.connParams {
+			clone.connParams[key] = value
+		}
+	}
+	clone.sslMode = dbconn.sslMode
+	clone.sslRootCert = dbconn.sslRootCert
+	clone.sslCert = dbconn.sslCert
+	clone.sslKey = dbconn.sslKey
+	clone.password = dbconn.password
+	clone.queryTransformer = dbconn.queryTransformer
+	clone.queryTiming = dbconn.queryTiming
+	clone.autoConnSelection = dbconn.autoConnSelection
+	clone.dryRun = dbconn.dryRun
+	clone.autoReconnect = dbconn.autoReconnect
+	if dbconn.sessionGUCs != nil {
+		clone.SetSessionGUCs(dbconn.sessionGUCs)
+	}
+	dbconn.resultCacheLock.Lock()
+	if dbconn.resultCache != nil {
+		clone.resultCacheTTL = dbconn.resultCacheTTL
+		clone.resultCache = make(map[string]cachedResult)
+	}
+	dbconn.resultCacheLock.Unlock()
+	return clone
+}
+
 func (dbconn *DBConn) MustBegin(whichConn ...int) {
 	err := dbconn.Begin(whichConn...)
 	gplog.FatalOnError(err)
@@ -127,19 +246,30 @@ func (dbconn *DBConn) MustBegin(whichConn ...int) {
 
 func (dbconn *DBConn) Begin(whichConn ...int) error {
 	connNum := dbconn.ValidateConnNum(whichConn...)
+	err := dbconn.beginTx(connNum)
+	if err != nil {
+		return err
+	}
+	_, err = dbconn.exec("SET TRANSACTION ISOLATION LEVEL SERIALIZABLE", connNum)
+	return err
+}
+
+func (dbconn *DBConn) beginTx(connNum int) error {
+	unlock := dbconn.lockConn(connNum)
+	defer unlock()
 	if dbconn.Tx[connNum] != nil {
 		return errors.New("Cannot begin transaction; there is already a transaction in progress")
 	}
 	var err error
 	dbconn.Tx[connNum], err = dbconn.ConnPool[connNum].Beginx()
-	if err != nil {
-		return err
-	}
-	_, err = dbconn.Exec("SET TRANSACTION ISOLATION LEVEL SERIALIZABLE", connNum)
 	return err
 }
 
 func (dbconn *DBConn) Close() {
+	dbconn.StopKeepAlive()
+	dbconn.unlistenAll()
+	dbconn.closePreparedStatements()
+	dbconn.InvalidateCache()
 	if dbconn.ConnPool != nil {
 		for _, conn := range dbconn.ConnPool {
 			if conn != nil {
@@ -149,9 +279,112 @@ func (dbconn *DBConn) Close() {
 		dbconn.ConnPool = nil
 		dbconn.Tx = nil
 		dbconn.NumConns = 0
+		dbconn.freeConns = nil
+		dbconn.connLocks = nil
+		dbconn.encoding = ""
+		dbconn.timeZone = ""
+		dbconn.sessionInfo = nil
+		dbconn.roles = nil
 	}
 }
 
+/*
+ * Resize grows or shrinks the connection pool to newNumConns connections in
+ * place, keeping the existing connections and the DBConn's configuration.
+ * New connections use the same connection string as the existing ones,
+ * including utility mode, and have the GUCs set with SetSessionGUCs applied
+ * to them.  If any new connection cannot be established, the pool is left as
+ * it was, but if the GUCs cannot be applied, the error is returned after the
+ * pool has grown.  Surplus connections are closed, and Resize panics if any of them
+ * has a transaction in progress, since closing it would lose the transaction.
+ *
+ * Resize returns an error without changing the pool if any connection is
+ * leased through WithConnection or auto connection selection.
+ */
+func (dbconn *DBConn) Resize(newNumConns int) error {
+	if newNumConns < 1 {
+		return errors.Errorf("Must specify a connection pool size that is a positive integer")
+	}
+	if dbconn.ConnPool == nil {
+		return errors.Errorf("The database connection must be open to resize the connection pool")
+	}
+	if len(dbconn.freeConns) != dbconn.NumConns {
+		return errors.New("Cannot resize the connection pool; there are connections in use by WithConnection or auto connection selection")
+	}
+	oldNumConns := dbconn.NumConns
+	for connNum := newNumConns; connNum < oldNumConns; connNum++ {
+		if dbconn.Tx[connNum] != nil {
+			gplog.Fatal(errors.Errorf("Cannot remove connection %d from the pool; there is a transaction in progress", connNum), "")
+		}
+	}
+	if dbconn.keepAlive != nil {
+		interval := dbconn.keepAlive.interval
+		dbconn.StopKeepAlive()
+		defer dbconn.StartKeepAlive(interval)
+	}
+	if newNumConns > oldNumConns {
+		newConns := make([]*sqlx.DB, 0, newNumConns-oldNumConns)
+		for connNum := oldNumConns; connNum < newNumConns; connNum++ {
+			conn, err := dbconn.Driver.Connect("pgx", dbconn.connStr)
+			err = dbconn.handleConnectionError(err)
+			if err != nil {
+				for _, newConn := range newConns {
+					_ = newConn.Close()
+				}
+				return err
+			}
+			conn.SetMaxOpenConns(1)
+			conn.SetMaxIdleConns(1)
+			newConns = append(newConns, conn)
+		}
+		dbconn.ConnPool = append(dbconn.ConnPool, newConns...)
+		dbconn.Tx = append(dbconn.Tx, make([]*sqlx.Tx, len(newConns))...)
+		dbconn.connLocks = append(dbconn.connLocks, makeConnLocks(len(newConns))...)
+		if dbconn.preparedStmts != nil {
+			dbconn.preparedStmts = append(dbconn.preparedStmts, make([]map[string]*sqlx.Stmt, len(newConns))...)
+		}
+		if dbconn.sessionInfo != nil {
+			dbconn.sessionInfo = append(dbconn.sessionInfo, make([]*SessionInfo, len(newConns))...)
+		}
+		if dbconn.roles != nil {
+			dbconn.roles = append(dbconn.roles, make([]string, len(newConns))...)
+		}
+	} else {
+		for connNum := newNumConns; connNum < oldNumConns; connNum++ {
+			if dbconn.preparedStmts != nil {
+				for _, stmt := range dbconn.preparedStmts[connNum] {
+					_ = stmt.Close()
+				}
+			}
+			_ = dbconn.ConnPool[connNum].Close()
+		}
+		dbconn.ConnPool = dbconn.ConnPool[:newNumConns]
+		dbconn.Tx = dbconn.Tx[:newNumConns]
+		dbconn.connLocks = dbconn.connLocks[:newNumConns]
+		if dbconn.preparedStmts != nil {
+			dbconn.preparedStmts = dbconn.preparedStmts[:newNumConns]
+		}
+		if dbconn.sessionInfo != nil {
+			dbconn.sessionInfo = dbconn.sessionInfo[:newNumConns]
+		}
+		if dbconn.roles != nil {
+			dbconn.roles = dbconn.roles[:newNumConns]
+		}
+	}
+	dbconn.NumConns = newNumConns
+	dbconn.freeConns = make(chan int, newNumConns)
+	for i := 0; i < newNumConns; i++ {
+		dbconn.freeConns <- i
+	}
+	for connNum := oldNumConns; connNum < newNumConns; connNum++ {
+		err := dbconn.applySessionGUCs(connNum)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (dbconn *DBConn) MustCommit(whichConn ...int) {
 	err := dbconn.Commit(whichConn...)
 	gplog.FatalOnError(err)
@@ -159,6 +392,8 @@ func (dbconn *DBConn) MustCommit(whichConn ...int) {
 
 func (dbconn *DBConn) Commit(whichConn ...int) error {
 	connNum := dbconn.ValidateConnNum(whichConn...)
+	unlock := dbconn.lockConn(connNum)
+	defer unlock()
 	if dbconn.Tx[connNum] == nil {
 		return errors.New("Cannot commit transaction; there is no transaction in progress")
 	}
@@ -174,6 +409,8 @@ func (dbconn *DBConn) MustRollback(whichConn ...int) {
 
 func (dbconn *DBConn) Rollback(whichConn ...int) error {
 	connNum := dbconn.ValidateConnNum(whichConn...)
+	unlock := dbconn.lockConn(connNum)
+	defer unlock()
 	if dbconn.Tx[connNum] == nil {
 		return errors.New("Cannot rollback transaction; there is no transaction in progress")
 	}
@@ -182,26 +419,161 @@ func (dbconn *DBConn) Rollback(whichConn ...int) error {
 	return err
 }
 
+/*
+ * DeferConstraints and ImmediateConstraints set whether deferrable constraints,
+ * such as foreign keys declared DEFERRABLE, are checked at commit or after each
+ * statement for the rest of the transaction on the given connection, e.g. so
+ * that rows referring to each other can be loaded in any order.  They return
+ * an error if there is no transaction in progress, since SET CONSTRAINTS has
+ * no effect outside of one.
+ */
+func (dbconn *DBConn) DeferConstraints(whichConn ...int) error {
+	return dbconn.setConstraints("DEFERRED", whichConn...)
+}
+
+func (dbconn *DBConn) ImmediateConstraints(whichConn ...int) error {
+	return dbconn.setConstraints("IMMEDIATE", whichConn...)
+}
+
+func (dbconn *DBConn) setConstraints(mode string, whichConn ...int) error {
+	connNum := dbconn.ValidateConnNum(whichConn...)
+	unlock := dbconn.lockConn(connNum)
+	defer unlock()
+	if dbconn.Tx[connNum] == nil {
+		return errors.Errorf("Cannot set constraints %s; there is no transaction in progress", strings.ToLower(mode))
+	}
+	_, err := dbconn.Tx[connNum].Exec(fmt.Sprintf("SET CONSTRAINTS ALL %s", mode))
+	return err
+}
+
+/*
+ * BeginAll, CommitAll, and RollbackAll manage a transaction on every
+ * connection in the pool at once.  There is no two-phase commit, so if a
+ * commit fails partway through CommitAll then the connections already
+ * committed stay committed, but the transactions on the remaining connections
+ * are rolled back so that none is left open.
+ */
+func (dbconn *DBConn) MustBeginAll() {
+	err := dbconn.BeginAll()
+	gplog.FatalOnError(err)
+}
+
+func (dbconn *DBConn) BeginAll() error {
+	for connNum := 0; connNum < dbconn.NumConns; connNum++ {
+		err := dbconn.Begin(connNum)
+		if err != nil {
+			_ = dbconn.RollbackAll()
+			return errors.Wrapf(err, "Cannot begin transaction on connection %d", connNum)
+		}
+	}
+	return nil
+}
+
+func (dbconn *DBConn) MustCommitAll() {
+	err := dbconn.CommitAll()
+	gplog.FatalOnError(err)
+}
+
+func (dbconn *DBConn) CommitAll() error {
+	for connNum := 0; connNum < dbconn.NumConns; connNum++ {
+		err := dbconn.Commit(connNum)
+		if err != nil {
+			_ = dbconn.RollbackAll()
+			return errors.Wrapf(err, "Cannot commit transaction on connection %d", connNum)
+		}
+	}
+	return nil
+}
+
+func (dbconn *DBConn) MustRollbackAll() {
+	err := dbconn.RollbackAll()
+	gplog.FatalOnError(err)
+}
+
+/*
+ * RollbackAll rolls back every open transaction in the pool, skipping
+ * connections with no transaction in progress, and returns the first error.
+ */
+func (dbconn *DBConn) RollbackAll() error {
+	var firstErr error
+	for connNum := 0; connNum < dbconn.NumConns; connNum++ {
+		if dbconn.Tx[connNum] == nil {
+			continue
+		}
+		err := dbconn.Rollback(connNum)
+		if err != nil && firstErr == nil {
+			firstErr = errors.Wrapf(err, "Cannot rollback transaction on connection %d", connNum)
+		}
+	}
+	return firstErr
+}
+
+const retryBaseDelay = 100 * time.Millisecond
+
+/*
+ * WithRetryableTransaction runs fn inside a transaction on the first
+ * connection and commits it, rolling back if fn returns an error.  Since
+ * Begin uses SERIALIZABLE isolation, concurrent transactions may fail with a
+ * serialization failure or deadlock; in that case the whole transaction is
+ * run again, up to maxRetries more times with an exponentially increasing
+ * delay between attempts.  Any other error is returned immediately.
+ */
+func (dbconn *DBConn) WithRetryableTransaction(maxRetries int, fn func(*DBConn) error) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = dbconn.runTransaction(fn)
+		if err == nil || !isRetryableTransactionError(err) || attempt >= maxRetries {
+			return err
+		}
+		delay := retryBaseDelay << uint(attempt)
+		gplog.Verbose("Transaction failed with SQLSTATE %s, retrying in %v (attempt %d of %d)", GetSQLState(err), delay, attempt+1, maxRetries)
+		operating.System.Sleep(delay)
+	}
+}
+
+func (dbconn *DBConn) runTransaction(fn func(*DBConn) error) error {
+	err := dbconn.Begin()
+	if err != nil {
+		return err
+	}
+	err = fn(dbconn)
+	if err != nil {
+		_ = dbconn.Rollback()
+		return err
+	}
+	return dbconn.Commit()
+}
+
 func (dbconn *DBConn) MustConnect(numConns int) {
 	err := dbconn.Connect(numConns)
 	gplog.FatalOnError(err)
 }
 
 func (dbconn *DBConn) Connect(numConns int, utilityMode ...bool) error {
+	return dbconn.ConnectContext(context.Background(), numConns, utilityMode...)
+}
+
+/*
+ * ConnectContext is Connect for callers that need to give up on connecting,
+ * e.g. when an orchestrator shuts down.  If ctx is cancelled or its deadline
+ * passes before every connection in the pool is established, the connections
+ * established so far are closed, so that connecting can be tried again, and
+ * the context's error is returned.
+ */
+func (dbconn *DBConn) ConnectContext(ctx context.Context, numConns int, utilityMode ...bool) error {
 	if numConns < 1 {
 		return errors.Errorf("Must specify a connection pool size that is a positive integer")
 	}
 	if dbconn.ConnPool != nil {
 		return errors.Errorf("The database connection must be closed before reusing the connection")
 	}
-	// This string takes in the literal user/database names. They do not need
-	// to be escaped or quoted.
-	// By default pgx/v4 turns on automatic prepared statement caching. This
-	// causes an issue in GPDB4 where creating an object, deleting it, creating
-	// the same object again, then querying for the object in the same
-	// connection will generate a cache lookup failure. To disable pgx's
-	// automatic prepared statement cache we set statement_cache_capacity to 0.
-	connStr := fmt.Sprintf("postgres://%s@%s:%d/%s?sslmode=disable&statement_cache_capacity=0", dbconn.User, dbconn.Host, dbconn.Port, dbconn.DBName)
+	if mode, ok := dbconn.connParams["sslmode"]; ok {
+		err := validateSSLMode(mode)
+		if err != nil {
+			return err
+		}
+	}
+	connStr := dbconn.buildConnectionString()
 
 	dbconn.ConnPool = make([]*sqlx.DB, numConns)
 	if len(utilityMode) > 1 {
@@ -213,10 +585,14 @@ func (dbconn *DBConn) Connect(numConns int, utilityMode ...bool) error {
 		// we need to just try one first and see whether it works.
 		roleConnStr := connStr + "&gp_role=utility"
 		sessionRoleConnStr := connStr + "&gp_session_role=utility"
-		utilConn, err := dbconn.Driver.Connect("pgx", sessionRoleConnStr)
+		utilConn, err := dbconn.connectDriver(ctx, sessionRoleConnStr)
 		if utilConn != nil {
 			utilConn.Close()
 		}
+		if ctx.Err() != nil {
+			dbconn.abandonConnect()
+			return ctx.Err()
+		}
 		if err != nil {
 			if strings.Contains(err.Error(), `unrecognized configuration parameter "gp_session_role"`) {
 				connStr = roleConnStr
@@ -229,7 +605,14 @@ func (dbconn *DBConn) Connect(numConns int, utilityMode ...bool) error {
 	}
 
 	for i := 0; i < numConns; i++ {
-		conn, err := dbconn.Driver.Connect("pgx", connStr)
+		conn, err := dbconn.connectDriver(ctx, connStr)
+		if ctx.Err() != nil {
+			if conn != nil {
+				_ = conn.Close()
+			}
+			dbconn.abandonConnect()
+			return ctx.Err()
+		}
 		err = dbconn.handleConnectionError(err)
 		if err != nil {
 			return err
@@ -239,15 +622,236 @@ func (dbconn *DBConn) Connect(numConns int, utilityMode ...bool) error {
 		dbconn.ConnPool[i] = conn
 	}
 	dbconn.Tx = make([]*sqlx.Tx, numConns)
+	dbconn.connLocks = makeConnLocks(numConns)
+	dbconn.preparedStmts = make([]map[string]*sqlx.Stmt, numConns)
+	dbconn.sessionInfo = make([]*SessionInfo, numConns)
+	dbconn.roles = make([]string, numConns)
 	dbconn.NumConns = numConns
+	dbconn.connStr = connStr
+	dbconn.freeConns = make(chan int, numConns)
+	for i := 0; i < numConns; i++ {
+		dbconn.freeConns <- i
+	}
 	version, err := InitializeVersion(dbconn)
 	if err != nil {
 		return errors.Wrap(err, "Failed to determine database version")
 	}
 	dbconn.Version = version
+	return dbconn.ApplySessionGUCs()
+}
+
+/*
+ * SetConnectionParam adds a parameter to the connection string used by
+ * Connect, e.g. to pass libpq options like keepalives or sslrootcert.  Any
+ * parameter set here overrides the default value for that parameter, except
+ * that a mode set with SetSSLMode takes precedence over an sslmode parameter.
+ * An sslmode parameter is validated in the same way as by SetSSLMode, and
+ * Connect returns an error if it is invalid.
+ */
+func (dbconn *DBConn) SetConnectionParam(key string, value string) {
+	if dbconn.connParams == nil {
+		dbconn.connParams = make(map[string]string)
+	}
+	dbconn.connParams[key] = value
+}
+
+var validSSLModes = map[string]bool{
+	"disable":     true,
+	"allow":       true,
+	"prefer":      true,
+	"require":     true,
+	"verify-ca":   true,
+	"verify-full": true,
+}
+
+/*
+ * SetSSLMode sets the sslmode used by Connect.  If it is not called, the
+ * sslmode is taken from PGSSLMODE if that is set, and is otherwise disabled.
+ */
+func (dbconn *DBConn) SetSSLMode(mode string) error {
+	err := validateSSLMode(mode)
+	if err != nil {
+		return err
+	}
+	dbconn.sslMode = mode
 	return nil
 }
 
+func validateSSLMode(mode string) error {
+	if !validSSLModes[mode] {
+		return errors.Errorf(`Invalid SSL mode "%s"; must be one of disable, allow, prefer, require, verify-ca, or verify-full`, mode)
+	}
+	return nil
+}
+
+/*
+ * SetSSLCertificates sets the paths to the root certificate used to verify the
+ * server and to the client certificate and key, if any.  Empty paths are left
+ * out of the connection string, so libpq defaults apply to them.
+ */
+func (dbconn *DBConn) SetSSLCertificates(rootCert string, cert string, key string) {
+	dbconn.sslRootCert = rootCert
+	dbconn.sslCert = cert
+	dbconn.sslKey = key
+}
+
+/*
+ * SetPassword sets the password used by Connect, overriding PGPASSWORD, a
+ * password file, and any password parameter set with SetConnectionParam.
+ * Like other secrets, it is replaced with "REDACTED" in ConnectionString,
+ * left out of DescribeConfig, and removed from the text of connection errors,
+ * so that it does not end up in logs.
+ */
+func (dbconn *DBConn) SetPassword(password string) {
+	dbconn.password = password
+}
+
+func (dbconn *DBConn) buildConnectionString() string {
+	return dbconn.formatConnectionString(dbconn.connectionParams())
+}
+
+func (dbconn *DBConn) formatConnectionString(params url.Values) string {
+	// This string takes in the literal user/database names. They do not need
+	// to be escaped or quoted.
+	return fmt.Sprintf("postgres://%s@%s:%d/%s?%s", dbconn.User, dbconn.Host, dbconn.Port, dbconn.DBName, params.Encode())
+}
+
+/*
+ * ConnectionString returns the connection string Connect would use, including
+ * defaults and any parameters set through SetConnectionParam or SetSSLMode,
+ * so that tests and debugging output can inspect it without connecting.  The
+ * values of secrets such as passwords are replaced with "REDACTED".  The
+ * gp_role or gp_session_role parameter added for utility mode is not
+ * included, since which one is used is only known after connecting.
+ */
+func (dbconn *DBConn) ConnectionString() string {
+	params := dbconn.connectionParams()
+	for key := range params {
+		if secretConnectionParams[key] {
+			params.Set(key, "REDACTED")
+		}
+	}
+	return dbconn.formatConnectionString(params)
+}
+
+func (dbconn *DBConn) connectionParams() url.Values {
+	// By default pgx/v4 turns on automatic prepared statement caching. This
+	// causes an issue in GPDB4 where creating an object, deleting it, creating
+	// the same object again, then querying for the object in the same
+	// connection will generate a cache lookup failure. To disable pgx's
+	// automatic prepared statement cache we set statement_cache_capacity to 0.
+	params := url.Values{}
+	params.Set("sslmode", "disable")
+	if envSSLMode := operating.System.Getenv("PGSSLMODE"); envSSLMode != "" {
+		params.Set("sslmode", envSSLMode)
+	}
+	if dbconn.sslRootCert != "" {
+		params.Set("sslrootcert", dbconn.sslRootCert)
+	}
+	if dbconn.sslCert != "" {
+		params.Set("sslcert", dbconn.sslCert)
+	}
+	if dbconn.sslKey != "" {
+		params.Set("sslkey", dbconn.sslKey)
+	}
+	params.Set("statement_cache_capacity", "0")
+	for key, value := range dbconn.connParams {
+		params.Set(key, value)
+	}
+	if dbconn.sslMode != "" {
+		params.Set("sslmode", dbconn.sslMode)
+	}
+	if dbconn.password != "" {
+		params.Set("password", dbconn.password)
+	}
+	return params
+}
+
+/*
+ * Connection parameters whose values must not appear in logs or support
+ * bundles.
+ */
+var secretConnectionParams = map[string]bool{
+	"password":    true,
+	"sslpassword": true,
+}
+
+/*
+ * DescribeConfig returns a snapshot of how the DBConn is configured, for
+ * inclusion in support bundles and debug logs.  The connection parameters are
+ * those Connect would use, including defaults and any set through
+ * SetConnectionParam, except that secrets such as passwords are left out.
+ */
+func (dbconn *DBConn) DescribeConfig() map[string]interface{} {
+	params := make(map[string]string)
+	for key, values := range dbconn.connectionParams() {
+		if !secretConnectionParams[key] {
+			params[key] = values[0]
+		}
+	}
+	config := map[string]interface{}{
+		"host":                dbconn.Host,
+		"port":                dbconn.Port,
+		"dbname":              dbconn.DBName,
+		"user":                dbconn.User,
+		"connected":           dbconn.ConnPool != nil,
+		"num_conns":           dbconn.NumConns,
+		"sslmode":             params["sslmode"],
+		"application_name":    params["application_name"],
+		"connection_params":   params,
+		"dry_run":             dbconn.dryRun,
+		"auto_conn_selection": dbconn.autoConnSelection,
+		"auto_reconnect":      dbconn.autoReconnect,
+	}
+	if dbconn.ConnPool != nil {
+		config["version"] = dbconn.Version.VersionString
+	}
+	return config
+}
+
+/*
+ * Connects through the driver, giving up once ctx is done even if the driver
+ * does not take a context.
+ */
+func (dbconn *DBConn) connectDriver(ctx context.Context, connStr string) (*sqlx.DB, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if driver, ok := dbconn.Driver.(ContextDBDriver); ok {
+		return driver.ConnectContext(ctx, "pgx", connStr)
+	}
+	type connectResult struct {
+		conn *sqlx.DB
+		err  error
+	}
+	results := make(chan connectResult, 1)
+	go func() {
+		conn, err := dbconn.Driver.Connect("pgx", connStr)
+		results <- connectResult{conn: conn, err: err}
+	}()
+	select {
+	case result := <-results:
+		return result.conn, result.err
+	case <-ctx.Done():
+		go func() {
+			if result := <-results; result.conn != nil {
+				_ = result.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// Closes the connections established by a cancelled ConnectContext.
+func (dbconn *DBConn) abandonConnect() {
+	for _, conn := range dbconn.ConnPool {
+		if conn != nil {
+			_ = conn.Close()
+		}
+	}
+	dbconn.ConnPool = nil
+}
+
 func (dbconn *DBConn) MustConnectInUtilityMode(numConns int) {
 	err := dbconn.Connect(numConns, true)
 	gplog.FatalOnError(err)
@@ -258,23 +862,24 @@ func (dbconn *DBConn) ConnectInUtilityMode(numConns int) error {
 }
 
 func (dbconn *DBConn) handleConnectionError(err error) error {
-	if err != nil {
-		if strings.Contains(err.Error(), "does not exist") {
-			if strings.Contains(err.Error(), "pq: role") {
-				return errors.Errorf(`Role "%s" does not exist on %s:%d, exiting`, dbconn.User, dbconn.Host, dbconn.Port)
-			} else if strings.Contains(err.Error(), "pq: database") {
-				return errors.Errorf(`Database "%s" does not exist on %s:%d, exiting`, dbconn.DBName, dbconn.Host, dbconn.Port)
-			}
-		} else if strings.Contains(err.Error(), "connection refused") {
-			return errors.Errorf(`could not connect to server: Connection refused
+	if err == nil {
+		return nil
+	}
+	switch ClassifyError(err) {
+	case ErrRoleDoesNotExist:
+		return errors.Errorf(`Role "%s" does not exist on %s:%d, exiting`, dbconn.User, dbconn.Host, dbconn.Port)
+	case ErrDBDoesNotExist:
+		return errors.Errorf(`Database "%s" does not exist on %s:%d, exiting`, dbconn.DBName, dbconn.Host, dbconn.Port)
+	case ErrConnectionRefused:
+		return errors.Errorf(`could not connect to server: Connection refused
 	Is the server running on host "%s" and accepting
 	TCP/IP connections on port %d?`, dbconn.Host, dbconn.Port)
-		} else {
-			return errors.Errorf("%v (%s:%d)", err, dbconn.Host, dbconn.Port)
-		}
 	}
-
-	return err
+	message := FormatPQError(err)
+	if dbconn.password != "" {
+		message = strings.Replace(message, dbconn.password, "REDACTED", -1)
+	}
+	return errors.Errorf("%s (%s:%d)", message, dbconn.Host, dbconn.Port)
 }
 
 /*
@@ -284,41 +889,251 @@ func (dbconn *DBConn) handleConnectionError(err error) error {
  * requiring that to be ensured at the call site.
  */
 
+/*
+ * SetQueryTransformer sets a function through which every statement passed to
+ * Exec, Get, Select, Query, and their variants is passed before being sent to
+ * the driver, such as to remap schema names.  Passing nil restores the default
+ * of sending statements unchanged.
+ *
+ * Statements that this package issues itself, such as the version query in
+ * Connect, the SET TRANSACTION in Begin, and the catalog queries in
+ * catalog.go, are not transformed.
+ */
+func (dbconn *DBConn) SetQueryTransformer(transformer func(query string) string) {
+	dbconn.queryTransformer = transformer
+}
+
+func (dbconn *DBConn) transformQuery(query string) string {
+	if dbconn.queryTransformer == nil {
+		return query
+	}
+	return dbconn.queryTransformer(query)
+}
+
+/*
+ * SetDryRun enables or disables dry-run mode, for utilities that offer to show
+ * the changes they would make without making them.  In dry-run mode, Exec,
+ * ExecWithArgs, ExecContext, and ExecPrepared log each statement at the info
+ * level instead of running it and return a result with zero rows affected, as
+ * do the functions built on them: the Must variants, ExecAndCount,
+ * ExecOnAllConnections, ExecIgnoreMissing, ExecScript, and BatchInsert.
+ *
+ * Get, Select, Query, and their variants still run, so that a utility can
+ * inspect the database to decide what it would do, as do the statements this
+ * package issues itself, such as those in Begin and Commit.
+ */
+func (dbconn *DBConn) SetDryRun(dryRun bool) {
+	dbconn.dryRun = dryRun
+}
+
+var dryRunResult = driver.RowsAffected(0)
+
+/*
+ * Returns true, after logging the statement, if it should not be run because
+ * dry-run mode is enabled.
+ */
+func (dbconn *DBConn) skipForDryRun(query string) bool {
+	if dbconn.dryRun {
+		gplog.Info("Dry run, not executing: %s", query)
+	}
+	return dbconn.dryRun
+}
+
 func (dbconn *DBConn) Exec(query string, whichConn ...int) (sql.Result, error) {
-	connNum := dbconn.ValidateConnNum(whichConn...)
+	query = dbconn.transformQuery(query)
+	if dbconn.skipForDryRun(query) {
+		dbconn.ValidateConnNum(whichConn...)
+		return dryRunResult, nil
+	}
+	connNum, release := dbconn.leaseConnNum(whichConn...)
+	defer release()
+	return dbconn.exec(query, connNum)
+}
+
+/*
+ * The unexported exec, get, and selectInto functions run statements without
+ * passing them through the query transformer, for statements issued by this
+ * package itself rather than by callers.
+ */
+func (dbconn *DBConn) exec(query string, connNum int) (sql.Result, error) {
+	unlock := dbconn.lockConn(connNum)
+	defer unlock()
+	start := operating.System.Now()
+	result, err := dbconn.execOnce(query, connNum)
+	if dbconn.reconnectAfterShutdown(err, connNum) {
+		result, err = dbconn.execOnce(query, connNum)
+	}
+	dbconn.logQueryTiming(query, connNum, start, resultRowCount(result, err), err)
+	return result, err
+}
+
+func (dbconn *DBConn) execOnce(query string, connNum int) (sql.Result, error) {
 	if dbconn.Tx[connNum] != nil {
 		return dbconn.Tx[connNum].Exec(query)
 	}
 	return dbconn.ConnPool[connNum].Exec(query)
 }
 
+func (dbconn *DBConn) ExecWithArgs(query string, args ...interface{}) (sql.Result, error) {
+	query = dbconn.transformQuery(query)
+	if dbconn.skipForDryRun(query) {
+		return dryRunResult, nil
+	}
+	unlock := dbconn.lockConn(0)
+	defer unlock()
+	start := operating.System.Now()
+	var result sql.Result
+	var err error
+	if dbconn.Tx[0] != nil {
+		result, err = dbconn.Tx[0].Exec(query, args...)
+	} else {
+		result, err = dbconn.ConnPool[0].Exec(query, args...)
+	}
+	dbconn.logQueryTiming(query, 0, start, resultRowCount(result, err), err)
+	return result, err
+}
+
+/*
+ * ExecAndCount is ExecWithArgs for callers that only need the number of rows
+ * the statement affected, e.g. for logging.
+ */
+func (dbconn *DBConn) ExecAndCount(query string, args ...interface{}) (int64, error) {
+	result, err := dbconn.ExecWithArgs(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+/*
+ * ExecReturning runs a statement with a RETURNING clause, such as an INSERT
+ * that returns a generated id, and scans the single row it returns into
+ * destination as Get would.  It returns sql.ErrNoRows if the statement returns
+ * no rows.  In dry-run mode the statement is not run and destination is left
+ * unchanged.
+ */
+func (dbconn *DBConn) ExecReturning(destination interface{}, query string, args ...interface{}) error {
+	query = dbconn.transformQuery(query)
+	if dbconn.skipForDryRun(query) {
+		return nil
+	}
+	return dbconn.getWithArgs(destination, query, args...)
+}
+
 func (dbconn *DBConn) MustExec(query string, whichConn ...int) {
 	_, err := dbconn.Exec(query, whichConn...)
-	gplog.FatalOnError(err)
+	fatalOnDatabaseError(err)
 }
 
 func (dbconn *DBConn) ExecContext(queryContext context.Context, query string, whichConn ...int) (sql.Result, error) {
+	query = dbconn.transformQuery(query)
 	connNum := dbconn.ValidateConnNum(whichConn...)
+	if dbconn.skipForDryRun(query) {
+		return dryRunResult, nil
+	}
+	unlock := dbconn.lockConn(connNum)
+	defer unlock()
+	start := operating.System.Now()
+	var result sql.Result
+	var err error
 	if dbconn.Tx[connNum] != nil {
-		return dbconn.Tx[connNum].ExecContext(queryContext, query)
+		result, err = dbconn.Tx[connNum].ExecContext(queryContext, query)
+	} else {
+		result, err = dbconn.ConnPool[connNum].ExecContext(queryContext, query)
 	}
-	return dbconn.ConnPool[connNum].ExecContext(queryContext, query)
+	dbconn.logQueryTiming(query, connNum, start, resultRowCount(result, err), err)
+	return result, err
 }
 
 func (dbconn *DBConn) MustExecContext(queryContext context.Context, query string, whichConn ...int) {
 	_, err := dbconn.ExecContext(queryContext, query, whichConn...)
-	gplog.FatalOnError(err)
+	fatalOnDatabaseError(err)
+}
+
+/*
+ * ExecOnAllConnections runs a statement on each connection in the pool in
+ * turn, for session-level statements such as SET that every connection needs.
+ * It stops at the first failure, so the statement may already have been run
+ * on the connections before the one that failed.
+ */
+func (dbconn *DBConn) ExecOnAllConnections(query string) error {
+	for connNum := 0; connNum < dbconn.NumConns; connNum++ {
+		_, err := dbconn.Exec(query, connNum)
+		if err != nil {
+			return errors.Wrapf(err, "Cannot execute statement on connection %d", connNum)
+		}
+	}
+	return nil
+}
+
+func (dbconn *DBConn) MustExecOnAllConnections(query string) {
+	err := dbconn.ExecOnAllConnections(query)
+	fatalOnDatabaseError(err)
+}
+
+/*
+ * ExecIgnoreMissing is intended for cleanup statements such as DROP TABLE, for
+ * which the object already being gone should not count as a failure; errors
+ * indicating an undefined object are swallowed, and all others are returned.
+ *
+ * Note that the server still aborts any transaction in progress when the
+ * statement fails, so this is mostly useful outside of a transaction.
+ */
+func (dbconn *DBConn) ExecIgnoreMissing(ddl string, whichConn ...int) error {
+	_, err := dbconn.Exec(ddl, whichConn...)
+	if err != nil && isUndefinedObjectError(err) {
+		return nil
+	}
+	return err
+}
+
+func (dbconn *DBConn) MustExecIgnoreMissing(ddl string, whichConn ...int) {
+	err := dbconn.ExecIgnoreMissing(ddl, whichConn...)
+	fatalOnDatabaseError(err)
 }
 
 func (dbconn *DBConn) GetWithArgs(destination interface{}, query string, args ...interface{}) error {
+	return dbconn.getWithArgs(destination, dbconn.transformQuery(query), args...)
+}
+
+func (dbconn *DBConn) getWithArgs(destination interface{}, query string, args ...interface{}) error {
+	unlock := dbconn.lockConn(0)
+	defer unlock()
+	start := operating.System.Now()
+	var err error
 	if dbconn.Tx[0] != nil {
-		return dbconn.Tx[0].Get(destination, query, args...)
+		err = dbconn.Tx[0].Get(destination, query, args...)
+	} else {
+		err = dbconn.ConnPool[0].Get(destination, query, args...)
 	}
-	return dbconn.ConnPool[0].Get(destination, query, args...)
+	dbconn.logQueryTiming(query, 0, start, destinationRowCount(destination, err), err)
+	return err
 }
 
 func (dbconn *DBConn) Get(destination interface{}, query string, whichConn ...int) error {
-	connNum := dbconn.ValidateConnNum(whichConn...)
+	connNum, release := dbconn.leaseConnNum(whichConn...)
+	defer release()
+	return dbconn.get(destination, dbconn.transformQuery(query), connNum)
+}
+
+func (dbconn *DBConn) get(destination interface{}, query string, connNum int) error {
+	unlock := dbconn.lockConn(connNum)
+	defer unlock()
+	return dbconn.getLocked(destination, query, connNum)
+}
+
+// getLocked is get for callers that already hold the connection's lock.
+func (dbconn *DBConn) getLocked(destination interface{}, query string, connNum int) error {
+	start := operating.System.Now()
+	err := dbconn.getOnce(destination, query, connNum)
+	if dbconn.reconnectAfterShutdown(err, connNum) {
+		err = dbconn.getOnce(destination, query, connNum)
+	}
+	dbconn.logQueryTiming(query, connNum, start, destinationRowCount(destination, err), err)
+	return err
+}
+
+func (dbconn *DBConn) getOnce(destination interface{}, query string, connNum int) error {
 	if dbconn.Tx[connNum] != nil {
 		return dbconn.Tx[connNum].Get(destination, query)
 	}
@@ -326,21 +1141,111 @@ func (dbconn *DBConn) Get(destination interface{}, query string, whichConn ...in
 }
 
 func (dbconn *DBConn) SelectWithArgs(destination interface{}, query string, args ...interface{}) error {
+	query = dbconn.transformQuery(query)
+	unlock := dbconn.lockConn(0)
+	defer unlock()
+	start := operating.System.Now()
+	var err error
 	if dbconn.Tx[0] != nil {
-		return dbconn.Tx[0].Select(destination, query, args...)
+		err = dbconn.Tx[0].Select(destination, query, args...)
+	} else {
+		err = dbconn.ConnPool[0].Select(destination, query, args...)
 	}
-	return dbconn.ConnPool[0].Select(destination, query, args...)
+	dbconn.logQueryTiming(query, 0, start, destinationRowCount(destination, err), err)
+	return err
 }
 
 func (dbconn *DBConn) Select(destination interface{}, query string, whichConn ...int) error {
-	connNum := dbconn.ValidateConnNum(whichConn...)
+	connNum, release := dbconn.leaseConnNum(whichConn...)
+	defer release()
+	return dbconn.selectInto(destination, dbconn.transformQuery(query), connNum)
+}
+
+func (dbconn *DBConn) selectInto(destination interface{}, query string, connNum int) error {
+	unlock := dbconn.lockConn(connNum)
+	defer unlock()
+	start := operating.System.Now()
+	err := dbconn.selectOnce(destination, query, connNum)
+	if dbconn.reconnectAfterShutdown(err, connNum) {
+		err = dbconn.selectOnce(destination, query, connNum)
+	}
+	dbconn.logQueryTiming(query, connNum, start, destinationRowCount(destination, err), err)
+	return err
+}
+
+func (dbconn *DBConn) selectOnce(destination interface{}, query string, connNum int) error {
 	if dbconn.Tx[connNum] != nil {
 		return dbconn.Tx[connNum].Select(destination, query)
 	}
 	return dbconn.ConnPool[connNum].Select(destination, query)
 }
 
+/*
+ * SelectExactlyN is SelectWithArgs for queries that are expected to return a
+ * specific number of rows, returning an error rather than a short or long
+ * result if the row count differs from n.
+ */
+func (dbconn *DBConn) SelectExactlyN(destination interface{}, n int, query string, args ...interface{}) error {
+	err := dbconn.SelectWithArgs(destination, query, args...)
+	if err != nil {
+		return err
+	}
+	numRows := reflect.Indirect(reflect.ValueOf(destination)).Len()
+	if numRows < n {
+		return errors.Errorf("Too few rows returned from query: got %d rows, expected %d rows", numRows, n)
+	} else if numRows > n {
+		return errors.Errorf("Too many rows returned from query: got %d rows, expected %d rows", numRows, n)
+	}
+	return nil
+}
+
+/*
+ * NamedGet and NamedSelect are GetWithArgs and SelectWithArgs for queries that
+ * take their arguments from the fields of a struct or the entries of a map,
+ * referenced by name as in "WHERE owner = :owner".  A slice argument used as
+ * in "WHERE oid IN (:oids)" is expanded to one parameter per element, and an
+ * empty slice is replaced with a single NULL so that IN matches no rows
+ * instead of causing a syntax error; note that NOT IN then matches no rows
+ * either.
+ */
+func (dbconn *DBConn) NamedGet(destination interface{}, query string, arg interface{}) error {
+	query, args, err := bindNamedQuery(query, arg)
+	if err != nil {
+		return err
+	}
+	return dbconn.GetWithArgs(destination, query, args...)
+}
+
+func (dbconn *DBConn) NamedSelect(destination interface{}, query string, arg interface{}) error {
+	query, args, err := bindNamedQuery(query, arg)
+	if err != nil {
+		return err
+	}
+	return dbconn.SelectWithArgs(destination, query, args...)
+}
+
+func bindNamedQuery(query string, arg interface{}) (string, []interface{}, error) {
+	query, args, err := sqlx.Named(query, arg)
+	if err != nil {
+		return "", nil, err
+	}
+	for i, arg := range args {
+		value := reflect.ValueOf(arg)
+		if _, isBytes := arg.([]byte); !isBytes && value.Kind() == reflect.Slice && value.Len() == 0 {
+			args[i] = []interface{}{nil}
+		}
+	}
+	query, args, err = sqlx.In(query, args...)
+	if err != nil {
+		return "", nil, err
+	}
+	return sqlx.Rebind(sqlx.DOLLAR, query), args, nil
+}
+
 func (dbconn *DBConn) QueryWithArgs(query string, args ...interface{}) (*sqlx.Rows, error) {
+	query = dbconn.transformQuery(query)
+	unlock := dbconn.lockConn(0)
+	defer unlock()
 	if dbconn.Tx[0] != nil {
 		return dbconn.Tx[0].Queryx(query, args...)
 	}
@@ -348,13 +1253,298 @@ func (dbconn *DBConn) QueryWithArgs(query string, args ...interface{}) (*sqlx.Ro
 }
 
 func (dbconn *DBConn) Query(query string, whichConn ...int) (*sqlx.Rows, error) {
+	query = dbconn.transformQuery(query)
 	connNum := dbconn.ValidateConnNum(whichConn...)
+	unlock := dbconn.lockConn(connNum)
+	defer unlock()
 	if dbconn.Tx[connNum] != nil {
 		return dbconn.Tx[connNum].Queryx(query)
 	}
 	return dbconn.ConnPool[connNum].Queryx(query)
 }
 
+/*
+ * SelectMaps is for queries whose columns are not known ahead of time, such
+ * that there is no struct to Select into.  Each row is returned as a map from
+ * column name to value, with NULL values mapped to nil.  Like the other
+ * functions that take query arguments, it runs on the first connection.
+ */
+func (dbconn *DBConn) SelectMaps(query string, args ...interface{}) ([]map[string]interface{}, error) {
+	rows, err := dbconn.QueryWithArgs(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		row := make(map[string]interface{})
+		err = rows.MapScan(row)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, row)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	return results, nil
+}
+
+/*
+ * A RowResult holds either one row delivered by SelectChan, as a map like
+ * those returned by SelectMaps, or the error that ended the query.
+ */
+type RowResult struct {
+	Row map[string]interface{}
+	Err error
+}
+
+/*
+ * SelectChan runs a query on the first connection and delivers its rows in
+ * order on the returned channel as they are read, so that a pipeline can start
+ * processing rows before the query has finished.  If reading a row fails, the
+ * error is delivered as the last RowResult.  The channel is closed once the
+ * rows are exhausted, after an error, or when ctx is cancelled, at which point
+ * the query's rows are closed as well.  Callers that stop reading early must
+ * cancel ctx so that the producer goroutine can exit.
+ */
+func (dbconn *DBConn) SelectChan(ctx context.Context, query string, args ...interface{}) (<-chan RowResult, error) {
+	query = dbconn.transformQuery(query)
+	var rows *sqlx.Rows
+	var err error
+	unlock := dbconn.lockConn(0)
+	if dbconn.Tx[0] != nil {
+		rows, err = dbconn.Tx[0].QueryxContext(ctx, query, args...)
+	} else {
+		rows, err = dbconn.ConnPool[0].QueryxContext(ctx, query, args...)
+	}
+	unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(chan RowResult)
+	go func() {
+		defer close(results)
+		defer rows.Close()
+		send := func(result RowResult) bool {
+			select {
+			case results <- result:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		for rows.Next() {
+			row := make(map[string]interface{})
+			err := rows.MapScan(row)
+			if err != nil {
+				send(RowResult{Err: err})
+				return
+			}
+			if !send(RowResult{Row: row}) {
+				return
+			}
+		}
+		if rows.Err() != nil && ctx.Err() == nil {
+			send(RowResult{Err: rows.Err()})
+		}
+	}()
+	return results, nil
+}
+
+/*
+ * The extended query protocol uses a 16-bit integer for the number of bind
+ * parameters, so no single statement can have more than this many.
+ */
+const maxBindParameters = 65535
+
+/*
+ * BatchInsert inserts rows into a table using multi-row INSERT statements of
+ * at most batchSize rows each, reducing the number of batches further if
+ * needed to stay under the bind parameter limit.  Like the WithArgs functions,
+ * it uses the first connection and the transaction on it, if one is in
+ * progress.  The table and column names are used as given, so they must
+ * already be quoted if necessary.
+ */
+func (dbconn *DBConn) BatchInsert(table string, columns []string, rows [][]interface{}, batchSize int) error {
+	if batchSize <= 0 {
+		return errors.Errorf("Batch size must be a positive integer, got %d", batchSize)
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	if len(columns) == 0 {
+		return errors.New("At least one column must be specified for a batch insert")
+	}
+	for i, row := range rows {
+		if len(row) != len(columns) {
+			return errors.Errorf("Row %d has %d values, expected %d values", i, len(row), len(columns))
+		}
+	}
+	if maxRows := maxBindParameters / len(columns); batchSize > maxRows {
+		batchSize = maxRows
+	}
+
+	insertPrefix := fmt.Sprintf("INSERT INTO %s (%s) VALUES ", table, strings.Join(columns, ", "))
+	for start := 0; start < len(rows); start += batchSize {
+		end := start + batchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+		valueLists := make([]string, 0, end-start)
+		args := make([]interface{}, 0, (end-start)*len(columns))
+		for _, row := range rows[start:end] {
+			placeholders := make([]string, len(row))
+			for j := range row {
+				placeholders[j] = fmt.Sprintf("$%d", len(args)+j+1)
+			}
+			valueLists = append(valueLists, fmt.Sprintf("(%s)", strings.Join(placeholders, ", ")))
+			args = append(args, row...)
+		}
+		_, err := dbconn.ExecWithArgs(insertPrefix+strings.Join(valueLists, ", "), args...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+ * WithConnection reserves a connection from the pool for the duration of fn,
+ * for session-stateful sequences such as those using temporary tables or SET
+ * LOCAL, and releases it afterwards even if fn panics.  If every connection is
+ * already reserved, it blocks until one is released.
+ *
+ * Reservations are only honored by other WithConnection callers; functions
+ * that are passed an explicit connection number can still use a reserved
+ * connection, so callers should not mix the two styles on one DBConn.
+ */
+func (dbconn *DBConn) WithConnection(fn func(connNum int) error) error {
+	freeConns := dbconn.freeConns
+	if freeConns == nil {
+		return errors.New("Cannot reserve a connection; the database connection is not open")
+	}
+	connNum := <-freeConns
+	defer func() { freeConns <- connNum }()
+	return fn(connNum)
+}
+
+/*
+ * EnableAutoConnSelection makes Exec, Get, and Select calls that are not given
+ * a connection number reserve whichever connection is free, as WithConnection
+ * does, instead of always using the first connection, so that goroutines
+ * sharing a DBConn do not queue up behind one another on a single connection.
+ * If every connection is reserved, these calls block until one is released, so
+ * they must not be made without a connection number inside a WithConnection
+ * callback that holds the last free connection.
+ *
+ * Since the connection used for a given call is not known in advance, callers
+ * using transactions should continue to pass connection numbers explicitly.
+ */
+func (dbconn *DBConn) EnableAutoConnSelection() {
+	dbconn.autoConnSelection = true
+}
+
+/*
+ * Returns the connection a statement should run on and a function to call
+ * once the statement is done, which releases the connection if it was
+ * reserved by automatic connection selection.
+ */
+/*
+ * lockConn and tryLockConn guard a pool connection while a statement runs on
+ * it or its transaction starts or ends, so that the keepalive goroutine skips
+ * connections that are in use rather than pinging or replacing them.  Either
+ * returns a function that unlocks the connection; lockConn waits until the
+ * connection is free, while tryLockConn returns false if it is in use.
+ */
+func makeConnLocks(numConns int) []chan struct{} {
+	locks := make([]chan struct{}, numConns)
+	for i := range locks {
+		locks[i] = make(chan struct{}, 1)
+	}
+	return locks
+}
+
+func (dbconn *DBConn) lockConn(connNum int) func() {
+	if connNum >= len(dbconn.connLocks) {
+		return func() {}
+	}
+	lock := dbconn.connLocks[connNum]
+	lock <- struct{}{}
+	return func() { <-lock }
+}
+
+func (dbconn *DBConn) tryLockConn(connNum int) (func(), bool) {
+	if connNum >= len(dbconn.connLocks) {
+		return func() {}, true
+	}
+	lock := dbconn.connLocks[connNum]
+	select {
+	case lock <- struct{}{}:
+		return func() { <-lock }, true
+	default:
+		return nil, false
+	}
+}
+
+func (dbconn *DBConn) leaseConnNum(whichConn ...int) (int, func()) {
+	freeConns := dbconn.freeConns
+	if len(whichConn) > 0 || !dbconn.autoConnSelection || freeConns == nil {
+		return dbconn.ValidateConnNum(whichConn...), func() {}
+	}
+	connNum := <-freeConns
+	return connNum, func() { freeConns <- connNum }
+}
+
+/*
+ * ExecuteInParallel calls fn once for each item, spreading the items across
+ * one worker goroutine per connection in the pool so that no more than
+ * NumConns statements run at once.  Each worker only ever passes its own
+ * connection number to fn.  The returned slice has one entry per item, in the
+ * same order as items, holding the error returned by fn for that item or nil
+ * if it succeeded.
+ */
+func (dbconn *DBConn) ExecuteInParallel(items []interface{}, fn func(conn *DBConn, connNum int, item interface{}) error) []error {
+	errs := make([]error, len(items))
+	if dbconn.ConnPool == nil {
+		for i := range errs {
+			errs[i] = errors.New("Cannot execute in parallel; the database connection is not open")
+		}
+		return errs
+	}
+	indices := make(chan int, len(items))
+	for i := range items {
+		indices <- i
+	}
+	close(indices)
+
+	var wg sync.WaitGroup
+	for connNum := 0; connNum < dbconn.NumConns; connNum++ {
+		wg.Add(1)
+		go func(connNum int) {
+			defer wg.Done()
+			for i := range indices {
+				errs[i] = fn(dbconn, connNum, items[i])
+			}
+		}(connNum)
+	}
+	wg.Wait()
+	return errs
+}
+
+/*
+ * GetPoolConnection returns the *sqlx.DB for a connection in the pool, for
+ * sqlx features that DBConn does not wrap.  The handle is still owned by the
+ * DBConn, so the caller must not close it and should use Close on the DBConn
+ * instead.  Statements run on the handle directly do not participate in a
+ * transaction started with Begin.
+ */
+func (dbconn *DBConn) GetPoolConnection(whichConn ...int) *sqlx.DB {
+	connNum := dbconn.ValidateConnNum(whichConn...)
+	return dbconn.ConnPool[connNum]
+}
+
 /*
  * Ensure there isn't a mismatch between the connection pool size and number of
  * jobs, and default to using the first connection if no number is given.
@@ -442,3 +1632,41 @@ func SelectStringSlice(connection *DBConn, query string, whichConn ...int) ([]st
 	}
 	return retval, nil
 }
+
+/*
+ * This is a convenience function for Select() when we're selecting key/value
+ * pairs in a particular order, which would be lost by scanning into a map.  As
+ * with SelectStringSlice, NULL values are returned as empty strings.
+ */
+func (dbconn *DBConn) MustSelectPairs(query string, whichConn ...int) ([]string, []string) {
+	keys, values, err := dbconn.SelectPairs(query, whichConn...)
+	fatalOnDatabaseError(err)
+	return keys, values
+}
+
+func (dbconn *DBConn) SelectPairs(query string, whichConn ...int) ([]string, []string, error) {
+	connNum := dbconn.ValidateConnNum(whichConn...)
+	rows, err := dbconn.Query(query, connNum)
+	if err != nil {
+		return []string{}, []string{}, err
+	}
+	defer rows.Close()
+	if cols, _ := rows.Rows.Columns(); len(cols) != 2 {
+		return []string{}, []string{}, errors.Errorf("Wrong number of columns returned from query: got %d columns, expected 2 columns", len(cols))
+	}
+	keys := make([]string, 0)
+	values := make([]string, 0)
+	for rows.Rows.Next() {
+		var key, value sql.NullString
+		err = rows.Rows.Scan(&key, &value)
+		if err != nil {
+			return []string{}, []string{}, err
+		}
+		keys = append(keys, key.String)
+		values = append(values, value.String)
+	}
+	if rows.Rows.Err() != nil {
+		return []string{}, []string{}, rows.Rows.Err()
+	}
+	return keys, values, nil
+}
@@ -2,9 +2,17 @@ package dbconn_test
 
 import (
 	"context"
+	"database/sql"
 	"database/sql/driver"
 	"fmt"
+	"net"
 	"os"
+	"os/user"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
@@ -12,7 +20,10 @@ import (
 	"github.com/cloudberrydb/gp-common-go-libs/dbconn"
 	"github.com/cloudberrydb/gp-common-go-libs/operating"
 	"github.com/cloudberrydb/gp-common-go-libs/testhelper"
+	"github.com/jackc/pgconn"
 	"github.com/jmoiron/sqlx"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/pkg/errors"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -23,12 +34,6 @@ var (
 	mock       sqlmock.Sqlmock
 )
 
-func ExpectBegin(mock sqlmock.Sqlmock) {
-	fakeResult := testhelper.TestResult{Rows: 0}
-	mock.ExpectBegin()
-	mock.ExpectExec("SET TRANSACTION(.*)").WillReturnResult(fakeResult)
-}
-
 func TestDBConn(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "dbconn tests")
@@ -64,6 +69,35 @@ var _ = Describe("dbconn/dbconn tests", func() {
 			Expect(connection.Host).To(Equal("mars"))
 			Expect(connection.Port).To(Equal(1234))
 		})
+		It("reads the connection info from the injected environment", func() {
+			defer func() { operating.System = operating.InitializeSystemFunctions() }()
+			env := map[string]string{}
+			operating.System.Getenv = func(key string) string { return env[key] }
+			operating.System.Setenv = func(key string, value string) error {
+				env[key] = value
+				return nil
+			}
+			Expect(operating.System.Setenv("PGUSER", "envuser")).To(Succeed())
+			Expect(operating.System.Setenv("PGHOST", "envhost")).To(Succeed())
+			Expect(operating.System.Setenv("PGPORT", "6000")).To(Succeed())
+
+			connection = dbconn.NewDBConnFromEnvironment("testdb")
+			Expect(connection.User).To(Equal("envuser"))
+			Expect(connection.Host).To(Equal("envhost"))
+			Expect(connection.Port).To(Equal(6000))
+			Expect(os.Getenv("PGHOST")).ToNot(Equal("envhost"))
+		})
+		It("falls back to the current user, hostname, and default port if the environment is empty", func() {
+			defer func() { operating.System = operating.InitializeSystemFunctions() }()
+			operating.System.Getenv = func(key string) string { return "" }
+			operating.System.CurrentUser = func() (*user.User, error) { return &user.User{Username: "testUser"}, nil }
+			operating.System.Hostname = func() (string, error) { return "testHost", nil }
+
+			connection = dbconn.NewDBConnFromEnvironment("testdb")
+			Expect(connection.User).To(Equal("testUser"))
+			Expect(connection.Host).To(Equal("testHost"))
+			Expect(connection.Port).To(Equal(5432))
+		})
 		It("fails if no database is given with the dbname flag", func() {
 			defer testhelper.ShouldPanicWithMessage("No database provided")
 			connection = dbconn.NewDBConnFromEnvironment("")
@@ -77,6 +111,76 @@ var _ = Describe("dbconn/dbconn tests", func() {
 			connection = dbconn.NewDBConn("testdb", "testuser", "", 1234)
 		})
 	})
+	Describe("DBConn.SetUser, SetHost, and SetPort", func() {
+		var driver *testhelper.TestDriver
+		BeforeEach(func() {
+			operating.System.Getenv = func(key string) string {
+				switch key {
+				case "PGUSER":
+					return "envuser"
+				case "PGHOST":
+					return "envhost"
+				case "PGPORT":
+					return "6000"
+				}
+				return ""
+			}
+			mockdb, mockDB := testhelper.CreateMockDB()
+			mock = mockDB
+			driver = &testhelper.TestDriver{DB: mockdb, DBName: "testdb", User: "testrole"}
+			connection = dbconn.NewDBConnWithDriver("testdb", driver)
+			testhelper.ExpectVersionQuery(mock, "6.0.0")
+		})
+		AfterEach(func() {
+			operating.System = operating.InitializeSystemFunctions()
+		})
+		It("uses PGUSER, PGHOST, and PGPORT if no values are set", func() {
+			connection.MustConnect(1)
+			Expect(driver.DataSourceName).To(HavePrefix("postgres://envuser@envhost:6000/testdb?"))
+		})
+		It("uses the set values instead of PGUSER, PGHOST, and PGPORT", func() {
+			connection.SetUser("testrole")
+			connection.SetHost("testhost")
+			connection.SetPort(5432)
+			connection.MustConnect(1)
+			Expect(driver.DataSourceName).To(HavePrefix("postgres://testrole@testhost:5432/testdb?"))
+		})
+		It("falls back to the environment for values that are not set", func() {
+			connection.SetPort(5433)
+			connection.MustConnect(1)
+			Expect(driver.DataSourceName).To(HavePrefix("postgres://envuser@envhost:5433/testdb?"))
+		})
+	})
+	Describe("NewDBConnWithDriver", func() {
+		It("uses the GPDBDriver by default", func() {
+			connection = dbconn.NewDBConn("testdb", "testuser", "mars", 1234)
+			Expect(connection.Driver).To(Equal(&dbconn.GPDBDriver{}))
+		})
+		It("connects through the given driver", func() {
+			mockdb, mock := testhelper.CreateMockDB()
+			driver := &testhelper.TestDriver{DB: mockdb, DBName: "testdb", User: "testrole"}
+			connection = dbconn.NewDBConnWithDriver("testdb", driver)
+			connection.User = "testrole"
+			connection.Host = "testhost"
+			connection.Port = 5432
+			Expect(connection.Driver).To(Equal(driver))
+
+			testhelper.ExpectVersionQuery(mock, "6.0.0")
+			connection.MustConnect(1)
+			Expect(driver.DriverName).To(Equal("pgx"))
+			Expect(driver.DataSourceName).To(Equal("postgres://testrole@testhost:5432/testdb?sslmode=disable&statement_cache_capacity=0"))
+			Expect(connection.ConnPool[0]).To(Equal(mockdb))
+
+			mock.ExpectExec("CREATE TABLE foo(.*)").WillReturnResult(testhelper.TestResult{Rows: 0})
+			_, err := connection.Exec("CREATE TABLE foo(i int)")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("fails if no database is given", func() {
+			defer testhelper.ShouldPanicWithMessage("No database provided")
+			connection = dbconn.NewDBConnWithDriver("", &dbconn.GPDBDriver{})
+		})
+	})
 	Describe("DBConn.MustConnect", func() {
 		var mockdb *sqlx.DB
 		BeforeEach(func() {
@@ -98,7 +202,7 @@ var _ = Describe("dbconn/dbconn tests", func() {
 			Expect(len(connection.Tx)).To(Equal(3))
 		})
 		It("does not connect if the database exists but the connection is refused", func() {
-			connection.Driver = &testhelper.TestDriver{ErrToReturn: fmt.Errorf("pq: connection refused"), DB: mockdb, User: "testrole"}
+			connection.Driver = &testhelper.TestDriver{ErrToReturn: &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, DB: mockdb, User: "testrole"}
 			defer testhelper.ShouldPanicWithMessage(`could not connect to server: Connection refused`)
 			connection.MustConnect(1)
 		})
@@ -107,18 +211,22 @@ var _ = Describe("dbconn/dbconn tests", func() {
 			connection.MustConnect(0)
 		})
 		It("fails if the database does not exist", func() {
-			connection.Driver = &testhelper.TestDriver{ErrToReturn: fmt.Errorf("pq: database \"testdb\" does not exist"), DB: mockdb, DBName: "testdb", User: "testrole"}
+			connection.Driver = &testhelper.TestDriver{ErrToReturn: &pgconn.PgError{Severity: "FATAL", Code: "3D000", Message: `database "testdb" does not exist`}, DB: mockdb, DBName: "testdb", User: "testrole"}
 			Expect(connection.DBName).To(Equal("testdb"))
 			defer testhelper.ShouldPanicWithMessage("Database \"testdb\" does not exist on testhost:5432, exiting")
 			connection.MustConnect(1)
 		})
 		It("fails if the role does not exist", func() {
-			oldPgUser := os.Getenv("PGUSER")
-			os.Setenv("PGUSER", "nonexistent")
-			defer os.Setenv("PGUSER", oldPgUser)
+			operating.System.Getenv = func(key string) string {
+				if key == "PGUSER" {
+					return "nonexistent"
+				}
+				return ""
+			}
+			defer func() { operating.System.Getenv = os.Getenv }()
 
 			connection = dbconn.NewDBConnFromEnvironment("testdb")
-			connection.Driver = &testhelper.TestDriver{ErrToReturn: fmt.Errorf("pq: role \"nonexistent\" does not exist"), DB: mockdb, DBName: "testdb", User: "nonexistent"}
+			connection.Driver = &testhelper.TestDriver{ErrToReturn: &pgconn.PgError{Severity: "FATAL", Code: "28000", Message: `role "nonexistent" does not exist`, Routine: "InitializeSessionUserId"}, DB: mockdb, DBName: "testdb", User: "nonexistent"}
 			Expect(connection.User).To(Equal("nonexistent"))
 			expectedStr := fmt.Sprintf("Role \"nonexistent\" does not exist on %s:%d, exiting", connection.Host, connection.Port)
 			defer testhelper.ShouldPanicWithMessage(expectedStr)
@@ -141,7 +249,7 @@ var _ = Describe("dbconn/dbconn tests", func() {
 			Expect(err).ToNot(HaveOccurred())
 		})
 		It("passes an error message on if a utility mode connection fails", func() {
-			connection, mock = testhelper.CreateMockDBConn(fmt.Errorf(`pq: database \"testdb\" does not exist`))
+			connection, mock = testhelper.CreateMockDBConn(&pgconn.PgError{Severity: "FATAL", Code: "3D000", Message: `database "testdb" does not exist`})
 			testhelper.ExpectVersionQuery(mock, "6.0.0")
 
 			Expect(connection.DBName).To(Equal("testdb"))
@@ -149,6 +257,464 @@ var _ = Describe("dbconn/dbconn tests", func() {
 			Expect(err.Error()).To(Equal(`Database "testdb" does not exist on testhost:5432, exiting`))
 		})
 	})
+	Describe("DBConn.ConnectContext", func() {
+		var (
+			mockdb *sqlx.DB
+			mock   sqlmock.Sqlmock
+		)
+		BeforeEach(func() {
+			mockdb, mock = testhelper.CreateMockDB()
+		})
+		newConnection := func(driver dbconn.DBDriver) *dbconn.DBConn {
+			conn := dbconn.NewDBConnWithDriver("testdb", driver)
+			conn.Host = "testhost"
+			conn.Port = 5432
+			return conn
+		}
+		connectInBackground := func(ctx context.Context) chan error {
+			errs := make(chan error, 1)
+			go func() {
+				errs <- connection.ConnectContext(ctx, 1)
+			}()
+			return errs
+		}
+
+		It("connects if the context is not cancelled", func() {
+			connection = newConnection(&testhelper.TestDriver{DB: mockdb})
+			testhelper.ExpectVersionQuery(mock, "6.0.0")
+
+			Expect(connection.ConnectContext(context.Background(), 1)).To(Succeed())
+			Expect(connection.Version.VersionString).To(Equal("6.0.0"))
+		})
+		It("abandons a slow connection attempt when the context is cancelled", func() {
+			driver := &slowContextDriver{}
+			connection = newConnection(driver)
+			ctx, cancel := context.WithCancel(context.Background())
+
+			errs := connectInBackground(ctx)
+			Eventually(driver.numAttempts).Should(Equal(int32(1)))
+			Consistently(errs, "20ms").ShouldNot(Receive())
+			cancel()
+			Eventually(errs).Should(Receive(Equal(context.Canceled)))
+			Expect(connection.ConnPool).To(BeNil())
+		})
+		It("returns the context error when the deadline passes", func() {
+			connection = newConnection(&slowContextDriver{})
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+
+			err := connection.ConnectContext(ctx, 1)
+			Expect(err).To(Equal(context.DeadlineExceeded))
+		})
+		It("stops waiting for a driver that does not take a context and closes its late connection", func() {
+			driver := &blockingDriver{release: make(chan struct{}), db: mockdb}
+			connection = newConnection(driver)
+			ctx, cancel := context.WithCancel(context.Background())
+			mock.ExpectClose()
+
+			errs := connectInBackground(ctx)
+			Consistently(errs, "20ms").ShouldNot(Receive())
+			cancel()
+			Eventually(errs).Should(Receive(Equal(context.Canceled)))
+			Expect(connection.ConnPool).To(BeNil())
+			close(driver.release)
+			Eventually(mock.ExpectationsWereMet).Should(Succeed())
+		})
+		It("does not try to connect if the context is already cancelled", func() {
+			driver := &slowContextDriver{}
+			connection = newConnection(driver)
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			err := connection.ConnectContext(ctx, 2)
+			Expect(err).To(Equal(context.Canceled))
+			Expect(driver.numAttempts()).To(Equal(int32(0)))
+		})
+		It("closes the connections already established if cancelled partway through the pool", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			connection = newConnection(&cancellingDriver{db: mockdb, cancel: cancel})
+			mock.ExpectClose()
+
+			err := connection.ConnectContext(ctx, 3)
+			Expect(err).To(Equal(context.Canceled))
+			Expect(connection.ConnPool).To(BeNil())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+
+			nextDB, nextMock := testhelper.CreateMockDB()
+			connection.Driver = &testhelper.TestDriver{DB: nextDB}
+			testhelper.ExpectVersionQuery(nextMock, "6.0.0")
+			Expect(connection.Connect(1)).To(Succeed())
+		})
+		It("abandons the utility mode probe when the context is cancelled", func() {
+			driver := &slowContextDriver{}
+			connection = newConnection(driver)
+			ctx, cancel := context.WithCancel(context.Background())
+
+			errs := make(chan error, 1)
+			go func() {
+				errs <- connection.ConnectContext(ctx, 1, true)
+			}()
+			Eventually(driver.numAttempts).Should(Equal(int32(1)))
+			cancel()
+			Eventually(errs).Should(Receive(Equal(context.Canceled)))
+			Expect(connection.ConnPool).To(BeNil())
+		})
+	})
+	Describe("DBConn.SetConnectionParam", func() {
+		var driver *testhelper.TestDriver
+		BeforeEach(func() {
+			connection, mock = testhelper.CreateMockDBConn()
+			connection.User = "testrole"
+			driver = connection.Driver.(*testhelper.TestDriver)
+			testhelper.ExpectVersionQuery(mock, "5.1.0")
+		})
+		It("uses the default parameters if none are set", func() {
+			connection.MustConnect(1)
+			Expect(driver.DataSourceName).To(Equal("postgres://testrole@testhost:5432/testdb?sslmode=disable&statement_cache_capacity=0"))
+		})
+		It("adds custom parameters to the connection string", func() {
+			connection.SetConnectionParam("keepalives", "1")
+			connection.SetConnectionParam("sslrootcert", "/etc/certs/root.crt")
+			connection.MustConnect(1)
+			Expect(driver.DataSourceName).To(Equal("postgres://testrole@testhost:5432/testdb?keepalives=1&sslmode=disable&sslrootcert=%2Fetc%2Fcerts%2Froot.crt&statement_cache_capacity=0"))
+		})
+		It("overrides a default parameter with a custom one", func() {
+			connection.SetConnectionParam("sslmode", "require")
+			connection.MustConnect(1)
+			Expect(driver.DataSourceName).To(Equal("postgres://testrole@testhost:5432/testdb?sslmode=require&statement_cache_capacity=0"))
+		})
+		It("keeps custom parameters in utility mode", func() {
+			connection, mock = testhelper.CreateMockDBConn(nil)
+			connection.User = "testrole"
+			driver = connection.Driver.(*testhelper.TestDriver)
+			testhelper.ExpectVersionQuery(mock, "6.0.0")
+			connection.SetConnectionParam("keepalives", "1")
+			connection.MustConnectInUtilityMode(1)
+			Expect(driver.DataSourceName).To(Equal("postgres://testrole@testhost:5432/testdb?keepalives=1&sslmode=disable&statement_cache_capacity=0&gp_session_role=utility"))
+		})
+	})
+	Describe("DBConn.SetPassword", func() {
+		var driver *testhelper.TestDriver
+		BeforeEach(func() {
+			connection, mock = testhelper.CreateMockDBConn()
+			connection.User = "testrole"
+			driver = connection.Driver.(*testhelper.TestDriver)
+		})
+		It("passes the password to the driver", func() {
+			testhelper.ExpectVersionQuery(mock, "5.1.0")
+			connection.SetPassword("s3cret")
+			connection.MustConnect(1)
+			Expect(driver.DataSourceName).To(Equal("postgres://testrole@testhost:5432/testdb?password=s3cret&sslmode=disable&statement_cache_capacity=0"))
+		})
+		It("overrides a password set as a connection parameter", func() {
+			testhelper.ExpectVersionQuery(mock, "5.1.0")
+			connection.SetConnectionParam("password", "fromparam")
+			connection.SetPassword("s3cret")
+			connection.MustConnect(1)
+			Expect(driver.DataSourceName).To(ContainSubstring("password=s3cret&"))
+			Expect(driver.DataSourceName).ToNot(ContainSubstring("fromparam"))
+		})
+		It("does not include the password in ConnectionString or DescribeConfig", func() {
+			connection.SetPassword("s3cret")
+			Expect(connection.ConnectionString()).To(Equal("postgres://testrole@testhost:5432/testdb?password=REDACTED&sslmode=disable&statement_cache_capacity=0"))
+			Expect(fmt.Sprintf("%v", connection.DescribeConfig())).ToNot(ContainSubstring("s3cret"))
+		})
+		It("does not log the password", func() {
+			stdout, stderr, logfile := testhelper.SetupTestLogger()
+			testhelper.ExpectVersionQuery(mock, "5.1.0")
+			connection.SetPassword("s3cret")
+			connection.MustConnect(1)
+			Expect(driver.DataSourceName).To(ContainSubstring("s3cret"))
+			for _, buffer := range []*gbytes.Buffer{stdout, stderr, logfile} {
+				Expect(string(buffer.Contents())).ToNot(ContainSubstring("s3cret"))
+			}
+		})
+		It("removes the password from connection errors", func() {
+			driver.ErrToReturn = errors.New("failed to connect with password s3cret")
+			connection.SetPassword("s3cret")
+			err := connection.Connect(1)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).ToNot(ContainSubstring("s3cret"))
+			Expect(err.Error()).To(ContainSubstring("password REDACTED"))
+		})
+		It("is copied by Clone", func() {
+			connection.SetPassword("s3cret")
+			Expect(connection.Clone().ConnectionString()).To(ContainSubstring("password=REDACTED"))
+		})
+	})
+	Describe("DBConn.DescribeConfig", func() {
+		It("describes an unconnected DBConn", func() {
+			connection, mock = testhelper.CreateMockDBConn()
+			connection.User = "testrole"
+			connection.SetConnectionParam("application_name", "gpbackup")
+
+			Expect(connection.DescribeConfig()).To(Equal(map[string]interface{}{
+				"host":                "testhost",
+				"port":                5432,
+				"dbname":              "testdb",
+				"user":                "testrole",
+				"connected":           false,
+				"num_conns":           0,
+				"sslmode":             "disable",
+				"application_name":    "gpbackup",
+				"connection_params":   map[string]string{"application_name": "gpbackup", "sslmode": "disable", "statement_cache_capacity": "0"},
+				"dry_run":             false,
+				"auto_conn_selection": false,
+				"auto_reconnect":      false,
+			}))
+		})
+		It("describes a connected DBConn", func() {
+			connection, mock = testhelper.CreateMockDBConn()
+			testhelper.ExpectVersionQuery(mock, "6.20.0")
+			_ = connection.SetSSLMode("verify-full")
+			connection.SetSSLCertificates("/etc/certs/root.crt", "", "")
+			connection.EnableAutoConnSelection()
+			connection.MustConnect(2)
+
+			config := connection.DescribeConfig()
+			Expect(config["connected"]).To(BeTrue())
+			Expect(config["num_conns"]).To(Equal(2))
+			Expect(config["version"]).To(Equal("6.20.0"))
+			Expect(config["sslmode"]).To(Equal("verify-full"))
+			Expect(config["auto_conn_selection"]).To(BeTrue())
+			Expect(config["connection_params"]).To(HaveKeyWithValue("sslrootcert", "/etc/certs/root.crt"))
+		})
+		It("omits the password", func() {
+			connection, mock = testhelper.CreateMockDBConn()
+			connection.SetConnectionParam("password", "hunter2")
+			connection.SetConnectionParam("sslpassword", "hunter3")
+
+			config := connection.DescribeConfig()
+			Expect(config["connection_params"]).ToNot(HaveKey("password"))
+			Expect(config["connection_params"]).ToNot(HaveKey("sslpassword"))
+			Expect(fmt.Sprint(config)).ToNot(ContainSubstring("hunter"))
+		})
+	})
+	Describe("DBConn.ConnectionString", func() {
+		AfterEach(func() {
+			operating.System = operating.InitializeSystemFunctions()
+		})
+		It("returns the connection string Connect would use without connecting", func() {
+			connection, mock = testhelper.CreateMockDBConn()
+			connection.User = "testrole"
+			connection.SetConnectionParam("application_name", "gpbackup")
+			Expect(connection.SetSSLMode("require")).To(Succeed())
+
+			Expect(connection.ConnectionString()).To(Equal("postgres://testrole@testhost:5432/testdb?application_name=gpbackup&sslmode=require&statement_cache_capacity=0"))
+			Expect(connection.ConnPool).To(BeNil())
+		})
+		It("matches the connection string passed to the driver", func() {
+			connection, mock = testhelper.CreateMockDBConn()
+			driver := connection.Driver.(*testhelper.TestDriver)
+			operating.System.Getenv = func(key string) string {
+				if key == "PGSSLMODE" {
+					return "prefer"
+				}
+				return ""
+			}
+			connection.SetConnectionParam("application_name", "gprestore")
+			expected := connection.ConnectionString()
+			testhelper.ExpectVersionQuery(mock, "6.0.0")
+			connection.MustConnect(1)
+
+			Expect(expected).To(ContainSubstring("sslmode=prefer"))
+			Expect(driver.DataSourceName).To(Equal(expected))
+		})
+		It("redacts the password", func() {
+			connection, mock = testhelper.CreateMockDBConn()
+			connection.SetConnectionParam("password", "hunter2")
+			connection.SetConnectionParam("sslpassword", "hunter3")
+
+			connStr := connection.ConnectionString()
+			Expect(connStr).To(ContainSubstring("password=REDACTED"))
+			Expect(connStr).To(ContainSubstring("sslpassword=REDACTED"))
+			Expect(connStr).ToNot(ContainSubstring("hunter"))
+		})
+	})
+	Describe("DBConn.SetSSLMode", func() {
+		var driver *testhelper.TestDriver
+		BeforeEach(func() {
+			connection, mock = testhelper.CreateMockDBConn()
+			connection.User = "testrole"
+			driver = connection.Driver.(*testhelper.TestDriver)
+			testhelper.ExpectVersionQuery(mock, "5.1.0")
+		})
+		AfterEach(func() {
+			operating.System = operating.InitializeSystemFunctions()
+		})
+		DescribeTable("accepts a valid sslmode", func(mode string) {
+			err := connection.SetSSLMode(mode)
+			Expect(err).ToNot(HaveOccurred())
+			connection.MustConnect(1)
+			Expect(driver.DataSourceName).To(Equal(fmt.Sprintf("postgres://testrole@testhost:5432/testdb?sslmode=%s&statement_cache_capacity=0", mode)))
+		},
+			Entry("disable", "disable"),
+			Entry("allow", "allow"),
+			Entry("prefer", "prefer"),
+			Entry("require", "require"),
+			Entry("verify-ca", "verify-ca"),
+			Entry("verify-full", "verify-full"),
+		)
+		It("rejects an invalid sslmode", func() {
+			err := connection.SetSSLMode("always")
+			Expect(err).To(MatchError(`Invalid SSL mode "always"; must be one of disable, allow, prefer, require, verify-ca, or verify-full`))
+			connection.MustConnect(1)
+			Expect(driver.DataSourceName).To(ContainSubstring("sslmode=disable"))
+		})
+		It("uses PGSSLMODE if no sslmode is set", func() {
+			operating.System.Getenv = func(key string) string {
+				if key == "PGSSLMODE" {
+					return "require"
+				}
+				return ""
+			}
+			connection.MustConnect(1)
+			Expect(driver.DataSourceName).To(ContainSubstring("sslmode=require"))
+		})
+		It("prefers the set sslmode over PGSSLMODE", func() {
+			operating.System.Getenv = func(key string) string {
+				if key == "PGSSLMODE" {
+					return "require"
+				}
+				return ""
+			}
+			_ = connection.SetSSLMode("verify-full")
+			connection.MustConnect(1)
+			Expect(driver.DataSourceName).To(ContainSubstring("sslmode=verify-full"))
+		})
+		It("adds the certificate paths to the connection string", func() {
+			_ = connection.SetSSLMode("verify-ca")
+			connection.SetSSLCertificates("/certs/root.crt", "/certs/client.crt", "")
+			connection.MustConnect(1)
+			Expect(driver.DataSourceName).To(Equal("postgres://testrole@testhost:5432/testdb?sslcert=%2Fcerts%2Fclient.crt&sslmode=verify-ca&sslrootcert=%2Fcerts%2Froot.crt&statement_cache_capacity=0"))
+		})
+		It("prefers the set sslmode over an sslmode connection parameter", func() {
+			connection.SetConnectionParam("sslmode", "disable")
+			_ = connection.SetSSLMode("verify-full")
+			connection.MustConnect(1)
+			Expect(driver.DataSourceName).To(ContainSubstring("sslmode=verify-full"))
+		})
+		It("validates an sslmode connection parameter on connect", func() {
+			connection.SetConnectionParam("sslmode", "always")
+			err := connection.Connect(1)
+			Expect(err).To(MatchError(`Invalid SSL mode "always"; must be one of disable, allow, prefer, require, verify-ca, or verify-full`))
+			Expect(connection.ConnPool).To(BeNil())
+		})
+	})
+	Describe("DBConn.Resize", func() {
+		var mocks []sqlmock.Sqlmock
+		fakeResult := testhelper.TestResult{Rows: 0}
+
+		It("grows the pool from 1 to 3 connections", func() {
+			connection, mocks = testhelper.CreateMockDBConnWithMocks(3)
+			connection.MustConnect(1)
+			firstConn := connection.ConnPool[0]
+
+			Expect(connection.Resize(3)).To(Succeed())
+			Expect(connection.NumConns).To(Equal(3))
+			Expect(connection.ConnPool).To(HaveLen(3))
+			Expect(connection.Tx).To(HaveLen(3))
+			Expect(connection.ConnPool[0]).To(BeIdenticalTo(firstConn))
+			Expect(connection.Version.VersionString).To(Equal("5.1.0"))
+
+			mocks[2].ExpectExec("SELECT 1").WillReturnResult(fakeResult)
+			connection.MustExec("SELECT 1", 2)
+			Expect(mocks[2].ExpectationsWereMet()).To(Succeed())
+		})
+		It("applies the session GUCs to new connections", func() {
+			connection, mocks = testhelper.CreateMockDBConnWithMocks(2)
+			connection.SetSessionGUCs(map[string]string{"search_path": "public"})
+			mocks[0].ExpectExec("SET search_path TO public").WillReturnResult(fakeResult)
+			connection.MustConnect(1)
+			mocks[1].ExpectExec("SET search_path TO public").WillReturnResult(fakeResult)
+
+			Expect(connection.Resize(2)).To(Succeed())
+			Expect(mocks[0].ExpectationsWereMet()).To(Succeed())
+			Expect(mocks[1].ExpectationsWereMet()).To(Succeed())
+		})
+		It("shrinks the pool from 3 to 1 connection and closes the surplus connections", func() {
+			connection, mocks = testhelper.CreateAndConnectMockDBWithMocks(3)
+			firstConn := connection.ConnPool[0]
+			mocks[1].ExpectClose()
+			mocks[2].ExpectClose()
+
+			Expect(connection.Resize(1)).To(Succeed())
+			Expect(connection.NumConns).To(Equal(1))
+			Expect(connection.ConnPool).To(HaveLen(1))
+			Expect(connection.Tx).To(HaveLen(1))
+			Expect(connection.ConnPool[0]).To(BeIdenticalTo(firstConn))
+			Expect(mocks[1].ExpectationsWereMet()).To(Succeed())
+			Expect(mocks[2].ExpectationsWereMet()).To(Succeed())
+			defer testhelper.ShouldPanicWithMessage("Invalid connection number: 1")
+			connection.ValidateConnNum(1)
+		})
+		It("leases only the remaining connections after shrinking", func() {
+			connection, mocks = testhelper.CreateAndConnectMockDBWithMocks(3)
+			connection.EnableAutoConnSelection()
+
+			Expect(connection.Resize(1)).To(Succeed())
+			for i := 0; i < 3; i++ {
+				Expect(connection.WithConnection(func(connNum int) error {
+					Expect(connNum).To(Equal(0))
+					return nil
+				})).To(Succeed())
+			}
+		})
+		It("keeps a transaction in progress on a remaining connection", func() {
+			connection, mocks = testhelper.CreateAndConnectMockDBWithMocks(2)
+			testhelper.ExpectBegin(mocks[0])
+			connection.MustBegin(0)
+
+			Expect(connection.Resize(1)).To(Succeed())
+			Expect(connection.Tx[0]).ToNot(BeNil())
+		})
+		It("panics if a connection to be removed has a transaction in progress", func() {
+			connection, mocks = testhelper.CreateAndConnectMockDBWithMocks(3)
+			testhelper.ExpectBegin(mocks[2])
+			connection.MustBegin(2)
+
+			defer func() {
+				Expect(connection.NumConns).To(Equal(3))
+				Expect(connection.ConnPool).To(HaveLen(3))
+				Expect(connection.Tx[2]).ToNot(BeNil())
+			}()
+			defer testhelper.ShouldPanicWithMessage("Cannot remove connection 2 from the pool; there is a transaction in progress")
+			_ = connection.Resize(1)
+		})
+		It("returns an error without changing the pool if a connection is leased", func() {
+			connection, mocks = testhelper.CreateAndConnectMockDBWithMocks(2)
+
+			err := connection.WithConnection(func(connNum int) error {
+				return connection.Resize(1)
+			})
+			Expect(err).To(MatchError("Cannot resize the connection pool; there are connections in use by WithConnection or auto connection selection"))
+			Expect(connection.NumConns).To(Equal(2))
+			Expect(connection.ConnPool).To(HaveLen(2))
+			for i := 0; i < 2; i++ {
+				Expect(connection.WithConnection(func(connNum int) error { return nil })).To(Succeed())
+			}
+		})
+		It("leaves the pool unchanged if a new connection cannot be established", func() {
+			connection, mocks = testhelper.CreateMockDBConnWithMocks(2)
+			connection.MustConnect(1)
+
+			err := connection.Resize(3)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("TestMultiDriver was only given 2 mock databases"))
+			Expect(connection.NumConns).To(Equal(1))
+			Expect(connection.ConnPool).To(HaveLen(1))
+		})
+		It("returns an error if the new size is not positive", func() {
+			err := connection.Resize(0)
+			Expect(err).To(MatchError("Must specify a connection pool size that is a positive integer"))
+			Expect(connection.NumConns).To(Equal(1))
+		})
+		It("returns an error if the connection is not open", func() {
+			connection.Close()
+			err := connection.Resize(2)
+			Expect(err).To(MatchError("The database connection must be open to resize the connection pool"))
+		})
+	})
 	Describe("DBConn.Close", func() {
 		BeforeEach(func() {
 			connection, mock = testhelper.CreateMockDBConn()
@@ -196,7 +762,7 @@ var _ = Describe("dbconn/dbconn tests", func() {
 		})
 		It("executes an INSERT in a transaction", func() {
 			fakeResult := testhelper.TestResult{Rows: 1}
-			ExpectBegin(mock)
+			testhelper.ExpectBegin(mock)
 			mock.ExpectExec("INSERT (.*)").WillReturnResult(fakeResult)
 			mock.ExpectCommit()
 
@@ -208,6 +774,139 @@ var _ = Describe("dbconn/dbconn tests", func() {
 			Expect(rowsReturned).To(Equal(int64(1)))
 		})
 	})
+	Describe("DBConn.ExecAndCount", func() {
+		It("returns the number of rows affected outside of a transaction", func() {
+			mock.ExpectExec("UPDATE (.*)").WithArgs("schema").WillReturnResult(testhelper.TestResult{Rows: 3})
+
+			count, err := connection.ExecAndCount("UPDATE foo SET i = 1 WHERE schemaname = $1", "schema")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(count).To(Equal(int64(3)))
+		})
+		It("returns the number of rows affected in a transaction", func() {
+			testhelper.ExpectBegin(mock)
+			mock.ExpectExec("DELETE (.*)").WillReturnResult(sqlmock.NewResult(0, 2))
+			mock.ExpectCommit()
+
+			connection.MustBegin()
+			count, err := connection.ExecAndCount("DELETE FROM foo")
+			connection.MustCommit()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(count).To(Equal(int64(2)))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("returns an error if the statement fails", func() {
+			mock.ExpectExec("DELETE (.*)").WillReturnError(errors.New("permission denied"))
+
+			count, err := connection.ExecAndCount("DELETE FROM foo")
+			Expect(err).To(MatchError("permission denied"))
+			Expect(count).To(Equal(int64(0)))
+		})
+		It("returns an error if the number of rows affected is unavailable", func() {
+			mock.ExpectExec("DELETE (.*)").WillReturnResult(sqlmock.NewErrorResult(errors.New("no RowsAffected available")))
+
+			_, err := connection.ExecAndCount("DELETE FROM foo")
+			Expect(err).To(MatchError("no RowsAffected available"))
+		})
+	})
+	Describe("DBConn.ExecReturning", func() {
+		It("scans the generated id returned by an insert", func() {
+			mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO foo (name) VALUES ($1) RETURNING id")).WithArgs("bar").
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+
+			var id int
+			err := connection.ExecReturning(&id, "INSERT INTO foo (name) VALUES ($1) RETURNING id", "bar")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(id).To(Equal(42))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("scans the returned row into a struct in a transaction", func() {
+			testhelper.ExpectBegin(mock)
+			mock.ExpectQuery("INSERT (.*) RETURNING (.*)").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(7, "bar"))
+			mock.ExpectCommit()
+
+			row := struct {
+				ID   int
+				Name string
+			}{}
+			connection.MustBegin()
+			err := connection.ExecReturning(&row, "INSERT INTO foo (name) VALUES ('bar') RETURNING id, name")
+			connection.MustCommit()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(row.ID).To(Equal(7))
+			Expect(row.Name).To(Equal("bar"))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("returns sql.ErrNoRows if no row is returned", func() {
+			mock.ExpectQuery("UPDATE (.*) RETURNING (.*)").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+			var id int
+			err := connection.ExecReturning(&id, "UPDATE foo SET name = 'bar' WHERE id = 0 RETURNING id")
+			Expect(err).To(Equal(sql.ErrNoRows))
+		})
+		It("returns an error if the statement fails", func() {
+			mock.ExpectQuery("INSERT (.*)").WillReturnError(errors.New("permission denied"))
+
+			var id int
+			err := connection.ExecReturning(&id, "INSERT INTO foo (name) VALUES ('bar') RETURNING id")
+			Expect(err).To(MatchError("permission denied"))
+		})
+		It("does not run the statement in dry-run mode", func() {
+			connection.SetDryRun(true)
+			id := 3
+			err := connection.ExecReturning(&id, "INSERT INTO foo (name) VALUES ('bar') RETURNING id")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(id).To(Equal(3))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+	})
+	Describe("DBConn.SetDryRun", func() {
+		var stdout *gbytes.Buffer
+		BeforeEach(func() {
+			stdout, _, _ = testhelper.SetupTestLogger()
+			connection.SetDryRun(true)
+		})
+		It("logs statements passed to Exec without running them", func() {
+			res, err := connection.Exec("DROP TABLE foo")
+			Expect(err).ToNot(HaveOccurred())
+			rowsAffected, err := res.RowsAffected()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rowsAffected).To(Equal(int64(0)))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+			testhelper.ExpectRegexp(stdout, "Dry run, not executing: DROP TABLE foo")
+		})
+		It("does not run statements passed to the other Exec functions", func() {
+			_, err := connection.ExecWithArgs("DELETE FROM foo WHERE i = $1", 1)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = connection.ExecContext(context.Background(), "TRUNCATE foo")
+			Expect(err).ToNot(HaveOccurred())
+			_, err = connection.ExecPrepared("INSERT INTO foo VALUES ($1)", 1)
+			Expect(err).ToNot(HaveOccurred())
+			count, err := connection.ExecAndCount("UPDATE foo SET i = 2")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(count).To(Equal(int64(0)))
+			connection.MustExecScript("DROP TABLE foo; DROP TABLE bar;")
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+			testhelper.ExpectRegexp(stdout, "Dry run, not executing: DELETE FROM foo WHERE i = $1")
+			testhelper.ExpectRegexp(stdout, "Dry run, not executing: TRUNCATE foo")
+			testhelper.ExpectRegexp(stdout, "Dry run, not executing: DROP TABLE bar")
+		})
+		It("still runs queries passed to Select", func() {
+			mock.ExpectQuery("SELECT (.*)").WillReturnRows(sqlmock.NewRows([]string{"relname"}).AddRow("foo"))
+			tables := make([]string, 0)
+			err := connection.Select(&tables, "SELECT relname FROM pg_class")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tables).To(Equal([]string{"foo"}))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("runs statements again once dry-run mode is disabled", func() {
+			connection.SetDryRun(false)
+			mock.ExpectExec("DROP TABLE foo").WillReturnResult(testhelper.TestResult{Rows: 0})
+			_, err := connection.Exec("DROP TABLE foo")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+	})
 	Describe("DBConn.ExecContext", func() {
 		It("executes an INSERT outside of a transaction", func() {
 			ctx, cancel := context.WithCancel(context.Background())
@@ -226,7 +925,7 @@ var _ = Describe("dbconn/dbconn tests", func() {
 			defer cancel()
 
 			fakeResult := testhelper.TestResult{Rows: 1}
-			ExpectBegin(mock)
+			testhelper.ExpectBegin(mock)
 			mock.ExpectExec("INSERT (.*)").WillReturnResult(fakeResult)
 			mock.ExpectCommit()
 
@@ -238,6 +937,172 @@ var _ = Describe("dbconn/dbconn tests", func() {
 			Expect(rowsReturned).To(Equal(int64(1)))
 		})
 	})
+	Describe("DBConn.ExecWithArgs", func() {
+		It("executes an INSERT with arguments outside of a transaction", func() {
+			fakeResult := testhelper.TestResult{Rows: 1}
+			mock.ExpectExec("INSERT (.*)").WithArgs("schema", "table").WillReturnResult(fakeResult)
+
+			res, err := connection.ExecWithArgs("INSERT INTO pg_tables VALUES ($1, $2)", "schema", "table")
+			Expect(err).ToNot(HaveOccurred())
+			rowsReturned, err := res.RowsAffected()
+			Expect(rowsReturned).To(Equal(int64(1)))
+		})
+		It("executes an INSERT with arguments in a transaction", func() {
+			fakeResult := testhelper.TestResult{Rows: 1}
+			testhelper.ExpectBegin(mock)
+			mock.ExpectExec("INSERT (.*)").WithArgs("schema", "table").WillReturnResult(fakeResult)
+			mock.ExpectCommit()
+
+			connection.MustBegin()
+			_, err := connection.ExecWithArgs("INSERT INTO pg_tables VALUES ($1, $2)", "schema", "table")
+			connection.MustCommit()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+	})
+	Describe("DBConn.BatchInsert", func() {
+		columns := []string{"id", "name"}
+		It("splits the rows into batches of the given size", func() {
+			rows := [][]interface{}{{1, "one"}, {2, "two"}, {3, "three"}, {4, "four"}, {5, "five"}}
+			mock.ExpectExec(regexp.QuoteMeta("INSERT INTO foo (id, name) VALUES ($1, $2), ($3, $4)")).
+				WithArgs(1, "one", 2, "two").WillReturnResult(testhelper.TestResult{Rows: 2})
+			mock.ExpectExec(regexp.QuoteMeta("INSERT INTO foo (id, name) VALUES ($1, $2), ($3, $4)")).
+				WithArgs(3, "three", 4, "four").WillReturnResult(testhelper.TestResult{Rows: 2})
+			mock.ExpectExec(regexp.QuoteMeta("INSERT INTO foo (id, name) VALUES ($1, $2)")).
+				WithArgs(5, "five").WillReturnResult(testhelper.TestResult{Rows: 1})
+
+			err := connection.BatchInsert("foo", columns, rows, 2)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("reduces the batch size to stay under the bind parameter limit", func() {
+			rows := make([][]interface{}, 40000)
+			for i := range rows {
+				rows[i] = []interface{}{i, "name"}
+			}
+			maxRows := 65535 / 2
+			mock.ExpectExec(regexp.QuoteMeta(fmt.Sprintf("($%d, $%d)", 2*maxRows-1, 2*maxRows)) + "$").WillReturnResult(testhelper.TestResult{Rows: int64(maxRows)})
+			mock.ExpectExec(regexp.QuoteMeta(fmt.Sprintf("($%d, $%d)", 2*(40000-maxRows)-1, 2*(40000-maxRows))) + "$").WillReturnResult(testhelper.TestResult{Rows: int64(40000 - maxRows)})
+
+			err := connection.BatchInsert("foo", columns, rows, 50000)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("inserts in the transaction if one is in progress", func() {
+			testhelper.ExpectBegin(mock)
+			mock.ExpectExec(regexp.QuoteMeta("INSERT INTO foo (id, name) VALUES ($1, $2)")).
+				WithArgs(1, "one").WillReturnResult(testhelper.TestResult{Rows: 1})
+			mock.ExpectCommit()
+
+			connection.MustBegin()
+			err := connection.BatchInsert("foo", columns, [][]interface{}{{1, "one"}}, 10)
+			connection.MustCommit()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("does nothing if there are no rows", func() {
+			err := connection.BatchInsert("foo", columns, [][]interface{}{}, 10)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("returns an error if the batch size is not positive", func() {
+			err := connection.BatchInsert("foo", columns, [][]interface{}{{1, "one"}}, 0)
+			Expect(err).To(MatchError("Batch size must be a positive integer, got 0"))
+		})
+		It("returns an error if a row has the wrong number of values", func() {
+			err := connection.BatchInsert("foo", columns, [][]interface{}{{1, "one"}, {2}}, 10)
+			Expect(err).To(MatchError("Row 1 has 1 values, expected 2 values"))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+	})
+	Describe("DBConn.SetQueryTransformer", func() {
+		remapSchema := func(query string) string {
+			return strings.Replace(query, "public.foo", "restored.foo", -1)
+		}
+		It("sends statements unchanged by default", func() {
+			mock.ExpectExec(regexp.QuoteMeta("INSERT INTO public.foo VALUES (1)")).WillReturnResult(testhelper.TestResult{Rows: 1})
+			_, err := connection.Exec("INSERT INTO public.foo VALUES (1)")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("rewrites statements passed to Exec and ExecWithArgs", func() {
+			connection.SetQueryTransformer(remapSchema)
+			mock.ExpectExec(regexp.QuoteMeta("INSERT INTO restored.foo VALUES (1)")).WillReturnResult(testhelper.TestResult{Rows: 1})
+			mock.ExpectExec(regexp.QuoteMeta("INSERT INTO restored.foo VALUES ($1)")).WithArgs(2).WillReturnResult(testhelper.TestResult{Rows: 1})
+			_, err := connection.Exec("INSERT INTO public.foo VALUES (1)")
+			Expect(err).ToNot(HaveOccurred())
+			_, err = connection.ExecWithArgs("INSERT INTO public.foo VALUES ($1)", 2)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("rewrites queries passed to Select and Get", func() {
+			connection.SetQueryTransformer(remapSchema)
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT i FROM restored.foo")).WillReturnRows(sqlmock.NewRows([]string{"i"}).AddRow(1))
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM restored.foo")).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+			results := make([]int, 0)
+			err := connection.Select(&results, "SELECT i FROM public.foo")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(results).To(Equal([]int{1}))
+			var count int
+			err = connection.Get(&count, "SELECT count(*) FROM public.foo")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(count).To(Equal(1))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("rewrites statements run inside a transaction", func() {
+			connection.SetQueryTransformer(remapSchema)
+			testhelper.ExpectBegin(mock)
+			mock.ExpectExec(regexp.QuoteMeta("DELETE FROM restored.foo")).WillReturnResult(testhelper.TestResult{Rows: 1})
+			mock.ExpectCommit()
+			connection.MustBegin()
+			connection.MustExec("DELETE FROM public.foo")
+			connection.MustCommit()
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("does not rewrite the statements issued by the package itself", func() {
+			connection, mock = testhelper.CreateMockDBConn()
+			connection.SetQueryTransformer(func(query string) string {
+				return "REWRITTEN " + query
+			})
+			versionRow := sqlmock.NewRows([]string{"versionstring"}).AddRow("(Greenplum Database 6.0.0)")
+			mock.ExpectQuery("^" + regexp.QuoteMeta("SELECT pg_catalog.version() AS versionstring") + "$").WillReturnRows(versionRow)
+			connection.MustConnect(1)
+			mock.ExpectBegin()
+			mock.ExpectExec("^SET TRANSACTION ISOLATION LEVEL SERIALIZABLE$").WillReturnResult(testhelper.TestResult{Rows: 0})
+			mock.ExpectExec("^" + regexp.QuoteMeta("REWRITTEN DELETE FROM foo") + "$").WillReturnResult(testhelper.TestResult{Rows: 1})
+			connection.MustBegin()
+			connection.MustExec("DELETE FROM foo")
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("restores the default when set to nil", func() {
+			connection.SetQueryTransformer(remapSchema)
+			connection.SetQueryTransformer(nil)
+			mock.ExpectExec(regexp.QuoteMeta("DELETE FROM public.foo")).WillReturnResult(testhelper.TestResult{Rows: 1})
+			connection.MustExec("DELETE FROM public.foo")
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+	})
+	Describe("DBConn.ExecIgnoreMissing", func() {
+		It("ignores an error for an object that does not exist", func() {
+			mock.ExpectExec("DROP TABLE foo").WillReturnError(&pgconn.PgError{Code: "42P01", Message: `table "foo" does not exist`})
+
+			err := connection.ExecIgnoreMissing("DROP TABLE foo")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("returns any other error", func() {
+			mock.ExpectExec("DROP TABLE foo").WillReturnError(&pgconn.PgError{Severity: "ERROR", Code: "2BP01", Message: "cannot drop table foo because other objects depend on it"})
+
+			err := connection.ExecIgnoreMissing("DROP TABLE foo")
+			Expect(err).To(MatchError("ERROR: cannot drop table foo because other objects depend on it (SQLSTATE 2BP01)"))
+		})
+		It("returns an error that did not come from the server", func() {
+			mock.ExpectExec("DROP TABLE foo").WillReturnError(errors.New("driver: bad connection"))
+
+			err := connection.ExecIgnoreMissing("DROP TABLE foo")
+			Expect(err).To(MatchError("driver: bad connection"))
+		})
+	})
 	Describe("DBConn.Get", func() {
 		It("executes a GET outside of a transaction", func() {
 			two_col_single_row := sqlmock.NewRows([]string{"schemaname", "tablename"}).
@@ -275,7 +1140,7 @@ var _ = Describe("dbconn/dbconn tests", func() {
 		It("executes a GET in a transaction", func() {
 			two_col_single_row := sqlmock.NewRows([]string{"schemaname", "tablename"}).
 				AddRow("schema1", "table1")
-			ExpectBegin(mock)
+			testhelper.ExpectBegin(mock)
 			mock.ExpectQuery("SELECT (.*)").WillReturnRows(two_col_single_row)
 			mock.ExpectCommit()
 
@@ -339,7 +1204,7 @@ var _ = Describe("dbconn/dbconn tests", func() {
 			two_col_rows := sqlmock.NewRows([]string{"schemaname", "tablename"}).
 				AddRow("schema1", "table1").
 				AddRow("schema2", "table2")
-			ExpectBegin(mock)
+			testhelper.ExpectBegin(mock)
 			mock.ExpectQuery("SELECT (.*)").WillReturnRows(two_col_rows)
 			mock.ExpectCommit()
 
@@ -360,14 +1225,241 @@ var _ = Describe("dbconn/dbconn tests", func() {
 			Expect(testSlice[1].Tablename).To(Equal("table2"))
 		})
 	})
+	Describe("DBConn.NamedSelect", func() {
+		type tableFilter struct {
+			Schema string `db:"schema"`
+			Oids   []int  `db:"oids"`
+		}
+		It("binds struct fields by name and expands a slice in an IN clause", func() {
+			mock.ExpectQuery("^"+regexp.QuoteMeta("SELECT relname FROM pg_class WHERE relnamespace = $1 AND oid IN ($2, $3, $4)")+"$").
+				WithArgs("public", 16384, 16385, 16386).
+				WillReturnRows(sqlmock.NewRows([]string{"relname"}).AddRow("foo").AddRow("bar"))
+
+			tables := make([]string, 0)
+			err := connection.NamedSelect(&tables, "SELECT relname FROM pg_class WHERE relnamespace = :schema AND oid IN (:oids)", tableFilter{Schema: "public", Oids: []int{16384, 16385, 16386}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tables).To(Equal([]string{"foo", "bar"}))
+		})
+		It("expands an empty slice to a NULL that matches no rows", func() {
+			mock.ExpectQuery("^"+regexp.QuoteMeta("SELECT relname FROM pg_class WHERE relnamespace = $1 AND oid IN ($2)")+"$").
+				WithArgs("public", nil).
+				WillReturnRows(sqlmock.NewRows([]string{"relname"}))
+
+			tables := make([]string, 0)
+			err := connection.NamedSelect(&tables, "SELECT relname FROM pg_class WHERE relnamespace = :schema AND oid IN (:oids)", tableFilter{Schema: "public"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tables).To(BeEmpty())
+		})
+		It("binds map entries by name", func() {
+			mock.ExpectQuery("^"+regexp.QuoteMeta("SELECT relname FROM pg_class WHERE relname IN ($1, $2)")+"$").
+				WithArgs("foo", "bar").
+				WillReturnRows(sqlmock.NewRows([]string{"relname"}).AddRow("foo"))
+
+			tables := make([]string, 0)
+			err := connection.NamedSelect(&tables, "SELECT relname FROM pg_class WHERE relname IN (:names)", map[string]interface{}{"names": []string{"foo", "bar"}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tables).To(Equal([]string{"foo"}))
+		})
+		It("returns an error if a named argument is missing", func() {
+			tables := make([]string, 0)
+			err := connection.NamedSelect(&tables, "SELECT relname FROM pg_class WHERE relname = :name", map[string]interface{}{})
+			Expect(err).To(HaveOccurred())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+	})
+	Describe("DBConn.NamedGet", func() {
+		It("binds struct fields by name in a transaction", func() {
+			testhelper.ExpectBegin(mock)
+			mock.ExpectQuery("^"+regexp.QuoteMeta("SELECT count(*) FROM pg_class WHERE oid IN ($1, $2)")+"$").
+				WithArgs(1, 2).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+			mock.ExpectCommit()
+
+			connection.MustBegin()
+			var count int
+			err := connection.NamedGet(&count, "SELECT count(*) FROM pg_class WHERE oid IN (:oids)", struct {
+				Oids []int `db:"oids"`
+			}{Oids: []int{1, 2}})
+			connection.MustCommit()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(count).To(Equal(2))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+	})
+	Describe("DBConn.SelectExactlyN", func() {
+		header := []string{"content"}
+		It("succeeds if exactly n rows are returned", func() {
+			fakeResult := sqlmock.NewRows(header).AddRow(0).AddRow(1)
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT content FROM gp_segment_configuration WHERE role = $1")).WithArgs("p").WillReturnRows(fakeResult)
+			results := make([]int, 0)
+			err := connection.SelectExactlyN(&results, 2, "SELECT content FROM gp_segment_configuration WHERE role = $1", "p")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(results).To(Equal([]int{0, 1}))
+		})
+		It("returns an error if too few rows are returned", func() {
+			fakeResult := sqlmock.NewRows(header).AddRow(0)
+			mock.ExpectQuery("SELECT (.*)").WillReturnRows(fakeResult)
+			results := make([]int, 0)
+			err := connection.SelectExactlyN(&results, 2, "SELECT content FROM gp_segment_configuration")
+			Expect(err).To(MatchError("Too few rows returned from query: got 1 rows, expected 2 rows"))
+		})
+		It("returns an error if too many rows are returned", func() {
+			fakeResult := sqlmock.NewRows(header).AddRow(0).AddRow(1).AddRow(2)
+			mock.ExpectQuery("SELECT (.*)").WillReturnRows(fakeResult)
+			results := make([]int, 0)
+			err := connection.SelectExactlyN(&results, 2, "SELECT content FROM gp_segment_configuration")
+			Expect(err).To(MatchError("Too many rows returned from query: got 3 rows, expected 2 rows"))
+		})
+		It("returns an error if the query fails", func() {
+			mock.ExpectQuery("SELECT (.*)").WillReturnError(errors.New("permission denied"))
+			results := make([]int, 0)
+			err := connection.SelectExactlyN(&results, 2, "SELECT content FROM gp_segment_configuration")
+			Expect(err).To(MatchError("permission denied"))
+		})
+	})
+	Describe("DBConn.SelectMaps", func() {
+		It("returns each row as a map from column name to value", func() {
+			fakeResult := sqlmock.NewRows([]string{"attname", "typname"}).AddRow("id", "int4").AddRow("notes", nil)
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT attname, typname FROM foo WHERE attrelid = $1")).WithArgs(16385).WillReturnRows(fakeResult)
+			results, err := connection.SelectMaps("SELECT attname, typname FROM foo WHERE attrelid = $1", 16385)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(results).To(HaveLen(2))
+			Expect(results[0]).To(Equal(map[string]interface{}{"attname": "id", "typname": "int4"}))
+			Expect(results[1]).To(HaveKey("typname"))
+			Expect(results[1]["attname"]).To(Equal("notes"))
+			Expect(results[1]["typname"]).To(BeNil())
+		})
+		It("returns an empty slice if the query selects no rows", func() {
+			mock.ExpectQuery("SELECT (.*)").WillReturnRows(sqlmock.NewRows([]string{"attname", "typname"}))
+			results, err := connection.SelectMaps("SELECT attname, typname FROM foo")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(results).To(BeEmpty())
+		})
+		It("returns an error if the query fails", func() {
+			mock.ExpectQuery("SELECT (.*)").WillReturnError(errors.New("relation foo does not exist"))
+			_, err := connection.SelectMaps("SELECT attname, typname FROM foo")
+			Expect(err).To(MatchError("relation foo does not exist"))
+		})
+	})
+	Describe("DBConn.SelectChan", func() {
+		header := []string{"id", "name"}
+		It("delivers rows in order and closes the channel", func() {
+			fakeResult := sqlmock.NewRows(header).AddRow(1, "one").AddRow(2, "two").AddRow(3, nil)
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT id, name FROM foo WHERE id > $1")).WithArgs(0).WillReturnRows(fakeResult).RowsWillBeClosed()
+			results, err := connection.SelectChan(context.Background(), "SELECT id, name FROM foo WHERE id > $1", 0)
+			Expect(err).ToNot(HaveOccurred())
+
+			received := make([]dbconn.RowResult, 0)
+			for result := range results {
+				received = append(received, result)
+			}
+			Expect(received).To(Equal([]dbconn.RowResult{
+				{Row: map[string]interface{}{"id": int64(1), "name": "one"}},
+				{Row: map[string]interface{}{"id": int64(2), "name": "two"}},
+				{Row: map[string]interface{}{"id": int64(3), "name": nil}},
+			}))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("delivers a row error and then closes the channel", func() {
+			fakeResult := sqlmock.NewRows(header).AddRow(1, "one").AddRow(2, "two").RowError(1, errors.New("connection reset"))
+			mock.ExpectQuery("SELECT (.*)").WillReturnRows(fakeResult)
+			results, err := connection.SelectChan(context.Background(), "SELECT id, name FROM foo")
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(<-results).To(Equal(dbconn.RowResult{Row: map[string]interface{}{"id": int64(1), "name": "one"}}))
+			result := <-results
+			Expect(result.Row).To(BeNil())
+			Expect(result.Err).To(MatchError("connection reset"))
+			Eventually(results).Should(BeClosed())
+		})
+		It("returns an error if the query fails", func() {
+			mock.ExpectQuery("SELECT (.*)").WillReturnError(errors.New("relation foo does not exist"))
+			_, err := connection.SelectChan(context.Background(), "SELECT id, name FROM foo")
+			Expect(err).To(MatchError("relation foo does not exist"))
+		})
+		It("stops delivering rows and closes the rows when the context is cancelled", func() {
+			fakeResult := sqlmock.NewRows(header).AddRow(1, "one").AddRow(2, "two").AddRow(3, "three")
+			mock.ExpectQuery("SELECT (.*)").WillReturnRows(fakeResult).RowsWillBeClosed()
+			ctx, cancel := context.WithCancel(context.Background())
+			results, err := connection.SelectChan(ctx, "SELECT id, name FROM foo")
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect((<-results).Row["id"]).To(Equal(int64(1)))
+			cancel()
+			Eventually(results).Should(BeClosed())
+			Eventually(mock.ExpectationsWereMet).Should(Succeed())
+		})
+	})
+	Describe("DBConn.Clone", func() {
+		It("copies the configuration but not the connection pool", func() {
+			connection, mock = testhelper.CreateMockDBConn()
+			connection.User = "testrole"
+			connection.SetConnectionParam("application_name", "gpbackup")
+			_ = connection.SetSSLMode("verify-full")
+			connection.SetSSLCertificates("/etc/certs/root.crt", "/etc/certs/client.crt", "/etc/certs/client.key")
+			connection.SetSessionGUCs(map[string]string{"statement_timeout": "0"})
+			connection.SetDryRun(true)
+			connection.EnableAutoReconnect()
+			testhelper.ExpectVersionQuery(mock, "6.20.0")
+			mock.ExpectExec("SET statement_timeout TO 0").WillReturnResult(testhelper.TestResult{Rows: 0})
+			mock.ExpectExec("SET statement_timeout TO 0").WillReturnResult(testhelper.TestResult{Rows: 0})
+			connection.MustConnect(2)
+
+			clone := connection.Clone()
+			Expect(clone.DBName).To(Equal("testdb"))
+			Expect(clone.User).To(Equal("testrole"))
+			Expect(clone.Host).To(Equal("testhost"))
+			Expect(clone.Port).To(Equal(5432))
+			Expect(clone.Driver).To(BeIdenticalTo(connection.Driver))
+			Expect(clone.DescribeConfig()).To(Equal(map[string]interface{}{
+				"host":                "testhost",
+				"port":                5432,
+				"dbname":              "testdb",
+				"user":                "testrole",
+				"connected":           false,
+				"num_conns":           0,
+				"sslmode":             "verify-full",
+				"application_name":    "gpbackup",
+				"connection_params":   connection.DescribeConfig()["connection_params"],
+				"dry_run":             true,
+				"auto_conn_selection": false,
+				"auto_reconnect":      true,
+			}))
+			Expect(clone.ConnPool).To(BeNil())
+			Expect(clone.Tx).To(BeNil())
+			Expect(clone.NumConns).To(Equal(0))
+			Expect(clone.Version).To(Equal(dbconn.GPDBVersion{}))
+		})
+		It("does not share options that are changed after cloning", func() {
+			connection, mock = testhelper.CreateMockDBConn()
+			connection.SetConnectionParam("application_name", "gpbackup")
+			clone := connection.Clone()
+			clone.SetConnectionParam("application_name", "gprestore")
+			clone.SetUser("otherrole")
+
+			Expect(connection.DescribeConfig()["application_name"]).To(Equal("gpbackup"))
+			Expect(connection.User).ToNot(Equal("otherrole"))
+		})
+		It("applies the session GUCs when the clone connects", func() {
+			connection, mock = testhelper.CreateMockDBConn()
+			connection.SetSessionGUCs(map[string]string{"statement_timeout": "0"})
+			clone := connection.Clone()
+			testhelper.ExpectVersionQuery(mock, "6.20.0")
+			mock.ExpectExec("SET statement_timeout TO 0").WillReturnResult(testhelper.TestResult{Rows: 0})
+
+			clone.MustConnect(1)
+			defer clone.Close()
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+	})
 	Describe("DBConn.MustBegin", func() {
 		It("successfully executes a BEGIN outside a transaction", func() {
-			ExpectBegin(mock)
+			testhelper.ExpectBegin(mock)
 			connection.MustBegin()
 			Expect(connection.Tx).To(Not(BeNil()))
 		})
 		It("panics if it executes a BEGIN in a transaction", func() {
-			ExpectBegin(mock)
+			testhelper.ExpectBegin(mock)
 			connection.MustBegin()
 			defer testhelper.ShouldPanicWithMessage("Cannot begin transaction; there is already a transaction in progress")
 			connection.MustBegin()
@@ -375,7 +1467,7 @@ var _ = Describe("dbconn/dbconn tests", func() {
 	})
 	Describe("DBConn.MustCommit", func() {
 		It("successfully executes a COMMIT in a transaction", func() {
-			ExpectBegin(mock)
+			testhelper.ExpectBegin(mock)
 			mock.ExpectCommit()
 			connection.MustBegin()
 			connection.MustCommit()
@@ -386,6 +1478,510 @@ var _ = Describe("dbconn/dbconn tests", func() {
 			connection.MustCommit()
 		})
 	})
+	Describe("DBConn.DeferConstraints and ImmediateConstraints", func() {
+		It("defers constraint checks in a transaction", func() {
+			testhelper.ExpectBegin(mock)
+			mock.ExpectExec("SET CONSTRAINTS ALL DEFERRED").WillReturnResult(testhelper.TestResult{Rows: 0})
+			mock.ExpectCommit()
+
+			connection.MustBegin()
+			Expect(connection.DeferConstraints()).To(Succeed())
+			connection.MustCommit()
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("makes constraint checks immediate in a transaction on the given connection", func() {
+			connection, mock = testhelper.CreateAndConnectMockDB(2)
+			testhelper.ExpectBegin(mock)
+			mock.ExpectExec("SET CONSTRAINTS ALL IMMEDIATE").WillReturnResult(testhelper.TestResult{Rows: 0})
+
+			connection.MustBegin(1)
+			Expect(connection.ImmediateConstraints(1)).To(Succeed())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("returns an error if there is no transaction in progress", func() {
+			err := connection.DeferConstraints()
+			Expect(err).To(MatchError("Cannot set constraints deferred; there is no transaction in progress"))
+			err = connection.ImmediateConstraints()
+			Expect(err).To(MatchError("Cannot set constraints immediate; there is no transaction in progress"))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("returns an error if the statement fails", func() {
+			testhelper.ExpectBegin(mock)
+			mock.ExpectExec("SET CONSTRAINTS ALL DEFERRED").WillReturnError(errors.New("current transaction is aborted"))
+
+			connection.MustBegin()
+			Expect(connection.DeferConstraints()).To(MatchError("current transaction is aborted"))
+		})
+	})
+	Describe("DBConn.ExecOnAllConnections", func() {
+		var mocks []sqlmock.Sqlmock
+		fakeResult := testhelper.TestResult{Rows: 0}
+		BeforeEach(func() {
+			connection, mocks = testhelper.CreateAndConnectMockDBWithMocks(3)
+		})
+		It("runs the statement on every connection", func() {
+			for _, mock := range mocks {
+				mock.ExpectExec(regexp.QuoteMeta("SET search_path TO public")).WillReturnResult(fakeResult)
+			}
+			connection.MustExecOnAllConnections("SET search_path TO public")
+			for i := 0; i < 3; i++ {
+				Expect(mocks[i].ExpectationsWereMet()).To(Succeed())
+			}
+		})
+		It("stops at the first failure and reports which connection failed", func() {
+			mocks[0].ExpectExec("SET (.*)").WillReturnResult(fakeResult)
+			mocks[1].ExpectExec("SET (.*)").WillReturnError(errors.New("invalid value for parameter"))
+			err := connection.ExecOnAllConnections("SET search_path TO public")
+			Expect(err).To(MatchError("Cannot execute statement on connection 1: invalid value for parameter"))
+			for i := 0; i < 3; i++ {
+				Expect(mocks[i].ExpectationsWereMet()).To(Succeed())
+			}
+		})
+	})
+	Describe("DBConn.BeginAll", func() {
+		var mocks []sqlmock.Sqlmock
+		BeforeEach(func() {
+			connection, mocks = testhelper.CreateAndConnectMockDBWithMocks(3)
+		})
+		It("begins a transaction on every connection", func() {
+			for _, mock := range mocks {
+				testhelper.ExpectBegin(mock)
+			}
+			connection.MustBeginAll()
+			for i := 0; i < 3; i++ {
+				Expect(connection.Tx[i]).ToNot(BeNil())
+				Expect(mocks[i].ExpectationsWereMet()).To(Succeed())
+			}
+		})
+		It("rolls back the transactions already begun if a begin fails", func() {
+			testhelper.ExpectBegin(mocks[0])
+			mocks[0].ExpectRollback()
+			mocks[1].ExpectBegin().WillReturnError(errors.New("too many connections"))
+			err := connection.BeginAll()
+			Expect(err).To(MatchError("Cannot begin transaction on connection 1: too many connections"))
+			for i := 0; i < 3; i++ {
+				Expect(connection.Tx[i]).To(BeNil())
+				Expect(mocks[i].ExpectationsWereMet()).To(Succeed())
+			}
+		})
+	})
+	Describe("DBConn.CommitAll", func() {
+		var mocks []sqlmock.Sqlmock
+		BeforeEach(func() {
+			connection, mocks = testhelper.CreateAndConnectMockDBWithMocks(3)
+			for _, mock := range mocks {
+				testhelper.ExpectBegin(mock)
+			}
+			connection.MustBeginAll()
+		})
+		It("commits the transaction on every connection", func() {
+			for _, mock := range mocks {
+				mock.ExpectCommit()
+			}
+			connection.MustCommitAll()
+			for i := 0; i < 3; i++ {
+				Expect(connection.Tx[i]).To(BeNil())
+				Expect(mocks[i].ExpectationsWereMet()).To(Succeed())
+			}
+		})
+		It("rolls back the remaining transactions if a commit fails", func() {
+			mocks[0].ExpectCommit()
+			mocks[1].ExpectCommit().WillReturnError(errors.New("connection reset"))
+			mocks[2].ExpectRollback()
+			err := connection.CommitAll()
+			Expect(err).To(MatchError("Cannot commit transaction on connection 1: connection reset"))
+			for i := 0; i < 3; i++ {
+				Expect(connection.Tx[i]).To(BeNil())
+				Expect(mocks[i].ExpectationsWereMet()).To(Succeed())
+			}
+		})
+		It("panics if a commit fails", func() {
+			mocks[0].ExpectCommit().WillReturnError(errors.New("connection reset"))
+			mocks[1].ExpectRollback()
+			mocks[2].ExpectRollback()
+			defer testhelper.ShouldPanicWithMessage("Cannot commit transaction on connection 0: connection reset")
+			connection.MustCommitAll()
+		})
+	})
+	Describe("DBConn.RollbackAll", func() {
+		var mocks []sqlmock.Sqlmock
+		BeforeEach(func() {
+			connection, mocks = testhelper.CreateAndConnectMockDBWithMocks(3)
+		})
+		It("rolls back the transaction on every connection", func() {
+			for _, mock := range mocks {
+				testhelper.ExpectTransactionWithRollback(mock)
+			}
+			connection.MustBeginAll()
+			connection.MustRollbackAll()
+			for i := 0; i < 3; i++ {
+				Expect(connection.Tx[i]).To(BeNil())
+				Expect(mocks[i].ExpectationsWereMet()).To(Succeed())
+			}
+		})
+		It("skips connections with no transaction in progress", func() {
+			testhelper.ExpectBegin(mocks[2])
+			mocks[2].ExpectRollback()
+			connection.MustBegin(2)
+			Expect(connection.RollbackAll()).To(Succeed())
+			for i := 0; i < 3; i++ {
+				Expect(mocks[i].ExpectationsWereMet()).To(Succeed())
+			}
+		})
+		It("rolls back every connection and returns the first error", func() {
+			for _, mock := range mocks {
+				testhelper.ExpectBegin(mock)
+			}
+			mocks[0].ExpectRollback()
+			mocks[1].ExpectRollback().WillReturnError(errors.New("connection reset"))
+			mocks[2].ExpectRollback()
+			connection.MustBeginAll()
+			err := connection.RollbackAll()
+			Expect(err).To(MatchError("Cannot rollback transaction on connection 1: connection reset"))
+			for i := 0; i < 3; i++ {
+				Expect(connection.Tx[i]).To(BeNil())
+				Expect(mocks[i].ExpectationsWereMet()).To(Succeed())
+			}
+		})
+	})
+	Describe("DBConn.WithRetryableTransaction", func() {
+		var sleeps []time.Duration
+		serializationFailure := &pgconn.PgError{Severity: "ERROR", Code: "40001", Message: "could not serialize access due to concurrent update"}
+		BeforeEach(func() {
+			sleeps = make([]time.Duration, 0)
+			operating.System.Sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+		})
+		AfterEach(func() {
+			operating.System.Sleep = time.Sleep
+		})
+		It("retries the transaction after serialization failures and then commits", func() {
+			fakeResult := testhelper.TestResult{Rows: 1}
+			for i := 0; i < 2; i++ {
+				testhelper.ExpectBegin(mock)
+				mock.ExpectExec("UPDATE foo (.*)").WillReturnError(serializationFailure)
+				mock.ExpectRollback()
+			}
+			testhelper.ExpectBegin(mock)
+			mock.ExpectExec("UPDATE foo (.*)").WillReturnResult(fakeResult)
+			mock.ExpectCommit()
+
+			attempts := 0
+			err := connection.WithRetryableTransaction(3, func(conn *dbconn.DBConn) error {
+				attempts++
+				_, err := conn.Exec("UPDATE foo SET i = 1")
+				return err
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(attempts).To(Equal(3))
+			Expect(sleeps).To(Equal([]time.Duration{100 * time.Millisecond, 200 * time.Millisecond}))
+			Expect(connection.Tx[0]).To(BeNil())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("retries the transaction after a deadlock", func() {
+			deadlock := &pgconn.PgError{Severity: "ERROR", Code: "40P01", Message: "deadlock detected"}
+			testhelper.ExpectTransactionWithRollback(mock)
+			testhelper.ExpectBegin(mock)
+			mock.ExpectCommit()
+
+			attempts := 0
+			err := connection.WithRetryableTransaction(1, func(conn *dbconn.DBConn) error {
+				attempts++
+				if attempts == 1 {
+					return deadlock
+				}
+				return nil
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(attempts).To(Equal(2))
+		})
+		It("returns the last error after exhausting the retries", func() {
+			for i := 0; i < 3; i++ {
+				testhelper.ExpectTransactionWithRollback(mock)
+			}
+
+			attempts := 0
+			err := connection.WithRetryableTransaction(2, func(conn *dbconn.DBConn) error {
+				attempts++
+				return serializationFailure
+			})
+			Expect(err).To(Equal(serializationFailure))
+			Expect(attempts).To(Equal(3))
+			Expect(sleeps).To(HaveLen(2))
+		})
+		It("returns a non-retryable error immediately", func() {
+			testhelper.ExpectTransactionWithRollback(mock)
+
+			attempts := 0
+			err := connection.WithRetryableTransaction(3, func(conn *dbconn.DBConn) error {
+				attempts++
+				return errors.New("relation foo does not exist")
+			})
+			Expect(err).To(MatchError("relation foo does not exist"))
+			Expect(attempts).To(Equal(1))
+			Expect(sleeps).To(BeEmpty())
+		})
+		It("retries when the commit fails with a serialization failure", func() {
+			testhelper.ExpectBegin(mock)
+			mock.ExpectCommit().WillReturnError(serializationFailure)
+			testhelper.ExpectBegin(mock)
+			mock.ExpectCommit()
+
+			attempts := 0
+			err := connection.WithRetryableTransaction(1, func(conn *dbconn.DBConn) error {
+				attempts++
+				return nil
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(attempts).To(Equal(2))
+		})
+		It("runs each attempt after the previous delay has passed", func() {
+			start := time.Date(2017, time.January, 1, 1, 1, 1, 1, time.Local)
+			clock := operating.NewFakeClock(start)
+			clock.Install()
+			defer func() { operating.System.Now = time.Now }()
+			for i := 0; i < 3; i++ {
+				testhelper.ExpectTransactionWithRollback(mock)
+			}
+
+			attemptTimes := make([]time.Duration, 0)
+			_ = connection.WithRetryableTransaction(2, func(conn *dbconn.DBConn) error {
+				attemptTimes = append(attemptTimes, operating.System.Now().Sub(start))
+				return serializationFailure
+			})
+			Expect(attemptTimes).To(Equal([]time.Duration{0, 100 * time.Millisecond, 300 * time.Millisecond}))
+		})
+	})
+	Describe("DBConn.WithConnection", func() {
+		It("uses the same connection throughout the callback", func() {
+			connection, mock = testhelper.CreateAndConnectMockDB(3)
+			fakeResult := testhelper.TestResult{Rows: 0}
+			mock.ExpectExec("CREATE TEMPORARY TABLE (.*)").WillReturnResult(fakeResult)
+			mock.ExpectExec("INSERT (.*)").WillReturnResult(fakeResult)
+
+			usedConns := make([]int, 0)
+			err := connection.WithConnection(func(connNum int) error {
+				usedConns = append(usedConns, connNum)
+				_, err := connection.Exec("CREATE TEMPORARY TABLE foo(i int)", connNum)
+				if err != nil {
+					return err
+				}
+				usedConns = append(usedConns, connNum)
+				_, err = connection.Exec("INSERT INTO foo VALUES (1)", connNum)
+				return err
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(usedConns).To(HaveLen(2))
+			Expect(usedConns[0]).To(Equal(usedConns[1]))
+		})
+		It("does not give a reserved connection to a concurrent caller", func() {
+			connection, mock = testhelper.CreateAndConnectMockDB(2)
+			reserved := make(chan int)
+			release := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				_ = connection.WithConnection(func(connNum int) error {
+					reserved <- connNum
+					<-release
+					return nil
+				})
+			}()
+			firstConn := <-reserved
+			err := connection.WithConnection(func(connNum int) error {
+				Expect(connNum).ToNot(Equal(firstConn))
+				return nil
+			})
+			close(release)
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("releases the connection after the callback returns an error", func() {
+			err := connection.WithConnection(func(connNum int) error {
+				return errors.New("callback failed")
+			})
+			Expect(err).To(MatchError("callback failed"))
+			err = connection.WithConnection(func(connNum int) error {
+				Expect(connNum).To(Equal(0))
+				return nil
+			})
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("releases the connection if the callback panics", func() {
+			func() {
+				defer testhelper.ShouldPanicWithMessage("callback panicked")
+				_ = connection.WithConnection(func(connNum int) error {
+					panic("callback panicked")
+				})
+			}()
+			err := connection.WithConnection(func(connNum int) error {
+				Expect(connNum).To(Equal(0))
+				return nil
+			})
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("returns an error if the connection is not open", func() {
+			connection.Close()
+			err := connection.WithConnection(func(connNum int) error { return nil })
+			Expect(err).To(MatchError("Cannot reserve a connection; the database connection is not open"))
+		})
+	})
+	Describe("DBConn.EnableAutoConnSelection", func() {
+		fakeResult := testhelper.TestResult{Rows: 0}
+		It("uses the first connection by default", func() {
+			multiConn, mocks := testhelper.CreateAndConnectMockDBWithMocks(2)
+			mocks[0].ExpectExec("SET (.*)").WillReturnResult(fakeResult)
+			mocks[0].ExpectExec("SET (.*)").WillReturnResult(fakeResult)
+
+			_, err := multiConn.Exec("SET search_path TO public")
+			Expect(err).ToNot(HaveOccurred())
+			_, err = multiConn.Exec("SET search_path TO public")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mocks[0].ExpectationsWereMet()).To(Succeed())
+			Expect(mocks[1].ExpectationsWereMet()).To(Succeed())
+		})
+		It("gives concurrent callers different connections", func() {
+			multiConn, mocks := testhelper.CreateAndConnectMockDBWithMocks(2)
+			multiConn.EnableAutoConnSelection()
+			// Each connection may only run one statement, and each statement
+			// holds its connection long enough for the calls to overlap
+			for _, connMock := range mocks {
+				connMock.ExpectExec("INSERT (.*)").WillDelayFor(100 * time.Millisecond).WillReturnResult(fakeResult)
+			}
+
+			var wg sync.WaitGroup
+			errs := make([]error, 2)
+			for i := range errs {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					_, errs[i] = multiConn.Exec("INSERT INTO foo VALUES (1)")
+				}(i)
+			}
+			wg.Wait()
+			Expect(errs).To(Equal([]error{nil, nil}))
+			for _, connMock := range mocks {
+				Expect(connMock.ExpectationsWereMet()).To(Succeed())
+			}
+		})
+		It("does not use a connection reserved with WithConnection", func() {
+			multiConn, mocks := testhelper.CreateAndConnectMockDBWithMocks(2)
+			multiConn.EnableAutoConnSelection()
+			mocks[1].ExpectQuery("SELECT (.*)").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+			mocks[1].ExpectQuery("SELECT (.*)").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+			err := multiConn.WithConnection(func(connNum int) error {
+				Expect(connNum).To(Equal(0))
+				var count int
+				err := multiConn.Get(&count, "SELECT count(*) FROM foo")
+				if err != nil {
+					return err
+				}
+				counts := make([]int, 0)
+				return multiConn.Select(&counts, "SELECT count(*) FROM foo")
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mocks[0].ExpectationsWereMet()).To(Succeed())
+			Expect(mocks[1].ExpectationsWereMet()).To(Succeed())
+		})
+		It("uses the connection passed by the caller", func() {
+			multiConn, mocks := testhelper.CreateAndConnectMockDBWithMocks(2)
+			multiConn.EnableAutoConnSelection()
+			mocks[1].ExpectExec("SET (.*)").WillReturnResult(fakeResult)
+
+			_, err := multiConn.Exec("SET search_path TO public", 1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mocks[1].ExpectationsWereMet()).To(Succeed())
+		})
+	})
+	Describe("DBConn.ExecuteInParallel", func() {
+		var items []interface{}
+		BeforeEach(func() {
+			connection, mock = testhelper.CreateAndConnectMockDB(3)
+			items = []interface{}{"a", "b", "c", "d", "e", "f", "g", "h", "i"}
+		})
+		It("processes every item using each connection", func() {
+			var mutex sync.Mutex
+			processed := make([]string, 0)
+			usedConns := make(map[int]bool)
+			var wg sync.WaitGroup
+			wg.Add(3)
+			errs := connection.ExecuteInParallel(items, func(conn *dbconn.DBConn, connNum int, item interface{}) error {
+				mutex.Lock()
+				processed = append(processed, item.(string))
+				firstUse := !usedConns[connNum]
+				usedConns[connNum] = true
+				mutex.Unlock()
+				if firstUse {
+					// Hold each worker until all three have started, so every connection must be used
+					wg.Done()
+					wg.Wait()
+				}
+				return nil
+			})
+			Expect(errs).To(Equal(make([]error, len(items))))
+			Expect(processed).To(ConsistOf(items...))
+			Expect(usedConns).To(Equal(map[int]bool{0: true, 1: true, 2: true}))
+		})
+		It("never runs more than NumConns calls at once", func() {
+			var running, maxRunning int32
+			connection.ExecuteInParallel(items, func(conn *dbconn.DBConn, connNum int, item interface{}) error {
+				current := atomic.AddInt32(&running, 1)
+				for {
+					previous := atomic.LoadInt32(&maxRunning)
+					if current <= previous || atomic.CompareAndSwapInt32(&maxRunning, previous, current) {
+						break
+					}
+				}
+				time.Sleep(time.Millisecond)
+				atomic.AddInt32(&running, -1)
+				return nil
+			})
+			Expect(maxRunning).To(BeNumerically("<=", 3))
+		})
+		It("returns errors in item order", func() {
+			errs := connection.ExecuteInParallel(items, func(conn *dbconn.DBConn, connNum int, item interface{}) error {
+				if item == "b" || item == "h" {
+					return errors.Errorf("failed on %s", item)
+				}
+				return nil
+			})
+			Expect(errs).To(HaveLen(len(items)))
+			for i, err := range errs {
+				switch i {
+				case 1:
+					Expect(err).To(MatchError("failed on b"))
+				case 7:
+					Expect(err).To(MatchError("failed on h"))
+				default:
+					Expect(err).ToNot(HaveOccurred())
+				}
+			}
+		})
+		It("returns an error for each item if the connection is not open", func() {
+			connection.Close()
+			called := false
+			errs := connection.ExecuteInParallel(items[:2], func(conn *dbconn.DBConn, connNum int, item interface{}) error {
+				called = true
+				return nil
+			})
+			Expect(called).To(BeFalse())
+			Expect(errs).To(HaveLen(2))
+			Expect(errs[0]).To(MatchError("Cannot execute in parallel; the database connection is not open"))
+		})
+	})
+	Describe("DBConn.GetPoolConnection", func() {
+		BeforeEach(func() {
+			connection, mock = testhelper.CreateAndConnectMockDB(3)
+		})
+		It("returns the first connection if no connection number is given", func() {
+			Expect(connection.GetPoolConnection()).To(BeIdenticalTo(connection.ConnPool[0]))
+		})
+		It("returns the connection in the given pool slot", func() {
+			Expect(connection.GetPoolConnection(2)).To(BeIdenticalTo(connection.ConnPool[2]))
+		})
+		It("panics if given an invalid connection number", func() {
+			defer testhelper.ShouldPanicWithMessage("Invalid connection number: 3")
+			connection.GetPoolConnection(3)
+		})
+	})
 	Describe("Dbconn.ValidateConnNum", func() {
 		BeforeEach(func() {
 			connection, mock = testhelper.CreateMockDBConn()
@@ -480,4 +2076,83 @@ var _ = Describe("dbconn/dbconn tests", func() {
 			dbconn.MustSelectString(connection, "SELECT foo FROM bar")
 		})
 	})
+	Describe("DBConn.SelectPairs", func() {
+		header := []string{"name", "setting"}
+
+		It("returns the keys and values in row order", func() {
+			fakeResult := sqlmock.NewRows(header).AddRow("work_mem", "32MB").AddRow("datestyle", "ISO, MDY").AddRow("search_path", nil)
+			mock.ExpectQuery("SELECT (.*)").WillReturnRows(fakeResult)
+			keys, values, err := connection.SelectPairs("SELECT name, setting FROM pg_settings")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(keys).To(Equal([]string{"work_mem", "datestyle", "search_path"}))
+			Expect(values).To(Equal([]string{"32MB", "ISO, MDY", ""}))
+		})
+		It("returns empty slices if the query selects no rows", func() {
+			mock.ExpectQuery("SELECT (.*)").WillReturnRows(sqlmock.NewRows(header))
+			keys, values, err := connection.SelectPairs("SELECT name, setting FROM pg_settings")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(keys).To(BeEmpty())
+			Expect(values).To(BeEmpty())
+		})
+		It("returns an error if the query selects one column", func() {
+			mock.ExpectQuery("SELECT (.*)").WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("work_mem"))
+			_, _, err := connection.SelectPairs("SELECT name FROM pg_settings")
+			Expect(err).To(MatchError("Wrong number of columns returned from query: got 1 columns, expected 2 columns"))
+		})
+		It("returns an error if the query selects three columns", func() {
+			mock.ExpectQuery("SELECT (.*)").WillReturnRows(sqlmock.NewRows([]string{"name", "setting", "unit"}).AddRow("work_mem", "32", "MB"))
+			_, _, err := connection.SelectPairs("SELECT name, setting, unit FROM pg_settings")
+			Expect(err).To(MatchError("Wrong number of columns returned from query: got 3 columns, expected 2 columns"))
+		})
+		It("panics if the query fails", func() {
+			mock.ExpectQuery("SELECT (.*)").WillReturnError(errors.New("permission denied"))
+			defer testhelper.ShouldPanicWithMessage("permission denied")
+			connection.MustSelectPairs("SELECT name, setting FROM pg_settings")
+		})
+	})
 })
+
+// A ContextDBDriver whose connection attempts only end when their context does
+type slowContextDriver struct {
+	attempts int32
+}
+
+func (driver *slowContextDriver) Connect(driverName string, dataSourceName string) (*sqlx.DB, error) {
+	return nil, errors.New("Connect should not be called on a ContextDBDriver")
+}
+
+func (driver *slowContextDriver) ConnectContext(ctx context.Context, driverName string, dataSourceName string) (*sqlx.DB, error) {
+	atomic.AddInt32(&driver.attempts, 1)
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (driver *slowContextDriver) numAttempts() int32 {
+	return atomic.LoadInt32(&driver.attempts)
+}
+
+// A DBDriver whose connection attempts block until release is closed
+type blockingDriver struct {
+	release chan struct{}
+	db      *sqlx.DB
+}
+
+func (driver *blockingDriver) Connect(driverName string, dataSourceName string) (*sqlx.DB, error) {
+	<-driver.release
+	return driver.db, nil
+}
+
+// A ContextDBDriver that connects once and then cancels the context
+type cancellingDriver struct {
+	db     *sqlx.DB
+	cancel context.CancelFunc
+}
+
+func (driver *cancellingDriver) Connect(driverName string, dataSourceName string) (*sqlx.DB, error) {
+	return nil, errors.New("Connect should not be called on a ContextDBDriver")
+}
+
+func (driver *cancellingDriver) ConnectContext(ctx context.Context, driverName string, dataSourceName string) (*sqlx.DB, error) {
+	driver.cancel()
+	return driver.db, nil
+}
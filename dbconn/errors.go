@@ -0,0 +1,136 @@
+package dbconn
+
+/*
+ * This file contains structs and functions related to inspecting errors
+ * returned by the database.
+ */
+
+import (
+	"fmt"
+	"syscall"
+
+	"github.com/cloudberrydb/gp-common-go-libs/gplog"
+	"github.com/jackc/pgconn"
+	"github.com/pkg/errors"
+)
+
+/*
+ * SQLSTATE codes for errors raised when a statement references an object that
+ * does not exist, e.g. a DROP without IF EXISTS.
+ */
+var undefinedObjectCodes = map[string]bool{
+	"3D000": true, // invalid_catalog_name
+	"3F000": true, // invalid_schema_name
+	"42703": true, // undefined_column
+	"42704": true, // undefined_object
+	"42883": true, // undefined_function
+	"42P01": true, // undefined_table
+}
+
+/*
+ * SQLSTATE codes for errors after which a transaction can succeed if it is
+ * simply run again.
+ */
+var retryableTransactionCodes = map[string]bool{
+	"40001": true, // serialization_failure
+	"40P01": true, // deadlock_detected
+}
+
+/*
+ * Returns the SQLSTATE code of a database error, or the empty string if the
+ * error did not originate from the server.
+ */
+func GetSQLState(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code
+	}
+	return ""
+}
+
+func isUndefinedObjectError(err error) bool {
+	return undefinedObjectCodes[GetSQLState(err)]
+}
+
+func isRetryableTransactionError(err error) bool {
+	return retryableTransactionCodes[GetSQLState(err)]
+}
+
+/*
+ * An ErrorKind is a broad category of database error, for callers that need
+ * to react to particular failures without depending on the text of the error
+ * message, which varies with the server's locale.
+ */
+type ErrorKind int
+
+const (
+	ErrUnknown ErrorKind = iota
+	ErrDBDoesNotExist
+	ErrRoleDoesNotExist
+	ErrConnectionRefused
+	ErrSerializationFailure
+	ErrServerShutdown
+)
+
+/*
+ * ClassifyError returns the kind of a database error based on its SQLSTATE,
+ * or ErrUnknown if it is nil or of some other kind.  A role that does not
+ * exist is reported with the same SQLSTATE as other authorization failures,
+ * such as a missing pg_hba.conf entry, so it is told apart by the server
+ * routine that raised the error.
+ */
+func ClassifyError(err error) ErrorKind {
+	if err == nil {
+		return ErrUnknown
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return ErrConnectionRefused
+	}
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return ErrUnknown
+	}
+	switch pgErr.Code {
+	case "3D000": // invalid_catalog_name
+		return ErrDBDoesNotExist
+	case "28000": // invalid_authorization_specification
+		if pgErr.Routine == "InitializeSessionUserId" {
+			return ErrRoleDoesNotExist
+		}
+	case "40001": // serialization_failure
+		return ErrSerializationFailure
+	case "57P01": // admin_shutdown
+		return ErrServerShutdown
+	}
+	return ErrUnknown
+}
+
+/*
+ * The message of an error returned by the server is often not enough to tell
+ * what went wrong without the accompanying DETAIL, HINT, and CONTEXT fields,
+ * so FormatPQError renders them in the same way as psql when present.  Errors
+ * that did not originate from the server are returned as-is.
+ */
+func FormatPQError(err error) string {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err.Error()
+	}
+	message := pgErr.Error()
+	if pgErr.Detail != "" {
+		message += fmt.Sprintf("\nDETAIL: %s", pgErr.Detail)
+	}
+	if pgErr.Hint != "" {
+		message += fmt.Sprintf("\nHINT: %s", pgErr.Hint)
+	}
+	if pgErr.Where != "" {
+		message += fmt.Sprintf("\nCONTEXT: %s", pgErr.Where)
+	}
+	return message
+}
+
+func fatalOnDatabaseError(err error) {
+	if err != nil {
+		gplog.Fatal(errors.New(FormatPQError(err)), "")
+	}
+}
@@ -0,0 +1,92 @@
+package dbconn_test
+
+import (
+	"net"
+	"os"
+	"syscall"
+
+	"github.com/cloudberrydb/gp-common-go-libs/dbconn"
+	"github.com/cloudberrydb/gp-common-go-libs/testhelper"
+	"github.com/jackc/pgconn"
+	"github.com/pkg/errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("dbconn/errors tests", func() {
+	Describe("FormatPQError", func() {
+		It("renders the detail, hint, and context of a server error", func() {
+			err := &pgconn.PgError{
+				Severity: "ERROR",
+				Code:     "23505",
+				Message:  `duplicate key value violates unique constraint "foo_pkey"`,
+				Detail:   "Key (id)=(1) already exists.",
+				Hint:     "Use a different id.",
+				Where:    "SQL function \"insert_foo\" statement 1",
+			}
+			Expect(dbconn.FormatPQError(err)).To(Equal(`ERROR: duplicate key value violates unique constraint "foo_pkey" (SQLSTATE 23505)
+DETAIL: Key (id)=(1) already exists.
+HINT: Use a different id.
+CONTEXT: SQL function "insert_foo" statement 1`))
+		})
+		It("leaves out fields that are not present", func() {
+			err := &pgconn.PgError{Severity: "ERROR", Code: "42601", Message: `syntax error at or near "SELEC"`, Hint: "Check your spelling."}
+			Expect(dbconn.FormatPQError(err)).To(Equal(`ERROR: syntax error at or near "SELEC" (SQLSTATE 42601)
+HINT: Check your spelling.`))
+		})
+		It("finds a server error that has been wrapped", func() {
+			err := errors.Wrap(&pgconn.PgError{Severity: "ERROR", Code: "42P01", Message: `relation "foo" does not exist`, Detail: "some detail"}, "wrapped")
+			Expect(dbconn.FormatPQError(err)).To(Equal(`ERROR: relation "foo" does not exist (SQLSTATE 42P01)
+DETAIL: some detail`))
+		})
+		It("returns the error message of a non-server error", func() {
+			Expect(dbconn.FormatPQError(errors.New("driver: bad connection"))).To(Equal("driver: bad connection"))
+		})
+	})
+	Describe("ClassifyError", func() {
+		It("classifies a database that does not exist", func() {
+			err := &pgconn.PgError{Severity: "FATAL", Code: "3D000", Message: `database "foo" does not exist`}
+			Expect(dbconn.ClassifyError(err)).To(Equal(dbconn.ErrDBDoesNotExist))
+		})
+		It("classifies a role that does not exist", func() {
+			err := &pgconn.PgError{Severity: "FATAL", Code: "28000", Message: `role "foo" does not exist`, Routine: "InitializeSessionUserId"}
+			Expect(dbconn.ClassifyError(err)).To(Equal(dbconn.ErrRoleDoesNotExist))
+		})
+		It("does not classify other authorization failures as a missing role", func() {
+			err := &pgconn.PgError{Severity: "FATAL", Code: "28000", Message: `no pg_hba.conf entry for host "10.0.0.1"`, Routine: "ClientAuthentication"}
+			Expect(dbconn.ClassifyError(err)).To(Equal(dbconn.ErrUnknown))
+		})
+		It("classifies a refused connection", func() {
+			err := &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+			Expect(dbconn.ClassifyError(errors.Wrap(err, "failed to connect"))).To(Equal(dbconn.ErrConnectionRefused))
+		})
+		It("classifies a serialization failure", func() {
+			err := errors.Wrap(&pgconn.PgError{Severity: "ERROR", Code: "40001", Message: "could not serialize access"}, "wrapped")
+			Expect(dbconn.ClassifyError(err)).To(Equal(dbconn.ErrSerializationFailure))
+		})
+		It("classifies a connection terminated by an administrator", func() {
+			err := &pgconn.PgError{Severity: "FATAL", Code: "57P01", Message: "terminating connection due to administrator command"}
+			Expect(dbconn.ClassifyError(err)).To(Equal(dbconn.ErrServerShutdown))
+		})
+		It("does not classify errors by their message", func() {
+			Expect(dbconn.ClassifyError(errors.New(`pq: database "foo" does not exist`))).To(Equal(dbconn.ErrUnknown))
+			Expect(dbconn.ClassifyError(&pgconn.PgError{Code: "42601", Message: "connection refused"})).To(Equal(dbconn.ErrUnknown))
+		})
+		It("returns ErrUnknown for a nil error", func() {
+			Expect(dbconn.ClassifyError(nil)).To(Equal(dbconn.ErrUnknown))
+		})
+	})
+	Describe("DBConn error handling", func() {
+		It("includes the detail of a failed statement in MustExec output", func() {
+			mock.ExpectExec("INSERT (.*)").WillReturnError(&pgconn.PgError{Severity: "ERROR", Code: "23505", Message: "duplicate key", Detail: "Key (id)=(1) already exists."})
+			defer testhelper.ShouldPanicWithMessage("ERROR: duplicate key (SQLSTATE 23505)\nDETAIL: Key (id)=(1) already exists.")
+			connection.MustExec("INSERT INTO foo VALUES (1)")
+		})
+		It("includes the detail of a failed connection attempt", func() {
+			connection, mock = testhelper.CreateMockDBConn(&pgconn.PgError{Severity: "FATAL", Code: "53300", Message: "sorry, too many clients already", Hint: "Try again later."})
+			err := connection.Connect(1)
+			Expect(err).To(MatchError("FATAL: sorry, too many clients already (SQLSTATE 53300)\nHINT: Try again later. (testhost:5432)"))
+		})
+	})
+})
@@ -0,0 +1,96 @@
+package dbconn
+
+/*
+ * This file contains functions related to keeping idle pool connections
+ * alive.
+ */
+
+import (
+	"context"
+	"time"
+
+	"github.com/cloudberrydb/gp-common-go-libs/gplog"
+	"github.com/cloudberrydb/gp-common-go-libs/operating"
+)
+
+type keepAlive struct {
+	interval time.Duration
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+/*
+ * StartKeepAlive starts a goroutine that pings every pool connection once per
+ * interval, so that firewalls do not drop connections that sit idle, and
+ * replaces any connection that fails its ping with a new one in the same way
+ * as EnableAutoReconnect does.  Connections with a transaction in progress are
+ * not pinged, since replacing them would lose the transaction, and neither
+ * are connections that are running a statement, since they are not idle.
+ * Calling StartKeepAlive again restarts the goroutine with the new interval,
+ * and Resize restarts it with the same interval.  StartKeepAlive does nothing
+ * if the DBConn is not connected.
+ *
+ * The goroutine stops when StopKeepAlive or Close is called.  Settings such as
+ * those made with SetSessionGUCs should be made before it is started, since it
+ * applies them to the connections it replaces.
+ */
+func (dbconn *DBConn) StartKeepAlive(interval time.Duration) {
+	dbconn.StopKeepAlive()
+	if dbconn.ConnPool == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	dbconn.keepAlive = &keepAlive{interval: interval, cancel: cancel, done: make(chan struct{})}
+	go dbconn.runKeepAlive(ctx, interval, dbconn.keepAlive.done)
+}
+
+// StopKeepAlive stops the goroutine started by StartKeepAlive, if any, and waits for it to exit.
+func (dbconn *DBConn) StopKeepAlive() {
+	if dbconn.keepAlive == nil {
+		return
+	}
+	dbconn.keepAlive.cancel()
+	<-dbconn.keepAlive.done
+	dbconn.keepAlive = nil
+}
+
+func (dbconn *DBConn) runKeepAlive(ctx context.Context, interval time.Duration, done chan struct{}) {
+	defer close(done)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-operating.System.After(interval):
+		}
+		for connNum := 0; connNum < dbconn.NumConns; connNum++ {
+			if !dbconn.pingIdleConn(ctx, connNum) {
+				return
+			}
+		}
+	}
+}
+
+/*
+ * Pings a connection, and replaces it if the ping fails, unless the
+ * connection is in use or has a transaction in progress.  Returns false if
+ * ctx was cancelled during the ping.
+ */
+func (dbconn *DBConn) pingIdleConn(ctx context.Context, connNum int) bool {
+	unlock, ok := dbconn.tryLockConn(connNum)
+	if !ok {
+		return true
+	}
+	defer unlock()
+	if dbconn.Tx[connNum] != nil {
+		return true
+	}
+	err := dbconn.ConnPool[connNum].PingContext(ctx)
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		gplog.Verbose("Connection %d failed a keepalive ping, reconnecting: %s", connNum, err.Error())
+		dbconn.reconnect(connNum)
+	}
+	return true
+}
@@ -0,0 +1,195 @@
+package dbconn_test
+
+import (
+	"sync/atomic"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/cloudberrydb/gp-common-go-libs/dbconn"
+	"github.com/cloudberrydb/gp-common-go-libs/operating"
+	"github.com/cloudberrydb/gp-common-go-libs/testhelper"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("dbconn/keepalive tests", func() {
+	Describe("DBConn.StartKeepAlive", func() {
+		var (
+			clock  *operating.FakeClock
+			mocks  []sqlmock.Sqlmock
+			nextDB *sqlx.DB
+		)
+		// Pings always succeed on a mock unless it is told to monitor them
+		createPingingMockDB := func() (*sqlx.DB, sqlmock.Sqlmock) {
+			db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+			Expect(err).ToNot(HaveOccurred())
+			return sqlx.NewDb(db, "sqlmock"), mock
+		}
+		BeforeEach(func() {
+			clock = operating.NewFakeClock(time.Date(2017, time.January, 1, 1, 1, 1, 1, time.Local))
+			clock.Install()
+			driver := &testhelper.TestMultiDriver{}
+			mocks = make([]sqlmock.Sqlmock, 2)
+			for i := range mocks {
+				var db *sqlx.DB
+				db, mocks[i] = createPingingMockDB()
+				driver.DBs = append(driver.DBs, db)
+			}
+			nextDB, _ = createPingingMockDB()
+			driver.DBs = append(driver.DBs, nextDB)
+			connection = dbconn.NewDBConnWithDriver("testdb", driver)
+			connection.Host = "testhost"
+			connection.Port = 5432
+			testhelper.ExpectVersionQuery(mocks[0], "6.0.0")
+			connection.MustConnect(2)
+		})
+		AfterEach(func() {
+			connection.StopKeepAlive()
+			operating.System = operating.InitializeSystemFunctions()
+		})
+
+		It("pings every connection once per interval", func() {
+			connection.StartKeepAlive(time.Minute)
+			for cycle := 0; cycle < 2; cycle++ {
+				Eventually(clock.NumWaiters).Should(Equal(1))
+				mocks[0].ExpectPing()
+				mocks[1].ExpectPing()
+				clock.Advance(time.Minute)
+				Eventually(mocks[0].ExpectationsWereMet).Should(Succeed())
+				Eventually(mocks[1].ExpectationsWereMet).Should(Succeed())
+			}
+		})
+		It("does not ping before the interval has passed", func() {
+			connection.StartKeepAlive(time.Minute)
+			Eventually(clock.NumWaiters).Should(Equal(1))
+			clock.Advance(59 * time.Second)
+			Consistently(clock.NumWaiters, "50ms").Should(Equal(1))
+			Expect(mocks[0].ExpectationsWereMet()).To(Succeed())
+			Expect(mocks[1].ExpectationsWereMet()).To(Succeed())
+		})
+		It("replaces a connection that fails its ping", func() {
+			mocks[0].ExpectPing()
+			mocks[1].ExpectPing().WillReturnError(errors.New("connection reset by peer"))
+
+			connection.StartKeepAlive(time.Minute)
+			Eventually(clock.NumWaiters).Should(Equal(1))
+			clock.Advance(time.Minute)
+			// The goroutine waits again once it has finished the cycle
+			Eventually(clock.NumWaiters).Should(Equal(1))
+			Expect(connection.ConnPool[1]).To(BeIdenticalTo(nextDB))
+			Expect(mocks[0].ExpectationsWereMet()).To(Succeed())
+		})
+		It("does not ping a connection with a transaction in progress", func() {
+			testhelper.ExpectBegin(mocks[1])
+			connection.MustBegin(1)
+			mocks[0].ExpectPing()
+
+			connection.StartKeepAlive(time.Minute)
+			Eventually(clock.NumWaiters).Should(Equal(1))
+			clock.Advance(time.Minute)
+			Eventually(mocks[0].ExpectationsWereMet).Should(Succeed())
+			Eventually(clock.NumWaiters).Should(Equal(1))
+			Expect(mocks[1].ExpectationsWereMet()).To(Succeed())
+			Expect(connection.ConnPool[1]).ToNot(BeIdenticalTo(nextDB))
+		})
+		It("does not ping a connection that is running a statement", func() {
+			mocks[0].ExpectPing()
+			mocks[1].ExpectExec("UPDATE (.*)").WillReturnResult(testhelper.TestResult{Rows: 1})
+			// The statement reads the time once it holds its connection, so
+			// blocking the first read keeps it in flight for the whole cycle
+			inFlight := make(chan struct{})
+			finish := make(chan struct{})
+			var calls int32
+			operating.System.Now = func() time.Time {
+				if atomic.AddInt32(&calls, 1) == 1 {
+					close(inFlight)
+					<-finish
+				}
+				return clock.Now()
+			}
+
+			connection.StartKeepAlive(time.Minute)
+			Eventually(clock.NumWaiters).Should(Equal(1))
+			errs := make(chan error, 1)
+			go func() {
+				_, err := connection.Exec("UPDATE foo SET i = 1", 1)
+				errs <- err
+			}()
+			Eventually(inFlight).Should(BeClosed())
+			clock.Advance(time.Minute)
+			Eventually(mocks[0].ExpectationsWereMet).Should(Succeed())
+			Eventually(clock.NumWaiters).Should(Equal(1))
+			close(finish)
+			Eventually(errs).Should(Receive(BeNil()))
+			Expect(mocks[1].ExpectationsWereMet()).To(Succeed())
+			Expect(connection.ConnPool[1]).ToNot(BeIdenticalTo(nextDB))
+		})
+		It("is restarted with the same interval by Resize", func() {
+			connection.StartKeepAlive(time.Minute)
+			Eventually(clock.NumWaiters).Should(Equal(1))
+			Expect(connection.Resize(1)).To(Succeed())
+			// The channel the first goroutine was waiting on is never received from
+			Eventually(clock.NumWaiters).Should(Equal(2))
+
+			mocks[0].ExpectPing()
+			clock.Advance(time.Minute)
+			Eventually(mocks[0].ExpectationsWereMet).Should(Succeed())
+			Eventually(clock.NumWaiters).Should(Equal(1))
+		})
+		It("does nothing if the connection is not open", func() {
+			connection.Close()
+			connection.StartKeepAlive(time.Minute)
+			Consistently(clock.NumWaiters, "50ms").Should(Equal(0))
+		})
+		It("restarts with the new interval if called again", func() {
+			connection.StartKeepAlive(time.Minute)
+			Eventually(clock.NumWaiters).Should(Equal(1))
+			connection.StartKeepAlive(time.Hour)
+			// The channel the first goroutine was waiting on is never received from
+			Eventually(clock.NumWaiters).Should(Equal(2))
+			clock.Advance(time.Minute)
+			Consistently(clock.NumWaiters, "50ms").Should(Equal(1))
+
+			mocks[0].ExpectPing()
+			mocks[1].ExpectPing()
+			clock.Advance(59 * time.Minute)
+			Eventually(mocks[0].ExpectationsWereMet).Should(Succeed())
+			Eventually(mocks[1].ExpectationsWereMet).Should(Succeed())
+		})
+	})
+	Describe("DBConn.StopKeepAlive", func() {
+		BeforeEach(func() {
+			operating.NewFakeClock(time.Date(2017, time.January, 1, 1, 1, 1, 1, time.Local)).Install()
+		})
+		AfterEach(func() {
+			operating.System = operating.InitializeSystemFunctions()
+		})
+
+		It("does nothing if no keepalive is running", func() {
+			connection.StopKeepAlive()
+			connection.StopKeepAlive()
+		})
+		It("stops the keepalive goroutine", func() {
+			connection.StartKeepAlive(time.Minute)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				connection.StopKeepAlive()
+			}()
+			Eventually(done).Should(BeClosed())
+		})
+		It("is called by Close", func() {
+			connection.StartKeepAlive(time.Minute)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				connection.Close()
+			}()
+			Eventually(done).Should(BeClosed())
+			connection.StopKeepAlive()
+		})
+	})
+})
@@ -0,0 +1,152 @@
+package dbconn
+
+/*
+ * This file contains structs and functions related to receiving asynchronous
+ * notifications sent with NOTIFY.
+ */
+
+import (
+	"context"
+
+	"github.com/cloudberrydb/gp-common-go-libs/gplog"
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+)
+
+type Notification struct {
+	Channel string
+	Payload string
+	PID     uint32
+}
+
+/*
+ * database/sql has no notion of asynchronous notifications, so listening has
+ * to happen on a raw driver connection that lives outside of ConnPool.  A
+ * NotificationListener wraps one such connection, and a DBDriver that also
+ * implements ListenerDriver is able to create them.
+ */
+type NotificationListener interface {
+	Listen(ctx context.Context, channel string) error
+	WaitForNotification(ctx context.Context) (*Notification, error)
+	Close(ctx context.Context) error
+}
+
+type ListenerDriver interface {
+	ConnectListener(ctx context.Context, dataSourceName string) (NotificationListener, error)
+}
+
+type pgxListener struct {
+	conn *pgx.Conn
+}
+
+func (driver *GPDBDriver) ConnectListener(ctx context.Context, dataSourceName string) (NotificationListener, error) {
+	conn, err := pgx.Connect(ctx, dataSourceName)
+	if err != nil {
+		return nil, err
+	}
+	return &pgxListener{conn: conn}, nil
+}
+
+func (listener *pgxListener) Listen(ctx context.Context, channel string) error {
+	_, err := listener.conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize())
+	return err
+}
+
+func (listener *pgxListener) WaitForNotification(ctx context.Context) (*Notification, error) {
+	notification, err := listener.conn.WaitForNotification(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Notification{Channel: notification.Channel, Payload: notification.Payload, PID: notification.PID}, nil
+}
+
+func (listener *pgxListener) Close(ctx context.Context) error {
+	return listener.conn.Close(ctx)
+}
+
+type activeListener struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+/*
+ * Listen opens a dedicated connection, issues LISTEN on it for the given
+ * channel, and delivers each notification received on the returned Go channel
+ * until Unlisten or Close is called, at which point the Go channel is closed.
+ * The Go channel is unbuffered, so notifications will queue up on the server
+ * side if the caller stops reading from it.
+ */
+func (dbconn *DBConn) Listen(channel string) (<-chan Notification, error) {
+	if dbconn.ConnPool == nil {
+		return nil, errors.New("Cannot listen for notifications; the database connection is not open")
+	}
+	if _, ok := dbconn.listeners[channel]; ok {
+		return nil, errors.Errorf("Cannot listen for notifications; already listening on channel %s", channel)
+	}
+	driver, ok := dbconn.Driver.(ListenerDriver)
+	if !ok {
+		return nil, errors.New("Cannot listen for notifications; the database driver does not support it")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	listener, err := driver.ConnectListener(ctx, dbconn.connStr)
+	if err != nil {
+		cancel()
+		return nil, dbconn.handleConnectionError(err)
+	}
+	err = listener.Listen(ctx, channel)
+	if err != nil {
+		_ = listener.Close(context.Background())
+		cancel()
+		return nil, err
+	}
+
+	notifications := make(chan Notification)
+	active := &activeListener{cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(active.done)
+		defer close(notifications)
+		defer func() { _ = listener.Close(context.Background()) }()
+		for {
+			notification, err := listener.WaitForNotification(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					gplog.Verbose("Stopped listening on channel %s: %v", channel, err)
+				}
+				return
+			}
+			select {
+			case notifications <- *notification:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	if dbconn.listeners == nil {
+		dbconn.listeners = make(map[string]*activeListener)
+	}
+	dbconn.listeners[channel] = active
+	return notifications, nil
+}
+
+/*
+ * Unlisten closes the dedicated connection for the channel, which also ends
+ * the LISTEN on the server side, and waits for the Go channel to be closed.
+ */
+func (dbconn *DBConn) Unlisten(channel string) error {
+	active, ok := dbconn.listeners[channel]
+	if !ok {
+		return errors.Errorf("Cannot stop listening for notifications; not listening on channel %s", channel)
+	}
+	active.cancel()
+	<-active.done
+	delete(dbconn.listeners, channel)
+	return nil
+}
+
+func (dbconn *DBConn) unlistenAll() {
+	for channel := range dbconn.listeners {
+		_ = dbconn.Unlisten(channel)
+	}
+}
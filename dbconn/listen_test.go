@@ -0,0 +1,70 @@
+package dbconn_test
+
+import (
+	"github.com/cloudberrydb/gp-common-go-libs/dbconn"
+	"github.com/cloudberrydb/gp-common-go-libs/testhelper"
+	"github.com/pkg/errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("dbconn/listen tests", func() {
+	var listener *testhelper.TestListener
+	BeforeEach(func() {
+		listener = testhelper.NewTestListener()
+		connection.Driver.(*testhelper.TestDriver).Listener = listener
+	})
+	Describe("DBConn.Listen", func() {
+		It("delivers notifications on the returned channel", func() {
+			notifications, err := connection.Listen("progress")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(listener.Channels).To(Equal([]string{"progress"}))
+
+			listener.Notifications <- &dbconn.Notification{Channel: "progress", Payload: "10%", PID: 1234}
+			Expect(<-notifications).To(Equal(dbconn.Notification{Channel: "progress", Payload: "10%", PID: 1234}))
+			listener.Notifications <- &dbconn.Notification{Channel: "progress", Payload: "20%", PID: 1234}
+			Expect(<-notifications).To(Equal(dbconn.Notification{Channel: "progress", Payload: "20%", PID: 1234}))
+		})
+		It("returns an error if already listening on the channel", func() {
+			_, err := connection.Listen("progress")
+			Expect(err).ToNot(HaveOccurred())
+			_, err = connection.Listen("progress")
+			Expect(err).To(MatchError("Cannot listen for notifications; already listening on channel progress"))
+		})
+		It("returns an error if the LISTEN fails", func() {
+			listener.ErrToReturn = errors.New("permission denied")
+			_, err := connection.Listen("progress")
+			Expect(err).To(MatchError("permission denied"))
+			Expect(listener.Closed).To(BeTrue())
+		})
+		It("returns an error if the connection is not open", func() {
+			connection.Close()
+			_, err := connection.Listen("progress")
+			Expect(err).To(MatchError("Cannot listen for notifications; the database connection is not open"))
+		})
+	})
+	Describe("DBConn.Unlisten", func() {
+		It("closes the notification channel and the listening connection", func() {
+			notifications, err := connection.Listen("progress")
+			Expect(err).ToNot(HaveOccurred())
+
+			err = connection.Unlisten("progress")
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(notifications).Should(BeClosed())
+			Expect(listener.Closed).To(BeTrue())
+		})
+		It("returns an error if not listening on the channel", func() {
+			err := connection.Unlisten("progress")
+			Expect(err).To(MatchError("Cannot stop listening for notifications; not listening on channel progress"))
+		})
+		It("stops listening when the connection is closed", func() {
+			notifications, err := connection.Listen("progress")
+			Expect(err).ToNot(HaveOccurred())
+
+			connection.Close()
+			Eventually(notifications).Should(BeClosed())
+			Expect(listener.Closed).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,99 @@
+package dbconn
+
+/*
+ * This file contains functions related to caching prepared statements.
+ */
+
+import (
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
+)
+
+/*
+ * ExecPrepared, GetPrepared, and SelectPrepared behave like ExecWithArgs,
+ * GetWithArgs, and SelectWithArgs, but prepare each distinct query once and
+ * reuse the prepared statement on later calls with the same query text, which
+ * avoids reparsing a query that is run many times with different arguments.
+ * Like the other functions that take query arguments, they run on the first
+ * connection, so the cache only holds statements prepared on connection 0.
+ * Cached statements are closed by Close.
+ *
+ * A query first run inside a transaction is prepared in that transaction and
+ * is not cached, since the transaction holds the connection's only pooled
+ * connection and the statement is closed when the transaction ends.
+ */
+func (dbconn *DBConn) ExecPrepared(query string, args ...interface{}) (sql.Result, error) {
+	if dbconn.skipForDryRun(dbconn.transformQuery(query)) {
+		return dryRunResult, nil
+	}
+	unlock := dbconn.lockConn(0)
+	defer unlock()
+	stmt, err := dbconn.getPreparedStatement(query)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.Exec(args...)
+}
+
+func (dbconn *DBConn) GetPrepared(destination interface{}, query string, args ...interface{}) error {
+	unlock := dbconn.lockConn(0)
+	defer unlock()
+	stmt, err := dbconn.getPreparedStatement(query)
+	if err != nil {
+		return err
+	}
+	return stmt.Get(destination, args...)
+}
+
+func (dbconn *DBConn) SelectPrepared(destination interface{}, query string, args ...interface{}) error {
+	unlock := dbconn.lockConn(0)
+	defer unlock()
+	stmt, err := dbconn.getPreparedStatement(query)
+	if err != nil {
+		return err
+	}
+	return stmt.Select(destination, args...)
+}
+
+/*
+ * Returns the cached statement for the query on the first connection,
+ * preparing it if needed.  Cached statements are bound to the transaction in
+ * progress, if any, each time they are used.  With a transaction in progress
+ * an uncached query is prepared through the transaction instead, as preparing
+ * it on the pool would wait forever for the connection the transaction holds.
+ */
+func (dbconn *DBConn) getPreparedStatement(query string) (*sqlx.Stmt, error) {
+	query = dbconn.transformQuery(query)
+	if dbconn.preparedStmts == nil {
+		dbconn.preparedStmts = make([]map[string]*sqlx.Stmt, dbconn.NumConns)
+	}
+	if dbconn.preparedStmts[0] == nil {
+		dbconn.preparedStmts[0] = make(map[string]*sqlx.Stmt)
+	}
+	stmt, ok := dbconn.preparedStmts[0][query]
+	if !ok && dbconn.Tx[0] != nil {
+		return dbconn.Tx[0].Preparex(query)
+	}
+	if !ok {
+		var err error
+		stmt, err = dbconn.ConnPool[0].Preparex(query)
+		if err != nil {
+			return nil, err
+		}
+		dbconn.preparedStmts[0][query] = stmt
+	}
+	if dbconn.Tx[0] != nil {
+		return dbconn.Tx[0].Stmtx(stmt), nil
+	}
+	return stmt, nil
+}
+
+func (dbconn *DBConn) closePreparedStatements() {
+	for _, stmts := range dbconn.preparedStmts {
+		for _, stmt := range stmts {
+			_ = stmt.Close()
+		}
+	}
+	dbconn.preparedStmts = nil
+}
@@ -0,0 +1,124 @@
+package dbconn_test
+
+import (
+	"regexp"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/cloudberrydb/gp-common-go-libs/testhelper"
+	"github.com/pkg/errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("dbconn/prepared tests", func() {
+	insertQuery := "INSERT INTO foo VALUES ($1)"
+	Describe("DBConn.ExecPrepared", func() {
+		It("prepares a query once and reuses it", func() {
+			prepare := mock.ExpectPrepare(regexp.QuoteMeta(insertQuery))
+			prepare.ExpectExec().WithArgs(1).WillReturnResult(testhelper.TestResult{Rows: 1})
+			prepare.ExpectExec().WithArgs(2).WillReturnResult(testhelper.TestResult{Rows: 1})
+			prepare.ExpectExec().WithArgs(3).WillReturnResult(testhelper.TestResult{Rows: 1})
+
+			for i := 1; i <= 3; i++ {
+				_, err := connection.ExecPrepared(insertQuery, i)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("prepares each distinct query separately", func() {
+			mock.ExpectPrepare(regexp.QuoteMeta(insertQuery)).ExpectExec().WithArgs(1).WillReturnResult(testhelper.TestResult{Rows: 1})
+			mock.ExpectPrepare(regexp.QuoteMeta("DELETE FROM foo WHERE i = $1")).ExpectExec().WithArgs(1).WillReturnResult(testhelper.TestResult{Rows: 1})
+
+			_, err := connection.ExecPrepared(insertQuery, 1)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = connection.ExecPrepared("DELETE FROM foo WHERE i = $1", 1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("runs a cached statement inside a transaction in progress", func() {
+			prepare := mock.ExpectPrepare(regexp.QuoteMeta(insertQuery))
+			prepare.ExpectExec().WithArgs(1).WillReturnResult(testhelper.TestResult{Rows: 1})
+			testhelper.ExpectBegin(mock)
+			prepare.ExpectExec().WithArgs(2).WillReturnResult(testhelper.TestResult{Rows: 1})
+			mock.ExpectCommit()
+
+			_, err := connection.ExecPrepared(insertQuery, 1)
+			Expect(err).ToNot(HaveOccurred())
+			connection.MustBegin()
+			_, err = connection.ExecPrepared(insertQuery, 2)
+			Expect(err).ToNot(HaveOccurred())
+			connection.MustCommit()
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("prepares a query first run inside a transaction in the transaction without caching it", func() {
+			testhelper.ExpectBegin(mock)
+			mock.ExpectPrepare(regexp.QuoteMeta(insertQuery)).ExpectExec().WithArgs(1).WillReturnResult(testhelper.TestResult{Rows: 1})
+			mock.ExpectCommit()
+			prepare := mock.ExpectPrepare(regexp.QuoteMeta(insertQuery))
+			prepare.ExpectExec().WithArgs(2).WillReturnResult(testhelper.TestResult{Rows: 1})
+
+			connection.MustBegin()
+			_, err := connection.ExecPrepared(insertQuery, 1)
+			Expect(err).ToNot(HaveOccurred())
+			connection.MustCommit()
+			_, err = connection.ExecPrepared(insertQuery, 2)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("does not wait for the pool when selecting with a prepared query inside a transaction", func() {
+			testhelper.ExpectBegin(mock)
+			mock.ExpectPrepare(regexp.QuoteMeta("SELECT i FROM foo WHERE i > $1")).ExpectQuery().WithArgs(0).
+				WillReturnRows(sqlmock.NewRows([]string{"i"}).AddRow(1).AddRow(2))
+			mock.ExpectCommit()
+
+			connection.MustBegin()
+			done := make(chan error, 1)
+			results := make([]int, 0)
+			go func() {
+				done <- connection.SelectPrepared(&results, "SELECT i FROM foo WHERE i > $1", 0)
+			}()
+			Eventually(done).Should(Receive(BeNil()))
+			connection.MustCommit()
+			Expect(results).To(Equal([]int{1, 2}))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("does not cache a query that fails to prepare", func() {
+			mock.ExpectPrepare(regexp.QuoteMeta(insertQuery)).WillReturnError(errors.New("relation foo does not exist"))
+			mock.ExpectPrepare(regexp.QuoteMeta(insertQuery)).ExpectExec().WithArgs(1).WillReturnResult(testhelper.TestResult{Rows: 1})
+
+			_, err := connection.ExecPrepared(insertQuery, 1)
+			Expect(err).To(MatchError("relation foo does not exist"))
+			_, err = connection.ExecPrepared(insertQuery, 1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+	})
+	Describe("DBConn.GetPrepared and DBConn.SelectPrepared", func() {
+		It("scans the results of a cached statement", func() {
+			countQuery := "SELECT count(*) FROM foo WHERE i > $1"
+			prepare := mock.ExpectPrepare(regexp.QuoteMeta(countQuery))
+			prepare.ExpectQuery().WithArgs(0).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+			prepare.ExpectQuery().WithArgs(0).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+
+			var count int
+			Expect(connection.GetPrepared(&count, countQuery, 0)).To(Succeed())
+			Expect(count).To(Equal(2))
+			counts := make([]int, 0)
+			Expect(connection.SelectPrepared(&counts, countQuery, 0)).To(Succeed())
+			Expect(counts).To(Equal([]int{2}))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+	})
+	Describe("DBConn.Close", func() {
+		It("closes the cached statements", func() {
+			prepare := mock.ExpectPrepare(regexp.QuoteMeta(insertQuery)).WillBeClosed()
+			prepare.ExpectExec().WithArgs(1).WillReturnResult(testhelper.TestResult{Rows: 1})
+			_, err := connection.ExecPrepared(insertQuery, 1)
+			Expect(err).ToNot(HaveOccurred())
+
+			connection.Close()
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+	})
+})
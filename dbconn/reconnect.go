@@ -0,0 +1,72 @@
+package dbconn
+
+/*
+ * This file contains functions related to reconnecting after the server
+ * terminates a connection.
+ */
+
+import (
+	"github.com/cloudberrydb/gp-common-go-libs/gplog"
+)
+
+/*
+ * EnableAutoReconnect makes Exec, Get, Select, and the functions built on
+ * them reconnect and run a statement once more if it fails because the server
+ * terminated the connection, as happens when an administrator shuts down or
+ * restarts the cluster for maintenance.  Only statements run outside of a
+ * transaction are retried, since a transaction in progress is lost along with
+ * its connection.  The GUCs set with SetSessionGUCs are applied to the new
+ * connection, but other session state, such as settings applied with SET, is
+ * not restored.
+ */
+func (dbconn *DBConn) EnableAutoReconnect() {
+	dbconn.autoReconnect = true
+}
+
+/*
+ * Returns true if err shows that the server terminated the connection and the
+ * connection was replaced, in which case the caller should run its statement
+ * again.  If reconnecting fails, the original error is left for the caller to
+ * return, so that it can still be classified as ErrServerShutdown.
+ */
+func (dbconn *DBConn) reconnectAfterShutdown(err error, connNum int) bool {
+	if !dbconn.autoReconnect || dbconn.Tx[connNum] != nil || ClassifyError(err) != ErrServerShutdown {
+		return false
+	}
+	gplog.Verbose("Connection %d was terminated by the server, reconnecting", connNum)
+	return dbconn.reconnect(connNum)
+}
+
+/*
+ * Replaces a pool connection with a new one, discarding the state tied to the
+ * old connection, and returns whether that succeeded.
+ */
+func (dbconn *DBConn) reconnect(connNum int) bool {
+	conn, err := dbconn.Driver.Connect("pgx", dbconn.connStr)
+	if err != nil {
+		gplog.Warn("Unable to reconnect connection %d: %s", connNum, dbconn.handleConnectionError(err).Error())
+		return false
+	}
+	conn.SetMaxOpenConns(1)
+	conn.SetMaxIdleConns(1)
+	if dbconn.preparedStmts != nil {
+		for _, stmt := range dbconn.preparedStmts[connNum] {
+			_ = stmt.Close()
+		}
+		dbconn.preparedStmts[connNum] = nil
+	}
+	_ = dbconn.ConnPool[connNum].Close()
+	dbconn.ConnPool[connNum] = conn
+	if dbconn.sessionInfo != nil {
+		dbconn.sessionInfo[connNum] = nil
+	}
+	if dbconn.roles != nil {
+		dbconn.roles[connNum] = ""
+	}
+	err = dbconn.applySessionGUCs(connNum)
+	if err != nil {
+		gplog.Warn("Unable to restore session settings after reconnecting: %s", err.Error())
+		return false
+	}
+	return true
+}
@@ -0,0 +1,105 @@
+package dbconn_test
+
+import (
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/cloudberrydb/gp-common-go-libs/dbconn"
+	"github.com/cloudberrydb/gp-common-go-libs/testhelper"
+	"github.com/jackc/pgconn"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("dbconn/reconnect tests", func() {
+	Describe("DBConn.EnableAutoReconnect", func() {
+		var (
+			driver    *testhelper.TestMultiDriver
+			firstMock sqlmock.Sqlmock
+			nextMock  sqlmock.Sqlmock
+			nextDB    *sqlx.DB
+		)
+		adminShutdown := &pgconn.PgError{Severity: "FATAL", Code: "57P01", Message: "terminating connection due to administrator command"}
+		fakeResult := testhelper.TestResult{Rows: 1}
+		BeforeEach(func() {
+			var firstDB *sqlx.DB
+			firstDB, firstMock = testhelper.CreateMockDB()
+			nextDB, nextMock = testhelper.CreateMockDB()
+			driver = &testhelper.TestMultiDriver{DBs: []*sqlx.DB{firstDB, nextDB}}
+			connection = dbconn.NewDBConnWithDriver("testdb", driver)
+			connection.Host = "testhost"
+			connection.Port = 5432
+			testhelper.ExpectVersionQuery(firstMock, "6.0.0")
+			connection.MustConnect(1)
+		})
+
+		It("surfaces a terminated connection as ErrServerShutdown", func() {
+			firstMock.ExpectExec("INSERT (.*)").WillReturnError(adminShutdown)
+
+			_, err := connection.Exec("INSERT INTO foo VALUES (1)")
+			Expect(dbconn.ClassifyError(err)).To(Equal(dbconn.ErrServerShutdown))
+			Expect(driver.CallNumber).To(Equal(1))
+		})
+		It("reconnects and runs the statement again", func() {
+			connection.EnableAutoReconnect()
+			firstMock.ExpectExec("INSERT (.*)").WillReturnError(adminShutdown)
+			nextMock.ExpectExec("INSERT (.*)").WillReturnResult(fakeResult)
+
+			_, err := connection.Exec("INSERT INTO foo VALUES (1)")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(connection.ConnPool[0]).To(Equal(nextDB))
+			Expect(firstMock.ExpectationsWereMet()).To(Succeed())
+			Expect(nextMock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("reconnects and runs a query again", func() {
+			connection.EnableAutoReconnect()
+			firstMock.ExpectQuery("SELECT (.*)").WillReturnError(adminShutdown)
+			nextMock.ExpectQuery("SELECT (.*)").WillReturnRows(sqlmock.NewRows([]string{"relname"}).AddRow("foo"))
+
+			tables := make([]string, 0)
+			err := connection.Select(&tables, "SELECT relname FROM pg_class")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tables).To(Equal([]string{"foo"}))
+			Expect(nextMock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("does not reconnect in a transaction", func() {
+			connection.EnableAutoReconnect()
+			testhelper.ExpectBegin(firstMock)
+			firstMock.ExpectExec("INSERT (.*)").WillReturnError(adminShutdown)
+
+			connection.MustBegin()
+			_, err := connection.Exec("INSERT INTO foo VALUES (1)")
+			Expect(dbconn.ClassifyError(err)).To(Equal(dbconn.ErrServerShutdown))
+			Expect(driver.CallNumber).To(Equal(1))
+			connection.Tx[0] = nil
+		})
+		It("does not reconnect for other errors", func() {
+			connection.EnableAutoReconnect()
+			firstMock.ExpectExec("INSERT (.*)").WillReturnError(errors.New("permission denied"))
+
+			_, err := connection.Exec("INSERT INTO foo VALUES (1)")
+			Expect(err).To(MatchError("permission denied"))
+			Expect(driver.CallNumber).To(Equal(1))
+		})
+		It("applies the session GUCs to the new connection", func() {
+			connection.EnableAutoReconnect()
+			connection.SetSessionGUCs(map[string]string{"statement_timeout": "0"})
+			firstMock.ExpectExec("INSERT (.*)").WillReturnError(adminShutdown)
+			nextMock.ExpectExec("SET statement_timeout TO 0").WillReturnResult(testhelper.TestResult{Rows: 0})
+			nextMock.ExpectExec("INSERT (.*)").WillReturnResult(fakeResult)
+
+			_, err := connection.Exec("INSERT INTO foo VALUES (1)")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(nextMock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("returns the original error if reconnecting fails", func() {
+			connection.EnableAutoReconnect()
+			connection.Driver = &testhelper.TestDriver{ErrToReturn: errors.New("the database system is shutting down")}
+			firstMock.ExpectExec("INSERT (.*)").WillReturnError(adminShutdown)
+
+			_, err := connection.Exec("INSERT INTO foo VALUES (1)")
+			Expect(dbconn.ClassifyError(err)).To(Equal(dbconn.ErrServerShutdown))
+		})
+	})
+})
@@ -0,0 +1,134 @@
+package dbconn
+
+/*
+ * This file contains structs and functions related to formatting query results
+ * as reports.
+ */
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+/*
+ * A Table holds the result of a query as text, with the columns in the order
+ * in which the query returned them.  NULL values are represented by empty
+ * strings.
+ */
+type Table struct {
+	Columns []string
+	Rows    [][]string
+}
+
+func (dbconn *DBConn) SelectTable(query string, args ...interface{}) (*Table, error) {
+	rows, err := dbconn.QueryWithArgs(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	table := &Table{Columns: columns, Rows: make([][]string, 0)}
+	for rows.Next() {
+		values, err := rows.SliceScan()
+		if err != nil {
+			return nil, err
+		}
+		row := make([]string, len(values))
+		for i, value := range values {
+			switch v := value.(type) {
+			case nil:
+				row[i] = ""
+			case []byte:
+				row[i] = string(v)
+			default:
+				row[i] = fmt.Sprint(v)
+			}
+		}
+		table.Rows = append(table.Rows, row)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	return table, nil
+}
+
+const reportEllipsis = "..."
+
+/*
+ * Pads or truncates a value to exactly width characters.  Values that do not
+ * fit end in an ellipsis, unless the column is too narrow to hold one.
+ */
+func fitToWidth(value string, width int) string {
+	runes := []rune(value)
+	if len(runes) > width {
+		if width > len(reportEllipsis) {
+			return string(runes[:width-len(reportEllipsis)]) + reportEllipsis
+		}
+		return string(runes[:width])
+	}
+	return value + strings.Repeat(" ", width-len(runes))
+}
+
+/*
+ * WriteFixedWidthReport writes a table as aligned columns, with a header line
+ * and a line of dashes under it, followed by one line per row.  Each column is
+ * padded or truncated to its width in colWidths; columns without an entry are
+ * as wide as their longest value or header.
+ */
+func WriteFixedWidthReport(t *Table, w io.Writer, colWidths map[string]int) error {
+	widths := make([]int, len(t.Columns))
+	for i, column := range t.Columns {
+		if width, ok := colWidths[column]; ok {
+			if width <= 0 {
+				return errors.Errorf("Invalid width %d for column %s: width must be positive", width, column)
+			}
+			widths[i] = width
+			continue
+		}
+		widths[i] = len([]rune(column))
+		for _, row := range t.Rows {
+			if i < len(row) && len([]rune(row[i])) > widths[i] {
+				widths[i] = len([]rune(row[i]))
+			}
+		}
+	}
+
+	writeLine := func(values []string) error {
+		fields := make([]string, len(widths))
+		for i, width := range widths {
+			value := ""
+			if i < len(values) {
+				value = values[i]
+			}
+			fields[i] = fitToWidth(value, width)
+		}
+		_, err := fmt.Fprintln(w, strings.TrimRight(strings.Join(fields, "  "), " "))
+		return err
+	}
+
+	err := writeLine(t.Columns)
+	if err != nil {
+		return err
+	}
+	underline := make([]string, len(widths))
+	for i, width := range widths {
+		underline[i] = strings.Repeat("-", width)
+	}
+	err = writeLine(underline)
+	if err != nil {
+		return err
+	}
+	for _, row := range t.Rows {
+		err = writeLine(row)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
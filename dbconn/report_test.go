@@ -0,0 +1,90 @@
+package dbconn_test
+
+import (
+	"bytes"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/cloudberrydb/gp-common-go-libs/dbconn"
+	"github.com/pkg/errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("dbconn/report tests", func() {
+	Describe("DBConn.SelectTable", func() {
+		It("returns the columns in query order and the values as text", func() {
+			rows := sqlmock.NewRows([]string{"name", "size", "owner"}).
+				AddRow("orders", 1024, nil).
+				AddRow([]byte("customers"), 42, "gpadmin")
+			mock.ExpectQuery("SELECT (.*)").WillReturnRows(rows)
+
+			table, err := connection.SelectTable("SELECT name, size, owner FROM sizes")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(table.Columns).To(Equal([]string{"name", "size", "owner"}))
+			Expect(table.Rows).To(Equal([][]string{{"orders", "1024", ""}, {"customers", "42", "gpadmin"}}))
+		})
+		It("returns an error if the query fails", func() {
+			mock.ExpectQuery("SELECT (.*)").WillReturnError(errors.New("relation does not exist"))
+			_, err := connection.SelectTable("SELECT name FROM sizes")
+			Expect(err).To(MatchError("relation does not exist"))
+		})
+	})
+	Describe("WriteFixedWidthReport", func() {
+		var (
+			table  *dbconn.Table
+			buffer *bytes.Buffer
+		)
+		BeforeEach(func() {
+			table = &dbconn.Table{
+				Columns: []string{"schema", "table", "size"},
+				Rows: [][]string{
+					{"public", "orders", "1024"},
+					{"sales", "customer_addresses", "42"},
+				},
+			}
+			buffer = &bytes.Buffer{}
+		})
+		It("pads each column to its configured width", func() {
+			err := dbconn.WriteFixedWidthReport(table, buffer, map[string]int{"schema": 8, "table": 20, "size": 6})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(buffer.String()).To(Equal(`schema    table                 size
+--------  --------------------  ------
+public    orders                1024
+sales     customer_addresses    42
+`))
+		})
+		It("truncates values wider than the column with an ellipsis", func() {
+			err := dbconn.WriteFixedWidthReport(table, buffer, map[string]int{"schema": 6, "table": 10, "size": 4})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(buffer.String()).To(Equal(`schema  table       size
+------  ----------  ----
+public  orders      1024
+sales   custome...  42
+`))
+		})
+		It("truncates without an ellipsis when the column is too narrow for one", func() {
+			err := dbconn.WriteFixedWidthReport(table, buffer, map[string]int{"schema": 2, "table": 3, "size": 4})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(buffer.String()).To(Equal(`sc  tab  size
+--  ---  ----
+pu  ord  1024
+sa  cus  42
+`))
+		})
+		It("sizes columns without a configured width to fit their values", func() {
+			err := dbconn.WriteFixedWidthReport(table, buffer, map[string]int{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(buffer.String()).To(Equal(`schema  table               size
+------  ------------------  ----
+public  orders              1024
+sales   customer_addresses  42
+`))
+		})
+		It("returns an error for a width that is not positive", func() {
+			err := dbconn.WriteFixedWidthReport(table, buffer, map[string]int{"size": 0})
+			Expect(err).To(MatchError("Invalid width 0 for column size: width must be positive"))
+			Expect(buffer.Len()).To(Equal(0))
+		})
+	})
+})
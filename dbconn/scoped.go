@@ -0,0 +1,72 @@
+package dbconn
+
+/*
+ * This file contains a wrapper around a single connection in a DBConn pool.
+ */
+
+import (
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
+)
+
+/*
+ * A ScopedConn is bound to one connection in the pool of its DBConn, so code
+ * that should run entirely on one connection can call Exec, Select, Begin, and
+ * so on without passing the connection number to each call.  It holds no state
+ * of its own; a transaction begun through a ScopedConn is the same one that
+ * the DBConn functions see when passed the same connection number.
+ */
+type ScopedConn struct {
+	DBConn  *DBConn
+	ConnNum int
+}
+
+func (dbconn *DBConn) Conn(whichConn int) *ScopedConn {
+	connNum := dbconn.ValidateConnNum(whichConn)
+	return &ScopedConn{DBConn: dbconn, ConnNum: connNum}
+}
+
+func (conn *ScopedConn) Begin() error {
+	return conn.DBConn.Begin(conn.ConnNum)
+}
+
+func (conn *ScopedConn) MustBegin() {
+	conn.DBConn.MustBegin(conn.ConnNum)
+}
+
+func (conn *ScopedConn) Commit() error {
+	return conn.DBConn.Commit(conn.ConnNum)
+}
+
+func (conn *ScopedConn) MustCommit() {
+	conn.DBConn.MustCommit(conn.ConnNum)
+}
+
+func (conn *ScopedConn) Rollback() error {
+	return conn.DBConn.Rollback(conn.ConnNum)
+}
+
+func (conn *ScopedConn) MustRollback() {
+	conn.DBConn.MustRollback(conn.ConnNum)
+}
+
+func (conn *ScopedConn) Exec(query string) (sql.Result, error) {
+	return conn.DBConn.Exec(query, conn.ConnNum)
+}
+
+func (conn *ScopedConn) MustExec(query string) {
+	conn.DBConn.MustExec(query, conn.ConnNum)
+}
+
+func (conn *ScopedConn) Get(destination interface{}, query string) error {
+	return conn.DBConn.Get(destination, query, conn.ConnNum)
+}
+
+func (conn *ScopedConn) Select(destination interface{}, query string) error {
+	return conn.DBConn.Select(destination, query, conn.ConnNum)
+}
+
+func (conn *ScopedConn) Query(query string) (*sqlx.Rows, error) {
+	return conn.DBConn.Query(query, conn.ConnNum)
+}
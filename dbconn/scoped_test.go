@@ -0,0 +1,70 @@
+package dbconn_test
+
+import (
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/cloudberrydb/gp-common-go-libs/testhelper"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("dbconn/scoped tests", func() {
+	BeforeEach(func() {
+		connection, mock = testhelper.CreateAndConnectMockDB(3)
+	})
+	Describe("DBConn.Conn", func() {
+		It("binds the handle to the given connection", func() {
+			conn := connection.Conn(2)
+			Expect(conn.DBConn).To(Equal(connection))
+			Expect(conn.ConnNum).To(Equal(2))
+		})
+		It("panics if given an invalid connection number", func() {
+			defer testhelper.ShouldPanicWithMessage("Invalid connection number: 3")
+			connection.Conn(3)
+		})
+	})
+	Describe("ScopedConn", func() {
+		It("runs a transaction on its own connection", func() {
+			conn := connection.Conn(2)
+			testhelper.ExpectBegin(mock)
+			mock.ExpectExec("INSERT INTO foo (.*)").WillReturnResult(testhelper.TestResult{Rows: 1})
+			mock.ExpectCommit()
+
+			conn.MustBegin()
+			Expect(connection.Tx[0]).To(BeNil())
+			Expect(connection.Tx[1]).To(BeNil())
+			Expect(connection.Tx[2]).ToNot(BeNil())
+			conn.MustExec("INSERT INTO foo VALUES (1)")
+			conn.MustCommit()
+			Expect(connection.Tx[2]).To(BeNil())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("rolls back a transaction on its own connection", func() {
+			conn := connection.Conn(1)
+			testhelper.ExpectTransactionWithRollback(mock)
+
+			Expect(conn.Begin()).To(Succeed())
+			Expect(connection.Tx[1]).ToNot(BeNil())
+			Expect(conn.Rollback()).To(Succeed())
+			Expect(connection.Tx[1]).To(BeNil())
+		})
+		It("does not see a transaction on another connection", func() {
+			testhelper.ExpectBegin(mock)
+			connection.MustBegin(0)
+			err := connection.Conn(1).Commit()
+			Expect(err).To(MatchError("Cannot commit transaction; there is no transaction in progress"))
+		})
+		It("selects and gets on its own connection", func() {
+			conn := connection.Conn(1)
+			mock.ExpectQuery("SELECT i FROM foo").WillReturnRows(sqlmock.NewRows([]string{"i"}).AddRow(1).AddRow(2))
+			mock.ExpectQuery("SELECT count(.*)").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+
+			results := make([]int, 0)
+			Expect(conn.Select(&results, "SELECT i FROM foo")).To(Succeed())
+			Expect(results).To(Equal([]int{1, 2}))
+			var count int
+			Expect(conn.Get(&count, "SELECT count(*) FROM foo")).To(Succeed())
+			Expect(count).To(Equal(2))
+		})
+	})
+})
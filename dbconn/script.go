@@ -0,0 +1,172 @@
+package dbconn
+
+/*
+ * This file contains functions related to running SQL scripts containing
+ * multiple statements.
+ */
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+/*
+ * ExecScript runs each statement in a semicolon-separated script in order on
+ * one connection, stopping at the first statement that fails.  Semicolons
+ * inside string literals, quoted identifiers, dollar-quoted strings, and
+ * comments do not end a statement.
+ *
+ * The statements are not run in a transaction unless the caller has begun
+ * one on the connection, so a failure partway through leaves the effects of
+ * the earlier statements in place.
+ */
+func (dbconn *DBConn) ExecScript(script string, whichConn ...int) error {
+	connNum := dbconn.ValidateConnNum(whichConn...)
+	statements, err := splitStatements(script)
+	if err != nil {
+		return err
+	}
+	for i, statement := range statements {
+		_, err = dbconn.Exec(statement, connNum)
+		if err != nil {
+			return errors.Wrapf(err, "Cannot execute statement %d of script", i+1)
+		}
+	}
+	return nil
+}
+
+func (dbconn *DBConn) MustExecScript(script string, whichConn ...int) {
+	err := dbconn.ExecScript(script, whichConn...)
+	fatalOnDatabaseError(err)
+}
+
+/*
+ * Splits a script into its statements, without their terminating semicolons.
+ * Statements that are empty or consist only of comments are dropped, since
+ * the server rejects an empty query string.
+ */
+func splitStatements(script string) ([]string, error) {
+	statements := make([]string, 0)
+	start := 0
+	hasContent := false
+	addStatement := func(end int) {
+		if hasContent {
+			statements = append(statements, strings.TrimSpace(script[start:end]))
+		}
+		start = end + 1
+		hasContent = false
+	}
+
+	for i := 0; i < len(script); i++ {
+		switch c := script[i]; {
+		case c == ';':
+			addStatement(i)
+			continue
+		case c == '-' && strings.HasPrefix(script[i:], "--"):
+			end := strings.IndexByte(script[i:], '\n')
+			if end == -1 {
+				i = len(script)
+			} else {
+				i += end
+			}
+			continue
+		case c == '/' && strings.HasPrefix(script[i:], "/*"):
+			end, err := findCommentEnd(script, i)
+			if err != nil {
+				return nil, err
+			}
+			i = end
+			continue
+		case c == '\'':
+			escapes := i > 0 && (script[i-1] == 'E' || script[i-1] == 'e') && (i == 1 || !isIdentifierChar(script[i-2]))
+			end, err := findQuoteEnd(script, i, '\'', escapes)
+			if err != nil {
+				return nil, err
+			}
+			i = end
+		case c == '"':
+			end, err := findQuoteEnd(script, i, '"', false)
+			if err != nil {
+				return nil, err
+			}
+			i = end
+		case c == '$' && (i == 0 || !isIdentifierChar(script[i-1])):
+			if tag, ok := dollarQuoteTag(script[i:]); ok {
+				end := strings.Index(script[i+len(tag):], tag)
+				if end == -1 {
+					return nil, errors.Errorf("Unterminated dollar-quoted string starting at offset %d of script", i)
+				}
+				i += len(tag) + end + len(tag) - 1
+			}
+		}
+		if script[i] != ' ' && script[i] != '\t' && script[i] != '\n' && script[i] != '\r' {
+			hasContent = true
+		}
+	}
+	addStatement(len(script))
+	return statements, nil
+}
+
+func isIdentifierChar(c byte) bool {
+	return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c >= 0x80
+}
+
+/*
+ * Returns the offset of the quote that closes the string or identifier
+ * starting at offset start.  A doubled quote character does not close it,
+ * and neither does one escaped with a backslash in an escape string.
+ */
+func findQuoteEnd(script string, start int, quote byte, escapes bool) (int, error) {
+	for i := start + 1; i < len(script); i++ {
+		if escapes && script[i] == '\\' {
+			i++
+		} else if script[i] == quote {
+			if i+1 < len(script) && script[i+1] == quote {
+				i++
+			} else {
+				return i, nil
+			}
+		}
+	}
+	return 0, errors.Errorf("Unterminated quoted string starting at offset %d of script", start)
+}
+
+/*
+ * Returns the offset of the last character of the block comment starting at
+ * offset start, accounting for nested comments as the server does.
+ */
+func findCommentEnd(script string, start int) (int, error) {
+	depth := 0
+	for i := start; i < len(script)-1; i++ {
+		if script[i] == '/' && script[i+1] == '*' {
+			depth++
+			i++
+		} else if script[i] == '*' && script[i+1] == '/' {
+			depth--
+			i++
+			if depth == 0 {
+				return i, nil
+			}
+		}
+	}
+	return 0, errors.Errorf("Unterminated comment starting at offset %d of script", start)
+}
+
+/*
+ * Returns the opening tag of a dollar-quoted string, such as "$$" or
+ * "$body$", if str starts with one.
+ */
+func dollarQuoteTag(str string) (string, bool) {
+	for i := 1; i < len(str); i++ {
+		c := str[i]
+		if c == '$' {
+			return str[:i+1], true
+		}
+		if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80 || (i > 1 && c >= '0' && c <= '9') {
+			continue
+		}
+		break
+	}
+	return "", false
+}
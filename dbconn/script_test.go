@@ -0,0 +1,77 @@
+package dbconn_test
+
+import (
+	"regexp"
+
+	"github.com/cloudberrydb/gp-common-go-libs/testhelper"
+	"github.com/pkg/errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("dbconn/script tests", func() {
+	Describe("DBConn.ExecScript", func() {
+		fakeResult := testhelper.TestResult{Rows: 0}
+		expectStatement := func(statement string) {
+			mock.ExpectExec("^" + regexp.QuoteMeta(statement) + "$").WillReturnResult(fakeResult)
+		}
+
+		It("runs each statement in the script in order", func() {
+			expectStatement("CREATE TABLE foo(i int)")
+			expectStatement("INSERT INTO foo VALUES (1)")
+			connection.MustExecScript(`CREATE TABLE foo(i int);
+INSERT INTO foo VALUES (1);
+`)
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("does not split on a semicolon inside a dollar-quoted function body", func() {
+			function := `CREATE FUNCTION add_one(i int) RETURNS int AS $body$
+BEGIN
+	RETURN i + 1;
+END;
+$body$ LANGUAGE plpgsql`
+			expectStatement(function)
+			expectStatement("SELECT add_one(1)")
+			err := connection.ExecScript(function + ";\nSELECT add_one(1)")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("does not split on a semicolon inside a $$-quoted string", func() {
+			expectStatement("DO $$BEGIN PERFORM 1; END$$")
+			err := connection.ExecScript("DO $$BEGIN PERFORM 1; END$$;")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("does not split on semicolons inside quotes or comments", func() {
+			expectStatement(`INSERT INTO "semi;colon" VALUES ('a;b', 'it''s;', E'\';')`)
+			expectStatement("-- one; two\nSELECT 1 /* three; /* four; */ five; */")
+			err := connection.ExecScript(`INSERT INTO "semi;colon" VALUES ('a;b', 'it''s;', E'\';');
+-- one; two
+SELECT 1 /* three; /* four; */ five; */;`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("skips empty statements and statements that are only comments", func() {
+			expectStatement("SELECT 1")
+			err := connection.ExecScript(";;\nSELECT 1;\n  ;\n-- trailing comment\n")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("stops at the first statement that fails", func() {
+			expectStatement("SELECT 1")
+			mock.ExpectExec("SELECT 2").WillReturnError(errors.New("division by zero"))
+			err := connection.ExecScript("SELECT 1; SELECT 2; SELECT 3;")
+			Expect(err).To(MatchError("Cannot execute statement 2 of script: division by zero"))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("returns an error for an unterminated string", func() {
+			err := connection.ExecScript("SELECT 1; SELECT 'abc;")
+			Expect(err).To(MatchError("Unterminated quoted string starting at offset 17 of script"))
+		})
+		It("returns an error for an unterminated dollar-quoted string", func() {
+			err := connection.ExecScript("DO $body$ BEGIN NULL; END $$;")
+			Expect(err).To(MatchError("Unterminated dollar-quoted string starting at offset 3 of script"))
+		})
+	})
+})
@@ -0,0 +1,86 @@
+package dbconn
+
+/*
+ * This file contains functions for running queries directly on each segment
+ * of a cluster.
+ */
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+/*
+ * A SegmentResult holds the rows a query returned on one primary segment, as
+ * maps like those returned by SelectMaps, or the error that prevented
+ * connecting to the segment or running the query there.
+ */
+type SegmentResult struct {
+	Hostname string
+	Port     int
+	Rows     []map[string]interface{}
+	Err      error
+}
+
+/*
+ * QueryAllSegments runs a query on every primary segment in parallel, for
+ * segment-level diagnostics such as per-segment disk usage, and returns the
+ * results keyed by content ID.  It reads the segment configuration through
+ * the coordinator connection, then opens a utility mode connection to the
+ * address of each segment with the same database, user, and connection
+ * parameters as the coordinator connection, and closes it once the query has
+ * run.  The hostname is reported in each SegmentResult for display.
+ *
+ * A segment that cannot be connected to or fails the query does not stop the
+ * others; its error is recorded in its SegmentResult.  An error is only
+ * returned if the segment configuration cannot be read.
+ */
+func QueryAllSegments(coordinator *DBConn, query string) (map[int]SegmentResult, error) {
+	segments, err := GetSegmentConfiguration(coordinator)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to read the segment configuration")
+	}
+	// The coordinator's version decides which utility mode GUC the segments
+	// accept, so there is no need to probe for it as Connect does.
+	utilityGUC := "gp_session_role"
+	if coordinator.Version.AtLeast("7") {
+		utilityGUC = "gp_role"
+	}
+
+	results := make(map[int]SegmentResult)
+	var resultsLock sync.Mutex
+	var wg sync.WaitGroup
+	for _, segment := range segments {
+		if segment.ContentID < 0 || segment.Role != "p" {
+			continue
+		}
+		wg.Add(1)
+		go func(segment SegmentConfig) {
+			defer wg.Done()
+			rows, err := querySegment(coordinator, segment, utilityGUC, query)
+			resultsLock.Lock()
+			defer resultsLock.Unlock()
+			results[segment.ContentID] = SegmentResult{Hostname: segment.Hostname, Port: segment.Port, Rows: rows, Err: err}
+		}(segment)
+	}
+	wg.Wait()
+	return results, nil
+}
+
+func querySegment(coordinator *DBConn, segment SegmentConfig, utilityGUC string, query string) ([]map[string]interface{}, error) {
+	conn := coordinator.Clone()
+	conn.Host = segment.Address
+	conn.Port = segment.Port
+	conn.SetConnectionParam(utilityGUC, "utility")
+	err := conn.Connect(1)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to connect to segment %d on %s:%d", segment.ContentID, segment.Address, segment.Port)
+	}
+	defer conn.Close()
+	rows, err := conn.SelectMaps(query)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to run query on segment %d on %s:%d", segment.ContentID, segment.Address, segment.Port)
+	}
+	return rows, nil
+}
@@ -0,0 +1,155 @@
+package dbconn_test
+
+import (
+	"net/url"
+	"regexp"
+	"sync"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/cloudberrydb/gp-common-go-libs/dbconn"
+	"github.com/cloudberrydb/gp-common-go-libs/testhelper"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("dbconn/segments tests", func() {
+	Describe("QueryAllSegments", func() {
+		var (
+			driver      *segmentDriver
+			segmentMock map[string]sqlmock.Sqlmock
+		)
+		configHeader := []string{"dbid", "contentid", "role", "preferredrole", "hostname", "address", "port", "datadir"}
+		expectSegmentConfiguration := func() {
+			mock.ExpectQuery("SELECT (.*) FROM gp_segment_configuration").WillReturnRows(sqlmock.NewRows(configHeader).
+				AddRow(1, -1, "p", "p", "cdw", "cdw-1", 5432, "/data/coordinator/gpseg-1").
+				AddRow(2, 0, "p", "p", "sdw1", "sdw1-1", 6000, "/data/primary/gpseg0").
+				AddRow(4, 0, "m", "m", "sdw2", "sdw2-1", 7000, "/data/mirror/gpseg0").
+				AddRow(3, 1, "p", "p", "sdw2", "sdw2-1", 6001, "/data/primary/gpseg1").
+				AddRow(5, 1, "m", "m", "sdw1", "sdw1-1", 7001, "/data/mirror/gpseg1"))
+		}
+		usageHeader := []string{"used"}
+		BeforeEach(func() {
+			testhelper.SetDBVersion(connection, "6.0.0")
+			driver = &segmentDriver{dbs: make(map[string]*sqlx.DB), dataSourceNames: make(map[string]string)}
+			segmentMock = make(map[string]sqlmock.Sqlmock)
+			for _, host := range []string{"sdw1-1:6000", "sdw2-1:6001"} {
+				var db *sqlx.DB
+				db, segmentMock[host] = testhelper.CreateMockDB()
+				driver.dbs[host] = db
+				testhelper.ExpectVersionQuery(segmentMock[host], "6.0.0")
+			}
+			connection.Driver = driver
+		})
+
+		It("runs the query on each primary segment and returns the results by content ID", func() {
+			expectSegmentConfiguration()
+			segmentMock["sdw1-1:6000"].ExpectQuery("SELECT used FROM disk_usage").WillReturnRows(sqlmock.NewRows(usageHeader).AddRow(100))
+			segmentMock["sdw2-1:6001"].ExpectQuery("SELECT used FROM disk_usage").WillReturnRows(sqlmock.NewRows(usageHeader).AddRow(200))
+
+			results, err := dbconn.QueryAllSegments(connection, "SELECT used FROM disk_usage")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(results).To(Equal(map[int]dbconn.SegmentResult{
+				0: {Hostname: "sdw1", Port: 6000, Rows: []map[string]interface{}{{"used": int64(100)}}},
+				1: {Hostname: "sdw2", Port: 6001, Rows: []map[string]interface{}{{"used": int64(200)}}},
+			}))
+			Expect(segmentMock["sdw1-1:6000"].ExpectationsWereMet()).To(Succeed())
+			Expect(segmentMock["sdw2-1:6001"].ExpectationsWereMet()).To(Succeed())
+		})
+		It("connects to each segment in utility mode as the coordinator's user", func() {
+			connection.User = "testrole"
+			expectSegmentConfiguration()
+			for _, host := range []string{"sdw1-1:6000", "sdw2-1:6001"} {
+				segmentMock[host].ExpectQuery("SELECT 1").WillReturnRows(sqlmock.NewRows(usageHeader).AddRow(1))
+				segmentMock[host].ExpectClose()
+			}
+
+			_, err := dbconn.QueryAllSegments(connection, "SELECT 1")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(driver.dataSourceNames).To(HaveLen(2))
+			for host, dataSourceName := range driver.dataSourceNames {
+				Expect(dataSourceName).To(HavePrefix("postgres://testrole@" + host + "/testdb?"))
+				Expect(dataSourceName).To(ContainSubstring("gp_session_role=utility"))
+				Expect(segmentMock[host].ExpectationsWereMet()).To(Succeed())
+			}
+		})
+		It("connects with gp_role in GPDB 7 and later", func() {
+			testhelper.SetDBVersion(connection, "7.0.0")
+			mock.ExpectQuery("SELECT (.*) FROM gp_segment_configuration").WillReturnRows(sqlmock.NewRows(configHeader).
+				AddRow(2, 0, "p", "p", "sdw1", "sdw1-1", 6000, "/data/primary/gpseg0"))
+			segmentMock["sdw1-1:6000"].ExpectQuery("SELECT 1").WillReturnRows(sqlmock.NewRows(usageHeader).AddRow(1))
+
+			results, err := dbconn.QueryAllSegments(connection, "SELECT 1")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(results).To(HaveLen(1))
+			Expect(results[0].Err).ToNot(HaveOccurred())
+			Expect(driver.dataSourceNames["sdw1-1:6000"]).To(ContainSubstring("gp_role=utility"))
+			Expect(driver.dataSourceNames["sdw1-1:6000"]).ToNot(ContainSubstring("gp_session_role"))
+		})
+		It("records the error of each segment that fails without affecting the others", func() {
+			mock.ExpectQuery("SELECT (.*) FROM gp_segment_configuration").WillReturnRows(sqlmock.NewRows(configHeader).
+				AddRow(2, 0, "p", "p", "sdw1", "sdw1-1", 6000, "/data/primary/gpseg0").
+				AddRow(3, 1, "p", "p", "sdw2", "sdw2-1", 6001, "/data/primary/gpseg1").
+				AddRow(6, 2, "p", "p", "sdw3", "sdw3-1", 6002, "/data/primary/gpseg2"))
+			segmentMock["sdw1-1:6000"].ExpectQuery("SELECT used FROM disk_usage").WillReturnRows(sqlmock.NewRows(usageHeader).AddRow(100))
+			segmentMock["sdw2-1:6001"].ExpectQuery("SELECT used FROM disk_usage").WillReturnError(errors.New("permission denied"))
+
+			results, err := dbconn.QueryAllSegments(connection, "SELECT used FROM disk_usage")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(results).To(HaveLen(3))
+			Expect(results[0].Err).ToNot(HaveOccurred())
+			Expect(results[0].Rows).To(Equal([]map[string]interface{}{{"used": int64(100)}}))
+			Expect(results[1].Err).To(MatchError("Unable to run query on segment 1 on sdw2-1:6001: permission denied"))
+			Expect(results[1].Rows).To(BeNil())
+			Expect(results[2].Err).To(HaveOccurred())
+			Expect(results[2].Err.Error()).To(HavePrefix("Unable to connect to segment 2 on sdw3-1:6002: "))
+			Expect(results[2].Hostname).To(Equal("sdw3"))
+			Expect(results[2].Port).To(Equal(6002))
+		})
+		It("returns an error if the segment configuration cannot be read", func() {
+			mock.ExpectQuery("SELECT (.*) FROM gp_segment_configuration").WillReturnError(errors.New("permission denied"))
+
+			results, err := dbconn.QueryAllSegments(connection, "SELECT 1")
+			Expect(err).To(MatchError("Unable to read the segment configuration: permission denied"))
+			Expect(results).To(BeNil())
+			Expect(driver.dataSourceNames).To(BeEmpty())
+		})
+		It("passes the query through the coordinator's query transformer", func() {
+			expectSegmentConfiguration()
+			connection.SetQueryTransformer(func(query string) string {
+				return regexp.MustCompile(`\bdisk_usage\b`).ReplaceAllString(query, "diag.disk_usage")
+			})
+			segmentMock["sdw1-1:6000"].ExpectQuery(regexp.QuoteMeta("SELECT used FROM diag.disk_usage")).WillReturnRows(sqlmock.NewRows(usageHeader).AddRow(100))
+			segmentMock["sdw2-1:6001"].ExpectQuery(regexp.QuoteMeta("SELECT used FROM diag.disk_usage")).WillReturnRows(sqlmock.NewRows(usageHeader).AddRow(200))
+
+			results, err := dbconn.QueryAllSegments(connection, "SELECT used FROM disk_usage")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(results[0].Err).ToNot(HaveOccurred())
+			Expect(results[1].Err).ToNot(HaveOccurred())
+		})
+	})
+})
+
+// A DBDriver that connects to a separate mock database for each host and port
+type segmentDriver struct {
+	lock            sync.Mutex
+	dbs             map[string]*sqlx.DB
+	dataSourceNames map[string]string
+}
+
+func (driver *segmentDriver) Connect(driverName string, dataSourceName string) (*sqlx.DB, error) {
+	parsed, err := url.Parse(dataSourceName)
+	if err != nil {
+		return nil, err
+	}
+	driver.lock.Lock()
+	defer driver.lock.Unlock()
+	driver.dataSourceNames[parsed.Host] = dataSourceName
+	db, ok := driver.dbs[parsed.Host]
+	if !ok {
+		return nil, errors.Errorf("no mock database for %s", parsed.Host)
+	}
+	return db, nil
+}
@@ -0,0 +1,178 @@
+package dbconn
+
+/*
+ * This file contains functions related to reading and setting server
+ * configuration parameters (GUCs).
+ */
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+/*
+ * A GUC to be set at the start of a session, along with the earliest GPDB
+ * version in which it exists (empty if it exists in all supported versions).
+ */
+type sessionSetting struct {
+	Name       string
+	Value      string
+	MinVersion string
+}
+
+/*
+ * These settings ensure that long-running catalog queries are not cancelled
+ * partway through and that output is formatted consistently regardless of the
+ * server or user defaults.
+ */
+var backupSessionSettings = []sessionSetting{
+	{Name: "statement_timeout", Value: "0"},
+	{Name: "DATESTYLE", Value: "ISO"},
+	{Name: "standard_conforming_strings", Value: "on"},
+	{Name: "enable_mergejoin", Value: "off"},
+	{Name: "synchronize_seqscans", Value: "off", MinVersion: "5"},
+	{Name: "INTERVALSTYLE", Value: "POSTGRES", MinVersion: "6"},
+	{Name: "lock_timeout", Value: "0", MinVersion: "6"},
+	{Name: "idle_in_transaction_session_timeout", Value: "0", MinVersion: "7"},
+}
+
+/*
+ * ApplyBackupSessionSettings sets the GUCs that gpbackup and similar utilities
+ * expect on a session, skipping any that do not exist in the connected
+ * database version.  It must be called once for each connection in the pool
+ * that needs the settings.
+ */
+func (dbconn *DBConn) ApplyBackupSessionSettings(whichConn ...int) error {
+	connNum := dbconn.ValidateConnNum(whichConn...)
+	for _, setting := range backupSessionSettings {
+		if setting.MinVersion != "" && dbconn.Version.Before(setting.MinVersion) {
+			continue
+		}
+		_, err := dbconn.exec(fmt.Sprintf("SET %s TO %s", setting.Name, setting.Value), connNum)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (dbconn *DBConn) MustApplyBackupSessionSettings(whichConn ...int) {
+	err := dbconn.ApplyBackupSessionSettings(whichConn...)
+	fatalOnDatabaseError(err)
+}
+
+/*
+ * The client encoding and time zone are fixed for the life of the connection
+ * unless a caller changes them explicitly, so they are queried once on the
+ * first connection and cached until Close is called.
+ */
+func (dbconn *DBConn) GetEncoding() (string, error) {
+	if dbconn.encoding == "" {
+		var encoding string
+		err := dbconn.get(&encoding, "SHOW client_encoding", 0)
+		if err != nil {
+			return "", err
+		}
+		dbconn.encoding = encoding
+	}
+	return dbconn.encoding, nil
+}
+
+func (dbconn *DBConn) GetTimeZone() (string, error) {
+	if dbconn.timeZone == "" {
+		var timeZone string
+		err := dbconn.get(&timeZone, "SHOW TimeZone", 0)
+		if err != nil {
+			return "", err
+		}
+		dbconn.timeZone = timeZone
+	}
+	return dbconn.timeZone, nil
+}
+
+/*
+ * GetSettings returns the current values of the named GUCs with a single
+ * query against pg_settings, for callers such as diagnostics that need many
+ * of them at once.  The map is keyed by the names as pg_settings reports
+ * them, which are lower case, and names that are not GUCs on the server are
+ * left out of it rather than causing an error.
+ */
+func (dbconn *DBConn) GetSettings(names []string, whichConn ...int) (map[string]string, error) {
+	connNum := dbconn.ValidateConnNum(whichConn...)
+	settings := make(map[string]string, len(names))
+	if len(names) == 0 {
+		return settings, nil
+	}
+	literals := make([]string, len(names))
+	for i, name := range names {
+		literals[i] = quoteLiteral(strings.ToLower(name))
+	}
+	query := fmt.Sprintf(`
+SELECT
+	name,
+	setting
+FROM pg_settings
+WHERE name = ANY(ARRAY[%s]);`, strings.Join(literals, ", "))
+	results := make([]struct {
+		Name    string
+		Setting string
+	}, 0)
+	err := dbconn.selectInto(&results, query, connNum)
+	if err != nil {
+		return nil, err
+	}
+	for _, result := range results {
+		settings[result.Name] = result.Setting
+	}
+	return settings, nil
+}
+
+/*
+ * SetSessionGUCs sets GUCs that Connect applies with SET to every connection
+ * in the pool as soon as it is established, replacing any set previously.
+ * Values are used in the SET statements as-is, so a value that is not a
+ * simple word or number must be quoted by the caller.
+ *
+ * If the DBConn is already connected, the new settings only take effect on the
+ * existing connections once ApplySessionGUCs is called.
+ */
+func (dbconn *DBConn) SetSessionGUCs(gucs map[string]string) {
+	dbconn.sessionGUCs = make(map[string]string, len(gucs))
+	for name, value := range gucs {
+		dbconn.sessionGUCs[name] = value
+	}
+}
+
+/*
+ * ApplySessionGUCs applies the GUCs set with SetSessionGUCs to every
+ * connection in the pool, for use after changing them on a connected DBConn.
+ */
+func (dbconn *DBConn) ApplySessionGUCs() error {
+	for connNum := 0; connNum < dbconn.NumConns; connNum++ {
+		unlock := dbconn.lockConn(connNum)
+		err := dbconn.applySessionGUCs(connNum)
+		unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (dbconn *DBConn) applySessionGUCs(connNum int) error {
+	names := make([]string, 0, len(dbconn.sessionGUCs))
+	for name := range dbconn.sessionGUCs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		_, err := dbconn.execOnce(fmt.Sprintf("SET %s TO %s", name, dbconn.sessionGUCs[name]), connNum)
+		if err != nil {
+			return errors.Wrapf(err, "Cannot set %s on connection %d", name, connNum)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package dbconn_test
+
+import (
+	"regexp"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cloudberrydb/gp-common-go-libs/testhelper"
+	"github.com/pkg/errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("dbconn/settings tests", func() {
+	Describe("DBConn.GetEncoding", func() {
+		It("returns the client encoding", func() {
+			mock.ExpectQuery("SHOW client_encoding").WillReturnRows(sqlmock.NewRows([]string{"client_encoding"}).AddRow("UTF8"))
+
+			encoding, err := connection.GetEncoding()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(encoding).To(Equal("UTF8"))
+		})
+		It("caches the client encoding for the life of the connection", func() {
+			mock.ExpectQuery("SHOW client_encoding").WillReturnRows(sqlmock.NewRows([]string{"client_encoding"}).AddRow("UTF8"))
+
+			_, err := connection.GetEncoding()
+			Expect(err).ToNot(HaveOccurred())
+			encoding, err := connection.GetEncoding()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(encoding).To(Equal("UTF8"))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("queries the client encoding again after the connection is closed", func() {
+			mock.ExpectQuery("SHOW client_encoding").WillReturnRows(sqlmock.NewRows([]string{"client_encoding"}).AddRow("UTF8"))
+			_, err := connection.GetEncoding()
+			Expect(err).ToNot(HaveOccurred())
+			connection.Close()
+
+			mockdb, newMock := testhelper.CreateMockDB()
+			connection.Driver = &testhelper.TestDriver{DB: mockdb, DBName: "testdb", User: "testrole"}
+			testhelper.ExpectVersionQuery(newMock, "5.1.0")
+			connection.MustConnect(1)
+			newMock.ExpectQuery("SHOW client_encoding").WillReturnRows(sqlmock.NewRows([]string{"client_encoding"}).AddRow("LATIN1"))
+			encoding, err := connection.GetEncoding()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(encoding).To(Equal("LATIN1"))
+		})
+	})
+	Describe("DBConn.GetTimeZone", func() {
+		It("returns the time zone", func() {
+			mock.ExpectQuery("SHOW TimeZone").WillReturnRows(sqlmock.NewRows([]string{"TimeZone"}).AddRow("US/Pacific"))
+
+			timeZone, err := connection.GetTimeZone()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(timeZone).To(Equal("US/Pacific"))
+		})
+		It("caches the time zone for the life of the connection", func() {
+			mock.ExpectQuery("SHOW TimeZone").WillReturnRows(sqlmock.NewRows([]string{"TimeZone"}).AddRow("US/Pacific"))
+
+			_, err := connection.GetTimeZone()
+			Expect(err).ToNot(HaveOccurred())
+			timeZone, err := connection.GetTimeZone()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(timeZone).To(Equal("US/Pacific"))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+	})
+	Describe("DBConn.ApplyBackupSessionSettings", func() {
+		expectSettings := func(settings ...string) {
+			fakeResult := testhelper.TestResult{Rows: 0}
+			for _, setting := range settings {
+				mock.ExpectExec(regexp.QuoteMeta("SET " + setting)).WillReturnResult(fakeResult)
+			}
+		}
+		It("sets all of the settings that exist in GPDB 7", func() {
+			testhelper.SetDBVersion(connection, "7.0.0")
+			expectSettings("statement_timeout TO 0", "DATESTYLE TO ISO", "standard_conforming_strings TO on",
+				"enable_mergejoin TO off", "synchronize_seqscans TO off", "INTERVALSTYLE TO POSTGRES",
+				"lock_timeout TO 0", "idle_in_transaction_session_timeout TO 0")
+
+			err := connection.ApplyBackupSessionSettings()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("skips settings that do not exist in GPDB 5", func() {
+			testhelper.SetDBVersion(connection, "5.1.0")
+			expectSettings("statement_timeout TO 0", "DATESTYLE TO ISO", "standard_conforming_strings TO on",
+				"enable_mergejoin TO off", "synchronize_seqscans TO off")
+
+			err := connection.ApplyBackupSessionSettings()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("skips settings that do not exist in GPDB 4", func() {
+			testhelper.SetDBVersion(connection, "4.3.0")
+			expectSettings("statement_timeout TO 0", "DATESTYLE TO ISO", "standard_conforming_strings TO on",
+				"enable_mergejoin TO off")
+
+			err := connection.ApplyBackupSessionSettings()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("stops at the first setting that fails", func() {
+			mock.ExpectExec(regexp.QuoteMeta("SET statement_timeout TO 0")).WillReturnError(errors.New("permission denied"))
+
+			err := connection.ApplyBackupSessionSettings()
+			Expect(err).To(MatchError("permission denied"))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+	})
+	Describe("DBConn.GetSettings", func() {
+		header := []string{"name", "setting"}
+
+		It("fetches all of the settings in one query", func() {
+			mock.ExpectQuery(regexp.QuoteMeta("WHERE name = ANY(ARRAY[E'work_mem', E'timezone', E'max_connections']);")).
+				WillReturnRows(sqlmock.NewRows(header).AddRow("max_connections", "250").AddRow("work_mem", "32768").AddRow("timezone", "UTC"))
+
+			settings, err := connection.GetSettings([]string{"work_mem", "TimeZone", "max_connections"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(settings).To(Equal(map[string]string{"work_mem": "32768", "timezone": "UTC", "max_connections": "250"}))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("leaves settings that do not exist out of the map", func() {
+			mock.ExpectQuery(regexp.QuoteMeta("WHERE name = ANY(ARRAY[E'work_mem', E'not_a_guc', E'it''s']);")).
+				WillReturnRows(sqlmock.NewRows(header).AddRow("work_mem", "32768"))
+
+			settings, err := connection.GetSettings([]string{"work_mem", "not_a_guc", "it's"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(settings).To(Equal(map[string]string{"work_mem": "32768"}))
+		})
+		It("returns an empty map without querying if no names are given", func() {
+			settings, err := connection.GetSettings([]string{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(settings).To(BeEmpty())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("returns an error if the query fails", func() {
+			mock.ExpectQuery("SELECT (.*)").WillReturnError(errors.New("permission denied"))
+
+			settings, err := connection.GetSettings([]string{"work_mem"})
+			Expect(err).To(MatchError("permission denied"))
+			Expect(settings).To(BeNil())
+		})
+	})
+	Describe("DBConn.SetSessionGUCs", func() {
+		var mocks []sqlmock.Sqlmock
+		fakeResult := testhelper.TestResult{Rows: 0}
+		expectSET := func(mock sqlmock.Sqlmock, statement string) {
+			mock.ExpectExec("^" + regexp.QuoteMeta(statement) + "$").WillReturnResult(fakeResult)
+		}
+
+		It("applies the settings once on each connection when connecting", func() {
+			connection, mocks = testhelper.CreateMockDBConnWithMocks(3)
+			connection.SetSessionGUCs(map[string]string{"statement_timeout": "0", "gp_select_invisible": "off"})
+			for _, mock := range mocks {
+				expectSET(mock, "SET gp_select_invisible TO off")
+				expectSET(mock, "SET statement_timeout TO 0")
+			}
+
+			connection.MustConnect(3)
+			for _, mock := range mocks {
+				Expect(mock.ExpectationsWereMet()).To(Succeed())
+			}
+		})
+		It("does not apply settings changed after connecting until they are applied", func() {
+			connection, mocks = testhelper.CreateMockDBConnWithMocks(2)
+			connection.MustConnect(2)
+			connection.SetSessionGUCs(map[string]string{"search_path": "public"})
+			for _, mock := range mocks {
+				Expect(mock.ExpectationsWereMet()).To(Succeed())
+				expectSET(mock, "SET search_path TO public")
+			}
+
+			err := connection.ApplySessionGUCs()
+			Expect(err).ToNot(HaveOccurred())
+			for _, mock := range mocks {
+				Expect(mock.ExpectationsWereMet()).To(Succeed())
+			}
+		})
+		It("does not keep a reference to the caller's map", func() {
+			connection, mocks = testhelper.CreateMockDBConnWithMocks(1)
+			gucs := map[string]string{"statement_timeout": "0"}
+			connection.SetSessionGUCs(gucs)
+			gucs["lock_timeout"] = "0"
+			expectSET(mocks[0], "SET statement_timeout TO 0")
+
+			connection.MustConnect(1)
+			Expect(mocks[0].ExpectationsWereMet()).To(Succeed())
+		})
+		It("returns an error identifying the setting and connection that failed", func() {
+			connection, mocks = testhelper.CreateMockDBConnWithMocks(2)
+			connection.SetSessionGUCs(map[string]string{"statement_timeout": "0"})
+			expectSET(mocks[0], "SET statement_timeout TO 0")
+			mocks[1].ExpectExec("SET (.*)").WillReturnError(errors.New("permission denied"))
+
+			err := connection.Connect(2)
+			Expect(err).To(MatchError("Cannot set statement_timeout on connection 1: permission denied"))
+		})
+	})
+})
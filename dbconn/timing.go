@@ -0,0 +1,89 @@
+package dbconn
+
+/*
+ * This file contains functions related to logging how long statements take.
+ */
+
+import (
+	"database/sql"
+	"reflect"
+	"time"
+
+	"github.com/cloudberrydb/gp-common-go-libs/gplog"
+	"github.com/cloudberrydb/gp-common-go-libs/operating"
+)
+
+type queryTiming struct {
+	logger *gplog.Entry
+	level  int
+}
+
+/*
+ * EnableQueryTiming logs how long each statement run through Exec, Get,
+ * Select, their WithArgs variants, ExecContext, ExecReturning, and the
+ * functions built on them took, along with the connection number and the
+ * number of rows affected or returned, as fields of a message such as
+ *
+ *   Query took 1.5s: SELECT oid FROM pg_class conn=0 rows=3
+ *
+ * The message is logged through the given Entry, such as one from
+ * gplog.WithFields or a SubLogger's Entry, or through the package-level
+ * functions if it is nil, at the given level: gplog.LOGINFO, LOGVERBOSE, or
+ * LOGDEBUG, or gplog.LOGERROR to log it as a warning so that it is shown at
+ * every verbosity.  Statements skipped in dry-run mode are not timed.
+ */
+func (dbconn *DBConn) EnableQueryTiming(logger *gplog.Entry, level int) {
+	dbconn.queryTiming = &queryTiming{logger: logger, level: level}
+}
+
+func (dbconn *DBConn) DisableQueryTiming() {
+	dbconn.queryTiming = nil
+}
+
+func (dbconn *DBConn) logQueryTiming(query string, connNum int, start time.Time, rows int64, err error) {
+	if dbconn.queryTiming == nil {
+		return
+	}
+	elapsed := operating.System.Now().Sub(start)
+	fields := map[string]interface{}{"conn": connNum, "rows": rows}
+	entry := gplog.WithFields(fields)
+	if dbconn.queryTiming.logger != nil {
+		entry = dbconn.queryTiming.logger.WithFields(fields)
+	}
+	log := entry.Debug
+	switch dbconn.queryTiming.level {
+	case gplog.LOGERROR:
+		log = entry.Warn
+	case gplog.LOGINFO:
+		log = entry.Info
+	case gplog.LOGVERBOSE:
+		log = entry.Verbose
+	}
+	if err != nil {
+		log("Query failed after %s: %s", elapsed, query)
+	} else {
+		log("Query took %s: %s", elapsed, query)
+	}
+}
+
+func resultRowCount(result sql.Result, err error) int64 {
+	if err != nil || result == nil {
+		return 0
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0
+	}
+	return rows
+}
+
+func destinationRowCount(destination interface{}, err error) int64 {
+	if err != nil {
+		return 0
+	}
+	value := reflect.Indirect(reflect.ValueOf(destination))
+	if value.Kind() == reflect.Slice {
+		return int64(value.Len())
+	}
+	return 1
+}
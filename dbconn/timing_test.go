@@ -0,0 +1,121 @@
+package dbconn_test
+
+import (
+	"context"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/cloudberrydb/gp-common-go-libs/gplog"
+	"github.com/cloudberrydb/gp-common-go-libs/operating"
+	"github.com/cloudberrydb/gp-common-go-libs/testhelper"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/pkg/errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("dbconn/timing tests", func() {
+	Describe("DBConn.EnableQueryTiming", func() {
+		var (
+			stdout  *gbytes.Buffer
+			stderr  *gbytes.Buffer
+			logfile *gbytes.Buffer
+		)
+		BeforeEach(func() {
+			// Each call to Now is 1.5 seconds after the previous one, so that
+			// every statement appears to take 1.5 seconds
+			now := time.Date(2017, time.January, 1, 1, 1, 1, 1, time.Local)
+			operating.System.Now = func() time.Time {
+				now = now.Add(1500 * time.Millisecond)
+				return now
+			}
+			stdout, stderr, logfile = testhelper.SetupTestLogger()
+		})
+		AfterEach(func() {
+			operating.System = operating.InitializeSystemFunctions()
+		})
+
+		It("logs the duration, connection, and rows affected of a statement", func() {
+			connection.EnableQueryTiming(nil, gplog.LOGINFO)
+			mock.ExpectExec("UPDATE (.*)").WillReturnResult(testhelper.TestResult{Rows: 3})
+
+			connection.MustExec("UPDATE foo SET i = 1")
+			Expect(string(stdout.Contents())).To(HaveSuffix("-[INFO]:-Query took 1.5s: UPDATE foo SET i = 1 conn=0 rows=3\n"))
+		})
+		It("logs the number of rows returned by a query", func() {
+			connection.EnableQueryTiming(nil, gplog.LOGINFO)
+			mock.ExpectQuery("SELECT (.*)").WillReturnRows(sqlmock.NewRows([]string{"i"}).AddRow(1).AddRow(2))
+			mock.ExpectQuery("SELECT (.*)").WillReturnRows(sqlmock.NewRows([]string{"i"}).AddRow(1))
+
+			results := make([]int, 0)
+			Expect(connection.Select(&results, "SELECT i FROM foo")).To(Succeed())
+			var result int
+			Expect(connection.GetWithArgs(&result, "SELECT i FROM foo WHERE i = $1", 1)).To(Succeed())
+			Expect(string(stdout.Contents())).To(ContainSubstring("-[INFO]:-Query took 1.5s: SELECT i FROM foo conn=0 rows=2\n"))
+			Expect(string(stdout.Contents())).To(HaveSuffix("-[INFO]:-Query took 1.5s: SELECT i FROM foo WHERE i = $1 conn=0 rows=1\n"))
+		})
+		It("logs the connection number the statement ran on", func() {
+			connection, mock = testhelper.CreateAndConnectMockDB(2)
+			connection.EnableQueryTiming(nil, gplog.LOGINFO)
+			mock.ExpectExec("SET (.*)").WillReturnResult(testhelper.TestResult{Rows: 0})
+
+			_, err := connection.ExecContext(context.Background(), "SET search_path TO public", 1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(stdout.Contents())).To(HaveSuffix("-[INFO]:-Query took 1.5s: SET search_path TO public conn=1 rows=0\n"))
+		})
+		It("logs the timing only at the configured level", func() {
+			connection.EnableQueryTiming(nil, gplog.LOGVERBOSE)
+			mock.ExpectExec("UPDATE (.*)").WillReturnResult(testhelper.TestResult{Rows: 1})
+			mock.ExpectExec("UPDATE (.*)").WillReturnResult(testhelper.TestResult{Rows: 1})
+
+			connection.MustExec("UPDATE foo SET i = 1")
+			Expect(string(stdout.Contents())).To(BeEmpty())
+			Expect(string(logfile.Contents())).To(HaveSuffix("-[DEBUG]:-Query took 1.5s: UPDATE foo SET i = 1 conn=0 rows=1\n"))
+
+			gplog.SetVerbosity(gplog.LOGVERBOSE)
+			connection.MustExec("UPDATE foo SET i = 1")
+			Expect(string(stdout.Contents())).To(HaveSuffix("-[DEBUG]:-Query took 1.5s: UPDATE foo SET i = 1 conn=0 rows=1\n"))
+		})
+		It("logs the timing as a warning at LOGERROR", func() {
+			connection.EnableQueryTiming(nil, gplog.LOGERROR)
+			mock.ExpectExec("UPDATE (.*)").WillReturnResult(testhelper.TestResult{Rows: 1})
+
+			gplog.SetVerbosity(gplog.LOGERROR)
+			connection.MustExec("UPDATE foo SET i = 1")
+			Expect(string(stdout.Contents())).To(HaveSuffix("-[WARNING]:-Query took 1.5s: UPDATE foo SET i = 1 conn=0 rows=1\n"))
+			Expect(string(stderr.Contents())).To(BeEmpty())
+		})
+		It("logs through the given entry", func() {
+			connection.EnableQueryTiming(gplog.WithField("step", "restore"), gplog.LOGINFO)
+			mock.ExpectExec("UPDATE (.*)").WillReturnResult(testhelper.TestResult{Rows: 1})
+
+			connection.MustExec("UPDATE foo SET i = 1")
+			Expect(string(stdout.Contents())).To(HaveSuffix("-[INFO]:-Query took 1.5s: UPDATE foo SET i = 1 conn=0 rows=1 step=restore\n"))
+		})
+		It("logs a statement that fails", func() {
+			connection.EnableQueryTiming(nil, gplog.LOGINFO)
+			mock.ExpectExec("UPDATE (.*)").WillReturnError(errors.New("permission denied"))
+
+			_, err := connection.Exec("UPDATE foo SET i = 1")
+			Expect(err).To(MatchError("permission denied"))
+			Expect(string(stdout.Contents())).To(HaveSuffix("-[INFO]:-Query failed after 1.5s: UPDATE foo SET i = 1 conn=0 rows=0\n"))
+		})
+		It("does not log statements skipped in dry-run mode", func() {
+			connection.EnableQueryTiming(nil, gplog.LOGINFO)
+			connection.SetDryRun(true)
+
+			connection.MustExec("UPDATE foo SET i = 1")
+			Expect(string(stdout.Contents())).ToNot(ContainSubstring("Query took"))
+		})
+		It("stops logging when disabled", func() {
+			connection.EnableQueryTiming(nil, gplog.LOGINFO)
+			connection.DisableQueryTiming()
+			mock.ExpectExec("UPDATE (.*)").WillReturnResult(testhelper.TestResult{Rows: 1})
+
+			connection.MustExec("UPDATE foo SET i = 1")
+			Expect(string(stdout.Contents())).To(BeEmpty())
+			Expect(string(logfile.Contents())).To(BeEmpty())
+		})
+	})
+})
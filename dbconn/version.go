@@ -5,6 +5,8 @@ import (
 	"strings"
 
 	"github.com/blang/semver"
+	"github.com/cloudberrydb/gp-common-go-libs/gplog"
+	"github.com/pkg/errors"
 )
 
 type GPDBVersion struct {
@@ -31,27 +33,68 @@ func NewVersion(versionStr string) GPDBVersion {
 }
 
 func InitializeVersion(dbconn *DBConn) (dbversion GPDBVersion, err error) {
-	err = dbconn.Get(&dbversion, "SELECT pg_catalog.version() AS versionstring")
+	var banner string
+	err = dbconn.get(&banner, "SELECT pg_catalog.version() AS versionstring", 0)
 	if err != nil {
 		return
 	}
-	versionStart := strings.Index(dbversion.VersionString, "(Greenplum Database ") + len("(Greenplum Database ")
-	versionEnd := strings.Index(dbversion.VersionString, ")")
-	dbversion.VersionString = dbversion.VersionString[versionStart:versionEnd]
+	return ParseVersionBanner(banner)
+}
+
+/*
+ * The products whose version strings ParseVersionBanner recognizes, in the
+ * form they appear in the parenthesised part of "SELECT version()".
+ */
+var versionBannerPrefixes = []string{"(Greenplum Database ", "(Cloudberry Database "}
+
+/*
+ * ParseVersionBanner extracts the GPDB version from a version string like the
+ * one returned by "SELECT version()", e.g. "PostgreSQL 9.4.24 (Greenplum
+ * Database 6.20.0 build commit:...) on x86_64-unknown-linux-gnu, ...".  If
+ * the product name is not one it recognizes, it falls back to the first X.Y.Z
+ * version inside the parentheses.
+ */
+func ParseVersionBanner(banner string) (dbversion GPDBVersion, err error) {
+	versionStart := -1
+	for _, prefix := range versionBannerPrefixes {
+		if index := strings.Index(banner, prefix); index != -1 {
+			versionStart = index + len(prefix)
+			break
+		}
+	}
+	if versionStart == -1 {
+		versionStart = strings.Index(banner, "(") + 1
+	}
+	versionEnd := strings.Index(banner[versionStart:], ")")
+	if versionStart == 0 || versionEnd == -1 {
+		return dbversion, errors.Errorf("Unable to find a database version in version string %q", banner)
+	}
+	dbversion.VersionString = banner[versionStart : versionStart+versionEnd]
 
 	pattern := regexp.MustCompile(`\d+\.\d+\.\d+`)
-	threeDigitVersion := pattern.FindStringSubmatch(dbversion.VersionString)[0]
+	threeDigitVersion := pattern.FindString(dbversion.VersionString)
+	if threeDigitVersion == "" {
+		return dbversion, errors.Errorf("Unable to parse version %q as a semantic version", dbversion.VersionString)
+	}
 	dbversion.SemVer, err = semver.Make(threeDigitVersion)
 	return
 }
 
 func StringToSemVerRange(versionStr string) semver.Range {
+	validRange := semver.MustParseRange(completeVersionRange(versionStr))
+	return validRange
+}
+
+/*
+ * Returns a version range string with missing minor and patch versions filled
+ * in with wildcards, e.g. ">=7" becomes ">=7.x", as semver requires.
+ */
+func completeVersionRange(versionStr string) string {
 	numDigits := len(strings.Split(versionStr, "."))
 	if numDigits < 3 {
 		versionStr += ".x"
 	}
-	validRange := semver.MustParseRange(versionStr)
-	return validRange
+	return versionStr
 }
 
 func (dbversion GPDBVersion) Before(targetVersion string) bool {
@@ -59,6 +102,11 @@ func (dbversion GPDBVersion) Before(targetVersion string) bool {
 	return validRange(dbversion.SemVer)
 }
 
+func (dbversion GPDBVersion) After(targetVersion string) bool {
+	validRange := StringToSemVerRange(">" + targetVersion)
+	return validRange(dbversion.SemVer)
+}
+
 func (dbversion GPDBVersion) AtLeast(targetVersion string) bool {
 	validRange := StringToSemVerRange(">=" + targetVersion)
 	return validRange(dbversion.SemVer)
@@ -68,3 +116,36 @@ func (dbversion GPDBVersion) Is(targetVersion string) bool {
 	validRange := StringToSemVerRange("==" + targetVersion)
 	return validRange(dbversion.SemVer)
 }
+
+/*
+ * Compare returns -1, 0, or 1 if this version is older than, the same as, or
+ * newer than the other version, respectively, for use when two versions need
+ * to be compared directly instead of against a version string.
+ */
+func (dbversion GPDBVersion) Compare(other GPDBVersion) int {
+	return dbversion.SemVer.Compare(other.SemVer)
+}
+
+/*
+ * RequireVersionAtLeast returns an error if the connected database is older
+ * than the given version, such as "7" or "6.20.0", so that a utility that
+ * depends on features of newer versions can exit with a clear message right
+ * after connecting instead of failing partway through its work.
+ */
+func (dbconn *DBConn) RequireVersionAtLeast(v string) error {
+	if dbconn.ConnPool == nil {
+		return errors.New("Cannot check the database version; the database connection is not open")
+	}
+	if _, err := semver.ParseRange(completeVersionRange(">=" + v)); err != nil {
+		return errors.Errorf("Invalid version requirement %q", v)
+	}
+	if !dbconn.Version.AtLeast(v) {
+		return errors.Errorf("Database version %s is not supported; version %s or later is required", dbconn.Version.VersionString, v)
+	}
+	return nil
+}
+
+func (dbconn *DBConn) MustRequireVersionAtLeast(v string) {
+	err := dbconn.RequireVersionAtLeast(v)
+	gplog.FatalOnError(err)
+}
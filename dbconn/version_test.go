@@ -1,8 +1,10 @@
 package dbconn_test
 
 import (
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
 	"github.com/blang/semver"
 	"github.com/cloudberrydb/gp-common-go-libs/dbconn"
+	"github.com/cloudberrydb/gp-common-go-libs/testhelper"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -105,4 +107,112 @@ var _ = Describe("dbconn/version tests", func() {
 			Expect(result).To(BeFalse())
 		})
 	})
+	Describe("After", func() {
+		It("returns true when comparing 5.1 to 5.0", func() {
+			connection.Version = fake51
+			result := connection.Version.After("5.0")
+			Expect(result).To(BeTrue())
+		})
+		It("returns true when comparing 5 to 4", func() {
+			connection.Version = fake50
+			result := connection.Version.After("4")
+			Expect(result).To(BeTrue())
+		})
+		It("returns false when comparing 5.1 to 5", func() {
+			connection.Version = fake51
+			result := connection.Version.After("5")
+			Expect(result).To(BeFalse())
+		})
+		It("returns false when comparing 5.0 to 5.1", func() {
+			connection.Version = fake50
+			result := connection.Version.After("5.1")
+			Expect(result).To(BeFalse())
+		})
+	})
+	Describe("Compare", func() {
+		It("returns -1 when comparing 4.3 to 5.0", func() {
+			Expect(fake43.Compare(fake50)).To(Equal(-1))
+		})
+		It("returns 0 when comparing 5.1 to 5.1", func() {
+			Expect(fake51.Compare(dbconn.NewVersion("5.1.0"))).To(Equal(0))
+		})
+		It("returns 1 when comparing 5.1 to 5.0", func() {
+			Expect(fake51.Compare(fake50)).To(Equal(1))
+		})
+		It("compares patch versions", func() {
+			Expect(dbconn.NewVersion("6.20.1").Compare(dbconn.NewVersion("6.20.0"))).To(Equal(1))
+		})
+	})
+	Describe("DBConn.RequireVersionAtLeast", func() {
+		BeforeEach(func() {
+			testhelper.SetDBVersion(connection, "6.20.0")
+		})
+		It("succeeds if the database is the required version", func() {
+			Expect(connection.RequireVersionAtLeast("6.20.0")).To(Succeed())
+		})
+		It("succeeds if the database is newer than the required version", func() {
+			Expect(connection.RequireVersionAtLeast("6")).To(Succeed())
+			Expect(connection.RequireVersionAtLeast("5.28")).To(Succeed())
+		})
+		It("returns an error if the database is older than the required version", func() {
+			err := connection.RequireVersionAtLeast("7")
+			Expect(err).To(MatchError("Database version 6.20.0 is not supported; version 7 or later is required"))
+		})
+		It("panics with the same message in MustRequireVersionAtLeast", func() {
+			defer testhelper.ShouldPanicWithMessage("Database version 6.20.0 is not supported; version 6.21.0 or later is required")
+			connection.MustRequireVersionAtLeast("6.21.0")
+		})
+		It("returns an error for an invalid version requirement", func() {
+			err := connection.RequireVersionAtLeast("seven")
+			Expect(err).To(MatchError(`Invalid version requirement "seven"`))
+		})
+		It("returns an error if the database connection is not open", func() {
+			connection = dbconn.NewDBConnFromEnvironment("testdb")
+			err := connection.RequireVersionAtLeast("6")
+			Expect(err).To(MatchError("Cannot check the database version; the database connection is not open"))
+		})
+	})
+	Describe("InitializeVersion", func() {
+		It("determines the version of a Cloudberry database on connect", func() {
+			connection, mock = testhelper.CreateMockDBConn()
+			versionRow := sqlmock.NewRows([]string{"versionstring"}).AddRow("PostgreSQL 14.4 (Cloudberry Database 1.5.2 build commit:abcdef) on x86_64-pc-linux-gnu")
+			mock.ExpectQuery("SELECT pg_catalog.version()").WillReturnRows(versionRow)
+			err := connection.Connect(1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(connection.Version.SemVer).To(Equal(semver.MustParse("1.5.2")))
+		})
+	})
+	Describe("ParseVersionBanner", func() {
+		It("parses a GPDB 6 version string", func() {
+			version, err := dbconn.ParseVersionBanner("PostgreSQL 9.4.26 (Greenplum Database 6.20.0 build commit:abcdef) on x86_64-unknown-linux-gnu")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(version.VersionString).To(Equal("6.20.0 build commit:abcdef"))
+			Expect(version.SemVer).To(Equal(semver.MustParse("6.20.0")))
+		})
+		It("parses a GPDB 4 version string with four digits", func() {
+			version, err := dbconn.ParseVersionBanner("PostgreSQL 8.2.15 (Greenplum Database 4.3.33.7 build 1)")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(version.SemVer).To(Equal(semver.MustParse("4.3.33")))
+		})
+		It("parses a Cloudberry version string", func() {
+			version, err := dbconn.ParseVersionBanner("PostgreSQL 14.4 (Cloudberry Database 1.5.2 build commit:abcdef) on x86_64-pc-linux-gnu, compiled by gcc")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(version.VersionString).To(Equal("1.5.2 build commit:abcdef"))
+			Expect(version.SemVer).To(Equal(semver.MustParse("1.5.2")))
+		})
+		It("falls back to the first version inside the parentheses for an unrecognized product", func() {
+			version, err := dbconn.ParseVersionBanner("PostgreSQL 14.4 (Some Database 2.1.0 build dev) on x86_64-pc-linux-gnu")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(version.VersionString).To(Equal("Some Database 2.1.0 build dev"))
+			Expect(version.SemVer).To(Equal(semver.MustParse("2.1.0")))
+		})
+		It("returns an error for a version string without a parenthesised version", func() {
+			_, err := dbconn.ParseVersionBanner("PostgreSQL 12.4 on x86_64-pc-linux-gnu")
+			Expect(err).To(MatchError(`Unable to find a database version in version string "PostgreSQL 12.4 on x86_64-pc-linux-gnu"`))
+		})
+		It("returns an error for a GPDB version that is not a semantic version", func() {
+			_, err := dbconn.ParseVersionBanner("PostgreSQL 8.2.15 (Greenplum Database main)")
+			Expect(err).To(MatchError(`Unable to parse version "main" as a semantic version`))
+		})
+	})
 })
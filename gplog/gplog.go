@@ -5,12 +5,21 @@ package gplog
  */
 
 import (
+	"bufio"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/cloudberrydb/gp-common-go-libs/operating"
 	"github.com/pkg/errors"
@@ -41,6 +50,10 @@ var (
 	 */
 	logFileNameFunc LogFileNameFunc
 	exitFunc        ExitFunc
+	// Functions registered with RegisterFatalHook that have not yet run
+	fatalHooks []func()
+	// Patterns registered with AddRedaction, which apply to every logger
+	redactions []redaction
 )
 
 const (
@@ -86,15 +99,100 @@ type LogPrefixFunc func(string) string
 type LogFileNameFunc func(string, string) string
 type ExitFunc func()
 
+/*
+ * The format in which log messages are written.  FormatText is the default
+ * "TIMESTAMP PROGRAM:USER:HOST:PID-[LEVEL]:-MESSAGE" format, and FormatJSON
+ * writes each message as a JSON object on its own line, for log aggregators.
+ */
+type LogFormat int
+
+const (
+	FormatText LogFormat = iota
+	FormatJSON
+)
+
+/*
+ * Whether warnings on stdout are written in yellow, and errors on stderr in
+ * red.  ColorAuto uses color only if the logger's stderr is a terminal.  The
+ * log file and writers added with AddLogWriter never receive color codes.
+ */
+type ColorMode int
+
+const (
+	ColorNever ColorMode = iota
+	ColorAuto
+	ColorAlways
+)
+
+const (
+	colorWarning = "\x1b[33m"
+	colorError   = "\x1b[31m"
+	colorReset   = "\x1b[0m"
+)
+
+// The layout of the timestamp that begins each message in FormatText
+const DefaultTimestampFormat = "20060102:15:04:05"
+
 type GpLogger struct {
-	logStdout      *log.Logger
-	logStderr      *log.Logger
-	logFile        *log.Logger
-	logFileName    string
-	shellVerbosity int
-	fileVerbosity  int
-	header         string
-	logPrefixFunc  LogPrefixFunc
+	logStdout       *log.Logger
+	logStderr       *log.Logger
+	logFile         *log.Logger
+	logFileWriter   *rotatingLogFile
+	logFileName     string
+	shellVerbosity  int
+	fileVerbosity   int
+	header          string
+	logPrefixFunc   LogPrefixFunc
+	program         string
+	pid             int
+	format          LogFormat
+	timestampFormat string
+	includeCaller   bool
+	color           bool
+
+	dedupConsecutive bool
+	dedupWindow      time.Duration
+	lastMessage      *repeatedMessage
+
+	errorCount int
+	warnCount  int
+
+	logWriters []logWriter
+}
+
+/*
+ * An additional destination for log messages, added with AddLogWriter.  If
+ * writeLevel is set, messages are passed to it along with the name of their
+ * level instead of being written to logger, for destinations such as syslog
+ * that record the level of each message separately.
+ */
+type logWriter struct {
+	writer     io.Writer
+	logger     *log.Logger
+	verbosity  int
+	writeLevel func(levelName string, message string) error
+}
+
+func (w logWriter) write(levelName string, message string) {
+	if w.writeLevel != nil {
+		_ = w.writeLevel(levelName, message)
+	} else {
+		_ = w.logger.Output(1, message)
+	}
+}
+
+/*
+ * The most recent message written while consecutive duplicates are being
+ * suppressed, along with how to write it and how many times it has repeated
+ * since it was written.
+ */
+type repeatedMessage struct {
+	level       string
+	body        string
+	fields      map[string]interface{}
+	write       func(message string)
+	repeatCount int
+	loggedAt    time.Time
 }
 
 /*
@@ -151,15 +249,21 @@ func NewLogger(stdout io.Writer, stderr io.Writer, logFile io.Writer, logFileNam
 	if len(logFileVerbosity) == 1 && logFileVerbosity[0] >= LOGERROR && logFileVerbosity[0] <= LOGDEBUG {
 		fileVerbosity = logFileVerbosity[0]
 	}
+	logFileWriter := &rotatingLogFile{writer: logFile, fileName: logFileName, stderr: stderr, maxBackups: 1}
 	return &GpLogger{
-		logStdout:      log.New(stdout, "", 0),
-		logStderr:      log.New(stderr, "", 0),
-		logFile:        log.New(logFile, "", 0),
-		logFileName:    logFileName,
-		shellVerbosity: shellVerbosity,
-		fileVerbosity:  fileVerbosity,
-		header:         GetHeader(program),
-		logPrefixFunc:  nil,
+		logStdout:       log.New(stdout, "", 0),
+		logStderr:       log.New(stderr, "", 0),
+		logFile:         log.New(logFileWriter, "", 0),
+		logFileWriter:   logFileWriter,
+		logFileName:     logFileName,
+		shellVerbosity:  shellVerbosity,
+		fileVerbosity:   fileVerbosity,
+		header:          GetHeader(program),
+		logPrefixFunc:   nil,
+		program:         program,
+		pid:             operating.System.Getpid(),
+		format:          FormatText,
+		timestampFormat: DefaultTimestampFormat,
 	}
 }
 
@@ -181,12 +285,248 @@ func SetLogFileNameFunc(fileNameFunc func(string, string) string) {
 	logFileNameFunc = fileNameFunc
 }
 
+/*
+ * When dedupConsecutive is true, a message identical to the one logged
+ * immediately before it (at the same level) is not written.  Instead, when the
+ * next different message is logged or Flush is called, the repeated message is
+ * written once more with " (repeated N times)" appended, where N is the number
+ * of repeats that were suppressed.  Turning deduplication off flushes any
+ * pending repeats.
+ */
+func SetDedupConsecutive(dedupConsecutive bool) {
+	setDedup(dedupConsecutive, 0)
+}
+
+/*
+ * SetDedup is like SetDedupConsecutive, but only suppresses repeats of a
+ * message within the given window of when it was last written, so that a
+ * message repeated for a long time is still written (with its repeat count)
+ * once per window.  The window is checked when the next message is logged, so
+ * the count of repeats is written then, or when Flush is called, rather than
+ * as soon as the window closes.  A window of 0 or less turns deduplication
+ * off.
+ */
+func SetDedup(window time.Duration) {
+	setDedup(window > 0, window)
+}
+
+func setDedup(dedupConsecutive bool, window time.Duration) {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	if !dedupConsecutive {
+		flushRepeatedMessage()
+		logger.lastMessage = nil
+	}
+	logger.dedupConsecutive = dedupConsecutive
+	logger.dedupWindow = window
+}
+
+/*
+ * Flush writes out any output that the logger is holding back, such as the
+ * count of a message suppressed by SetDedupConsecutive or SetDedup, or log
+ * file output buffered by SetBuffered.  Utilities should call it before
+ * exiting.
+ */
+func Flush() {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	flushRepeatedMessage()
+	logger.lastMessage = nil
+	logger.logFileWriter.flush()
+}
+
+/*
+ * SetBuffered buffers up to size bytes of output to the log file in memory,
+ * so that each message does not take a separate write, for utilities that log
+ * heavily.  The buffer is written out when it fills, when Flush is called,
+ * and when a fatal error is logged; output still in the buffer when a
+ * utility exits any other way is lost.  Output to stdout, stderr, and writers
+ * added with AddLogWriter is never buffered.  A size of 0 or less writes out
+ * the buffer and turns buffering off, which is the default.
+ */
+func SetBuffered(size int) {
+	logger.logFileWriter.setBuffered(size)
+}
+
+/*
+ * SetLogFormat sets the format of subsequent log messages on every output.  A
+ * custom prefix set with SetLogPrefixFunc only applies to FormatText.
+ */
+func SetLogFormat(format LogFormat) {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	logger.format = format
+}
+
+/*
+ * AddLogWriter adds a destination that receives each message in addition to
+ * the log file, stdout, and stderr, if the message is at or below the given
+ * verbosity (one of the log level constants above, as for SetVerbosity).
+ * Warnings and errors reach every writer regardless of its verbosity.
+ * Adding a writer that was already added changes its verbosity.
+ */
+func AddLogWriter(writer io.Writer, verbosity int) {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	for i := range logger.logWriters {
+		if logger.logWriters[i].writer == writer {
+			logger.logWriters[i].verbosity = verbosity
+			return
+		}
+	}
+	logger.logWriters = append(logger.logWriters, logWriter{writer: writer, logger: log.New(writer, "", 0), verbosity: verbosity})
+}
+
+/*
+ * RemoveLogWriter stops writing messages to a writer added with AddLogWriter,
+ * and does nothing if the writer was never added.
+ */
+func RemoveLogWriter(writer io.Writer) {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	for i := range logger.logWriters {
+		if logger.logWriters[i].writer == writer {
+			logger.logWriters = append(logger.logWriters[:i], logger.logWriters[i+1:]...)
+			return
+		}
+	}
+}
+
+/*
+ * SetLogFileWriter replaces the log file with the given writer, e.g. an
+ * in-memory buffer, which receives exactly what would have been written to
+ * the file, subject to the log file verbosity.  Any output buffered for the
+ * previous writer is written to it first, but the previous writer is not
+ * closed.  Messages written to a custom writer are never rotated, and
+ * GetLogFilePath still returns the name of the original log file.
+ */
+func SetLogFileWriter(writer io.Writer) {
+	logger.logFileWriter.setWriter(writer)
+}
+
+/*
+ * SetMaxLogSize enables rotation of the log file: before a message is written
+ * that would take the file past maxBytes, the file is renamed to
+ * "<logfile>.1" (with any existing backups renamed to "<logfile>.2" and so
+ * on) and a new log file is opened in its place.  A message larger than
+ * maxBytes is still written whole, to an empty file.  A maxBytes of 0 or less
+ * disables rotation, which is the default.
+ */
+func SetMaxLogSize(maxBytes int64) {
+	logger.logFileWriter.setMaxSize(maxBytes)
+}
+
+/*
+ * SetMaxLogBackups sets how many rotated log files to keep, deleting the
+ * oldest when a rotation would exceed it.  The default is 1; a count of 0
+ * discards the contents of the log file each time it is rotated.
+ */
+func SetMaxLogBackups(count int) {
+	logger.logFileWriter.setMaxBackups(count)
+}
+
+/*
+ * SetTimestampFormat sets the layout, as accepted by time.Format, of the
+ * timestamp in messages written in FormatText, e.g. time.RFC3339.  It returns
+ * an error and leaves the format unchanged if the layout contains no time
+ * elements, which would stamp every message with the same literal text.
+ */
+func SetTimestampFormat(layout string) error {
+	reference := time.Date(1999, time.December, 31, 22, 58, 59, 987654321, time.UTC)
+	if layout == "" || reference.Format(layout) == layout {
+		return errors.Errorf("Invalid timestamp format %q: the format contains no time elements", layout)
+	}
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	logger.timestampFormat = layout
+	return nil
+}
+
+/*
+ * SetIncludeCaller sets whether Verbose() and Debug() messages begin with the
+ * "file:line: " of the code that logged them.  It is off by default, because
+ * finding the caller is expensive relative to the rest of logging a message.
+ */
+func SetIncludeCaller(includeCaller bool) {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	logger.includeCaller = includeCaller
+}
+
+/*
+ * Returns "file:line: " for the first caller outside of gplog, or an empty
+ * string if SetIncludeCaller is off.  The caller must hold logMutex.
+ */
+func callerPrefix() string {
+	if !logger.includeCaller {
+		return ""
+	}
+	pcs := make([]uintptr, 16)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	for {
+		frame, more := frames.Next()
+		if !isLoggingFrame(frame.Function) {
+			return fmt.Sprintf("%s:%d: ", filepath.Base(frame.File), frame.Line)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+
+/*
+ * The prefixes of the functions to skip when looking for the caller of a
+ * logging function, i.e. gplog's own functions and any logging packages that
+ * are adapted to write through them.
+ */
+var loggingFunctionPrefixes = []string{reflect.TypeOf(Entry{}).PkgPath() + "."}
+
+func isLoggingFrame(function string) bool {
+	for _, prefix := range loggingFunctionPrefixes {
+		if strings.HasPrefix(function, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ * SetColor sets whether messages on stdout and stderr are colorized by level.
+ * Color is off by default.  ColorAuto checks whether stderr is a terminal when
+ * SetColor is called.
+ */
+func SetColor(mode ColorMode) {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	switch mode {
+	case ColorAlways:
+		logger.color = true
+	case ColorAuto:
+		stderrFile, ok := logger.logStderr.Writer().(*os.File)
+		logger.color = ok && operating.System.IsTerminal(stderrFile)
+	default:
+		logger.color = false
+	}
+}
+
+// The caller must hold logMutex.
+func colorize(color string, message string) string {
+	if !logger.color {
+		return message
+	}
+	return color + message + colorReset
+}
+
 func SetExitFunc(pExitFunc func()) {
 	exitFunc = pExitFunc
 }
 
 func defaultLogPrefixFunc(level string) string {
-	logTimestamp := operating.System.Now().Format("20060102:15:04:05")
+	return formatLogPrefix(level, operating.System.Now())
+}
+
+func formatLogPrefix(level string, timestamp time.Time) string {
+	logTimestamp := timestamp.Format(logger.timestampFormat)
 	return fmt.Sprintf("%s %s", logTimestamp, fmt.Sprintf(logger.header, level))
 }
 
@@ -197,18 +537,252 @@ func GetLogPrefix(level string) string {
 	return defaultLogPrefixFunc(level)
 }
 
+/*
+ * The fields of a message written in FormatJSON.  Timestamps are in RFC 3339
+ * format, and StackTrace is only present for fatal errors written to the log
+ * file or at verbose level.
+ */
+type jsonLogEntry struct {
+	Timestamp  string `json:"timestamp"`
+	Level      string `json:"level"`
+	Program    string `json:"program"`
+	Pid        int    `json:"pid"`
+	Message    string `json:"message"`
+	StackTrace string `json:"stacktrace,omitempty"`
+}
+
+type redaction struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+/*
+ * AddRedaction registers a pattern to replace in every subsequent message,
+ * e.g. regexp.MustCompile(`password=\S+`), in both formats and on every
+ * destination.  Patterns apply to message bodies and to the values of fields,
+ * in the order they were added, and the replacement may refer to submatches
+ * as in regexp.ReplaceAllString.
+ */
+func AddRedaction(pattern *regexp.Regexp, replacement string) {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	redactions = append(redactions, redaction{pattern: pattern, replacement: replacement})
+}
+
+// ClearRedactions removes every pattern registered with AddRedaction.
+func ClearRedactions() {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	redactions = nil
+}
+
+// The caller must hold logMutex.
+func redact(str string) string {
+	for _, r := range redactions {
+		str = r.pattern.ReplaceAllString(str, r.replacement)
+	}
+	return str
+}
+
+/*
+ * Formats a message body and its fields stamped with the current time, in the
+ * logger's format.  The caller must hold logMutex.
+ */
+func formatMessage(level string, body string, fields map[string]interface{}) string {
+	if logger.format == FormatJSON {
+		return formatJSONMessage(level, body, fields, operating.System.Now(), "")
+	}
+	return GetLogPrefix(level) + redact(body) + formatTextFields(fields)
+}
+
+func formatMessageAt(timestamp time.Time, level string, body string) string {
+	if logger.format == FormatJSON {
+		return formatJSONMessage(level, body, nil, timestamp, "")
+	}
+	return formatLogPrefix(level, timestamp) + redact(body)
+}
+
+func formatJSONMessage(level string, body string, fields map[string]interface{}, timestamp time.Time, stackTrace string) string {
+	entry := jsonLogEntry{
+		Timestamp:  timestamp.Format(time.RFC3339Nano),
+		Level:      level,
+		Program:    logger.program,
+		Pid:        logger.pid,
+		Message:    redact(body),
+		StackTrace: strings.TrimSpace(stackTrace),
+	}
+	if len(fields) == 0 {
+		// Marshaling a struct of strings and ints cannot fail
+		line, _ := json.Marshal(entry)
+		return string(line)
+	}
+
+	// The standard keys take precedence over fields with the same names
+	object := make(map[string]interface{}, len(fields)+6)
+	for key, value := range fields {
+		switch typedValue := value.(type) {
+		case error:
+			// Most error types have no exported fields and would marshal as {}
+			value = redact(typedValue.Error())
+		case string:
+			value = redact(typedValue)
+		}
+		object[key] = value
+	}
+	object["timestamp"] = entry.Timestamp
+	object["level"] = entry.Level
+	object["program"] = entry.Program
+	object["pid"] = entry.Pid
+	object["message"] = entry.Message
+	if entry.StackTrace != "" {
+		object["stacktrace"] = entry.StackTrace
+	}
+	line, err := json.Marshal(object)
+	if err != nil {
+		// A field value can't be represented in JSON, so write the fields as text
+		for key, value := range fields {
+			if !isStandardJSONKey(key) {
+				object[key] = redact(fmt.Sprintf("%v", value))
+			}
+		}
+		line, _ = json.Marshal(object)
+	}
+	return string(line)
+}
+
+func isStandardJSONKey(key string) bool {
+	switch key {
+	case "timestamp", "level", "program", "pid", "message", "stacktrace":
+		return true
+	}
+	return false
+}
+
+/*
+ * Formats fields as " key=value" pairs sorted by key, quoting any value that
+ * is empty or contains spaces, quotes, or equals signs.
+ */
+func formatTextFields(fields map[string]interface{}) string {
+	if len(fields) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var builder strings.Builder
+	for _, key := range keys {
+		value := redact(fmt.Sprintf("%v", fields[key]))
+		if value == "" || strings.ContainsAny(value, " \t\r\n\"=") {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(&builder, " %s=%s", key, value)
+	}
+	return builder.String()
+}
+
 func GetLogFilePath() string {
 	return logger.logFileName
 }
 
 func GetVerbosity() int {
+	logMutex.Lock()
+	defer logMutex.Unlock()
 	return logger.shellVerbosity
 }
 
 func SetVerbosity(verbosity int) {
+	logMutex.Lock()
+	defer logMutex.Unlock()
 	logger.shellVerbosity = verbosity
 }
 
+/*
+ * EnableSignalVerbosityToggle starts listening for the given signal (e.g.
+ * syscall.SIGUSR1), and each time it is received advances the shell verbosity
+ * to the next level, from Error through Debug and then back to Error, and
+ * logs the new level.  This lets an operator turn on debug output in a
+ * running utility without restarting it.  Calling the returned function stops
+ * listening for the signal.
+ */
+func EnableSignalVerbosityToggle(sig os.Signal) (disable func()) {
+	signals := operating.System.NotifySignals(sig)
+	stopNotify := operating.System.StopNotify
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-signals:
+				select {
+				case <-done:
+					// Ignore a signal received after disabling the toggle
+					return
+				default:
+					toggleVerbosity()
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			stopNotify(signals)
+			close(done)
+		})
+	}
+}
+
+func toggleVerbosity() {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	logger.shellVerbosity = (logger.shellVerbosity + 1) % (LOGDEBUG + 1)
+	// Write the new level even if it is too low for informational messages to appear
+	logMessage("INFO", fmt.Sprintf("Verbosity set to %s", LevelString(logger.shellVerbosity)), nil, func(message string) {
+		_ = logger.logFile.Output(1, message)
+		_ = logger.logStdout.Output(1, message)
+		writeToLogWriters(LOGERROR, "INFO", message)
+	})
+}
+
+var levelNames = map[int]string{
+	LOGERROR:   "error",
+	LOGINFO:    "info",
+	LOGVERBOSE: "verbose",
+	LOGDEBUG:   "debug",
+}
+
+/*
+ * LevelString returns the name of one of the log level constants above, e.g.
+ * "verbose" for LOGVERBOSE, for use in flags and messages.
+ */
+func LevelString(level int) string {
+	if name, ok := levelNames[level]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", level)
+}
+
+/*
+ * ParseLevel returns the log level constant with the given name, ignoring
+ * case, e.g. for a --log-level flag.  Since warnings are shown at every
+ * verbosity, "warn" and "warning" are accepted as synonyms for "error".
+ */
+func ParseLevel(name string) (int, error) {
+	lowerName := strings.ToLower(strings.TrimSpace(name))
+	if lowerName == "warn" || lowerName == "warning" {
+		return LOGERROR, nil
+	}
+	for level, levelName := range levelNames {
+		if lowerName == levelName {
+			return level, nil
+		}
+	}
+	return 0, errors.Errorf("Invalid log level %q; must be one of error, warn, info, verbose, or debug", name)
+}
+
 func GetLogFileVerbosity() int {
 	return logger.fileVerbosity
 }
@@ -226,81 +800,243 @@ func SetErrorCode(code int) {
 }
 
 /*
- * Log output functions, as described above
+ * GetErrorCount and GetWarnCount return how many messages have been logged by
+ * Error() and Warn() respectively since the logger was created or ResetCounts
+ * was last called, including duplicates suppressed by SetDedupConsecutive.
  */
+func GetErrorCount() int {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	return logger.errorCount
+}
 
-func Info(s string, v ...interface{}) {
+func GetWarnCount() int {
 	logMutex.Lock()
 	defer logMutex.Unlock()
-	message := GetLogPrefix("INFO") + fmt.Sprintf(s, v...)
-	if logger.fileVerbosity >= LOGINFO {
-		_ = logger.logFile.Output(1, message)
-	}
-	if logger.shellVerbosity >= LOGINFO {
-		_ = logger.logStdout.Output(1, message)
-	}
+	return logger.warnCount
 }
 
-func Warn(s string, v ...interface{}) {
+func ResetCounts() {
 	logMutex.Lock()
 	defer logMutex.Unlock()
-	message := GetLogPrefix("WARNING") + fmt.Sprintf(s, v...)
-	_ = logger.logFile.Output(1, message)
-	_ = logger.logStdout.Output(1, message)
+	logger.errorCount = 0
+	logger.warnCount = 0
+}
+
+/*
+ * Log output functions, as described above
+ */
+
+func Info(s string, v ...interface{}) {
+	info(nil, s, v...)
+}
+
+func Warn(s string, v ...interface{}) {
+	warn(nil, s, v...)
 }
 
 func Verbose(s string, v ...interface{}) {
+	verbose(nil, s, v...)
+}
+
+func Debug(s string, v ...interface{}) {
+	debug(nil, s, v...)
+}
+
+func Error(s string, v ...interface{}) {
+	logError(nil, s, v...)
+}
+
+func Fatal(err error, s string, v ...interface{}) {
+	fatal(nil, err, s, v...)
+}
+
+func info(entry *Entry, s string, v ...interface{}) {
 	logMutex.Lock()
 	defer logMutex.Unlock()
-	message := GetLogPrefix("DEBUG") + fmt.Sprintf(s, v...)
-	if logger.fileVerbosity >= LOGVERBOSE {
-		_ = logger.logFile.Output(1, message)
-	}
-	if logger.shellVerbosity >= LOGVERBOSE {
-		_ = logger.logStdout.Output(1, message)
-	}
+	logMessage("INFO", entry.componentPrefix()+fmt.Sprintf(s, v...), entry.fieldMap(), func(message string) {
+		writeLeveledMessage(LOGINFO, entry.shellVerbosity(), message)
+	})
 }
 
-func Debug(s string, v ...interface{}) {
+func warn(entry *Entry, s string, v ...interface{}) {
 	logMutex.Lock()
 	defer logMutex.Unlock()
-	message := GetLogPrefix("DEBUG") + fmt.Sprintf(s, v...)
-	if logger.fileVerbosity >= LOGDEBUG {
+	logger.warnCount++
+	logMessage("WARNING", entry.componentPrefix()+fmt.Sprintf(s, v...), entry.fieldMap(), func(message string) {
 		_ = logger.logFile.Output(1, message)
-	}
-	if logger.shellVerbosity >= LOGDEBUG {
-		_ = logger.logStdout.Output(1, message)
-	}
+		_ = logger.logStdout.Output(1, colorize(colorWarning, message))
+		writeToLogWriters(LOGERROR, "WARNING", message)
+	})
 }
 
-func Error(s string, v ...interface{}) {
+func verbose(entry *Entry, s string, v ...interface{}) {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	logMessage("DEBUG", entry.componentPrefix()+callerPrefix()+fmt.Sprintf(s, v...), entry.fieldMap(), func(message string) {
+		writeLeveledMessage(LOGVERBOSE, entry.shellVerbosity(), message)
+	})
+}
+
+func debug(entry *Entry, s string, v ...interface{}) {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	logMessage("DEBUG", entry.componentPrefix()+callerPrefix()+fmt.Sprintf(s, v...), entry.fieldMap(), func(message string) {
+		writeLeveledMessage(LOGDEBUG, entry.shellVerbosity(), message)
+	})
+}
+
+func logError(entry *Entry, s string, v ...interface{}) {
 	logMutex.Lock()
 	defer logMutex.Unlock()
-	message := GetLogPrefix("ERROR") + fmt.Sprintf(s, v...)
 	errorCode = 1
-	_ = logger.logFile.Output(1, message)
-	_ = logger.logStderr.Output(1, message)
+	logger.errorCount++
+	logMessage("ERROR", entry.componentPrefix()+fmt.Sprintf(s, v...), entry.fieldMap(), func(message string) {
+		_ = logger.logFile.Output(1, message)
+		_ = logger.logStderr.Output(1, colorize(colorError, message))
+		writeToLogWriters(LOGERROR, "ERROR", message)
+	})
 }
 
-func Fatal(err error, s string, v ...interface{}) {
+func fatal(entry *Entry, err error, s string, v ...interface{}) {
+	message := logFatal(entry, err, s, v...)
+	runFatalHooks()
+	logger.logFileWriter.flush()
+	abort(message)
+}
+
+/*
+ * Writes a fatal error to the log file and returns the message to abort with.
+ */
+func logFatal(entry *Entry, err error, s string, v ...interface{}) string {
 	logMutex.Lock()
 	defer logMutex.Unlock()
-	message := GetLogPrefix("CRITICAL")
+	flushRepeatedMessage()
+	fields := entry.fieldMap()
+	body := entry.componentPrefix()
 	errorCode = 2
 	stackTraceStr := ""
 	if err != nil {
-		message += fmt.Sprintf("%v", err)
+		body += fmt.Sprintf("%v", err)
 		stackTraceStr = formatStackTrace(errors.WithStack(err))
 		if s != "" {
-			message += ": "
+			body += ": "
 		}
 	}
-	message += strings.TrimSpace(fmt.Sprintf(s, v...))
-	_ = logger.logFile.Output(1, message+stackTraceStr)
-	if logger.shellVerbosity >= LOGVERBOSE {
-		abort(message + stackTraceStr)
+	body += strings.TrimSpace(fmt.Sprintf(s, v...))
+	var message, messageWithStackTrace string
+	if logger.format == FormatJSON {
+		now := operating.System.Now()
+		message = formatJSONMessage("CRITICAL", body, fields, now, "")
+		messageWithStackTrace = formatJSONMessage("CRITICAL", body, fields, now, stackTraceStr)
 	} else {
-		abort(message)
+		message = GetLogPrefix("CRITICAL") + redact(body) + formatTextFields(fields)
+		messageWithStackTrace = message + stackTraceStr
+	}
+	_ = logger.logFile.Output(1, messageWithStackTrace)
+	logger.logFileWriter.flush()
+	for _, logWriter := range logger.logWriters {
+		if logWriter.verbosity >= LOGVERBOSE {
+			logWriter.write("CRITICAL", messageWithStackTrace)
+		} else {
+			logWriter.write("CRITICAL", message)
+		}
+	}
+	if logger.shellVerbosity >= LOGVERBOSE {
+		return messageWithStackTrace
+	}
+	return message
+}
+
+/*
+ * LogAt writes a message stamped with the given time instead of the current
+ * time, for replaying events that happened elsewhere (e.g. when importing the
+ * logs of another system).  The level is one of the verbosity constants above,
+ * and the message is routed as it would be by Error(), Info(), Verbose(), or
+ * Debug().  A custom prefix function set with SetLogPrefixFunc has no way to
+ * accept a timestamp, so LogAt always uses the default prefix format.
+ */
+func LogAt(timestamp time.Time, level int, s string, v ...interface{}) {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	flushRepeatedMessage()
+	logger.lastMessage = nil
+	switch level {
+	case LOGERROR:
+		message := formatMessageAt(timestamp, "ERROR", fmt.Sprintf(s, v...))
+		errorCode = 1
+		logger.errorCount++
+		_ = logger.logFile.Output(1, message)
+		_ = logger.logStderr.Output(1, colorize(colorError, message))
+		writeToLogWriters(LOGERROR, "ERROR", message)
+	case LOGINFO:
+		writeLeveledMessage(level, logger.shellVerbosity, formatMessageAt(timestamp, "INFO", fmt.Sprintf(s, v...)))
+	default:
+		writeLeveledMessage(level, logger.shellVerbosity, formatMessageAt(timestamp, "DEBUG", fmt.Sprintf(s, v...)))
+	}
+}
+
+/*
+ * Prefixes the message body and passes it to write, unless it is a suppressed
+ * duplicate of the previous message.  The caller must hold logMutex.
+ */
+func logMessage(level string, body string, fields map[string]interface{}, write func(message string)) {
+	if logger.dedupConsecutive {
+		now := operating.System.Now()
+		last := logger.lastMessage
+		if last != nil && last.level == level && last.body == body && reflect.DeepEqual(last.fields, fields) &&
+			(logger.dedupWindow <= 0 || now.Sub(last.loggedAt) < logger.dedupWindow) {
+			last.repeatCount++
+			return
+		}
+		flushRepeatedMessage()
+		logger.lastMessage = &repeatedMessage{level: level, body: body, fields: fields, write: write, loggedAt: now}
+	}
+	write(formatMessage(level, body, fields))
+}
+
+/*
+ * Writes the count of suppressed repeats of the previous message, if any.  The
+ * caller must hold logMutex.
+ */
+func flushRepeatedMessage() {
+	last := logger.lastMessage
+	if last == nil || last.repeatCount == 0 {
+		return
+	}
+	last.write(formatMessage(last.level, fmt.Sprintf("%s (repeated %d times)", last.body, last.repeatCount), last.fields))
+	last.repeatCount = 0
+}
+
+/*
+ * Writes a message to the log file, to stdout, and to any writers added with
+ * AddLogWriter, subject to their respective verbosity settings, where
+ * shellVerbosity is the verbosity for stdout.  The caller must hold logMutex.
+ */
+func writeLeveledMessage(level int, shellVerbosity int, message string) {
+	if logger.fileVerbosity >= level {
+		_ = logger.logFile.Output(1, message)
+	}
+	if shellVerbosity >= level {
+		_ = logger.logStdout.Output(1, message)
+	}
+	levelName := "DEBUG"
+	if level == LOGINFO {
+		levelName = "INFO"
+	}
+	writeToLogWriters(level, levelName, message)
+}
+
+/*
+ * Writes a message to the writers added with AddLogWriter whose verbosity is
+ * at least level.  Warnings and errors are written at LOGERROR, so that every
+ * writer receives them.  The caller must hold logMutex.
+ */
+func writeToLogWriters(level int, levelName string, message string) {
+	for _, logWriter := range logger.logWriters {
+		if logWriter.verbosity >= level {
+			logWriter.write(levelName, message)
+		}
 	}
 }
 
@@ -315,13 +1051,194 @@ func FatalOnError(err error, output ...string) {
 }
 
 func FatalWithoutPanic(s string, v ...interface{}) {
+	func() {
+		logMutex.Lock()
+		defer logMutex.Unlock()
+		flushRepeatedMessage()
+		message := formatMessage("CRITICAL", fmt.Sprintf(s, v...), nil)
+		errorCode = 2
+		_ = logger.logFile.Output(1, message)
+		_ = logger.logStderr.Output(1, colorize(colorError, message))
+		writeToLogWriters(LOGERROR, "CRITICAL", message)
+		logger.logFileWriter.flush()
+	}()
+	runFatalHooks()
+	logger.logFileWriter.flush()
+	exitFunc()
+}
+
+/*
+ * RegisterFatalHook registers a function to run when Fatal(), FatalOnError(),
+ * or FatalWithoutPanic() is called, after the error has been logged and before
+ * the panic or call to the exit function, e.g. to remove lock files or flush
+ * buffered output.  Hooks run in the reverse of the order they were registered
+ * in, like deferred calls, and each runs only once even if several fatal
+ * errors occur.  A hook may log messages, but must not call a fatal function
+ * itself.  A hook that panics is reported on stderr and does not prevent the
+ * remaining hooks from running.
+ */
+func RegisterFatalHook(hook func()) {
 	logMutex.Lock()
 	defer logMutex.Unlock()
-	message := GetLogPrefix("CRITICAL") + fmt.Sprintf(s, v...)
-	errorCode = 2
-	_ = logger.logFile.Output(1, message)
-	_ = logger.logStderr.Output(1, message)
-	exitFunc()
+	fatalHooks = append(fatalHooks, hook)
+}
+
+func runFatalHooks() {
+	logMutex.Lock()
+	hooks := fatalHooks
+	fatalHooks = nil
+	logMutex.Unlock()
+	for i := len(hooks) - 1; i >= 0; i-- {
+		runFatalHook(hooks[i])
+	}
+}
+
+func runFatalHook(hook func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			logMutex.Lock()
+			defer logMutex.Unlock()
+			_ = logger.logStderr.Output(1, colorize(colorError, formatMessage("CRITICAL", fmt.Sprintf("Fatal hook panicked: %v", r), nil)))
+		}
+	}()
+	hook()
+}
+
+/*
+ * An Entry carries fields, such as a request or correlation ID, that are
+ * appended to each message logged through it: as " key=value" pairs sorted by
+ * key in FormatText, or as additional keys in FormatJSON.  Entries write to
+ * the current logger and never modify it, so messages logged directly with
+ * the package-level functions have no fields.
+ */
+type Entry struct {
+	fields    map[string]interface{}
+	component *component
+}
+
+func WithFields(fields map[string]interface{}) *Entry {
+	return (&Entry{}).WithFields(fields)
+}
+
+func WithField(key string, value interface{}) *Entry {
+	return (&Entry{}).WithField(key, value)
+}
+
+/*
+ * WithFields returns a new Entry with the fields of this one and the given
+ * fields, which replace any existing fields with the same keys.
+ */
+func (entry *Entry) WithFields(fields map[string]interface{}) *Entry {
+	merged := make(map[string]interface{}, len(entry.fields)+len(fields))
+	for key, value := range entry.fields {
+		merged[key] = value
+	}
+	for key, value := range fields {
+		merged[key] = value
+	}
+	return &Entry{fields: merged, component: entry.component}
+}
+
+func (entry *Entry) WithField(key string, value interface{}) *Entry {
+	return entry.WithFields(map[string]interface{}{key: value})
+}
+
+func (entry *Entry) Info(s string, v ...interface{}) {
+	info(entry, s, v...)
+}
+
+func (entry *Entry) Warn(s string, v ...interface{}) {
+	warn(entry, s, v...)
+}
+
+func (entry *Entry) Verbose(s string, v ...interface{}) {
+	verbose(entry, s, v...)
+}
+
+func (entry *Entry) Debug(s string, v ...interface{}) {
+	debug(entry, s, v...)
+}
+
+func (entry *Entry) Error(s string, v ...interface{}) {
+	logError(entry, s, v...)
+}
+
+func (entry *Entry) Fatal(err error, s string, v ...interface{}) {
+	fatal(entry, err, s, v...)
+}
+
+/*
+ * The following functions handle a nil Entry, which is how the package-level
+ * output functions log messages without fields.  The caller must hold
+ * logMutex.
+ */
+
+func (entry *Entry) fieldMap() map[string]interface{} {
+	if entry == nil {
+		return nil
+	}
+	return entry.fields
+}
+
+func (entry *Entry) componentPrefix() string {
+	if entry == nil || entry.component == nil {
+		return ""
+	}
+	return "[" + entry.component.name + "] "
+}
+
+func (entry *Entry) shellVerbosity() int {
+	if entry == nil || entry.component == nil || !entry.component.hasVerbosity {
+		return logger.shellVerbosity
+	}
+	return entry.component.verbosity
+}
+
+/*
+ * A SubLogger logs the messages of one component of a utility, beginning each
+ * with "[name] " so that the messages of different components can be told
+ * apart.  It shares the destinations and settings of the current logger, but
+ * its shell verbosity can be set independently with SetVerbosity.  Entries
+ * derived from a SubLogger with WithFields share its name and verbosity.
+ */
+type SubLogger struct {
+	*Entry
+}
+
+type component struct {
+	name         string
+	verbosity    int
+	hasVerbosity bool
+}
+
+func NewSubLogger(name string) *SubLogger {
+	return &SubLogger{Entry: &Entry{component: &component{name: name}}}
+}
+
+/*
+ * SetVerbosity sets the verbosity of the sub-logger's messages on stdout,
+ * overriding the logger's shell verbosity.  The log file verbosity still
+ * applies to the sub-logger's messages.
+ */
+func (subLogger *SubLogger) SetVerbosity(verbosity int) {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	subLogger.component.verbosity = verbosity
+	subLogger.component.hasVerbosity = true
+}
+
+// GetVerbosity returns the shell verbosity that applies to the sub-logger.
+func (subLogger *SubLogger) GetVerbosity() int {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	return subLogger.shellVerbosity()
+}
+
+// ResetVerbosity makes the sub-logger follow the logger's shell verbosity again.
+func (subLogger *SubLogger) ResetVerbosity() {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	subLogger.component.hasVerbosity = false
 }
 
 type stackTracer interface {
@@ -352,6 +1269,149 @@ func abort(output ...interface{}) {
 	}
 	panic(errStr)
 }
+
+/*
+ * A rotatingLogFile is the writer for a logger's log file, and tracks the size
+ * of the file so that it can be rotated once SetMaxLogSize is called.  Once
+ * SetBuffered is called, writes go through buffer instead of directly to the
+ * writer for the file.  It has its own mutex so that writes stay safe if the
+ * log file is ever written to outside of logMutex.
+ */
+type rotatingLogFile struct {
+	lock       sync.Mutex
+	writer     io.Writer
+	buffer     *bufio.Writer
+	custom     bool
+	fileName   string
+	stderr     io.Writer
+	size       int64
+	maxSize    int64
+	maxBackups int
+}
+
+func (file *rotatingLogFile) Write(p []byte) (int, error) {
+	file.lock.Lock()
+	defer file.lock.Unlock()
+	if file.maxSize > 0 && !file.custom && file.size > 0 && file.size+int64(len(p)) > file.maxSize {
+		file.rotate()
+	}
+	var n int
+	var err error
+	if file.buffer != nil {
+		n, err = file.buffer.Write(p)
+	} else {
+		n, err = file.writer.Write(p)
+	}
+	file.size += int64(n)
+	return n, err
+}
+
+func (file *rotatingLogFile) setWriter(writer io.Writer) {
+	file.lock.Lock()
+	defer file.lock.Unlock()
+	file.flushBuffer()
+	file.writer = writer
+	file.custom = true
+	file.size = 0
+	if file.buffer != nil {
+		file.buffer.Reset(writer)
+	}
+}
+
+func (file *rotatingLogFile) setBuffered(size int) {
+	file.lock.Lock()
+	defer file.lock.Unlock()
+	file.flushBuffer()
+	if size <= 0 {
+		file.buffer = nil
+	} else {
+		file.buffer = bufio.NewWriterSize(file.writer, size)
+	}
+}
+
+func (file *rotatingLogFile) flush() {
+	file.lock.Lock()
+	defer file.lock.Unlock()
+	file.flushBuffer()
+}
+
+// The caller must hold file.lock.
+func (file *rotatingLogFile) flushBuffer() {
+	if file.buffer != nil {
+		_ = file.buffer.Flush()
+	}
+}
+
+func (file *rotatingLogFile) setMaxSize(maxBytes int64) {
+	file.lock.Lock()
+	defer file.lock.Unlock()
+	if file.maxSize <= 0 && maxBytes > 0 {
+		// The file is opened for appending, so count what was written before this process
+		if info, err := operating.System.Stat(file.fileName); err == nil && info.Mode().IsRegular() && info.Size() > file.size {
+			file.size = info.Size()
+		}
+	}
+	file.maxSize = maxBytes
+}
+
+func (file *rotatingLogFile) setMaxBackups(count int) {
+	file.lock.Lock()
+	defer file.lock.Unlock()
+	if count < 0 {
+		count = 0
+	}
+	file.maxBackups = count
+}
+
+/*
+ * Moves the current log file aside and opens a new one.  If that fails, the
+ * logger keeps writing to the current file, and tries again once another
+ * maxSize bytes have been written to it.  The caller must hold file.lock.
+ */
+func (file *rotatingLogFile) rotate() {
+	file.size = 0
+	file.flushBuffer()
+	err := file.renameBackups()
+	if err != nil {
+		fmt.Fprintf(file.stderr, "Cannot rotate log file %s: %v\n", file.fileName, err)
+		return
+	}
+	flags := os.O_APPEND | os.O_CREATE | os.O_WRONLY
+	newWriter, err := operating.System.OpenFileWrite(file.fileName, flags, 0644)
+	if err != nil {
+		fmt.Fprintf(file.stderr, "Cannot open new log file %s: %v\n", file.fileName, err)
+		return
+	}
+	if closer, ok := file.writer.(io.Closer); ok {
+		_ = closer.Close()
+	}
+	file.writer = newWriter
+	if file.buffer != nil {
+		file.buffer.Reset(newWriter)
+	}
+}
+
+func (file *rotatingLogFile) renameBackups() error {
+	if file.maxBackups == 0 {
+		err := operating.System.Remove(file.fileName)
+		if err != nil && !operating.System.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	err := operating.System.Remove(fmt.Sprintf("%s.%d", file.fileName, file.maxBackups))
+	if err != nil && !operating.System.IsNotExist(err) {
+		return err
+	}
+	for i := file.maxBackups - 1; i >= 1; i-- {
+		err = operating.System.Rename(fmt.Sprintf("%s.%d", file.fileName, i), fmt.Sprintf("%s.%d", file.fileName, i+1))
+		if err != nil && !operating.System.IsNotExist(err) {
+			return err
+		}
+	}
+	return operating.System.Rename(file.fileName, file.fileName+".1")
+}
+
 func openLogFile(filename string) io.WriteCloser {
 	flags := os.O_APPEND | os.O_CREATE | os.O_WRONLY
 	fileHandle, err := operating.System.OpenFileWrite(filename, flags, 0644)
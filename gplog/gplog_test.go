@@ -1,12 +1,18 @@
 package gplog_test
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"os/user"
 	"reflect"
+	"regexp"
+	"runtime"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 
@@ -158,6 +164,41 @@ var _ = Describe("logger/log tests", func() {
 			gplog.SetLogPrefixFunc(nil)
 		})
 	})
+	Describe("LevelString and ParseLevel", func() {
+		DescribeTable("converts each level to its name and back", func(level int, name string) {
+			Expect(gplog.LevelString(level)).To(Equal(name))
+			parsedLevel, err := gplog.ParseLevel(name)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(parsedLevel).To(Equal(level))
+		},
+			Entry("error", gplog.LOGERROR, "error"),
+			Entry("info", gplog.LOGINFO, "info"),
+			Entry("verbose", gplog.LOGVERBOSE, "verbose"),
+			Entry("debug", gplog.LOGDEBUG, "debug"),
+		)
+		DescribeTable("parses names regardless of case", func(name string, level int) {
+			parsedLevel, err := gplog.ParseLevel(name)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(parsedLevel).To(Equal(level))
+		},
+			Entry("upper case", "DEBUG", gplog.LOGDEBUG),
+			Entry("mixed case", "Verbose", gplog.LOGVERBOSE),
+			Entry("surrounding spaces", " info ", gplog.LOGINFO),
+			Entry("warn", "warn", gplog.LOGERROR),
+			Entry("warning", "WARNING", gplog.LOGERROR),
+		)
+		DescribeTable("returns an error for an unknown name", func(name string) {
+			_, err := gplog.ParseLevel(name)
+			Expect(err).To(MatchError(fmt.Sprintf("Invalid log level %q; must be one of error, warn, info, verbose, or debug", name)))
+		},
+			Entry("empty name", ""),
+			Entry("unknown name", "trace"),
+			Entry("number", "3"),
+		)
+		It("names an unknown level", func() {
+			Expect(gplog.LevelString(42)).To(Equal("unknown(42)"))
+		})
+	})
 	Describe("Output function tests", func() {
 		patternExpected := "20170101:01:01:01 testProgram:testUser:testHost:000000-[%s]:-"
 		infoExpected := fmt.Sprintf(patternExpected, "INFO")
@@ -510,5 +551,1029 @@ var _ = Describe("logger/log tests", func() {
 				})
 			})
 		})
+		Describe("LogAt", func() {
+			replayTime := time.Date(2016, time.March, 4, 5, 6, 7, 0, time.Local)
+			replayPattern := "20160304:05:06:07 testProgram:testUser:testHost:000000-[%s]:-"
+
+			It("uses the supplied timestamp for an Info message", func() {
+				expectedMessage := "replayed info"
+				gplog.LogAt(replayTime, gplog.LOGINFO, expectedMessage)
+				testhelper.ExpectRegexp(stdout, fmt.Sprintf(replayPattern, "INFO")+expectedMessage)
+				testhelper.ExpectRegexp(logfile, fmt.Sprintf(replayPattern, "INFO")+expectedMessage)
+				testhelper.NotExpectRegexp(logfile, infoExpected+expectedMessage)
+			})
+			It("uses the supplied timestamp for an Error message, printing to stderr", func() {
+				expectedMessage := "replayed error"
+				gplog.LogAt(replayTime, gplog.LOGERROR, "replayed %s", "error")
+				testhelper.NotExpectRegexp(stdout, fmt.Sprintf(replayPattern, "ERROR")+expectedMessage)
+				testhelper.ExpectRegexp(stderr, fmt.Sprintf(replayPattern, "ERROR")+expectedMessage)
+				testhelper.ExpectRegexp(logfile, fmt.Sprintf(replayPattern, "ERROR")+expectedMessage)
+				Expect(gplog.GetErrorCode()).To(Equal(1))
+				gplog.SetErrorCode(0)
+			})
+			It("respects the verbosity of the supplied level", func() {
+				expectedMessage := "replayed debug"
+				gplog.LogAt(replayTime, gplog.LOGDEBUG, expectedMessage)
+				testhelper.NotExpectRegexp(stdout, fmt.Sprintf(replayPattern, "DEBUG")+expectedMessage)
+				testhelper.ExpectRegexp(logfile, fmt.Sprintf(replayPattern, "DEBUG")+expectedMessage)
+			})
+		})
+		Describe("SetDedupConsecutive", func() {
+			BeforeEach(func() {
+				gplog.SetDedupConsecutive(true)
+			})
+			AfterEach(func() {
+				gplog.SetDedupConsecutive(false)
+			})
+			It("collapses repeated messages when a distinct message is logged", func() {
+				for i := 0; i < 4; i++ {
+					gplog.Info("retrying connection")
+				}
+				gplog.Info("connected")
+				Expect(string(stdout.Contents())).To(Equal(infoExpected + "retrying connection\n" +
+					infoExpected + "retrying connection (repeated 3 times)\n" +
+					infoExpected + "connected\n"))
+			})
+			It("collapses repeated messages on Flush", func() {
+				gplog.Warn("disk is almost full")
+				gplog.Warn("disk is almost full")
+				gplog.Flush()
+				Expect(string(stdout.Contents())).To(Equal(warnExpected + "disk is almost full\n" +
+					warnExpected + "disk is almost full (repeated 1 times)\n"))
+				Expect(string(logfile.Contents())).To(Equal(string(stdout.Contents())))
+			})
+			It("does not add a count for a message that was not repeated", func() {
+				gplog.Info("first")
+				gplog.Info("second")
+				gplog.Flush()
+				Expect(string(stdout.Contents())).To(Equal(infoExpected + "first\n" + infoExpected + "second\n"))
+			})
+			It("does not collapse identical messages at different levels", func() {
+				gplog.Info("same text")
+				gplog.Warn("same text")
+				Expect(string(stdout.Contents())).To(Equal(infoExpected + "same text\n" + warnExpected + "same text\n"))
+			})
+			It("writes the repeat count of an error to stderr", func() {
+				gplog.Error("segment %d is down", 2)
+				gplog.Error("segment %d is down", 2)
+				gplog.Error("segment %d is down", 3)
+				Expect(string(stderr.Contents())).To(Equal(errorExpected + "segment 2 is down\n" +
+					errorExpected + "segment 2 is down (repeated 1 times)\n" +
+					errorExpected + "segment 3 is down\n"))
+				gplog.SetErrorCode(0)
+			})
+			It("writes repeated messages normally when disabled", func() {
+				gplog.SetDedupConsecutive(false)
+				gplog.Info("retrying connection")
+				gplog.Info("retrying connection")
+				Expect(string(stdout.Contents())).To(Equal(infoExpected + "retrying connection\n" + infoExpected + "retrying connection\n"))
+			})
+		})
+		Describe("EnableSignalVerbosityToggle", func() {
+			var (
+				signals       chan os.Signal
+				notified      []os.Signal
+				stoppedSignal <-chan os.Signal
+				disable       func()
+			)
+			BeforeEach(func() {
+				signals = make(chan os.Signal, 1)
+				stoppedSignal = nil
+				operating.System.NotifySignals = func(sigs ...os.Signal) <-chan os.Signal {
+					notified = sigs
+					return signals
+				}
+				operating.System.StopNotify = func(c <-chan os.Signal) {
+					stoppedSignal = c
+				}
+				gplog.SetVerbosity(gplog.LOGINFO)
+				disable = gplog.EnableSignalVerbosityToggle(syscall.SIGUSR1)
+			})
+			AfterEach(func() {
+				disable()
+			})
+			It("listens for the given signal", func() {
+				Expect(notified).To(Equal([]os.Signal{syscall.SIGUSR1}))
+			})
+			It("advances the verbosity and logs the new level each time the signal is received", func() {
+				signals <- syscall.SIGUSR1
+				Eventually(gplog.GetVerbosity).Should(Equal(gplog.LOGVERBOSE))
+				signals <- syscall.SIGUSR1
+				Eventually(gplog.GetVerbosity).Should(Equal(gplog.LOGDEBUG))
+				Eventually(stdout).Should(gbytes.Say(regexp.QuoteMeta(infoExpected + "Verbosity set to verbose\n" + infoExpected + "Verbosity set to debug\n")))
+			})
+			It("cycles back to Error after Debug and logs the new level regardless of verbosity", func() {
+				gplog.SetVerbosity(gplog.LOGDEBUG)
+				signals <- syscall.SIGUSR1
+				Eventually(gplog.GetVerbosity).Should(Equal(gplog.LOGERROR))
+				Eventually(stdout).Should(gbytes.Say(regexp.QuoteMeta(infoExpected + "Verbosity set to error\n")))
+				signals <- syscall.SIGUSR1
+				Eventually(gplog.GetVerbosity).Should(Equal(gplog.LOGINFO))
+			})
+			It("stops listening for the signal when disabled", func() {
+				disable()
+				Expect(stoppedSignal).To(Equal((<-chan os.Signal)(signals)))
+				signals <- syscall.SIGUSR1
+				Consistently(gplog.GetVerbosity, "50ms").Should(Equal(gplog.LOGINFO))
+			})
+		})
+		Describe("AddRedaction", func() {
+			BeforeEach(func() {
+				gplog.AddRedaction(regexp.MustCompile(`password=\S+`), "password=********")
+			})
+			AfterEach(func() {
+				gplog.ClearRedactions()
+				gplog.SetLogFormat(gplog.FormatText)
+				gplog.SetErrorCode(0)
+			})
+			It("redacts matching text in messages on every destination", func() {
+				gplog.Info("Connecting with host=localhost password=secret dbname=postgres")
+				gplog.Error("Cannot connect with password=secret")
+				Expect(string(stdout.Contents())).To(Equal(infoExpected + "Connecting with host=localhost password=******** dbname=postgres\n"))
+				Expect(string(stderr.Contents())).To(Equal(errorExpected + "Cannot connect with password=********\n"))
+				Expect(string(logfile.Contents())).ToNot(ContainSubstring("secret"))
+			})
+			It("redacts matching text in JSON mode", func() {
+				gplog.SetLogFormat(gplog.FormatJSON)
+				gplog.Info("Connecting with password=secret")
+				entry := make(map[string]interface{})
+				Expect(json.Unmarshal(stdout.Contents(), &entry)).To(Succeed())
+				Expect(entry).To(HaveKeyWithValue("message", "Connecting with password=********"))
+			})
+			It("redacts matching text in the values of fields", func() {
+				gplog.WithFields(map[string]interface{}{"dsn": "password=secret", "cause": errors.New("bad password=secret")}).Info("connecting")
+				Expect(string(stdout.Contents())).To(Equal(infoExpected + `connecting cause="bad password=********" dsn="password=********"` + "\n"))
+				stdout.Clear()
+				gplog.SetLogFormat(gplog.FormatJSON)
+				gplog.WithFields(map[string]interface{}{"dsn": "password=secret", "cause": errors.New("bad password=secret")}).Info("connecting")
+				entry := make(map[string]interface{})
+				Expect(json.Unmarshal(stdout.Contents(), &entry)).To(Succeed())
+				Expect(entry).To(HaveKeyWithValue("dsn", "password=********"))
+				Expect(entry).To(HaveKeyWithValue("cause", "bad password=********"))
+			})
+			It("redacts matching text in fatal errors", func() {
+				defer func() {
+					Expect(string(logfile.Contents())).ToNot(ContainSubstring("secret"))
+				}()
+				defer testhelper.ShouldPanicWithMessage("Cannot connect with password=********")
+				gplog.Fatal(errors.New("Cannot connect with password=secret"), "")
+			})
+			It("redacts matching text in messages logged with LogAt", func() {
+				gplog.LogAt(time.Date(2016, time.March, 4, 5, 6, 7, 0, time.UTC), gplog.LOGINFO, "password=secret")
+				Expect(string(stdout.Contents())).To(Equal("20160304:05:06:07 testProgram:testUser:testHost:000000-[INFO]:-password=********\n"))
+			})
+			It("applies the patterns in the order they were added, with submatch replacements", func() {
+				gplog.AddRedaction(regexp.MustCompile(`(token)=\w+`), "$1=REDACTED")
+				gplog.Info("password=secret token=abc123")
+				Expect(string(stdout.Contents())).To(Equal(infoExpected + "password=******** token=REDACTED\n"))
+			})
+			It("does not redact messages once the patterns are cleared", func() {
+				gplog.ClearRedactions()
+				gplog.Info("password=secret")
+				Expect(string(stdout.Contents())).To(Equal(infoExpected + "password=secret\n"))
+			})
+		})
+		Describe("SetIncludeCaller", func() {
+			BeforeEach(func() {
+				gplog.SetVerbosity(gplog.LOGDEBUG)
+				gplog.SetIncludeCaller(true)
+			})
+			AfterEach(func() {
+				gplog.SetIncludeCaller(false)
+			})
+			It("begins Debug and Verbose messages with the file and line of the caller", func() {
+				_, _, line, _ := runtime.Caller(0)
+				gplog.Debug("debug")
+				gplog.Verbose("verbose")
+				Expect(string(stdout.Contents())).To(Equal(fmt.Sprintf("%sgplog_test.go:%d: debug\n%sgplog_test.go:%d: verbose\n",
+					debugExpected, line+1, verboseExpected, line+2)))
+			})
+			It("reports the caller of an Entry method", func() {
+				_, _, line, _ := runtime.Caller(0)
+				gplog.WithField("segment", 1).Debug("debug")
+				Expect(string(stdout.Contents())).To(Equal(fmt.Sprintf("%sgplog_test.go:%d: debug segment=1\n", debugExpected, line+1)))
+			})
+			It("does not add the caller to messages at other levels", func() {
+				gplog.Info("info")
+				gplog.Warn("warn")
+				Expect(string(stdout.Contents())).To(Equal(infoExpected + "info\n" + warnExpected + "warn\n"))
+			})
+			It("does not add the caller by default", func() {
+				gplog.SetIncludeCaller(false)
+				gplog.Debug("debug")
+				Expect(string(stdout.Contents())).To(Equal(debugExpected + "debug\n"))
+			})
+		})
+		Describe("SetDedup", func() {
+			var now time.Time
+			BeforeEach(func() {
+				now = time.Date(2017, time.January, 1, 1, 1, 1, 1, time.Local)
+				operating.System.Now = func() time.Time { return now }
+				gplog.SetDedup(time.Minute)
+			})
+			AfterEach(func() {
+				gplog.SetDedup(0)
+			})
+			It("collapses repeated messages within the window", func() {
+				for i := 0; i < 4; i++ {
+					gplog.Warn("retrying connection")
+					now = now.Add(10 * time.Second)
+				}
+				gplog.Info("connected")
+				Expect(string(stdout.Contents())).To(Equal(warnExpected + "retrying connection\n" +
+					"20170101:01:01:41 testProgram:testUser:testHost:000000-[WARNING]:-retrying connection (repeated 3 times)\n" +
+					"20170101:01:01:41 testProgram:testUser:testHost:000000-[INFO]:-connected\n"))
+			})
+			It("writes the repeat count and the message again once the window closes", func() {
+				for i := 0; i < 5; i++ {
+					gplog.Warn("retrying connection")
+					now = now.Add(20 * time.Second)
+				}
+				Expect(string(stdout.Contents())).To(Equal(warnExpected + "retrying connection\n" +
+					"20170101:01:02:01 testProgram:testUser:testHost:000000-[WARNING]:-retrying connection (repeated 2 times)\n" +
+					"20170101:01:02:01 testProgram:testUser:testHost:000000-[WARNING]:-retrying connection\n"))
+				gplog.Flush()
+				testhelper.ExpectRegexp(stdout, "[WARNING]:-retrying connection (repeated 1 times)\n")
+			})
+			It("writes a repeated message again once the window closes if it was not repeated within it", func() {
+				gplog.Warn("retrying connection")
+				now = now.Add(time.Minute)
+				gplog.Warn("retrying connection")
+				Expect(string(stdout.Contents())).To(Equal(warnExpected + "retrying connection\n" +
+					"20170101:01:02:01 testProgram:testUser:testHost:000000-[WARNING]:-retrying connection\n"))
+			})
+			It("does not collapse messages at different levels", func() {
+				gplog.Warn("retrying connection")
+				gplog.Info("retrying connection")
+				Expect(string(stdout.Contents())).To(Equal(warnExpected + "retrying connection\n" + infoExpected + "retrying connection\n"))
+			})
+			It("writes each message when the window is 0", func() {
+				gplog.SetDedup(0)
+				gplog.Info("retrying connection")
+				gplog.Info("retrying connection")
+				Expect(string(stdout.Contents())).To(Equal(infoExpected + "retrying connection\n" + infoExpected + "retrying connection\n"))
+			})
+			It("writes the pending repeat count when deduplication is turned off", func() {
+				gplog.Info("retrying connection")
+				gplog.Info("retrying connection")
+				gplog.SetDedup(0)
+				Expect(string(stdout.Contents())).To(Equal(infoExpected + "retrying connection\n" + infoExpected + "retrying connection (repeated 1 times)\n"))
+			})
+		})
+		Describe("SetLogFormat", func() {
+			parseLines := func(buffer *gbytes.Buffer) []map[string]interface{} {
+				entries := make([]map[string]interface{}, 0)
+				for _, line := range strings.Split(strings.TrimSpace(string(buffer.Contents())), "\n") {
+					entry := make(map[string]interface{})
+					Expect(json.Unmarshal([]byte(line), &entry)).To(Succeed(), line)
+					entries = append(entries, entry)
+				}
+				return entries
+			}
+			expectEntry := func(entry map[string]interface{}, level string, message string) {
+				Expect(entry).To(HaveLen(5))
+				Expect(entry).To(HaveKeyWithValue("timestamp", time.Date(2017, time.January, 1, 1, 1, 1, 1, time.Local).Format(time.RFC3339Nano)))
+				Expect(entry).To(HaveKeyWithValue("level", level))
+				Expect(entry).To(HaveKeyWithValue("program", "testProgram"))
+				Expect(entry).To(HaveKeyWithValue("pid", float64(0)))
+				Expect(entry).To(HaveKeyWithValue("message", message))
+			}
+			BeforeEach(func() {
+				gplog.SetVerbosity(gplog.LOGDEBUG)
+				gplog.SetLogFormat(gplog.FormatJSON)
+			})
+			AfterEach(func() {
+				gplog.SetLogFormat(gplog.FormatText)
+				gplog.SetErrorCode(0)
+			})
+			It("writes each message at each level as a JSON object", func() {
+				gplog.Info("info %d", 1)
+				gplog.Warn("warn")
+				gplog.Verbose("verbose")
+				gplog.Debug("debug")
+				gplog.Error("error")
+
+				stdoutEntries := parseLines(stdout)
+				Expect(stdoutEntries).To(HaveLen(4))
+				expectEntry(stdoutEntries[0], "INFO", "info 1")
+				expectEntry(stdoutEntries[1], "WARNING", "warn")
+				expectEntry(stdoutEntries[2], "DEBUG", "verbose")
+				expectEntry(stdoutEntries[3], "DEBUG", "debug")
+				stderrEntries := parseLines(stderr)
+				Expect(stderrEntries).To(HaveLen(1))
+				expectEntry(stderrEntries[0], "ERROR", "error")
+				Expect(parseLines(logfile)).To(HaveLen(5))
+			})
+			It("escapes special characters in the message", func() {
+				gplog.Info(`quoted "value" with a newline` + "\n" + `and a \ backslash`)
+				entries := parseLines(stdout)
+				Expect(entries).To(HaveLen(1))
+				expectEntry(entries[0], "INFO", `quoted "value" with a newline`+"\n"+`and a \ backslash`)
+			})
+			It("writes a fatal error as a JSON object with its stack trace in the log file", func() {
+				func() {
+					defer func() { _ = recover() }()
+					gplog.Fatal(errors.New("fatal error"), "while connecting")
+				}()
+				entries := parseLines(logfile)
+				Expect(entries).To(HaveLen(1))
+				Expect(entries[0]).To(HaveKeyWithValue("level", "CRITICAL"))
+				Expect(entries[0]).To(HaveKeyWithValue("message", "fatal error: while connecting"))
+				Expect(entries[0]).To(HaveKey("stacktrace"))
+			})
+			It("writes a message logged with LogAt using the supplied timestamp", func() {
+				replayTime := time.Date(2016, time.March, 4, 5, 6, 7, 0, time.UTC)
+				gplog.LogAt(replayTime, gplog.LOGINFO, "replayed")
+				entries := parseLines(stdout)
+				Expect(entries).To(HaveLen(1))
+				Expect(entries[0]).To(HaveKeyWithValue("timestamp", "2016-03-04T05:06:07Z"))
+			})
+			It("writes the repeat count of a deduplicated message as a JSON object", func() {
+				gplog.SetDedupConsecutive(true)
+				defer gplog.SetDedupConsecutive(false)
+				gplog.Info("retrying")
+				gplog.Info("retrying")
+				gplog.Flush()
+				entries := parseLines(stdout)
+				Expect(entries).To(HaveLen(2))
+				expectEntry(entries[1], "INFO", "retrying (repeated 1 times)")
+			})
+			It("writes text again once the format is reset", func() {
+				gplog.SetLogFormat(gplog.FormatText)
+				gplog.Info("plain")
+				Expect(string(stdout.Contents())).To(Equal(infoExpected + "plain\n"))
+			})
+		})
+		Describe("SetColor", func() {
+			AfterEach(func() {
+				gplog.SetColor(gplog.ColorNever)
+				gplog.SetErrorCode(0)
+			})
+			It("colors warnings yellow and errors red with ColorAlways", func() {
+				gplog.SetColor(gplog.ColorAlways)
+				gplog.Info("info")
+				gplog.Warn("warn")
+				gplog.Error("error")
+				Expect(string(stdout.Contents())).To(Equal(infoExpected + "info\n\x1b[33m" + warnExpected + "warn\x1b[0m\n"))
+				Expect(string(stderr.Contents())).To(Equal("\x1b[31m" + errorExpected + "error\x1b[0m\n"))
+			})
+			It("colors fatal errors red with ColorAlways", func() {
+				gplog.SetColor(gplog.ColorAlways)
+				gplog.SetExitFunc(func() {})
+				gplog.FatalWithoutPanic("fatal")
+				Expect(string(stderr.Contents())).To(Equal("\x1b[31m" + fatalExpected + "fatal\x1b[0m\n"))
+			})
+			It("never writes color codes to the log file or added writers", func() {
+				writer := gbytes.NewBuffer()
+				gplog.AddLogWriter(writer, gplog.LOGINFO)
+				gplog.SetColor(gplog.ColorAlways)
+				gplog.Warn("warn")
+				gplog.Error("error")
+				Expect(string(logfile.Contents())).To(Equal(warnExpected + "warn\n" + errorExpected + "error\n"))
+				Expect(string(writer.Contents())).To(Equal(warnExpected + "warn\n" + errorExpected + "error\n"))
+			})
+			It("does not color output with ColorNever", func() {
+				gplog.SetColor(gplog.ColorAlways)
+				gplog.SetColor(gplog.ColorNever)
+				gplog.Warn("warn")
+				gplog.Error("error")
+				Expect(string(stdout.Contents())).To(Equal(warnExpected + "warn\n"))
+				Expect(string(stderr.Contents())).To(Equal(errorExpected + "error\n"))
+			})
+			Context("ColorAuto", func() {
+				var stderrFile *os.File
+				BeforeEach(func() {
+					var err error
+					stderrFile, err = os.CreateTemp("", "gplog_stderr")
+					Expect(err).ToNot(HaveOccurred())
+					gplog.SetLogger(gplog.NewLogger(stdout, stderrFile, logfile, "gbytes.Buffer", gplog.LOGINFO, "testProgram"))
+				})
+				AfterEach(func() {
+					_ = stderrFile.Close()
+					_ = os.Remove(stderrFile.Name())
+				})
+				It("colors output if stderr is a terminal", func() {
+					operating.System.IsTerminal = func(file *os.File) bool { return file == stderrFile }
+					gplog.SetColor(gplog.ColorAuto)
+					gplog.Warn("warn")
+					Expect(string(stdout.Contents())).To(Equal("\x1b[33m" + warnExpected + "warn\x1b[0m\n"))
+				})
+				It("does not color output if stderr is not a terminal", func() {
+					operating.System.IsTerminal = func(file *os.File) bool { return false }
+					gplog.SetColor(gplog.ColorAuto)
+					gplog.Warn("warn")
+					Expect(string(stdout.Contents())).To(Equal(warnExpected + "warn\n"))
+				})
+				It("does not color output if stderr is not a file", func() {
+					operating.System.IsTerminal = func(file *os.File) bool { return true }
+					stdout, stderr, logfile = testhelper.SetupTestLogger()
+					gplog.SetColor(gplog.ColorAuto)
+					gplog.Warn("warn")
+					Expect(string(stdout.Contents())).To(Equal(warnExpected + "warn\n"))
+				})
+			})
+		})
+		Describe("SetBuffered", func() {
+			BeforeEach(func() {
+				gplog.SetBuffered(1024)
+			})
+			AfterEach(func() {
+				gplog.SetBuffered(0)
+				gplog.SetErrorCode(0)
+			})
+			It("holds back log file output until Flush is called", func() {
+				gplog.Info("first")
+				gplog.Debug("second")
+				Expect(string(logfile.Contents())).To(BeEmpty())
+				Expect(string(stdout.Contents())).To(Equal(infoExpected + "first\n"))
+				gplog.Flush()
+				Expect(string(logfile.Contents())).To(Equal(infoExpected + "first\n" + debugExpected + "second\n"))
+			})
+			It("writes out the buffer when it fills", func() {
+				line := infoExpected + "message\n"
+				gplog.SetBuffered(len(line) * 2)
+				gplog.Info("message")
+				gplog.Info("message")
+				Expect(string(logfile.Contents())).To(BeEmpty())
+				gplog.Info("message")
+				Expect(string(logfile.Contents())).To(Equal(line + line))
+			})
+			It("writes out the buffer when a fatal error is logged", func() {
+				gplog.Info("before")
+				func() {
+					defer func() { _ = recover() }()
+					gplog.Fatal(errors.New("fatal error"), "")
+				}()
+				testhelper.ExpectRegexp(logfile, infoExpected+"before\n"+fatalExpected+"fatal error")
+			})
+			It("writes out the buffer, including messages logged by fatal hooks, before exiting", func() {
+				var contentsAtExit string
+				gplog.SetExitFunc(func() { contentsAtExit = string(logfile.Contents()) })
+				gplog.RegisterFatalHook(func() { gplog.Info("cleaning up") })
+				gplog.FatalWithoutPanic("fatal error")
+				Expect(contentsAtExit).To(Equal(fatalExpected + "fatal error\n" + infoExpected + "cleaning up\n"))
+			})
+			It("writes out the buffer and stops buffering when the size is 0", func() {
+				gplog.Info("buffered")
+				gplog.SetBuffered(0)
+				Expect(string(logfile.Contents())).To(Equal(infoExpected + "buffered\n"))
+				gplog.Info("unbuffered")
+				Expect(string(logfile.Contents())).To(Equal(infoExpected + "buffered\n" + infoExpected + "unbuffered\n"))
+			})
+			It("writes out the buffer to the old log file before rotating it", func() {
+				newLogFile := gbytes.NewBuffer()
+				operating.System.Rename = func(oldpath string, newpath string) error { return nil }
+				operating.System.Remove = func(name string) error { return nil }
+				operating.System.OpenFileWrite = func(name string, flag int, perm os.FileMode) (io.WriteCloser, error) { return newLogFile, nil }
+				line := infoExpected + "message\n"
+				gplog.SetMaxLogSize(int64(len(line)))
+				gplog.Info("message")
+				gplog.Info("message")
+				Expect(string(logfile.Contents())).To(Equal(line))
+				Expect(string(newLogFile.Contents())).To(BeEmpty())
+				gplog.Flush()
+				Expect(string(newLogFile.Contents())).To(Equal(line))
+			})
+		})
+		Describe("NewSubLogger", func() {
+			AfterEach(func() {
+				gplog.SetErrorCode(0)
+			})
+			It("begins each message with the name of the component", func() {
+				restoreLogger := gplog.NewSubLogger("restore")
+				restoreLogger.Info("info")
+				restoreLogger.Warn("warn")
+				restoreLogger.Error("error")
+				gplog.Info("unnamed")
+				Expect(string(stdout.Contents())).To(Equal(infoExpected + "[restore] info\n" + warnExpected + "[restore] warn\n" + infoExpected + "unnamed\n"))
+				Expect(string(stderr.Contents())).To(Equal(errorExpected + "[restore] error\n"))
+			})
+			It("includes the name of the component in a fatal error", func() {
+				defer testhelper.ShouldPanicWithMessage("[restore] cannot continue")
+				gplog.NewSubLogger("restore").Fatal(nil, "cannot continue")
+			})
+			It("shares its name with entries derived from it", func() {
+				gplog.NewSubLogger("restore").WithField("table", "public.foo").Info("restoring")
+				Expect(string(stdout.Contents())).To(Equal(infoExpected + "[restore] restoring table=public.foo\n"))
+			})
+			It("follows the logger's verbosity by default", func() {
+				restoreLogger := gplog.NewSubLogger("restore")
+				restoreLogger.Verbose("hidden")
+				gplog.SetVerbosity(gplog.LOGVERBOSE)
+				restoreLogger.Verbose("shown")
+				Expect(restoreLogger.GetVerbosity()).To(Equal(gplog.LOGVERBOSE))
+				Expect(string(stdout.Contents())).To(Equal(verboseExpected + "[restore] shown\n"))
+			})
+			It("allows the verbosity of each component to differ", func() {
+				restoreLogger := gplog.NewSubLogger("restore")
+				backupLogger := gplog.NewSubLogger("backup")
+				restoreLogger.SetVerbosity(gplog.LOGDEBUG)
+				backupLogger.SetVerbosity(gplog.LOGERROR)
+				restoreLogger.Debug("restore debug")
+				backupLogger.Info("backup info")
+				backupLogger.Warn("backup warn")
+				gplog.Verbose("base verbose")
+				gplog.Info("base info")
+				Expect(string(stdout.Contents())).To(Equal(debugExpected + "[restore] restore debug\n" + warnExpected + "[backup] backup warn\n" + infoExpected + "base info\n"))
+				Expect(string(logfile.Contents())).To(ContainSubstring("[backup] backup info"))
+				Expect(gplog.GetVerbosity()).To(Equal(gplog.LOGINFO))
+			})
+			It("applies the verbosity of a component to entries derived from it", func() {
+				restoreLogger := gplog.NewSubLogger("restore")
+				entry := restoreLogger.WithField("table", "public.foo")
+				restoreLogger.SetVerbosity(gplog.LOGDEBUG)
+				entry.Debug("restoring")
+				Expect(string(stdout.Contents())).To(Equal(debugExpected + "[restore] restoring table=public.foo\n"))
+			})
+			It("follows the logger's verbosity again once reset", func() {
+				restoreLogger := gplog.NewSubLogger("restore")
+				restoreLogger.SetVerbosity(gplog.LOGDEBUG)
+				restoreLogger.ResetVerbosity()
+				restoreLogger.Debug("hidden")
+				Expect(restoreLogger.GetVerbosity()).To(Equal(gplog.LOGINFO))
+				Expect(string(stdout.Contents())).To(BeEmpty())
+			})
+		})
+		Describe("SetLogFileWriter", func() {
+			var writer *bytes.Buffer
+			BeforeEach(func() {
+				writer = &bytes.Buffer{}
+				gplog.SetLogFileWriter(writer)
+			})
+			AfterEach(func() {
+				gplog.SetLogFormat(gplog.FormatText)
+				gplog.SetErrorCode(0)
+			})
+			It("writes messages to the writer instead of the log file", func() {
+				gplog.Info("info")
+				gplog.Debug("debug")
+				gplog.Error("error")
+				Expect(writer.String()).To(Equal(infoExpected + "info\n" + debugExpected + "debug\n" + errorExpected + "error\n"))
+				Expect(string(logfile.Contents())).To(BeEmpty())
+				Expect(string(stdout.Contents())).To(Equal(infoExpected + "info\n"))
+			})
+			It("applies the log file verbosity to the writer", func() {
+				gplog.SetLogFileVerbosity(gplog.LOGINFO)
+				defer gplog.SetLogFileVerbosity(gplog.LOGDEBUG)
+				gplog.Info("info")
+				gplog.Debug("debug")
+				Expect(writer.String()).To(Equal(infoExpected + "info\n"))
+			})
+			It("applies the log format to the writer", func() {
+				gplog.SetLogFormat(gplog.FormatJSON)
+				gplog.Info("info")
+				entry := make(map[string]interface{})
+				Expect(json.Unmarshal(writer.Bytes(), &entry)).To(Succeed())
+				Expect(entry).To(HaveKeyWithValue("message", "info"))
+			})
+			It("writes buffered output to the previous writer before replacing it", func() {
+				gplog.SetBuffered(1024)
+				defer gplog.SetBuffered(0)
+				gplog.Info("first")
+				newWriter := &bytes.Buffer{}
+				gplog.SetLogFileWriter(newWriter)
+				gplog.Info("second")
+				Expect(writer.String()).To(Equal(infoExpected + "first\n"))
+				Expect(newWriter.String()).To(BeEmpty())
+				gplog.Flush()
+				Expect(newWriter.String()).To(Equal(infoExpected + "second\n"))
+			})
+			It("does not rotate a custom writer", func() {
+				operating.System.Rename = func(oldpath string, newpath string) error {
+					Fail("Unexpected rename of " + oldpath)
+					return nil
+				}
+				gplog.SetMaxLogSize(1)
+				gplog.Info("first")
+				gplog.Info("second")
+				Expect(writer.String()).To(Equal(infoExpected + "first\n" + infoExpected + "second\n"))
+			})
+		})
+		Describe("SetMaxLogSize", func() {
+			var (
+				logFiles   []*gbytes.Buffer
+				fileOps    []string
+				renameErr  error
+				line       string
+				logName    = "/tmp/log_dir/testProgram_20170101.log"
+				lineLength int64
+			)
+			BeforeEach(func() {
+				logFiles = []*gbytes.Buffer{gbytes.NewBuffer()}
+				fileOps = make([]string, 0)
+				renameErr = nil
+				operating.System.Stat = func(name string) (os.FileInfo, error) { return nil, os.ErrNotExist }
+				operating.System.Remove = func(name string) error {
+					fileOps = append(fileOps, "remove "+name)
+					return nil
+				}
+				operating.System.Rename = func(oldpath string, newpath string) error {
+					fileOps = append(fileOps, fmt.Sprintf("rename %s %s", oldpath, newpath))
+					return renameErr
+				}
+				operating.System.OpenFileWrite = func(name string, flag int, perm os.FileMode) (io.WriteCloser, error) {
+					fileOps = append(fileOps, "open "+name)
+					logFiles = append(logFiles, gbytes.NewBuffer())
+					return logFiles[len(logFiles)-1], nil
+				}
+				gplog.SetLogger(gplog.NewLogger(stdout, stderr, logFiles[0], logName, gplog.LOGINFO, "testProgram"))
+				line = infoExpected + "message\n"
+				lineLength = int64(len(line))
+			})
+			It("does not rotate the log file by default", func() {
+				for i := 0; i < 10; i++ {
+					gplog.Info("message")
+				}
+				Expect(logFiles).To(HaveLen(1))
+				Expect(fileOps).To(BeEmpty())
+			})
+			It("does not rotate the log file while it is under the limit", func() {
+				gplog.SetMaxLogSize(lineLength * 2)
+				gplog.Info("message")
+				gplog.Info("message")
+				Expect(logFiles).To(HaveLen(1))
+				Expect(string(logFiles[0].Contents())).To(Equal(line + line))
+			})
+			It("rotates the log file before a message that would exceed the limit", func() {
+				gplog.SetMaxLogSize(lineLength * 2)
+				for i := 0; i < 5; i++ {
+					gplog.Info("message")
+				}
+				Expect(logFiles).To(HaveLen(3))
+				Expect(string(logFiles[0].Contents())).To(Equal(line + line))
+				Expect(string(logFiles[1].Contents())).To(Equal(line + line))
+				Expect(string(logFiles[2].Contents())).To(Equal(line))
+				Expect(logFiles[0].Closed()).To(BeTrue())
+				Expect(logFiles[1].Closed()).To(BeTrue())
+				Expect(fileOps).To(Equal([]string{
+					"remove " + logName + ".1", "rename " + logName + " " + logName + ".1", "open " + logName,
+					"remove " + logName + ".1", "rename " + logName + " " + logName + ".1", "open " + logName,
+				}))
+			})
+			It("writes a message larger than the limit to its own file", func() {
+				gplog.SetMaxLogSize(lineLength / 2)
+				gplog.Info("message")
+				gplog.Info("message")
+				Expect(logFiles).To(HaveLen(2))
+				Expect(string(logFiles[0].Contents())).To(Equal(line))
+				Expect(string(logFiles[1].Contents())).To(Equal(line))
+			})
+			It("shifts existing backups and deletes the oldest beyond the backup count", func() {
+				gplog.SetMaxLogSize(lineLength)
+				gplog.SetMaxLogBackups(3)
+				gplog.Info("message")
+				gplog.Info("message")
+				Expect(fileOps).To(Equal([]string{
+					"remove " + logName + ".3",
+					"rename " + logName + ".2 " + logName + ".3",
+					"rename " + logName + ".1 " + logName + ".2",
+					"rename " + logName + " " + logName + ".1",
+					"open " + logName,
+				}))
+			})
+			It("discards the log file contents when no backups are kept", func() {
+				gplog.SetMaxLogSize(lineLength)
+				gplog.SetMaxLogBackups(0)
+				gplog.Info("message")
+				gplog.Info("message")
+				Expect(fileOps).To(Equal([]string{"remove " + logName, "open " + logName}))
+			})
+			It("counts the contents of an existing log file toward the limit", func() {
+				existingFile := "/tmp/log_dir/existing.log"
+				Expect(os.WriteFile(existingFile, []byte(line), 0644)).To(Succeed())
+				defer os.Remove(existingFile)
+				operating.System.Stat = os.Stat
+				gplog.SetLogger(gplog.NewLogger(stdout, stderr, logFiles[0], existingFile, gplog.LOGINFO, "testProgram"))
+				gplog.SetMaxLogSize(lineLength * 2)
+				gplog.Info("message")
+				gplog.Info("message")
+				Expect(logFiles).To(HaveLen(2))
+			})
+			It("keeps writing to the current log file if it cannot be rotated", func() {
+				renameErr = errors.New("permission denied")
+				gplog.SetMaxLogSize(lineLength)
+				gplog.Info("message")
+				gplog.Info("message")
+				Expect(logFiles).To(HaveLen(1))
+				Expect(string(logFiles[0].Contents())).To(Equal(line + line))
+				Expect(string(stderr.Contents())).To(Equal(fmt.Sprintf("Cannot rotate log file %s: permission denied\n", logName)))
+			})
+			It("does not lose or split messages written concurrently", func() {
+				gplog.SetMaxLogSize(lineLength * 3)
+				var wg sync.WaitGroup
+				for i := 0; i < 10; i++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						for j := 0; j < 10; j++ {
+							gplog.Info("message")
+						}
+					}()
+				}
+				wg.Wait()
+				contents := ""
+				for _, logFile := range logFiles {
+					Expect(int64(len(logFile.Contents()))).To(BeNumerically("<=", lineLength*3))
+					contents += string(logFile.Contents())
+				}
+				Expect(contents).To(Equal(strings.Repeat(line, 100)))
+			})
+		})
+		Describe("AddLogWriter", func() {
+			var (
+				errorWriter   *gbytes.Buffer
+				infoWriter    *gbytes.Buffer
+				verboseWriter *gbytes.Buffer
+				debugWriter   *gbytes.Buffer
+			)
+			BeforeEach(func() {
+				errorWriter = gbytes.NewBuffer()
+				infoWriter = gbytes.NewBuffer()
+				verboseWriter = gbytes.NewBuffer()
+				debugWriter = gbytes.NewBuffer()
+				gplog.AddLogWriter(errorWriter, gplog.LOGERROR)
+				gplog.AddLogWriter(infoWriter, gplog.LOGINFO)
+				gplog.AddLogWriter(verboseWriter, gplog.LOGVERBOSE)
+				gplog.AddLogWriter(debugWriter, gplog.LOGDEBUG)
+			})
+			AfterEach(func() {
+				gplog.SetErrorCode(0)
+			})
+			It("writes each message only to the writers whose verbosity it meets", func() {
+				gplog.Info("info")
+				gplog.Verbose("verbose")
+				gplog.Debug("debug")
+				Expect(string(errorWriter.Contents())).To(BeEmpty())
+				Expect(string(infoWriter.Contents())).To(Equal(infoExpected + "info\n"))
+				Expect(string(verboseWriter.Contents())).To(Equal(infoExpected + "info\n" + verboseExpected + "verbose\n"))
+				Expect(string(debugWriter.Contents())).To(Equal(infoExpected + "info\n" + verboseExpected + "verbose\n" + debugExpected + "debug\n"))
+			})
+			It("writes warnings and errors to every writer", func() {
+				gplog.Warn("warn")
+				gplog.Error("error")
+				for _, writer := range []*gbytes.Buffer{errorWriter, infoWriter, verboseWriter, debugWriter} {
+					Expect(string(writer.Contents())).To(Equal(warnExpected + "warn\n" + errorExpected + "error\n"))
+				}
+			})
+			It("writes to the writers independently of the shell and log file verbosity", func() {
+				gplog.SetVerbosity(gplog.LOGERROR)
+				gplog.SetLogFileVerbosity(gplog.LOGERROR)
+				defer gplog.SetLogFileVerbosity(gplog.LOGDEBUG)
+				gplog.Debug("debug")
+				Expect(string(stdout.Contents())).To(BeEmpty())
+				Expect(string(logfile.Contents())).To(BeEmpty())
+				Expect(string(debugWriter.Contents())).To(Equal(debugExpected + "debug\n"))
+			})
+			It("writes messages logged with LogAt or WithFields to the writers", func() {
+				gplog.LogAt(time.Date(2016, time.March, 4, 5, 6, 7, 0, time.UTC), gplog.LOGINFO, "replayed")
+				gplog.WithField("segment", 1).Info("segment info")
+				Expect(string(infoWriter.Contents())).To(Equal("20160304:05:06:07 testProgram:testUser:testHost:000000-[INFO]:-replayed\n" +
+					infoExpected + "segment info segment=1\n"))
+			})
+			It("writes a fatal error to every writer, with the stack trace only at verbose level or higher", func() {
+				func() {
+					defer func() { _ = recover() }()
+					gplog.Fatal(errors.New("fatal error"), "")
+				}()
+				Expect(string(infoWriter.Contents())).To(Equal(fatalExpected + "fatal error\n"))
+				testhelper.ExpectRegexp(verboseWriter, fatalExpected+"fatal error\n")
+				Expect(len(verboseWriter.Contents())).To(BeNumerically(">", len(infoWriter.Contents())))
+			})
+			It("changes the verbosity of a writer that is added again", func() {
+				gplog.AddLogWriter(errorWriter, gplog.LOGINFO)
+				gplog.Info("info")
+				Expect(string(errorWriter.Contents())).To(Equal(infoExpected + "info\n"))
+			})
+			It("stops writing to a writer once it is removed", func() {
+				gplog.Info("before")
+				gplog.RemoveLogWriter(infoWriter)
+				gplog.Info("after")
+				Expect(string(infoWriter.Contents())).To(Equal(infoExpected + "before\n"))
+				Expect(string(debugWriter.Contents())).To(Equal(infoExpected + "before\n" + infoExpected + "after\n"))
+				Expect(string(stdout.Contents())).To(Equal(infoExpected + "before\n" + infoExpected + "after\n"))
+			})
+			It("does nothing when removing a writer that was never added", func() {
+				gplog.RemoveLogWriter(gbytes.NewBuffer())
+				gplog.Info("info")
+				Expect(string(infoWriter.Contents())).To(Equal(infoExpected + "info\n"))
+			})
+		})
+		Describe("RegisterFatalHook", func() {
+			var calls []string
+			BeforeEach(func() {
+				calls = make([]string, 0)
+				gplog.SetExitFunc(func() {
+					calls = append(calls, fmt.Sprintf("exit with error code %d", gplog.GetErrorCode()))
+				})
+			})
+			AfterEach(func() {
+				gplog.SetErrorCode(0)
+			})
+			It("runs the hooks in reverse order before exiting in FatalWithoutPanic", func() {
+				gplog.RegisterFatalHook(func() { calls = append(calls, "remove lock file") })
+				gplog.RegisterFatalHook(func() { calls = append(calls, "flush buffers") })
+				gplog.FatalWithoutPanic("fatal error")
+				Expect(calls).To(Equal([]string{"flush buffers", "remove lock file", "exit with error code 2"}))
+			})
+			It("runs the hooks after logging the error and before panicking in Fatal", func() {
+				gplog.RegisterFatalHook(func() {
+					calls = append(calls, "remove lock file")
+					Expect(string(logfile.Contents())).To(ContainSubstring("fatal error"))
+				})
+				func() {
+					defer func() {
+						calls = append(calls, fmt.Sprintf("panic: %v", recover()))
+					}()
+					gplog.Fatal(errors.New("fatal error"), "")
+				}()
+				Expect(calls).To(Equal([]string{"remove lock file", "panic: " + fatalExpected + "fatal error"}))
+			})
+			It("allows the hooks to log messages", func() {
+				gplog.RegisterFatalHook(func() { gplog.Info("Removing lock file") })
+				gplog.FatalWithoutPanic("fatal error")
+				Expect(string(stdout.Contents())).To(Equal(infoExpected + "Removing lock file\n"))
+			})
+			It("runs each hook only once", func() {
+				gplog.RegisterFatalHook(func() { calls = append(calls, "remove lock file") })
+				gplog.FatalWithoutPanic("fatal error")
+				gplog.FatalWithoutPanic("another fatal error")
+				Expect(calls).To(Equal([]string{"remove lock file", "exit with error code 2", "exit with error code 2"}))
+			})
+			It("runs the remaining hooks if a hook panics", func() {
+				gplog.RegisterFatalHook(func() { calls = append(calls, "remove lock file") })
+				gplog.RegisterFatalHook(func() { panic("hook failed") })
+				gplog.FatalWithoutPanic("fatal error")
+				Expect(calls).To(Equal([]string{"remove lock file", "exit with error code 2"}))
+				testhelper.ExpectRegexp(stderr, fatalExpected+"Fatal hook panicked: hook failed")
+			})
+			It("exits without running hooks if none are registered", func() {
+				gplog.FatalWithoutPanic("fatal error")
+				Expect(calls).To(Equal([]string{"exit with error code 2"}))
+			})
+		})
+		Describe("GetErrorCount and GetWarnCount", func() {
+			AfterEach(func() {
+				gplog.SetErrorCode(0)
+			})
+			It("starts at zero for a new logger", func() {
+				Expect(gplog.GetErrorCount()).To(Equal(0))
+				Expect(gplog.GetWarnCount()).To(Equal(0))
+			})
+			It("counts the errors and warnings logged at each level", func() {
+				gplog.SetVerbosity(gplog.LOGDEBUG)
+				gplog.Info("info")
+				gplog.Warn("warn 1")
+				gplog.Error("error 1")
+				gplog.Verbose("verbose")
+				gplog.Warn("warn 2")
+				gplog.Debug("debug")
+				gplog.Error("error 2")
+				gplog.WithField("segment", 1).Error("error 3")
+				gplog.LogAt(time.Date(2016, time.March, 4, 5, 6, 7, 0, time.UTC), gplog.LOGERROR, "replayed error")
+				gplog.LogAt(time.Date(2016, time.March, 4, 5, 6, 7, 0, time.UTC), gplog.LOGINFO, "replayed info")
+				Expect(gplog.GetErrorCount()).To(Equal(4))
+				Expect(gplog.GetWarnCount()).To(Equal(2))
+			})
+			It("counts messages that are suppressed as duplicates", func() {
+				gplog.SetDedupConsecutive(true)
+				defer gplog.SetDedupConsecutive(false)
+				gplog.Error("retry failed")
+				gplog.Error("retry failed")
+				gplog.Warn("retrying")
+				gplog.Warn("retrying")
+				gplog.Warn("retrying")
+				Expect(gplog.GetErrorCount()).To(Equal(2))
+				Expect(gplog.GetWarnCount()).To(Equal(3))
+			})
+			It("counts from zero again after ResetCounts", func() {
+				gplog.Error("error")
+				gplog.Warn("warn")
+				gplog.ResetCounts()
+				Expect(gplog.GetErrorCount()).To(Equal(0))
+				Expect(gplog.GetWarnCount()).To(Equal(0))
+				gplog.Warn("warn")
+				Expect(gplog.GetErrorCount()).To(Equal(0))
+				Expect(gplog.GetWarnCount()).To(Equal(1))
+			})
+			It("starts at zero when a new logger is set", func() {
+				gplog.Error("error")
+				stdout, stderr, logfile = testhelper.SetupTestLogger()
+				Expect(gplog.GetErrorCount()).To(Equal(0))
+			})
+		})
+		Describe("SetTimestampFormat", func() {
+			AfterEach(func() {
+				Expect(gplog.SetTimestampFormat(gplog.DefaultTimestampFormat)).To(Succeed())
+			})
+			It("uses the default timestamp format if none is set", func() {
+				gplog.Info("message")
+				Expect(string(stdout.Contents())).To(Equal("20170101:01:01:01 testProgram:testUser:testHost:000000-[INFO]:-message\n"))
+			})
+			It("uses a custom timestamp format in subsequent messages", func() {
+				Expect(gplog.SetTimestampFormat("2006-01-02 15:04:05.000")).To(Succeed())
+				gplog.Info("message")
+				Expect(string(stdout.Contents())).To(Equal("2017-01-01 01:01:01.000 testProgram:testUser:testHost:000000-[INFO]:-message\n"))
+			})
+			It("uses a custom timestamp format for messages logged with LogAt", func() {
+				Expect(gplog.SetTimestampFormat(time.RFC3339)).To(Succeed())
+				gplog.LogAt(time.Date(2016, time.March, 4, 5, 6, 7, 0, time.UTC), gplog.LOGINFO, "replayed")
+				Expect(string(stdout.Contents())).To(Equal("2016-03-04T05:06:07Z testProgram:testUser:testHost:000000-[INFO]:-replayed\n"))
+			})
+			It("does not change the timestamps written in JSON mode", func() {
+				Expect(gplog.SetTimestampFormat("2006-01-02")).To(Succeed())
+				gplog.SetLogFormat(gplog.FormatJSON)
+				defer gplog.SetLogFormat(gplog.FormatText)
+				gplog.Info("message")
+				entry := make(map[string]interface{})
+				Expect(json.Unmarshal(stdout.Contents(), &entry)).To(Succeed())
+				Expect(entry).To(HaveKeyWithValue("timestamp", time.Date(2017, time.January, 1, 1, 1, 1, 1, time.Local).Format(time.RFC3339Nano)))
+			})
+			DescribeTable("rejects a layout with no time elements", func(layout string) {
+				err := gplog.SetTimestampFormat(layout)
+				Expect(err).To(MatchError(fmt.Sprintf("Invalid timestamp format %q: the format contains no time elements", layout)))
+				gplog.Info("message")
+				Expect(string(stdout.Contents())).To(Equal(infoExpected + "message\n"))
+			},
+				Entry("empty layout", ""),
+				Entry("literal text", "timestamp"),
+				Entry("misspelled layout", "YYYY-MM-DD"),
+			)
+		})
+		Describe("WithFields", func() {
+			AfterEach(func() {
+				gplog.SetLogFormat(gplog.FormatText)
+				gplog.SetErrorCode(0)
+			})
+			It("appends the fields to each message as sorted key=value pairs", func() {
+				entry := gplog.WithFields(map[string]interface{}{"request_id": "abc123", "attempt": 2})
+				entry.Info("connecting")
+				entry.Warn("retrying")
+				entry.Error("failed")
+				Expect(string(stdout.Contents())).To(Equal(infoExpected + "connecting attempt=2 request_id=abc123\n" + warnExpected + "retrying attempt=2 request_id=abc123\n"))
+				Expect(string(stderr.Contents())).To(Equal(errorExpected + "failed attempt=2 request_id=abc123\n"))
+			})
+			It("quotes values that are empty or contain spaces", func() {
+				gplog.WithFields(map[string]interface{}{"table": "my table", "schema": ""}).Info("analyzing")
+				Expect(string(stdout.Contents())).To(Equal(infoExpected + `analyzing schema="" table="my table"` + "\n"))
+			})
+			It("writes the fields as additional keys in JSON mode", func() {
+				gplog.SetLogFormat(gplog.FormatJSON)
+				gplog.WithFields(map[string]interface{}{"request_id": "abc123", "attempt": 2, "cause": errors.New("timeout")}).Info("connecting")
+				entry := make(map[string]interface{})
+				Expect(json.Unmarshal(stdout.Contents(), &entry)).To(Succeed())
+				Expect(entry).To(HaveKeyWithValue("message", "connecting"))
+				Expect(entry).To(HaveKeyWithValue("request_id", "abc123"))
+				Expect(entry).To(HaveKeyWithValue("attempt", float64(2)))
+				Expect(entry).To(HaveKeyWithValue("cause", "timeout"))
+			})
+			It("does not let fields replace the standard JSON keys", func() {
+				gplog.SetLogFormat(gplog.FormatJSON)
+				gplog.WithField("level", "bogus").Info("connecting")
+				entry := make(map[string]interface{})
+				Expect(json.Unmarshal(stdout.Contents(), &entry)).To(Succeed())
+				Expect(entry).To(HaveKeyWithValue("level", "INFO"))
+			})
+			It("writes fields that cannot be marshaled to JSON as text", func() {
+				gplog.SetLogFormat(gplog.FormatJSON)
+				gplog.WithField("callback", make(chan int)).Info("connecting")
+				entry := make(map[string]interface{})
+				Expect(json.Unmarshal(stdout.Contents(), &entry)).To(Succeed())
+				Expect(entry).To(HaveKey("callback"))
+				Expect(entry).To(HaveKeyWithValue("message", "connecting"))
+			})
+			It("does not add fields to messages logged by the base logger", func() {
+				gplog.WithField("request_id", "abc123").Info("connecting")
+				gplog.Info("done")
+				Expect(string(stdout.Contents())).To(Equal(infoExpected + "connecting request_id=abc123\n" + infoExpected + "done\n"))
+			})
+			It("does not modify the entry it derives a new entry from", func() {
+				base := gplog.WithField("request_id", "abc123")
+				base.WithField("step", "restore").Info("derived")
+				base.WithField("request_id", "def456").Info("replaced")
+				base.Info("base")
+				Expect(string(stdout.Contents())).To(Equal(infoExpected + "derived request_id=abc123 step=restore\n" +
+					infoExpected + "replaced request_id=def456\n" + infoExpected + "base request_id=abc123\n"))
+			})
+			It("does not modify an entry when the map it was created from changes", func() {
+				fields := map[string]interface{}{"request_id": "abc123"}
+				entry := gplog.WithFields(fields)
+				fields["request_id"] = "def456"
+				entry.Info("connecting")
+				Expect(string(stdout.Contents())).To(Equal(infoExpected + "connecting request_id=abc123\n"))
+			})
+			It("includes the fields in a fatal error", func() {
+				defer testhelper.ShouldPanicWithMessage("failed request_id=abc123")
+				gplog.WithField("request_id", "abc123").Fatal(nil, "failed")
+			})
+			It("writes repeats of a message with different fields separately when deduplicating", func() {
+				gplog.SetDedupConsecutive(true)
+				defer gplog.SetDedupConsecutive(false)
+				gplog.WithField("segment", 0).Info("retrying")
+				gplog.WithField("segment", 0).Info("retrying")
+				gplog.WithField("segment", 1).Info("retrying")
+				Expect(string(stdout.Contents())).To(Equal(infoExpected + "retrying segment=0\n" +
+					infoExpected + "retrying (repeated 1 times) segment=0\n" + infoExpected + "retrying segment=1\n"))
+			})
+		})
 	})
 })
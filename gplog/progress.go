@@ -0,0 +1,64 @@
+package gplog
+
+/*
+ * This file contains structs and functions for logging the progress of long
+ * operations.
+ */
+
+import (
+	"sync"
+)
+
+/*
+ * A ProgressLogger counts the units of work done out of a known total, and
+ * logs "X of Y done (Z%)" at Info level each time the count reaches a multiple
+ * of everyN and when the work is complete, so that long operations report
+ * progress periodically without logging every unit.  If everyN is 0 or less,
+ * progress is only logged on completion.  Work done beyond the total is
+ * counted but not logged.  A ProgressLogger is safe to use from multiple
+ * goroutines.
+ */
+type ProgressLogger struct {
+	lock   sync.Mutex
+	total  int64
+	everyN int64
+	done   int64
+}
+
+func NewProgressLogger(total int64, everyN int64) *ProgressLogger {
+	return &ProgressLogger{total: total, everyN: everyN}
+}
+
+func (progress *ProgressLogger) Increment() {
+	progress.Add(1)
+}
+
+/*
+ * Add counts n more units of work as done.  If the count passes more than one
+ * multiple of everyN at once, progress is only logged once.
+ */
+func (progress *ProgressLogger) Add(n int64) {
+	progress.lock.Lock()
+	defer progress.lock.Unlock()
+	if n <= 0 || progress.done >= progress.total {
+		progress.done += n
+		return
+	}
+	previous := progress.done
+	progress.done += n
+	current := progress.done
+	if current > progress.total {
+		current = progress.total
+	}
+	passedInterval := progress.everyN > 0 && current/progress.everyN > previous/progress.everyN
+	if passedInterval || current == progress.total {
+		Info("%d of %d done (%d%%)", current, progress.total, current*100/progress.total)
+	}
+}
+
+// Done returns the number of units of work counted so far.
+func (progress *ProgressLogger) Done() int64 {
+	progress.lock.Lock()
+	defer progress.lock.Unlock()
+	return progress.done
+}
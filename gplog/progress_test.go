@@ -0,0 +1,116 @@
+package gplog_test
+
+import (
+	"fmt"
+	"os/user"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudberrydb/gp-common-go-libs/gplog"
+	"github.com/cloudberrydb/gp-common-go-libs/operating"
+	"github.com/cloudberrydb/gp-common-go-libs/testhelper"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("progress logger tests", func() {
+	var stdout *gbytes.Buffer
+	infoExpected := "20170101:01:01:01 testProgram:testUser:testHost:000000-[INFO]:-"
+
+	lines := func() []string {
+		contents := strings.TrimSuffix(string(stdout.Contents()), "\n")
+		if contents == "" {
+			return []string{}
+		}
+		return strings.Split(contents, "\n")
+	}
+
+	BeforeEach(func() {
+		operating.System.CurrentUser = func() (*user.User, error) { return &user.User{Username: "testUser", HomeDir: "testDir"}, nil }
+		operating.System.Getpid = func() int { return 0 }
+		operating.System.Hostname = func() (string, error) { return "testHost", nil }
+		operating.System.Now = func() time.Time { return time.Date(2017, time.January, 1, 1, 1, 1, 1, time.Local) }
+		stdout, _, _ = testhelper.SetupTestLogger()
+	})
+	AfterEach(func() {
+		operating.System = operating.InitializeSystemFunctions()
+	})
+
+	It("logs progress every N units", func() {
+		progress := gplog.NewProgressLogger(100, 25)
+		for i := 0; i < 100; i++ {
+			progress.Increment()
+		}
+		Expect(lines()).To(Equal([]string{
+			infoExpected + "25 of 100 done (25%)",
+			infoExpected + "50 of 100 done (50%)",
+			infoExpected + "75 of 100 done (75%)",
+			infoExpected + "100 of 100 done (100%)",
+		}))
+	})
+	It("logs progress on completion if the total is not a multiple of N", func() {
+		progress := gplog.NewProgressLogger(10, 4)
+		for i := 0; i < 10; i++ {
+			progress.Increment()
+		}
+		Expect(lines()).To(Equal([]string{
+			infoExpected + "4 of 10 done (40%)",
+			infoExpected + "8 of 10 done (80%)",
+			infoExpected + "10 of 10 done (100%)",
+		}))
+	})
+	It("does not log progress before the first interval", func() {
+		progress := gplog.NewProgressLogger(10, 4)
+		progress.Increment()
+		progress.Increment()
+		progress.Increment()
+		Expect(lines()).To(BeEmpty())
+		Expect(progress.Done()).To(Equal(int64(3)))
+	})
+	It("logs progress once when an addition passes several intervals", func() {
+		progress := gplog.NewProgressLogger(100, 10)
+		progress.Add(35)
+		progress.Add(5)
+		Expect(lines()).To(Equal([]string{
+			infoExpected + "35 of 100 done (35%)",
+			infoExpected + "40 of 100 done (40%)",
+		}))
+	})
+	It("only logs progress on completion if N is 0", func() {
+		progress := gplog.NewProgressLogger(5, 0)
+		for i := 0; i < 5; i++ {
+			progress.Increment()
+		}
+		Expect(lines()).To(Equal([]string{infoExpected + "5 of 5 done (100%)"}))
+	})
+	It("does not log work done beyond the total", func() {
+		progress := gplog.NewProgressLogger(4, 2)
+		for i := 0; i < 8; i++ {
+			progress.Increment()
+		}
+		Expect(lines()).To(HaveLen(2))
+		Expect(progress.Done()).To(Equal(int64(8)))
+	})
+	It("logs the expected number of lines when incremented concurrently", func() {
+		progress := gplog.NewProgressLogger(1000, 100)
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					progress.Increment()
+				}
+			}()
+		}
+		wg.Wait()
+		expected := make([]string, 0)
+		for done := 100; done <= 1000; done += 100 {
+			expected = append(expected, fmt.Sprintf("%s%d of 1000 done (%d%%)", infoExpected, done, done/10))
+		}
+		Expect(lines()).To(Equal(expected))
+		Expect(progress.Done()).To(Equal(int64(1000)))
+	})
+})
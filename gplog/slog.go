@@ -0,0 +1,140 @@
+//go:build go1.21
+
+package gplog
+
+/*
+ * This file contains an adapter for writing log/slog records through gplog.
+ */
+
+import (
+	"context"
+	"log/slog"
+)
+
+func init() {
+	// Report the caller of a slog.Logger method rather than slog itself
+	loggingFunctionPrefixes = append(loggingFunctionPrefixes, "log/slog.")
+}
+
+/*
+ * NewSlogHandler returns a slog.Handler that writes each record through the
+ * current logger, so that a slog.Logger shares gplog's destinations, format,
+ * and verbosity settings.  Record levels map to the gplog output functions as
+ * follows:
+ * - LevelError and above: Error()
+ * - LevelWarn up to LevelError: Warn()
+ * - LevelInfo up to LevelWarn: Info()
+ * - Above LevelDebug up to LevelInfo: Verbose()
+ * - LevelDebug and below: Debug()
+ *
+ * Attributes are written as fields, as with WithFields, with the keys of
+ * attributes in groups qualified by the group names, e.g. "request.id".  The
+ * record's own timestamp is not used; messages are stamped as they are written.
+ */
+func NewSlogHandler() slog.Handler {
+	return &slogHandler{fields: map[string]interface{}{}}
+}
+
+type slogHandler struct {
+	fields map[string]interface{}
+	// The qualifying prefix for the keys of subsequent attributes, e.g. "request."
+	groupPrefix string
+}
+
+func slogLevelToLogLevel(level slog.Level) int {
+	switch {
+	case level >= slog.LevelWarn:
+		return LOGERROR
+	case level >= slog.LevelInfo:
+		return LOGINFO
+	case level > slog.LevelDebug:
+		return LOGVERBOSE
+	default:
+		return LOGDEBUG
+	}
+}
+
+func (handler *slogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	logLevel := slogLevelToLogLevel(level)
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	if logLevel <= logger.shellVerbosity || logLevel <= logger.fileVerbosity {
+		return true
+	}
+	for _, logWriter := range logger.logWriters {
+		if logLevel <= logWriter.verbosity {
+			return true
+		}
+	}
+	return false
+}
+
+func (handler *slogHandler) Handle(_ context.Context, record slog.Record) error {
+	fields := make(map[string]interface{}, len(handler.fields)+record.NumAttrs())
+	for key, value := range handler.fields {
+		fields[key] = value
+	}
+	record.Attrs(func(attr slog.Attr) bool {
+		addSlogAttr(fields, handler.groupPrefix, attr)
+		return true
+	})
+	entry := &Entry{fields: fields}
+
+	switch {
+	case record.Level >= slog.LevelError:
+		logError(entry, "%s", record.Message)
+	case record.Level >= slog.LevelWarn:
+		warn(entry, "%s", record.Message)
+	case record.Level >= slog.LevelInfo:
+		info(entry, "%s", record.Message)
+	case record.Level > slog.LevelDebug:
+		verbose(entry, "%s", record.Message)
+	default:
+		debug(entry, "%s", record.Message)
+	}
+	return nil
+}
+
+func (handler *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	fields := make(map[string]interface{}, len(handler.fields)+len(attrs))
+	for key, value := range handler.fields {
+		fields[key] = value
+	}
+	for _, attr := range attrs {
+		addSlogAttr(fields, handler.groupPrefix, attr)
+	}
+	return &slogHandler{fields: fields, groupPrefix: handler.groupPrefix}
+}
+
+func (handler *slogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return handler
+	}
+	return &slogHandler{fields: handler.fields, groupPrefix: handler.groupPrefix + name + "."}
+}
+
+/*
+ * Adds an attribute to fields under its qualified key, flattening groups into
+ * one field per attribute and ignoring empty attributes, as slog.Handler
+ * implementations are expected to.
+ */
+func addSlogAttr(fields map[string]interface{}, prefix string, attr slog.Attr) {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+	if attr.Value.Kind() == slog.KindGroup {
+		groupPrefix := prefix
+		if attr.Key != "" {
+			groupPrefix = prefix + attr.Key + "."
+		}
+		for _, groupAttr := range attr.Value.Group() {
+			addSlogAttr(fields, groupPrefix, groupAttr)
+		}
+		return
+	}
+	if attr.Key == "" {
+		return
+	}
+	fields[prefix+attr.Key] = attr.Value.Any()
+}
@@ -0,0 +1,128 @@
+//go:build go1.21
+
+package gplog_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/user"
+	"runtime"
+	"time"
+
+	"github.com/cloudberrydb/gp-common-go-libs/gplog"
+	"github.com/cloudberrydb/gp-common-go-libs/operating"
+	"github.com/cloudberrydb/gp-common-go-libs/testhelper"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("slog handler tests", func() {
+	var (
+		stdout  *gbytes.Buffer
+		stderr  *gbytes.Buffer
+		logfile *gbytes.Buffer
+		logger  *slog.Logger
+	)
+	patternExpected := "20170101:01:01:01 testProgram:testUser:testHost:000000-[%s]:-"
+	infoExpected := fmt.Sprintf(patternExpected, "INFO")
+	warnExpected := fmt.Sprintf(patternExpected, "WARNING")
+	debugExpected := fmt.Sprintf(patternExpected, "DEBUG")
+	errorExpected := fmt.Sprintf(patternExpected, "ERROR")
+
+	BeforeEach(func() {
+		operating.System.CurrentUser = func() (*user.User, error) { return &user.User{Username: "testUser", HomeDir: "testDir"}, nil }
+		operating.System.Getpid = func() int { return 0 }
+		operating.System.Hostname = func() (string, error) { return "testHost", nil }
+		operating.System.Now = func() time.Time { return time.Date(2017, time.January, 1, 1, 1, 1, 1, time.Local) }
+		stdout, stderr, logfile = testhelper.SetupTestLogger()
+		logger = slog.New(gplog.NewSlogHandler())
+	})
+	AfterEach(func() {
+		operating.System = operating.InitializeSystemFunctions()
+		gplog.SetErrorCode(0)
+	})
+
+	It("writes each record through the gplog function for its level", func() {
+		gplog.SetVerbosity(gplog.LOGDEBUG)
+		logger.Info("info")
+		logger.Warn("warn")
+		logger.Log(context.Background(), slog.LevelDebug+2, "verbose")
+		logger.Debug("debug")
+		logger.Error("error")
+		Expect(string(stdout.Contents())).To(Equal(infoExpected + "info\n" + warnExpected + "warn\n" +
+			debugExpected + "verbose\n" + debugExpected + "debug\n"))
+		Expect(string(stderr.Contents())).To(Equal(errorExpected + "error\n"))
+		Expect(gplog.GetErrorCode()).To(Equal(1))
+	})
+	It("respects the gplog verbosity settings", func() {
+		gplog.SetVerbosity(gplog.LOGINFO)
+		logger.Debug("debug")
+		Expect(string(stdout.Contents())).To(BeEmpty())
+		Expect(string(logfile.Contents())).To(Equal(debugExpected + "debug\n"))
+	})
+	It("reports a level as disabled only if no destination would write it", func() {
+		gplog.SetVerbosity(gplog.LOGINFO)
+		Expect(logger.Enabled(context.Background(), slog.LevelDebug)).To(BeTrue())
+		gplog.SetLogFileVerbosity(gplog.LOGINFO)
+		defer gplog.SetLogFileVerbosity(gplog.LOGDEBUG)
+		Expect(logger.Enabled(context.Background(), slog.LevelDebug)).To(BeFalse())
+		Expect(logger.Enabled(context.Background(), slog.LevelInfo)).To(BeTrue())
+		Expect(logger.Enabled(context.Background(), slog.LevelWarn)).To(BeTrue())
+	})
+	It("writes the attributes of a record as fields", func() {
+		logger.Info("connecting", "host", "localhost", "port", 5432)
+		Expect(string(stdout.Contents())).To(Equal(infoExpected + "connecting host=localhost port=5432\n"))
+	})
+	It("preserves attributes added with With", func() {
+		requestLogger := logger.With("request_id", "abc123")
+		requestLogger.Info("connecting", "attempt", 1)
+		logger.Info("unrelated")
+		Expect(string(stdout.Contents())).To(Equal(infoExpected + "connecting attempt=1 request_id=abc123\n" + infoExpected + "unrelated\n"))
+	})
+	It("qualifies the keys of attributes in groups", func() {
+		groupLogger := logger.WithGroup("request").With("id", "abc123").WithGroup("db")
+		groupLogger.Info("connecting", "host", "localhost", slog.Group("retry", "attempt", 2))
+		Expect(string(stdout.Contents())).To(Equal(infoExpected + "connecting request.db.host=localhost request.db.retry.attempt=2 request.id=abc123\n"))
+	})
+	It("ignores empty attributes and groups", func() {
+		logger.WithGroup("").Info("connecting", slog.Attr{}, slog.Group("empty"), slog.Group("", "inline", true))
+		Expect(string(stdout.Contents())).To(Equal(infoExpected + "connecting inline=true\n"))
+	})
+	It("resolves attribute values that implement slog.LogValuer", func() {
+		logger.Info("connecting", "password", redactedValue("secret"))
+		Expect(string(stdout.Contents())).To(Equal(infoExpected + "connecting password=REDACTED\n"))
+	})
+	It("writes the attributes as JSON keys in JSON mode", func() {
+		gplog.SetLogFormat(gplog.FormatJSON)
+		defer gplog.SetLogFormat(gplog.FormatText)
+		logger.WithGroup("request").Error("failed", "id", "abc123", "err", errors.New("timeout"))
+		entry := make(map[string]interface{})
+		Expect(json.Unmarshal(stderr.Contents(), &entry)).To(Succeed())
+		Expect(entry).To(HaveKeyWithValue("message", "failed"))
+		Expect(entry).To(HaveKeyWithValue("level", "ERROR"))
+		Expect(entry).To(HaveKeyWithValue("request.id", "abc123"))
+		Expect(entry).To(HaveKeyWithValue("request.err", "timeout"))
+	})
+	It("reports the caller of the slog.Logger method when including the caller", func() {
+		gplog.SetVerbosity(gplog.LOGDEBUG)
+		gplog.SetIncludeCaller(true)
+		defer gplog.SetIncludeCaller(false)
+		_, _, line, _ := runtime.Caller(0)
+		logger.Debug("debug")
+		Expect(string(stdout.Contents())).To(Equal(fmt.Sprintf("%sslog_test.go:%d: debug\n", debugExpected, line+1)))
+	})
+	It("writes a message containing format verbs as is", func() {
+		logger.Info("100% done")
+		Expect(string(stdout.Contents())).To(Equal(infoExpected + "100% done\n"))
+	})
+})
+
+type redactedValue string
+
+func (redactedValue) LogValue() slog.Value {
+	return slog.StringValue("REDACTED")
+}
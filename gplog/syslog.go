@@ -0,0 +1,58 @@
+//go:build !windows && !plan9
+
+package gplog
+
+/*
+ * This file contains functions for writing log messages to syslog, which is
+ * not available on Windows or Plan 9.
+ */
+
+import (
+	"log/syslog"
+
+	"github.com/pkg/errors"
+)
+
+/*
+ * AddSyslogWriter connects to a syslog daemon and writes every subsequent
+ * message to it in addition to the logger's other destinations, with the
+ * level of the message as its syslog severity and the given tag.  The network
+ * and address are as for syslog.Dial; an empty network connects to the local
+ * syslog daemon.  Messages at every verbosity are sent, so that the syslog
+ * configuration decides which severities to keep.
+ *
+ * If syslog cannot be reached, AddSyslogWriter returns an error and logging
+ * continues as before.  Messages that cannot be written to syslog later on
+ * are dropped without affecting the other destinations.
+ */
+func AddSyslogWriter(network string, addr string, tag string) error {
+	writer, err := syslog.Dial(network, addr, syslog.LOG_USER|syslog.LOG_INFO, tag)
+	if err != nil {
+		return errors.Wrapf(err, "Cannot connect to syslog")
+	}
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	logger.logWriters = append(logger.logWriters, logWriter{
+		writer:    writer,
+		verbosity: LOGDEBUG,
+		writeLevel: func(levelName string, message string) error {
+			return writeSyslogMessage(writer, levelName, message)
+		},
+	})
+	return nil
+}
+
+func writeSyslogMessage(writer *syslog.Writer, levelName string, message string) error {
+	switch levelName {
+	case "CRITICAL":
+		return writer.Crit(message)
+	case "ERROR":
+		return writer.Err(message)
+	case "WARNING":
+		return writer.Warning(message)
+	case "INFO":
+		return writer.Info(message)
+	default:
+		return writer.Debug(message)
+	}
+}
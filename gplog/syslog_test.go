@@ -0,0 +1,104 @@
+//go:build !windows && !plan9
+
+package gplog_test
+
+import (
+	"net"
+	"os/user"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/cloudberrydb/gp-common-go-libs/gplog"
+	"github.com/cloudberrydb/gp-common-go-libs/operating"
+	"github.com/cloudberrydb/gp-common-go-libs/testhelper"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("syslog writer tests", func() {
+	var (
+		stdout   *gbytes.Buffer
+		logfile  *gbytes.Buffer
+		listener net.PacketConn
+	)
+	// Matches the priority, tag, and message of an RFC 3164 packet from log/syslog
+	packetPattern := regexp.MustCompile(`^<(\d+)>\S+ \S+ (\S+)\[\d+\]: (.*)\n$`)
+
+	readPacket := func() (severity int, tag string, message string) {
+		buffer := make([]byte, 4096)
+		Expect(listener.SetReadDeadline(time.Now().Add(5 * time.Second))).To(Succeed())
+		n, _, err := listener.ReadFrom(buffer)
+		Expect(err).ToNot(HaveOccurred())
+		matches := packetPattern.FindStringSubmatch(string(buffer[:n]))
+		Expect(matches).ToNot(BeNil(), string(buffer[:n]))
+		priority, err := strconv.Atoi(matches[1])
+		Expect(err).ToNot(HaveOccurred())
+		// The facility is LOG_USER, and the severity is in the low three bits
+		Expect(priority >> 3).To(Equal(1))
+		return priority & 7, matches[2], matches[3]
+	}
+
+	BeforeEach(func() {
+		operating.System.CurrentUser = func() (*user.User, error) { return &user.User{Username: "testUser", HomeDir: "testDir"}, nil }
+		operating.System.Getpid = func() int { return 0 }
+		operating.System.Hostname = func() (string, error) { return "testHost", nil }
+		operating.System.Now = func() time.Time { return time.Date(2017, time.January, 1, 1, 1, 1, 1, time.Local) }
+		stdout, _, logfile = testhelper.SetupTestLogger()
+		var err error
+		listener, err = net.ListenPacket("udp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		_ = listener.Close()
+		operating.System = operating.InitializeSystemFunctions()
+		gplog.SetErrorCode(0)
+	})
+
+	It("writes each message with the syslog severity for its level", func() {
+		Expect(gplog.AddSyslogWriter("udp", listener.LocalAddr().String(), "testProgram")).To(Succeed())
+		gplog.Error("error")
+		gplog.Warn("warn")
+		gplog.Info("info")
+		gplog.Verbose("verbose")
+		gplog.Debug("debug")
+		gplog.SetExitFunc(func() {})
+		gplog.FatalWithoutPanic("fatal")
+
+		expected := []struct {
+			severity int
+			message  string
+		}{
+			{3, "20170101:01:01:01 testProgram:testUser:testHost:000000-[ERROR]:-error"},
+			{4, "20170101:01:01:01 testProgram:testUser:testHost:000000-[WARNING]:-warn"},
+			{6, "20170101:01:01:01 testProgram:testUser:testHost:000000-[INFO]:-info"},
+			{7, "20170101:01:01:01 testProgram:testUser:testHost:000000-[DEBUG]:-verbose"},
+			{7, "20170101:01:01:01 testProgram:testUser:testHost:000000-[DEBUG]:-debug"},
+			{2, "20170101:01:01:01 testProgram:testUser:testHost:000000-[CRITICAL]:-fatal"},
+		}
+		for _, packet := range expected {
+			severity, tag, message := readPacket()
+			Expect(tag).To(Equal("testProgram"))
+			Expect(message).To(Equal(packet.message))
+			Expect(severity).To(Equal(packet.severity))
+		}
+	})
+	It("writes messages regardless of the shell verbosity", func() {
+		Expect(gplog.AddSyslogWriter("udp", listener.LocalAddr().String(), "testProgram")).To(Succeed())
+		gplog.SetVerbosity(gplog.LOGERROR)
+		gplog.Debug("debug")
+		severity, _, message := readPacket()
+		Expect(severity).To(Equal(7))
+		Expect(message).To(HaveSuffix("-[DEBUG]:-debug"))
+		Expect(string(stdout.Contents())).To(BeEmpty())
+	})
+	It("returns an error and keeps logging to the other destinations if syslog cannot be reached", func() {
+		err := gplog.AddSyslogWriter("unknownnetwork", "localhost:514", "testProgram")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(HavePrefix("Cannot connect to syslog: "))
+		gplog.Info("info")
+		Expect(string(stdout.Contents())).To(HaveSuffix("-[INFO]:-info\n"))
+		Expect(string(logfile.Contents())).To(HaveSuffix("-[INFO]:-info\n"))
+	})
+})
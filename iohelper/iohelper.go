@@ -10,6 +10,7 @@ import (
 	"bufio"
 	"io"
 	"os"
+	"path/filepath"
 
 	"github.com/cloudberrydb/gp-common-go-libs/gplog"
 	"github.com/cloudberrydb/gp-common-go-libs/operating"
@@ -87,6 +88,31 @@ func FileExistsAndIsReadable(filename string) bool {
 	return false
 }
 
+/*
+ * PathExists reports whether anything exists at the path, including a socket
+ * or a symbolic link whose target does not exist.  Errors other than the path
+ * not existing, such as permission errors, are returned.
+ */
+func PathExists(path string) (bool, error) {
+	_, err := operating.System.Lstat(path)
+	if err == nil {
+		return true, nil
+	}
+	if operating.System.IsNotExist(err) {
+		return false, nil
+	}
+	return false, errors.Errorf("Unable to check whether path exists: %s", err)
+}
+
+// RemoveIfExists removes a file or empty directory, doing nothing if it does not exist.
+func RemoveIfExists(path string) error {
+	err := operating.System.Remove(path)
+	if err != nil && !operating.System.IsNotExist(err) {
+		return errors.Errorf("Unable to remove path: %s", err)
+	}
+	return nil
+}
+
 func ReadLinesFromFile(filename string) ([]string, error) {
 	fileHandle, err := OpenFileForReading(filename)
 	if err != nil {
@@ -110,3 +136,46 @@ func MustReadLinesFromFile(filename string) []string {
 	gplog.FatalOnError(err)
 	return contents
 }
+
+/*
+ * WriteFile writes data to a file with standard 644 permissions, replacing any
+ * existing content and first creating any parent directories that don't exist
+ * yet.
+ */
+func WriteFile(filename string, data []byte) error {
+	err := operating.System.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		return errors.Errorf("Unable to create directory for file: %s", err)
+	}
+	err = operating.System.WriteFile(filename, data, 0644)
+	if err != nil {
+		return errors.Errorf("Unable to write file: %s", err)
+	}
+	return nil
+}
+
+func MustWriteFile(filename string, data []byte) {
+	err := WriteFile(filename, data)
+	gplog.FatalOnError(err)
+}
+
+/*
+ * CreateTempFile creates a new file in System.TempDir() whose name starts with
+ * the pattern, replacing a "*" in the pattern with a random string if there is
+ * one, and opens it for reading and writing.  The caller is responsible for
+ * closing the file and removing it with System.Remove once it is no longer
+ * needed.
+ */
+func CreateTempFile(pattern string) (*os.File, error) {
+	file, err := operating.System.TempFile(operating.System.TempDir(), pattern)
+	if err != nil {
+		return nil, errors.Errorf("Unable to create temporary file: %s", err)
+	}
+	return file, nil
+}
+
+func MustCreateTempFile(pattern string) *os.File {
+	file, err := CreateTempFile(pattern)
+	gplog.FatalOnError(err)
+	return file
+}
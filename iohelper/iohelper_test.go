@@ -4,6 +4,7 @@ import (
 	"errors"
 	"io"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/cloudberrydb/gp-common-go-libs/iohelper"
@@ -167,6 +168,70 @@ var _ = Describe("operating/io tests", func() {
 			Expect(check).To(BeFalse())
 		})
 	})
+	Describe("PathExists", func() {
+		AfterEach(func() {
+			operating.System = operating.InitializeSystemFunctions()
+		})
+		It("returns true if the path exists", func() {
+			operating.System.Lstat = func(name string) (os.FileInfo, error) { return nil, nil }
+			exists, err := iohelper.PathExists("/tmp/.s.PGSQL.5432")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(exists).To(BeTrue())
+		})
+		It("returns false if the path does not exist", func() {
+			operating.System.Lstat = func(name string) (os.FileInfo, error) {
+				return nil, &os.PathError{Op: "lstat", Path: name, Err: os.ErrNotExist}
+			}
+			exists, err := iohelper.PathExists("/tmp/.s.PGSQL.5432")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(exists).To(BeFalse())
+		})
+		It("returns an error if the path cannot be checked", func() {
+			operating.System.Lstat = func(name string) (os.FileInfo, error) {
+				return nil, errors.New("Permission denied")
+			}
+			exists, err := iohelper.PathExists("/tmp/.s.PGSQL.5432")
+			Expect(err).To(MatchError("Unable to check whether path exists: Permission denied"))
+			Expect(exists).To(BeFalse())
+		})
+		It("does not follow a symbolic link by default", func() {
+			dir, err := os.MkdirTemp("", "gp_common_go_libs_test")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+			link := filepath.Join(dir, "link")
+			Expect(os.Symlink(filepath.Join(dir, "nonexistent"), link)).To(Succeed())
+			exists, err := iohelper.PathExists(link)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(exists).To(BeTrue())
+		})
+	})
+	Describe("RemoveIfExists", func() {
+		AfterEach(func() {
+			operating.System = operating.InitializeSystemFunctions()
+		})
+		It("removes the path", func() {
+			var removed string
+			operating.System.Remove = func(name string) error {
+				removed = name
+				return nil
+			}
+			Expect(iohelper.RemoveIfExists("/tmp/backup.lock")).To(Succeed())
+			Expect(removed).To(Equal("/tmp/backup.lock"))
+		})
+		It("does nothing if the path does not exist", func() {
+			operating.System.Remove = func(name string) error {
+				return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+			}
+			Expect(iohelper.RemoveIfExists("/tmp/backup.lock")).To(Succeed())
+		})
+		It("returns an error if the path cannot be removed", func() {
+			operating.System.Remove = func(name string) error {
+				return errors.New("Permission denied")
+			}
+			err := iohelper.RemoveIfExists("/tmp/backup.lock")
+			Expect(err).To(MatchError("Unable to remove path: Permission denied"))
+		})
+	})
 	Describe("Reading file contents", func() {
 		fileContents := `public.foo
 public."bar%baz"`
@@ -225,6 +290,127 @@ public."bar%baz"`
 			})
 		})
 	})
+	Describe("Writing file contents", func() {
+		var (
+			files       map[string][]byte
+			directories map[string]bool
+		)
+		errWriteFile := errors.New("Unable to write file: Permission denied")
+		errCreateDir := errors.New("Unable to create directory for file: Permission denied")
+
+		BeforeEach(func() {
+			files = make(map[string][]byte)
+			directories = make(map[string]bool)
+			operating.System.MkdirAll = func(path string, perm os.FileMode) error {
+				directories[path] = true
+				return nil
+			}
+			operating.System.WriteFile = func(filename string, data []byte, perm os.FileMode) error {
+				if !directories[filepath.Dir(filename)] {
+					return os.ErrNotExist
+				}
+				files[filename] = data
+				return nil
+			}
+			operating.System.ReadFile = func(filename string) ([]byte, error) {
+				data, ok := files[filename]
+				if !ok {
+					return nil, os.ErrNotExist
+				}
+				return data, nil
+			}
+		})
+		AfterEach(func() {
+			operating.System = operating.InitializeSystemFunctions()
+		})
+
+		Describe("WriteFile", func() {
+			It("creates the parent directory and writes the file", func() {
+				err := iohelper.WriteFile("/tmp/config/gpbackup.conf", []byte("compression: true\n"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(directories).To(HaveKey("/tmp/config"))
+				contents, err := operating.System.ReadFile("/tmp/config/gpbackup.conf")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(contents)).To(Equal("compression: true\n"))
+			})
+			It("replaces any existing content", func() {
+				Expect(iohelper.WriteFile("/tmp/config/gpbackup.conf", []byte("compression: true\n"))).To(Succeed())
+				Expect(iohelper.WriteFile("/tmp/config/gpbackup.conf", []byte("compression: false\n"))).To(Succeed())
+				Expect(files).To(HaveLen(1))
+				Expect(string(files["/tmp/config/gpbackup.conf"])).To(Equal("compression: false\n"))
+			})
+			It("returns an error if the directory cannot be created", func() {
+				operating.System.MkdirAll = func(path string, perm os.FileMode) error {
+					return errors.New("Permission denied")
+				}
+				err := iohelper.WriteFile("/tmp/config/gpbackup.conf", []byte("compression: true\n"))
+				Expect(err.Error()).To(Equal(errCreateDir.Error()))
+				Expect(files).To(BeEmpty())
+			})
+			It("returns an error if the file cannot be written", func() {
+				operating.System.WriteFile = func(filename string, data []byte, perm os.FileMode) error {
+					return errors.New("Permission denied")
+				}
+				err := iohelper.WriteFile("/tmp/config/gpbackup.conf", []byte("compression: true\n"))
+				Expect(err.Error()).To(Equal(errWriteFile.Error()))
+			})
+		})
+		Describe("CreateTempFile", func() {
+			It("creates the file in the temporary directory", func() {
+				var passedDir, passedPattern string
+				operating.System.TempDir = func() string { return "/tmp/staging" }
+				operating.System.TempFile = func(dir, pattern string) (*os.File, error) {
+					passedDir, passedPattern = dir, pattern
+					return os.Stdout, nil
+				}
+				file, err := iohelper.CreateTempFile("backup_*.tmp")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(file).To(Equal(os.Stdout))
+				Expect(passedDir).To(Equal("/tmp/staging"))
+				Expect(passedPattern).To(Equal("backup_*.tmp"))
+			})
+			It("returns an error if one is generated", func() {
+				operating.System.TempFile = func(dir, pattern string) (*os.File, error) {
+					return nil, errors.New("Permission denied")
+				}
+				file, err := iohelper.CreateTempFile("backup_*.tmp")
+				Expect(err).To(MatchError("Unable to create temporary file: Permission denied"))
+				Expect(file).To(BeNil())
+			})
+			It("creates a real file that the caller must remove by default", func() {
+				file, err := iohelper.CreateTempFile("gp_common_go_libs_test_*")
+				Expect(err).ToNot(HaveOccurred())
+				defer os.Remove(file.Name())
+				Expect(file.Close()).To(Succeed())
+				Expect(filepath.Dir(file.Name())).To(Equal(filepath.Clean(os.TempDir())))
+				Expect(filepath.Base(file.Name())).To(HavePrefix("gp_common_go_libs_test_"))
+				_, err = os.Stat(file.Name())
+				Expect(err).ToNot(HaveOccurred())
+			})
+		})
+		Describe("MustCreateTempFile", func() {
+			It("panics on error", func() {
+				operating.System.TempFile = func(dir, pattern string) (*os.File, error) {
+					return nil, errors.New("Permission denied")
+				}
+				defer testhelper.ShouldPanicWithMessage("Unable to create temporary file: Permission denied")
+				iohelper.MustCreateTempFile("backup_*.tmp")
+			})
+		})
+		Describe("MustWriteFile", func() {
+			It("writes the file", func() {
+				iohelper.MustWriteFile("/tmp/config/gpbackup.conf", []byte("compression: true\n"))
+				Expect(string(files["/tmp/config/gpbackup.conf"])).To(Equal("compression: true\n"))
+			})
+			It("panics on error", func() {
+				operating.System.WriteFile = func(filename string, data []byte, perm os.FileMode) error {
+					return errors.New("Permission denied")
+				}
+				defer testhelper.ShouldPanicWithMessage(errWriteFile.Error())
+				iohelper.MustWriteFile("/tmp/config/gpbackup.conf", []byte("compression: true\n"))
+			})
+		})
+	})
 })
 
 type FailsClosing struct{}
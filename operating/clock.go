@@ -0,0 +1,103 @@
+package operating
+
+/*
+ * This file contains a fake clock for testing code that waits or measures
+ * elapsed time, such as retry and timeout logic, without real delays.
+ */
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+ * A FakeClock reports a time that only changes when the test advances it.
+ * Its Sleep function advances the clock by the requested duration and returns
+ * immediately, so code that sleeps between retries sees the time it expects to
+ * have passed, and the channels returned by its After function receive once
+ * the clock has been advanced past their deadline, so that tests can trigger
+ * periodic work in another goroutine.  A FakeClock is safe to use from
+ * multiple goroutines, though concurrent calls to Sleep each advance the
+ * clock in turn.
+ */
+type FakeClock struct {
+	lock    sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	deadline time.Time
+	channel  chan time.Time
+}
+
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+func (clock *FakeClock) Now() time.Time {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+	return clock.now
+}
+
+func (clock *FakeClock) Advance(d time.Duration) {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+	clock.now = clock.now.Add(d)
+	remaining := clock.waiters[:0]
+	for _, waiter := range clock.waiters {
+		if waiter.deadline.After(clock.now) {
+			remaining = append(remaining, waiter)
+		} else {
+			waiter.channel <- clock.now
+		}
+	}
+	clock.waiters = remaining
+}
+
+/*
+ * After returns a channel that receives the time once the clock has been
+ * advanced by at least d, or immediately if d <= 0, as with time.After.
+ */
+func (clock *FakeClock) After(d time.Duration) <-chan time.Time {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+	channel := make(chan time.Time, 1)
+	if d <= 0 {
+		channel <- clock.now
+	} else {
+		clock.waiters = append(clock.waiters, fakeWaiter{deadline: clock.now.Add(d), channel: channel})
+	}
+	return channel
+}
+
+/*
+ * NumWaiters returns the number of channels returned by After that have not
+ * yet received, so that a test can wait for another goroutine to start
+ * waiting before advancing the clock.
+ */
+func (clock *FakeClock) NumWaiters() int {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+	return len(clock.waiters)
+}
+
+// Sleep advances the clock by d, as time.Sleep does nothing for d <= 0.
+func (clock *FakeClock) Sleep(d time.Duration) {
+	if d > 0 {
+		clock.Advance(d)
+	}
+}
+
+/*
+ * Install replaces System.Now, System.Sleep, and System.After with the clock's
+ * functions.
+ * Tests can restore the real clock by reinitializing System with
+ * InitializeSystemFunctions.
+ */
+func (clock *FakeClock) Install() {
+	System.Now = clock.Now
+	System.Sleep = clock.Sleep
+	System.After = clock.After
+}
@@ -0,0 +1,93 @@
+package operating_test
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cloudberrydb/gp-common-go-libs/operating"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("operating/clock tests", func() {
+	start := time.Date(2017, time.January, 1, 1, 1, 1, 1, time.Local)
+	var clock *operating.FakeClock
+
+	BeforeEach(func() {
+		clock = operating.NewFakeClock(start)
+	})
+	AfterEach(func() {
+		operating.System = operating.InitializeSystemFunctions()
+	})
+
+	Describe("FakeClock", func() {
+		It("reports the start time until it is advanced", func() {
+			Expect(clock.Now()).To(Equal(start))
+			Expect(clock.Now()).To(Equal(start))
+		})
+		It("reports the advanced time", func() {
+			clock.Advance(time.Minute)
+			clock.Advance(30 * time.Second)
+			Expect(clock.Now()).To(Equal(start.Add(90 * time.Second)))
+		})
+		It("advances the clock on Sleep and returns immediately", func() {
+			realStart := time.Now()
+			clock.Sleep(time.Hour)
+			Expect(time.Since(realStart)).To(BeNumerically("<", time.Second))
+			Expect(clock.Now()).To(Equal(start.Add(time.Hour)))
+		})
+		It("does not move the clock backwards on a negative Sleep", func() {
+			clock.Sleep(-time.Hour)
+			Expect(clock.Now()).To(Equal(start))
+		})
+		It("accumulates concurrent advances", func() {
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					clock.Sleep(time.Second)
+				}()
+			}
+			wg.Wait()
+			Expect(clock.Now()).To(Equal(start.Add(10 * time.Second)))
+		})
+	})
+	Describe("FakeClock.After", func() {
+		It("receives once the clock is advanced past the deadline", func() {
+			channel := clock.After(time.Minute)
+			Expect(clock.NumWaiters()).To(Equal(1))
+			clock.Advance(30 * time.Second)
+			Consistently(channel, "10ms").ShouldNot(Receive())
+			clock.Advance(30 * time.Second)
+			Expect(channel).To(Receive(Equal(start.Add(time.Minute))))
+			Expect(clock.NumWaiters()).To(Equal(0))
+		})
+		It("receives immediately for a duration of 0 or less", func() {
+			Expect(clock.After(0)).To(Receive(Equal(start)))
+			Expect(clock.NumWaiters()).To(Equal(0))
+		})
+		It("receives when Sleep advances the clock", func() {
+			early := clock.After(time.Second)
+			late := clock.After(time.Hour)
+			clock.Sleep(time.Minute)
+			Expect(early).To(Receive())
+			Expect(late).ToNot(Receive())
+			Expect(clock.NumWaiters()).To(Equal(1))
+		})
+	})
+	Describe("FakeClock.Install", func() {
+		It("replaces System.Now, System.Sleep, and System.After with the fake clock", func() {
+			clock.Install()
+			Expect(operating.System.Now()).To(Equal(start))
+			realStart := time.Now()
+			operating.System.Sleep(time.Hour)
+			Expect(time.Since(realStart)).To(BeNumerically("<", time.Second))
+			Expect(operating.System.Now()).To(Equal(start.Add(time.Hour)))
+			channel := operating.System.After(time.Second)
+			clock.Advance(time.Second)
+			Expect(channel).To(Receive())
+		})
+	})
+})
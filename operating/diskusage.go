@@ -0,0 +1,27 @@
+//go:build linux || darwin || freebsd
+
+package operating
+
+import (
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+/*
+ * DiskUsage reports the total size and the free and used space in bytes of
+ * the filesystem containing path.  The free space is the space available to
+ * unprivileged users, so it does not include blocks reserved for root.
+ */
+func DiskUsage(path string) (total uint64, free uint64, used uint64, err error) {
+	var stat syscall.Statfs_t
+	err = syscall.Statfs(path, &stat)
+	if err != nil {
+		return 0, 0, 0, errors.Wrapf(err, "Cannot get disk usage for %s", path)
+	}
+	blockSize := uint64(stat.Bsize)
+	total = uint64(stat.Blocks) * blockSize
+	free = uint64(stat.Bavail) * blockSize
+	used = (uint64(stat.Blocks) - uint64(stat.Bfree)) * blockSize
+	return total, free, used, nil
+}
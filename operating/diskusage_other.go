@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !freebsd
+
+package operating
+
+import (
+	"runtime"
+
+	"github.com/pkg/errors"
+)
+
+// DiskUsage is not supported on this platform and always returns an error.
+func DiskUsage(path string) (total uint64, free uint64, used uint64, err error) {
+	return 0, 0, 0, errors.Errorf("Cannot get disk usage for %s: not supported on %s", path, runtime.GOOS)
+}
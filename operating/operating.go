@@ -8,7 +8,9 @@ package operating
 import (
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"os"
+	"os/exec"
 	"os/user"
 	"path/filepath"
 	"time"
@@ -41,6 +43,40 @@ func OpenFileWrite(name string, flag int, perm os.FileMode) (io.WriteCloser, err
 	return writer, err
 }
 
+/*
+ * Functions for mocking out running external commands
+ */
+
+// ExecCommand runs a command and returns its combined stdout and stderr output.
+func ExecCommand(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).CombinedOutput()
+}
+
+/*
+ * ExecCommandStreaming runs a command, writing its stdout and stderr output to
+ * the given writers as it is produced instead of returning it when the command
+ * finishes, for long-running commands whose output should be shown as it
+ * arrives.
+ */
+func ExecCommandStreaming(stdout io.Writer, stderr io.Writer, name string, args ...string) error {
+	command := exec.Command(name, args...)
+	command.Stdout = stdout
+	command.Stderr = stderr
+	return command.Run()
+}
+
+/*
+ * Reports whether a file is a terminal, i.e. a character device, without
+ * depending on a platform-specific terminal package.
+ */
+func IsTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
 /*
  * SystemFunctions holds function pointers for built-in functions that will need
  * to be mocked out for unit testing.  All built-in functions manipulating the
@@ -51,51 +87,89 @@ func OpenFileWrite(name string, flag int, perm os.FileMode) (io.WriteCloser, err
  * All function pointers in SystemFunctions refer directly to built-in functions
  * except for OpenFileRead and OpenFileWrite, which both refer to os.OpenFile but
  * return either an io.ReadCloser or io.WriteCloser instead of an *os.File, to make
- * mocking file opening in tests easier.
+ * mocking file opening in tests easier, ExecCommand and ExecCommandStreaming,
+ * which wrap exec.Command so that tests can return canned command output, and
+ * DiskUsage, which wraps statfs on the platforms that support it.
+ *
+ * Rand is a random number generator seeded from the current time, which tests
+ * can replace with one from NewRand to get the same sequence on every run.
+ *
+ * As with ioutil.TempFile, files created with TempFile are not removed
+ * automatically; the caller must close and remove them when finished.
  */
 
 type SystemFunctions struct {
-	Chmod         func(name string, mode os.FileMode) error
-	CurrentUser   func() (*user.User, error)
-	Getenv        func(key string) string
-	Getpid        func() int
-	Glob          func(pattern string) (matches []string, err error)
-	Hostname      func() (string, error)
-	IsNotExist    func(err error) bool
-	MkdirAll      func(path string, perm os.FileMode) error
-	Now           func() time.Time
-	OpenFileRead  func(name string, flag int, perm os.FileMode) (ReadCloserAt, error)
-	OpenFileWrite func(name string, flag int, perm os.FileMode) (io.WriteCloser, error)
-	ReadFile      func(filename string) ([]byte, error)
-	Remove        func(name string) error
-	RemoveAll     func(name string) error
-	Stat          func(name string) (os.FileInfo, error)
-	Stdin         ReadCloserAt
-	Stdout        io.WriteCloser
-	TempFile      func(dir, pattern string) (f *os.File, err error)
-	Local         *time.Location
+	After                func(d time.Duration) <-chan time.Time
+	Chmod                func(name string, mode os.FileMode) error
+	CurrentUser          func() (*user.User, error)
+	DiskUsage            func(path string) (total uint64, free uint64, used uint64, err error)
+	ExecCommand          func(name string, args ...string) ([]byte, error)
+	ExecCommandStreaming func(stdout io.Writer, stderr io.Writer, name string, args ...string) error
+	Getenv               func(key string) string
+	Getpid               func() int
+	Glob                 func(pattern string) (matches []string, err error)
+	Hostname             func() (string, error)
+	IsNotExist           func(err error) bool
+	IsTerminal           func(file *os.File) bool
+	LookupEnv            func(key string) (string, bool)
+	Lstat                func(name string) (os.FileInfo, error)
+	MkdirAll             func(path string, perm os.FileMode) error
+	NotifySignals        func(sigs ...os.Signal) <-chan os.Signal
+	Now                  func() time.Time
+	OpenFileRead         func(name string, flag int, perm os.FileMode) (ReadCloserAt, error)
+	OpenFileWrite        func(name string, flag int, perm os.FileMode) (io.WriteCloser, error)
+	Rand                 *rand.Rand
+	ReadFile             func(filename string) ([]byte, error)
+	Remove               func(name string) error
+	RemoveAll            func(name string) error
+	Rename               func(oldpath string, newpath string) error
+	Setenv               func(key string, value string) error
+	Sleep                func(d time.Duration)
+	Stat                 func(name string) (os.FileInfo, error)
+	Stdin                ReadCloserAt
+	Stdout               io.WriteCloser
+	StopNotify           func(signals <-chan os.Signal)
+	TempDir              func() string
+	TempFile             func(dir, pattern string) (f *os.File, err error)
+	WriteFile            func(filename string, data []byte, perm os.FileMode) error
+	Local                *time.Location
 }
 
 func InitializeSystemFunctions() *SystemFunctions {
 	return &SystemFunctions{
-		Chmod:         os.Chmod,
-		CurrentUser:   user.Current,
-		Getenv:        os.Getenv,
-		Getpid:        os.Getpid,
-		Glob:          filepath.Glob,
-		Hostname:      os.Hostname,
-		IsNotExist:    os.IsNotExist,
-		MkdirAll:      os.MkdirAll,
-		Now:           time.Now,
-		OpenFileRead:  OpenFileRead,
-		OpenFileWrite: OpenFileWrite,
-		ReadFile:      ioutil.ReadFile,
-		Remove:        os.Remove,
-		RemoveAll:     os.RemoveAll,
-		Stat:          os.Stat,
-		Stdin:         os.Stdin,
-		Stdout:        os.Stdout,
-		TempFile:      ioutil.TempFile,
-		Local:         time.Local,
+		After:                time.After,
+		Chmod:                os.Chmod,
+		CurrentUser:          user.Current,
+		DiskUsage:            DiskUsage,
+		ExecCommand:          ExecCommand,
+		ExecCommandStreaming: ExecCommandStreaming,
+		Getenv:               os.Getenv,
+		Getpid:               os.Getpid,
+		Glob:                 filepath.Glob,
+		Hostname:             os.Hostname,
+		IsNotExist:           os.IsNotExist,
+		IsTerminal:           IsTerminal,
+		LookupEnv:            os.LookupEnv,
+		Lstat:                os.Lstat,
+		MkdirAll:             os.MkdirAll,
+		NotifySignals:        NotifySignals,
+		Now:                  time.Now,
+		OpenFileRead:         OpenFileRead,
+		OpenFileWrite:        OpenFileWrite,
+		Rand:                 NewRand(time.Now().UnixNano()),
+		ReadFile:             ioutil.ReadFile,
+		Remove:               os.Remove,
+		RemoveAll:            os.RemoveAll,
+		Rename:               os.Rename,
+		Setenv:               os.Setenv,
+		Sleep:                time.Sleep,
+		Stat:                 os.Stat,
+		Stdin:                os.Stdin,
+		Stdout:               os.Stdout,
+		StopNotify:           StopNotify,
+		TempDir:              os.TempDir,
+		TempFile:             ioutil.TempFile,
+		WriteFile:            ioutil.WriteFile,
+		Local:                time.Local,
 	}
 }
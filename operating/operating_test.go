@@ -0,0 +1,136 @@
+package operating_test
+
+import (
+	"os"
+	"os/user"
+	"sync"
+	"testing"
+
+	"github.com/cloudberrydb/gp-common-go-libs/operating"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestOperating(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "operating tests")
+}
+
+var _ = Describe("operating/operating tests", func() {
+	AfterEach(func() {
+		operating.System = operating.InitializeSystemFunctions()
+	})
+
+	Describe("System.Hostname", func() {
+		It("defaults to the hostname reported by the OS", func() {
+			expected, err := os.Hostname()
+			Expect(err).ToNot(HaveOccurred())
+			hostname, err := operating.System.Hostname()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(hostname).To(Equal(expected))
+		})
+		It("can be overridden", func() {
+			operating.System.Hostname = func() (string, error) { return "testHost", nil }
+			hostname, err := operating.System.Hostname()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(hostname).To(Equal("testHost"))
+		})
+	})
+	Describe("System.CurrentUser", func() {
+		It("defaults to the user running the process", func() {
+			expected, err := user.Current()
+			Expect(err).ToNot(HaveOccurred())
+			currentUser, err := operating.System.CurrentUser()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(currentUser).To(Equal(expected))
+		})
+		It("can be overridden", func() {
+			operating.System.CurrentUser = func() (*user.User, error) { return &user.User{Username: "testUser", HomeDir: "testDir"}, nil }
+			currentUser, err := operating.System.CurrentUser()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(currentUser.Username).To(Equal("testUser"))
+			Expect(currentUser.HomeDir).To(Equal("testDir"))
+		})
+		It("is restored by InitializeSystemFunctions", func() {
+			operating.System.CurrentUser = func() (*user.User, error) { return &user.User{Username: "testUser"}, nil }
+			operating.System = operating.InitializeSystemFunctions()
+			expected, _ := user.Current()
+			currentUser, _ := operating.System.CurrentUser()
+			Expect(currentUser).To(Equal(expected))
+		})
+	})
+	Describe("System.Setenv and System.LookupEnv", func() {
+		key := "GP_COMMON_GO_LIBS_TEST_VAR"
+		AfterEach(func() {
+			_ = os.Unsetenv(key)
+		})
+		It("default to the process environment", func() {
+			_, isSet := operating.System.LookupEnv(key)
+			Expect(isSet).To(BeFalse())
+			Expect(operating.System.Setenv(key, "")).To(Succeed())
+			value, isSet := operating.System.LookupEnv(key)
+			Expect(isSet).To(BeTrue())
+			Expect(value).To(Equal(""))
+			Expect(operating.System.Setenv(key, "value")).To(Succeed())
+			Expect(os.Getenv(key)).To(Equal("value"))
+		})
+		It("can be overridden to use a fake environment", func() {
+			env := map[string]string{}
+			operating.System.Setenv = func(key string, value string) error {
+				env[key] = value
+				return nil
+			}
+			operating.System.LookupEnv = func(key string) (string, bool) {
+				value, isSet := env[key]
+				return value, isSet
+			}
+			Expect(operating.System.Setenv(key, "value")).To(Succeed())
+			value, isSet := operating.System.LookupEnv(key)
+			Expect(isSet).To(BeTrue())
+			Expect(value).To(Equal("value"))
+			_, isSet = os.LookupEnv(key)
+			Expect(isSet).To(BeFalse())
+		})
+	})
+	Describe("System.DiskUsage", func() {
+		It("defaults to reporting the usage of the filesystem containing the path", func() {
+			total, free, used, err := operating.System.DiskUsage(os.TempDir())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(total).To(BeNumerically(">", 0))
+			Expect(free).To(BeNumerically("<=", total))
+			Expect(used).To(BeNumerically("<=", total))
+		})
+		It("returns an error for a nonexistent path", func() {
+			_, _, _, err := operating.System.DiskUsage("/nonexistent/gp_common_go_libs_test")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(HavePrefix("Cannot get disk usage for /nonexistent/gp_common_go_libs_test: "))
+		})
+		It("can be overridden to return canned values", func() {
+			operating.System.DiskUsage = func(path string) (uint64, uint64, uint64, error) {
+				return 1000, 400, 600, nil
+			}
+			total, free, used, err := operating.System.DiskUsage("/data")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(total).To(Equal(uint64(1000)))
+			Expect(free).To(Equal(uint64(400)))
+			Expect(used).To(Equal(uint64(600)))
+		})
+	})
+	Describe("NewRand", func() {
+		It("can be used from multiple goroutines", func() {
+			random := operating.NewRand(1)
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 100; j++ {
+						_ = random.Intn(100)
+					}
+				}()
+			}
+			wg.Wait()
+		})
+	})
+})
@@ -0,0 +1,43 @@
+package operating
+
+/*
+ * This file contains functions for generating random numbers through System,
+ * so that tests can make random names and IDs deterministic.
+ */
+
+import (
+	"math/rand"
+	"sync"
+)
+
+/*
+ * NewRand returns a random number generator seeded with seed, which unlike one
+ * from rand.New is safe to use from multiple goroutines, as System.Rand is
+ * shared by the whole process.
+ */
+func NewRand(seed int64) *rand.Rand {
+	return rand.New(&lockedSource{source: rand.NewSource(seed).(rand.Source64)})
+}
+
+type lockedSource struct {
+	lock   sync.Mutex
+	source rand.Source64
+}
+
+func (source *lockedSource) Int63() int64 {
+	source.lock.Lock()
+	defer source.lock.Unlock()
+	return source.source.Int63()
+}
+
+func (source *lockedSource) Uint64() uint64 {
+	source.lock.Lock()
+	defer source.lock.Unlock()
+	return source.source.Uint64()
+}
+
+func (source *lockedSource) Seed(seed int64) {
+	source.lock.Lock()
+	defer source.lock.Unlock()
+	source.source.Seed(seed)
+}
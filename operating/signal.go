@@ -0,0 +1,82 @@
+package operating
+
+/*
+ * This file contains functions for handling signals through System, so that
+ * tests can deliver fake signals instead of signalling the test process.
+ */
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+var (
+	notifyLock     sync.Mutex
+	notifyChannels = make(map[<-chan os.Signal]chan os.Signal)
+)
+
+/*
+ * NotifySignals returns a channel that receives the given signals, or all
+ * incoming signals if none are given, as for signal.Notify.  The channel is
+ * buffered, so a signal arriving while the receiver is busy is not lost.
+ */
+func NotifySignals(sigs ...os.Signal) <-chan os.Signal {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, sigs...)
+	notifyLock.Lock()
+	defer notifyLock.Unlock()
+	notifyChannels[signals] = signals
+	return signals
+}
+
+// StopNotify stops delivering signals to a channel returned by NotifySignals.
+func StopNotify(signals <-chan os.Signal) {
+	notifyLock.Lock()
+	defer notifyLock.Unlock()
+	if channel, ok := notifyChannels[signals]; ok {
+		signal.Stop(channel)
+		delete(notifyChannels, signals)
+	}
+}
+
+/*
+ * RegisterCleanupOnSignal runs fn once when the process receives one of the
+ * given signals, or SIGINT or SIGTERM if none are given, so that a tool can
+ * remove temporary files or terminate queries before exiting.  Handling of the
+ * signals is stopped as soon as one arrives, so a second signal received while
+ * fn is running gets the default behavior, which usually terminates the
+ * process.  Exiting after the cleanup is left to fn.
+ *
+ * The returned function unregisters the cleanup without running it.
+ */
+func RegisterCleanupOnSignal(fn func(), sigs ...os.Signal) (unregister func()) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	signals := System.NotifySignals(sigs...)
+	stopNotify := System.StopNotify
+	done := make(chan struct{})
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			stopNotify(signals)
+			close(done)
+		})
+	}
+	go func() {
+		select {
+		case <-signals:
+			select {
+			case <-done:
+				// Ignore a signal received after unregistering the cleanup
+			default:
+				stop()
+				fn()
+			}
+		case <-done:
+		}
+	}()
+	return stop
+}
@@ -0,0 +1,78 @@
+package operating_test
+
+import (
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/cloudberrydb/gp-common-go-libs/operating"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("operating/signal tests", func() {
+	AfterEach(func() {
+		operating.System = operating.InitializeSystemFunctions()
+	})
+
+	Describe("NotifySignals and StopNotify", func() {
+		It("deliver signals received by the process until stopped", func() {
+			signals := operating.System.NotifySignals(syscall.SIGUSR2)
+			defer operating.System.StopNotify(signals)
+			Expect(syscall.Kill(os.Getpid(), syscall.SIGUSR2)).To(Succeed())
+			Eventually(signals, 5*time.Second).Should(Receive(Equal(syscall.SIGUSR2)))
+		})
+		It("ignore a channel that was not returned by NotifySignals", func() {
+			Expect(func() { operating.System.StopNotify(make(chan os.Signal)) }).ToNot(Panic())
+		})
+	})
+	Describe("RegisterCleanupOnSignal", func() {
+		var (
+			signals  chan os.Signal
+			notified []os.Signal
+			stops    chan (<-chan os.Signal)
+		)
+		BeforeEach(func() {
+			signals = make(chan os.Signal, 1)
+			notified = nil
+			stops = make(chan (<-chan os.Signal), 2)
+			operating.System.NotifySignals = func(sigs ...os.Signal) <-chan os.Signal {
+				notified = sigs
+				return signals
+			}
+			operating.System.StopNotify = func(c <-chan os.Signal) {
+				stops <- c
+			}
+		})
+
+		It("listens for SIGINT and SIGTERM by default", func() {
+			unregister := operating.RegisterCleanupOnSignal(func() {})
+			defer unregister()
+			Expect(notified).To(Equal([]os.Signal{os.Interrupt, syscall.SIGTERM}))
+		})
+		It("listens for the given signals", func() {
+			unregister := operating.RegisterCleanupOnSignal(func() {}, syscall.SIGHUP)
+			defer unregister()
+			Expect(notified).To(Equal([]os.Signal{syscall.SIGHUP}))
+		})
+		It("runs the cleanup once when a signal is delivered and stops listening", func() {
+			cleanups := make(chan struct{}, 2)
+			operating.RegisterCleanupOnSignal(func() { cleanups <- struct{}{} })
+			signals <- syscall.SIGTERM
+			Eventually(cleanups).Should(Receive())
+			Expect(stops).To(Receive(Equal((<-chan os.Signal)(signals))))
+			Consistently(cleanups, 100*time.Millisecond).ShouldNot(Receive())
+		})
+		It("does not run the cleanup after it is unregistered", func() {
+			cleanups := make(chan struct{}, 1)
+			unregister := operating.RegisterCleanupOnSignal(func() { cleanups <- struct{}{} })
+			unregister()
+			unregister()
+			Expect(stops).To(HaveLen(1))
+			Expect(stops).To(Receive(Equal((<-chan os.Signal)(signals))))
+			signals <- syscall.SIGTERM
+			Consistently(cleanups, 100*time.Millisecond).ShouldNot(Receive())
+		})
+	})
+})
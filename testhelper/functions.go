@@ -1,6 +1,8 @@
 package testhelper
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"regexp"
@@ -19,15 +21,41 @@ import (
  * Functions for setting up the test environment and mocking out variables
  */
 
+// The buffers created by the most recent call to SetupTestLogger
+var logBuffers []*gbytes.Buffer
+
 func SetupTestLogger() (*gbytes.Buffer, *gbytes.Buffer, *gbytes.Buffer) {
 	testStdout := gbytes.NewBuffer()
 	testStderr := gbytes.NewBuffer()
 	testLogfile := gbytes.NewBuffer()
 	testLogger := gplog.NewLogger(testStdout, testStderr, testLogfile, "gbytes.Buffer", gplog.LOGINFO, "testProgram")
 	gplog.SetLogger(testLogger)
+	logBuffers = []*gbytes.Buffer{testStdout, testStderr, testLogfile}
 	return testStdout, testStderr, testLogfile
 }
 
+/*
+ * ResetLogBuffers clears the stdout, stderr, and logfile buffers returned by
+ * the most recent call to SetupTestLogger or SetupTestEnvironment, so that
+ * output logged by one spec is not seen by the next.  Suites that set up the
+ * logger once in BeforeSuite should call it in a top-level BeforeEach:
+ *
+ *	var _ = BeforeSuite(func() {
+ *		stdout, stderr, logfile = testhelper.SetupTestLogger()
+ *	})
+ *	var _ = BeforeEach(func() {
+ *		testhelper.ResetLogBuffers()
+ *	})
+ *
+ * The buffers are cleared in place, so references to them stay valid.
+ */
+func ResetLogBuffers() {
+	for _, buffer := range logBuffers {
+		err := buffer.Clear()
+		Expect(err).ToNot(HaveOccurred(), "Could not clear log buffer")
+	}
+}
+
 func SetupTestEnvironment() (*dbconn.DBConn, sqlmock.Sqlmock, *gbytes.Buffer, *gbytes.Buffer, *gbytes.Buffer) {
 	testStdout, testStderr, testLogfile := SetupTestLogger()
 	connection, mock := CreateAndConnectMockDB(1)
@@ -35,6 +63,18 @@ func SetupTestEnvironment() (*dbconn.DBConn, sqlmock.Sqlmock, *gbytes.Buffer, *g
 	return connection, mock, testStdout, testStderr, testLogfile
 }
 
+/*
+ * SetupTestEnvironmentWithContext sets up the test environment in the same way
+ * as SetupTestEnvironment, and also returns a context for the context-aware
+ * DBConn methods along with the function that cancels it.  Tests should defer
+ * the cancel function so that the context's resources are released.
+ */
+func SetupTestEnvironmentWithContext() (*dbconn.DBConn, sqlmock.Sqlmock, *gbytes.Buffer, *gbytes.Buffer, *gbytes.Buffer, context.Context, context.CancelFunc) {
+	connection, mock, testStdout, testStderr, testLogfile := SetupTestEnvironment()
+	ctx, cancel := context.WithCancel(context.Background())
+	return connection, mock, testStdout, testStderr, testLogfile, ctx, cancel
+}
+
 func CreateMockDB() (*sqlx.DB, sqlmock.Sqlmock) {
 	db, mock, err := sqlmock.New()
 	mockdb := sqlx.NewDb(db, "sqlmock")
@@ -71,13 +111,111 @@ func ExpectVersionQuery(mock sqlmock.Sqlmock, versionStr string) {
 	mock.ExpectQuery(regexp.QuoteMeta("SELECT pg_catalog.version() AS versionstring")).WillReturnRows(versionRow)
 }
 
+// ExpectBegin expects the statements DBConn.Begin runs to start a transaction.
+func ExpectBegin(mock sqlmock.Sqlmock) {
+	fakeResult := TestResult{Rows: 0}
+	mock.ExpectBegin()
+	mock.ExpectExec("SET TRANSACTION(.*)").WillReturnResult(fakeResult)
+}
+
+/*
+ * ExpectTransactionWithRollback expects a transaction that is started by
+ * DBConn.Begin and then rolled back without running any other statements, as
+ * when the work done in the transaction fails before reaching the database.
+ * Tests of transactions that do run statements can call ExpectBegin, program
+ * the statements, and then call mock.ExpectRollback themselves.
+ */
+func ExpectTransactionWithRollback(mock sqlmock.Sqlmock) {
+	ExpectBegin(mock)
+	mock.ExpectRollback()
+}
+
+/*
+ * ExpectQueryError and ExpectExecError program the mock to return err for the
+ * next query (as run by Get, Select, and Query) or statement (as run by Exec)
+ * matching the regular expression pattern, for testing error paths.
+ */
+func ExpectQueryError(mock sqlmock.Sqlmock, pattern string, err error) {
+	mock.ExpectQuery(pattern).WillReturnError(err)
+}
+
+func ExpectExecError(mock sqlmock.Sqlmock, pattern string, err error) {
+	mock.ExpectExec(pattern).WillReturnError(err)
+}
+
+/*
+ * ExpectExactQuery and ExpectExactExec expect a query or statement whose SQL
+ * is exactly exactSQL, apart from leading, trailing, and repeated whitespace,
+ * and return the expectation so that its result can be programmed.  The mocks
+ * created by CreateMockDB match SQL as a regular expression, so these anchor
+ * the quoted SQL to match it as sqlmock.QueryMatcherEqual would instead.
+ */
+func ExpectExactQuery(mock sqlmock.Sqlmock, exactSQL string) *sqlmock.ExpectedQuery {
+	return mock.ExpectQuery(exactSQLPattern(exactSQL))
+}
+
+func ExpectExactExec(mock sqlmock.Sqlmock, exactSQL string) *sqlmock.ExpectedExec {
+	return mock.ExpectExec(exactSQLPattern(exactSQL))
+}
+
+func exactSQLPattern(exactSQL string) string {
+	return "^" + regexp.QuoteMeta(exactSQL) + "$"
+}
+
+/*
+ * ExpectCopy programs the mock to accept a COPY ... FROM statement into the
+ * table, with or without a column list, and to report that it copied the given
+ * number of rows.
+ */
+func ExpectCopy(mock sqlmock.Sqlmock, table string, rows int64) {
+	pattern := fmt.Sprintf(`^COPY %s[ (].*FROM `, regexp.QuoteMeta(table))
+	mock.ExpectExec(pattern).WillReturnResult(TestResult{Rows: rows})
+}
+
 func CreateAndConnectMockDB(numConns int) (*dbconn.DBConn, sqlmock.Sqlmock) {
+	return CreateAndConnectMockDBWithVersion(numConns, "5.1.0")
+}
+
+/*
+ * CreateAndConnectMockDBWithVersion connects a mock DBConn whose version query
+ * reports the given version, e.g. "7.0.0", so that connection.Version reflects
+ * it without hand-rolling the version query expectation.
+ */
+func CreateAndConnectMockDBWithVersion(numConns int, versionStr string) (*dbconn.DBConn, sqlmock.Sqlmock) {
 	connection, mock := CreateMockDBConn()
-	ExpectVersionQuery(mock, "5.1.0")
+	ExpectVersionQuery(mock, versionStr)
 	connection.MustConnect(numConns)
 	return connection, mock
 }
 
+/*
+ * CreateMockDBConnWithMocks creates a DBConn whose numConns pool connections
+ * each use their own mock, expecting the version query on the first
+ * connection, without connecting it.  The mock at index i is used for
+ * connection number i.
+ */
+func CreateMockDBConnWithMocks(numConns int) (*dbconn.DBConn, []sqlmock.Sqlmock) {
+	driver := &TestMultiDriver{}
+	mocks := make([]sqlmock.Sqlmock, numConns)
+	for i := 0; i < numConns; i++ {
+		var mockdb *sqlx.DB
+		mockdb, mocks[i] = CreateMockDB()
+		driver.DBs = append(driver.DBs, mockdb)
+	}
+	connection := dbconn.NewDBConnFromEnvironment("testdb")
+	connection.Driver = driver
+	connection.Host = "testhost"
+	connection.Port = 5432
+	ExpectVersionQuery(mocks[0], "5.1.0")
+	return connection, mocks
+}
+
+func CreateAndConnectMockDBWithMocks(numConns int) (*dbconn.DBConn, []sqlmock.Sqlmock) {
+	connection, mocks := CreateMockDBConnWithMocks(numConns)
+	connection.MustConnect(numConns)
+	return connection, mocks
+}
+
 func ExpectRegexp(buffer *gbytes.Buffer, testStr string) {
 	Expect(buffer).Should(gbytes.Say(regexp.QuoteMeta(testStr)))
 }
@@ -86,6 +224,54 @@ func NotExpectRegexp(buffer *gbytes.Buffer, testStr string) {
 	Expect(buffer).ShouldNot(gbytes.Say(regexp.QuoteMeta(testStr)))
 }
 
+/*
+ * ExpectLogMessage asserts that the buffer contains a line logged by gplog at
+ * the given level (e.g. "ERROR", or "warn" for "WARNING", ignoring case)
+ * whose message contains the substring.  Unlike ExpectRegexp, it does not
+ * consume the buffer, so the same output can be checked more than once.
+ */
+func ExpectLogMessage(buffer *gbytes.Buffer, level string, substring string) {
+	ExpectWithOffset(1, hasLogMessage(buffer, level, substring)).To(BeTrue(),
+		"Expected a %s message containing %q in log output:\n%s", level, substring, buffer.Contents())
+}
+
+func ExpectNoLogMessage(buffer *gbytes.Buffer, level string, substring string) {
+	ExpectWithOffset(1, hasLogMessage(buffer, level, substring)).To(BeFalse(),
+		"Expected no %s message containing %q in log output:\n%s", level, substring, buffer.Contents())
+}
+
+func hasLogMessage(buffer *gbytes.Buffer, level string, substring string) bool {
+	level = strings.ToUpper(level)
+	if level == "WARN" {
+		level = "WARNING"
+	}
+	levelMarker := fmt.Sprintf("-[%s]:-", level)
+	for _, line := range strings.Split(string(buffer.Contents()), "\n") {
+		markerIndex := strings.Index(line, levelMarker)
+		if markerIndex >= 0 && strings.Contains(line[markerIndex+len(levelMarker):], substring) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ * ParseJSONLogLines decodes each line of the buffer written by gplog in JSON
+ * format into a map, so tests can assert on individual fields.  Lines that are
+ * not JSON objects, such as text-format messages or other program output, are
+ * skipped.  Like ExpectLogMessage, it does not consume the buffer.
+ */
+func ParseJSONLogLines(buffer *gbytes.Buffer) []map[string]interface{} {
+	entries := make([]map[string]interface{}, 0)
+	for _, line := range strings.Split(string(buffer.Contents()), "\n") {
+		entry := make(map[string]interface{})
+		if err := json.Unmarshal([]byte(line), &entry); err == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
 func ShouldPanicWithMessage(message string) {
 	r := recover()
 	Expect(r).NotTo(BeNil(), "Function did not panic as expected")
@@ -99,6 +285,15 @@ func AssertQueryRuns(connection *dbconn.DBConn, query string) {
 	Expect(err).To(BeNil(), "%s", query)
 }
 
+/*
+ * SeedRandom replaces operating.System.Rand with a generator seeded with seed,
+ * so that code generating random names or IDs through it produces the same
+ * values on every test run.
+ */
+func SeedRandom(seed int64) {
+	operating.System.Rand = operating.NewRand(seed)
+}
+
 /*
  * This function call should be followed by a call to InitializeSystemFunctions
  * in a defer statement or AfterEach block.
@@ -5,20 +5,29 @@ package testhelper
  */
 
 import (
+	"context"
+
 	"github.com/cloudberrydb/gp-common-go-libs/cluster"
+	"github.com/cloudberrydb/gp-common-go-libs/dbconn"
 	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
 )
 
 type TestDriver struct {
-	ErrToReturn  error
-	ErrsToReturn []error
-	DB           *sqlx.DB
-	DBName       string
-	User         string
-	CallNumber   int
+	ErrToReturn    error
+	ErrsToReturn   []error
+	DB             *sqlx.DB
+	DBName         string
+	User           string
+	CallNumber     int
+	Listener       *TestListener
+	DriverName     string // The driver name passed to the most recent Connect call
+	DataSourceName string // The connection string passed to the most recent Connect call
 }
 
 func (driver *TestDriver) Connect(driverName string, dataSourceName string) (*sqlx.DB, error) {
+	driver.DriverName = driverName
+	driver.DataSourceName = dataSourceName
 	if driver.ErrsToReturn != nil && driver.CallNumber < len(driver.ErrsToReturn) {
 		// Return the errors in the order specified until we run out of specified errors, then return normally
 		err := driver.ErrsToReturn[driver.CallNumber]
@@ -30,6 +39,74 @@ func (driver *TestDriver) Connect(driverName string, dataSourceName string) (*sq
 	return driver.DB, nil
 }
 
+func (driver *TestDriver) ConnectListener(ctx context.Context, dataSourceName string) (dbconn.NotificationListener, error) {
+	if driver.ErrToReturn != nil {
+		return nil, driver.ErrToReturn
+	}
+	if driver.Listener == nil {
+		return nil, errors.New("No TestListener was provided to the TestDriver")
+	}
+	return driver.Listener, nil
+}
+
+/*
+ * The TestDriver returns the same mock DB for every connection in the pool,
+ * which only allows one open transaction at a time because each pool entry is
+ * limited to one connection.  The TestMultiDriver instead returns the next of
+ * DBs on each call to Connect, so that each pool entry, or each reconnection,
+ * gets its own mock with its own expectations.
+ */
+type TestMultiDriver struct {
+	DBs        []*sqlx.DB
+	CallNumber int
+}
+
+func (driver *TestMultiDriver) Connect(driverName string, dataSourceName string) (*sqlx.DB, error) {
+	if driver.CallNumber >= len(driver.DBs) {
+		return nil, errors.Errorf("TestMultiDriver was only given %d mock databases", len(driver.DBs))
+	}
+	db := driver.DBs[driver.CallNumber]
+	driver.CallNumber++
+	return db, nil
+}
+
+/*
+ * TestListener stands in for a dedicated LISTEN connection; tests send on
+ * Notifications to simulate the server delivering a NOTIFY.
+ */
+type TestListener struct {
+	Notifications chan *dbconn.Notification
+	Channels      []string
+	ErrToReturn   error
+	Closed        bool
+}
+
+func NewTestListener() *TestListener {
+	return &TestListener{Notifications: make(chan *dbconn.Notification)}
+}
+
+func (listener *TestListener) Listen(ctx context.Context, channel string) error {
+	if listener.ErrToReturn != nil {
+		return listener.ErrToReturn
+	}
+	listener.Channels = append(listener.Channels, channel)
+	return nil
+}
+
+func (listener *TestListener) WaitForNotification(ctx context.Context) (*dbconn.Notification, error) {
+	select {
+	case notification := <-listener.Notifications:
+		return notification, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (listener *TestListener) Close(ctx context.Context) error {
+	listener.Closed = true
+	return nil
+}
+
 type TestResult struct {
 	Rows int64
 }
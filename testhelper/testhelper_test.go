@@ -0,0 +1,416 @@
+package testhelper_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+
+	"github.com/cloudberrydb/gp-common-go-libs/dbconn"
+	"github.com/cloudberrydb/gp-common-go-libs/gplog"
+	"github.com/cloudberrydb/gp-common-go-libs/operating"
+	"github.com/cloudberrydb/gp-common-go-libs/testhelper"
+	"github.com/pkg/errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+func TestTestHelper(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "testhelper tests")
+}
+
+var _ = Describe("testhelper tests", func() {
+	var (
+		stdout  *gbytes.Buffer
+		stderr  *gbytes.Buffer
+		logfile *gbytes.Buffer
+	)
+
+	BeforeEach(func() {
+		stdout, stderr, logfile = testhelper.SetupTestLogger()
+	})
+	AfterEach(func() {
+		gplog.SetErrorCode(0)
+	})
+
+	Describe("ExpectLogMessage", func() {
+		BeforeEach(func() {
+			gplog.Info("Starting backup of database testdb")
+			gplog.Warn("Skipping table public.foo")
+			gplog.Error("Cannot back up table public.bar")
+		})
+		It("passes if a message at the level contains the substring", func() {
+			testhelper.ExpectLogMessage(stdout, "INFO", "backup of database")
+			testhelper.ExpectLogMessage(stdout, "WARNING", "public.foo")
+			testhelper.ExpectLogMessage(stderr, "ERROR", "public.bar")
+			testhelper.ExpectLogMessage(logfile, "ERROR", "public.bar")
+		})
+		It("ignores the case of the level and accepts warn for warnings", func() {
+			testhelper.ExpectLogMessage(stdout, "info", "backup of database")
+			testhelper.ExpectLogMessage(stdout, "warn", "public.foo")
+		})
+		It("does not consume the buffer", func() {
+			testhelper.ExpectLogMessage(logfile, "INFO", "testdb")
+			testhelper.ExpectLogMessage(logfile, "INFO", "testdb")
+		})
+		It("fails if no message contains the substring", func() {
+			failures := InterceptGomegaFailures(func() {
+				testhelper.ExpectLogMessage(stdout, "INFO", "restore")
+			})
+			Expect(failures).To(HaveLen(1))
+			Expect(failures[0]).To(ContainSubstring(`Expected a INFO message containing "restore" in log output:`))
+			Expect(failures[0]).To(ContainSubstring("Starting backup of database testdb"))
+		})
+		It("fails if the message containing the substring is at a different level", func() {
+			failures := InterceptGomegaFailures(func() {
+				testhelper.ExpectLogMessage(stdout, "ERROR", "public.foo")
+			})
+			Expect(failures).To(HaveLen(1))
+		})
+		It("does not match the substring against the prefix of the message", func() {
+			failures := InterceptGomegaFailures(func() {
+				testhelper.ExpectLogMessage(stdout, "INFO", "testProgram")
+			})
+			Expect(failures).To(HaveLen(1))
+		})
+	})
+	Describe("ExpectNoLogMessage", func() {
+		BeforeEach(func() {
+			gplog.Warn("Skipping table public.foo")
+		})
+		It("passes if no message at the level contains the substring", func() {
+			testhelper.ExpectNoLogMessage(stdout, "WARNING", "public.bar")
+			testhelper.ExpectNoLogMessage(stdout, "INFO", "public.foo")
+		})
+		It("fails if a message at the level contains the substring", func() {
+			failures := InterceptGomegaFailures(func() {
+				testhelper.ExpectNoLogMessage(stdout, "WARNING", "public.foo")
+			})
+			Expect(failures).To(HaveLen(1))
+			Expect(failures[0]).To(ContainSubstring(`Expected no WARNING message containing "public.foo" in log output:`))
+		})
+	})
+	Describe("ParseJSONLogLines", func() {
+		It("decodes each JSON line into a map of its fields", func() {
+			gplog.SetLogFormat(gplog.FormatJSON)
+			defer gplog.SetLogFormat(gplog.FormatText)
+			gplog.WithField("table", "public.foo").Warn("Skipping table")
+			gplog.WithFields(map[string]interface{}{"count": 3}).Info("Backed up tables")
+
+			entries := testhelper.ParseJSONLogLines(stdout)
+			Expect(entries).To(HaveLen(2))
+			Expect(entries[0]).To(HaveKeyWithValue("level", "WARNING"))
+			Expect(entries[0]).To(HaveKeyWithValue("message", "Skipping table"))
+			Expect(entries[0]).To(HaveKeyWithValue("table", "public.foo"))
+			Expect(entries[1]).To(HaveKeyWithValue("level", "INFO"))
+			Expect(entries[1]).To(HaveKeyWithValue("count", float64(3)))
+		})
+		It("skips lines that are not JSON objects", func() {
+			gplog.Info("Text message")
+			gplog.SetLogFormat(gplog.FormatJSON)
+			defer gplog.SetLogFormat(gplog.FormatText)
+			gplog.Info("JSON message")
+			_, _ = stdout.Write([]byte("{not json\n[1, 2]\n\n"))
+
+			entries := testhelper.ParseJSONLogLines(stdout)
+			Expect(entries).To(HaveLen(1))
+			Expect(entries[0]).To(HaveKeyWithValue("message", "JSON message"))
+		})
+		It("returns an empty slice for an empty buffer", func() {
+			Expect(testhelper.ParseJSONLogLines(stdout)).To(BeEmpty())
+		})
+	})
+	Describe("CreateAndConnectMockDBWithMocks", func() {
+		It("programs each pool connection with its own mock", func() {
+			connection, mocks := testhelper.CreateAndConnectMockDBWithMocks(3)
+			defer connection.Close()
+			Expect(connection.NumConns).To(Equal(3))
+			Expect(mocks).To(HaveLen(3))
+			for i := range mocks {
+				mocks[i].ExpectQuery(fmt.Sprintf("SELECT %d", i)).WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(i))
+			}
+
+			for i := range mocks {
+				var result int
+				Expect(connection.Get(&result, fmt.Sprintf("SELECT %d", i), i)).To(Succeed())
+				Expect(result).To(Equal(i))
+			}
+			for i := range mocks {
+				Expect(mocks[i].ExpectationsWereMet()).To(Succeed())
+			}
+		})
+		It("fails to connect if the pool needs more connections than there are mocks", func() {
+			connection, _ := testhelper.CreateMockDBConnWithMocks(1)
+			err := connection.Connect(2)
+			Expect(err).To(MatchError(ContainSubstring("TestMultiDriver was only given 1 mock databases")))
+		})
+	})
+	Describe("CreateAndConnectMockDBWithVersion", func() {
+		It("sets the connection version to the programmed version", func() {
+			connection, mock := testhelper.CreateAndConnectMockDBWithVersion(1, "7.1.0")
+			defer connection.Close()
+			Expect(connection.Version.AtLeast("7")).To(BeTrue())
+			Expect(connection.Version.Is("7.1.0")).To(BeTrue())
+			Expect(connection.Version.Before("6")).To(BeFalse())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("defaults to version 5.1.0 in CreateAndConnectMockDB", func() {
+			connection, _ := testhelper.CreateAndConnectMockDB(1)
+			defer connection.Close()
+			Expect(connection.Version.Is("5.1.0")).To(BeTrue())
+			Expect(connection.Version.AtLeast("6")).To(BeFalse())
+		})
+	})
+	Describe("SetDBVersion", func() {
+		It("changes the version of an existing connection", func() {
+			connection, _ := testhelper.CreateAndConnectMockDBWithVersion(1, "6.0.0")
+			defer connection.Close()
+			testhelper.SetDBVersion(connection, "7.0.0")
+			Expect(connection.Version.AtLeast("7")).To(BeTrue())
+		})
+	})
+	Describe("ExpectTransactionWithRollback", func() {
+		It("expects a transaction that is begun and rolled back", func() {
+			connection, mock := testhelper.CreateAndConnectMockDB(1)
+			defer connection.Close()
+			testhelper.ExpectTransactionWithRollback(mock)
+
+			Expect(connection.Begin()).To(Succeed())
+			Expect(connection.Rollback()).To(Succeed())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("is not met by a transaction that is committed", func() {
+			connection, mock := testhelper.CreateAndConnectMockDB(1)
+			defer connection.Close()
+			testhelper.ExpectTransactionWithRollback(mock)
+
+			Expect(connection.Begin()).To(Succeed())
+			Expect(connection.Commit()).ToNot(Succeed())
+			Expect(mock.ExpectationsWereMet()).ToNot(Succeed())
+		})
+	})
+	Describe("SeedRandom", func() {
+		AfterEach(func() {
+			operating.System = operating.InitializeSystemFunctions()
+		})
+		generate := func() []int64 {
+			values := make([]int64, 5)
+			for i := range values {
+				values[i] = operating.System.Rand.Int63()
+			}
+			return values
+		}
+		It("produces the same sequence each time it is seeded with the same seed", func() {
+			testhelper.SeedRandom(42)
+			first := generate()
+			testhelper.SeedRandom(42)
+			second := generate()
+			Expect(second).To(Equal(first))
+		})
+		It("produces a different sequence for a different seed", func() {
+			testhelper.SeedRandom(42)
+			first := generate()
+			testhelper.SeedRandom(43)
+			second := generate()
+			Expect(second).ToNot(Equal(first))
+		})
+	})
+	Describe("ExpectQueryError and ExpectExecError", func() {
+		var (
+			connection *dbconn.DBConn
+			mock       sqlmock.Sqlmock
+		)
+		BeforeEach(func() {
+			connection, mock = testhelper.CreateAndConnectMockDB(1)
+		})
+		AfterEach(func() {
+			connection.Close()
+		})
+		It("returns the error from queries matching the pattern", func() {
+			testhelper.ExpectQueryError(mock, "SELECT (.*) FROM pg_class", errors.New("permission denied for pg_class"))
+			testhelper.ExpectQueryError(mock, "SELECT (.*) FROM pg_class", errors.New("permission denied for pg_class"))
+
+			var count int
+			err := connection.Get(&count, "SELECT count(*) FROM pg_class")
+			Expect(err).To(MatchError("permission denied for pg_class"))
+			names := make([]string, 0)
+			err = connection.Select(&names, "SELECT relname FROM pg_class")
+			Expect(err).To(MatchError("permission denied for pg_class"))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("returns the error from statements matching the pattern", func() {
+			testhelper.ExpectExecError(mock, "DROP TABLE foo", errors.New(`table "foo" does not exist`))
+
+			_, err := connection.Exec("DROP TABLE foo")
+			Expect(err).To(MatchError(`table "foo" does not exist`))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("does not match statements of the other kind", func() {
+			testhelper.ExpectExecError(mock, "SELECT 1", errors.New("unexpected"))
+
+			var result int
+			err := connection.Get(&result, "SELECT 1")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).ToNot(Equal("unexpected"))
+		})
+	})
+	Describe("ExpectCopy", func() {
+		var (
+			connection *dbconn.DBConn
+			mock       sqlmock.Sqlmock
+		)
+		BeforeEach(func() {
+			connection, mock = testhelper.CreateAndConnectMockDB(1)
+		})
+		AfterEach(func() {
+			connection.Close()
+		})
+		It("accepts a COPY into the table and returns the row count", func() {
+			testhelper.ExpectCopy(mock, "public.foo", 42)
+
+			result, err := connection.Exec("COPY public.foo FROM '/tmp/foo.csv' WITH CSV")
+			Expect(err).ToNot(HaveOccurred())
+			rows, _ := result.RowsAffected()
+			Expect(rows).To(Equal(int64(42)))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("accepts a COPY with a column list", func() {
+			testhelper.ExpectCopy(mock, `public."bar baz"`, 3)
+
+			result, err := connection.Exec(`COPY public."bar baz"(i, j) FROM PROGRAM 'cat /tmp/bar' ON SEGMENT`)
+			Expect(err).ToNot(HaveOccurred())
+			rows, _ := result.RowsAffected()
+			Expect(rows).To(Equal(int64(3)))
+		})
+		It("does not accept a COPY into a different table", func() {
+			testhelper.ExpectCopy(mock, "public.foo", 42)
+
+			_, err := connection.Exec("COPY public.foobar FROM '/tmp/foo.csv'")
+			Expect(err).To(HaveOccurred())
+		})
+		It("does not accept a COPY to a file", func() {
+			testhelper.ExpectCopy(mock, "public.foo", 42)
+
+			_, err := connection.Exec("COPY public.foo TO '/tmp/foo.csv'")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Describe("SetupTestEnvironmentWithContext", func() {
+		It("returns a context that can be cancelled mid-query", func() {
+			connection, mock, _, _, _, ctx, cancel := testhelper.SetupTestEnvironmentWithContext()
+			defer connection.Close()
+			defer cancel()
+			mock.ExpectQuery("SELECT pg_sleep").WillDelayFor(5 * time.Second).WillReturnRows(sqlmock.NewRows([]string{"pg_sleep"}).AddRow(""))
+
+			go func() {
+				time.Sleep(10 * time.Millisecond)
+				cancel()
+			}()
+			start := time.Now()
+			_, err := connection.SelectChan(ctx, "SELECT pg_sleep(5)")
+			Expect(err).To(HaveOccurred())
+			Expect(ctx.Err()).To(Equal(context.Canceled))
+			Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+		})
+		It("returns the same handles as SetupTestEnvironment", func() {
+			connection, mock, stdout, _, logfile, ctx, cancel := testhelper.SetupTestEnvironmentWithContext()
+			defer connection.Close()
+			defer cancel()
+			Expect(ctx.Err()).ToNot(HaveOccurred())
+			Expect(connection.Version.Is("5.1.0")).To(BeTrue())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+			gplog.Info("info")
+			testhelper.ExpectLogMessage(stdout, "INFO", "info")
+			testhelper.ExpectLogMessage(logfile, "INFO", "info")
+		})
+	})
+	Describe("ExpectExactQuery and ExpectExactExec", func() {
+		var (
+			connection *dbconn.DBConn
+			mock       sqlmock.Sqlmock
+		)
+		BeforeEach(func() {
+			connection, mock = testhelper.CreateAndConnectMockDB(1)
+		})
+		AfterEach(func() {
+			connection.Close()
+		})
+		It("accepts the exact SQL", func() {
+			testhelper.ExpectExactExec(mock, "SET search_path TO public, pg_catalog").WillReturnResult(testhelper.TestResult{})
+			testhelper.ExpectExactQuery(mock, "SELECT count(*) FROM pg_class").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+			_, err := connection.Exec("SET search_path TO public, pg_catalog")
+			Expect(err).ToNot(HaveOccurred())
+			var count int
+			Expect(connection.Get(&count, "SELECT count(*) FROM pg_class")).To(Succeed())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("ignores differences in whitespace", func() {
+			testhelper.ExpectExactExec(mock, "SET statement_timeout = 0").WillReturnResult(testhelper.TestResult{})
+
+			_, err := connection.Exec("  SET statement_timeout\n\t= 0 ")
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("treats regular expression characters literally", func() {
+			testhelper.ExpectExactQuery(mock, "SELECT count(*) FROM pg_class")
+
+			var count int
+			err := connection.Get(&count, "SELECT count() FROM pg_class")
+			Expect(err).To(MatchError(ContainSubstring("could not match actual sql")))
+		})
+		It("rejects SQL that only contains the exact SQL", func() {
+			testhelper.ExpectExactExec(mock, "SET search_path TO public")
+
+			_, err := connection.Exec("SET search_path TO public, pg_catalog")
+			Expect(err).To(MatchError(ContainSubstring("could not match actual sql")))
+		})
+		It("rejects SQL that differs in case", func() {
+			testhelper.ExpectExactExec(mock, "SET search_path TO public")
+
+			_, err := connection.Exec("SET SEARCH_PATH TO public")
+			Expect(err).To(MatchError(ContainSubstring("could not match actual sql")))
+		})
+	})
+	Describe("ResetLogBuffers", func() {
+		It("clears the log buffers", func() {
+			gplog.Info("info")
+			gplog.Error("error")
+			gplog.SetErrorCode(0)
+			Expect(stdout.Contents()).ToNot(BeEmpty())
+			Expect(stderr.Contents()).ToNot(BeEmpty())
+			Expect(logfile.Contents()).ToNot(BeEmpty())
+
+			testhelper.ResetLogBuffers()
+			Expect(stdout.Contents()).To(BeEmpty())
+			Expect(stderr.Contents()).To(BeEmpty())
+			Expect(logfile.Contents()).To(BeEmpty())
+		})
+		It("keeps the buffers attached to the logger", func() {
+			gplog.Info("before")
+			testhelper.ResetLogBuffers()
+			gplog.Info("after")
+			testhelper.ExpectNoLogMessage(stdout, "INFO", "before")
+			testhelper.ExpectLogMessage(stdout, "INFO", "after")
+			testhelper.ExpectLogMessage(logfile, "INFO", "after")
+		})
+		It("resets the buffers for reading with gbytes.Say", func() {
+			gplog.Info("before")
+			Expect(stdout).To(gbytes.Say("before"))
+			testhelper.ResetLogBuffers()
+			gplog.Info("after")
+			Expect(stdout).To(gbytes.Say("after"))
+		})
+		It("clears the buffers created by SetupTestEnvironment", func() {
+			connection, _, testStdout, _, _ := testhelper.SetupTestEnvironment()
+			defer connection.Close()
+			gplog.Info("info")
+			testhelper.ResetLogBuffers()
+			Expect(testStdout.Contents()).To(BeEmpty())
+		})
+	})
+})